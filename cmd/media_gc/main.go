@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/magnusohle/openanki-backend/internal/database"
+	"github.com/magnusohle/openanki-backend/internal/media"
+)
+
+// media_gc deletes media blobs that no user media file points at, in the
+// store the server is configured with (see media.NewBlobStore). Run it
+// periodically, e.g. from cron, next to the server:
+//
+//	media_gc -dry-run        # report only
+//	media_gc -grace 72h
+//	media_gc -migrate        # also deduplicate media stored per user
+//	media_gc -remove-unused  # also delete media files no note uses, from
+//	                         # the users' collections and their devices
+func main() {
+	dryRun := flag.Bool("dry-run", false, "only report what would be deleted")
+	grace := flag.Duration("grace", 7*24*time.Hour, "keep unused files and blobs written within this period")
+	migrate := flag.Bool("migrate", false, "move media stored per user to shared, deduplicated blobs")
+	removeUnused := flag.Bool("remove-unused", false, "delete media files no note uses; devices delete them too on their next sync")
+	verbose := flag.Bool("v", false, "list every orphaned blob")
+	flag.Parse()
+
+	if err := godotenv.Load(); err != nil {
+		log.Println("ℹ️ No .env file loaded via godotenv (systemd vars will be used if set)")
+	}
+
+	// Same database as the server
+	dbPath := os.Getenv("DB_PATH")
+	if dbPath == "" {
+		home, _ := os.UserHomeDir()
+		dbPath = home + "/.checkst/openanki.db"
+	}
+	repo, err := database.InitDB(dbPath)
+	if err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	if err := repo.InitSyncSchema(); err != nil {
+		log.Fatalf("Failed to initialize sync schema: %v", err)
+	}
+
+	store, err := media.NewBlobStore()
+	if err != nil {
+		log.Fatalf("❌ Failed to init storage: %v", err)
+	}
+
+	gc := &media.GC{
+		Repo:         repo,
+		Store:        store,
+		Grace:        *grace,
+		DryRun:       *dryRun,
+		Migrate:      *migrate,
+		RemoveUnused: *removeUnused,
+	}
+	report, err := gc.Run(context.Background())
+	if err != nil {
+		log.Fatalf("❌ Media GC failed: %v", err)
+	}
+
+	if *verbose {
+		for _, o := range report.Orphans {
+			fmt.Printf("%s\t%d\t%s\n", o.Key, o.Size, o.LastModified.Format(time.RFC3339))
+		}
+	}
+	fmt.Printf("Scanned %d blobs of %d users\n", report.Objects, report.Users)
+	fmt.Printf("Orphaned: %d (%d bytes), %d more within the grace period\n",
+		len(report.Orphans), report.OrphanBytes, report.Recent)
+	fmt.Printf("Shared blobs no file uses: %d (%d bytes)\n", report.Released, report.ReleasedBytes)
+	if *migrate && !*dryRun {
+		fmt.Printf("Migrated to shared blobs: %d\n", report.Migrated)
+	}
+	if *dryRun {
+		fmt.Println("Dry run, nothing deleted")
+	} else {
+		fmt.Printf("Deleted: %d (%d bytes)\n", report.Deleted, report.DeletedBytes)
+	}
+	fmt.Printf("Media files no note uses: %d (%d bytes), %d more within the grace period\n",
+		report.Unused, report.UnusedBytes, report.UnusedRecent)
+	if *removeUnused && !*dryRun {
+		fmt.Printf("Unused media files deleted: %d (%d bytes)\n", report.Removed, report.RemovedBytes)
+	}
+	fmt.Printf("Note references to missing files: %d\n", report.Missing)
+}
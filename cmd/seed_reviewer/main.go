@@ -1,21 +1,15 @@
 package main
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
 	"log"
 	"os"
 
 	"github.com/joho/godotenv"
+	"github.com/magnusohle/openanki-backend/internal/auth"
 	"github.com/magnusohle/openanki-backend/internal/database"
 )
 
-func hashPassword(password string) string {
-	hash := sha256.Sum256([]byte(password))
-	return hex.EncodeToString(hash[:])
-}
-
 func main() {
 	// 1. Load Enviroment
 	if err := godotenv.Load(".env"); err != nil {
@@ -85,7 +79,10 @@ func main() {
 
 	if user == nil {
 		log.Println("Creating Reviewer Account...")
-		hashed := hashPassword(password)
+		hashed, err := auth.HashPassword(password)
+		if err != nil {
+			log.Fatalf("Failed to hash password: %v", err)
+		}
 		user, err = database.CreateUser(email, hashed, username)
 		if err != nil {
 			log.Fatalf("Failed to create user: %v", err)
@@ -141,7 +138,7 @@ func main() {
 		}
 		jsonBytes, _ := json.Marshal(deckData)
 
-		_, err = database.DB.Exec(`INSERT INTO group_decks (group_id, uploader_id, name, card_count, deck_data) VALUES (?, ?, ?, ?, ?)`,
+		_, err = database.DB.Exec(`INSERT INTO group_decks (group_id, uploader_id, name, card_count, deck_data, published_at) VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`,
 			vargroupID, user.ID, "Capitals Demo", 3, string(jsonBytes))
 		
 		if err != nil {
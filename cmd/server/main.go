@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 
     "github.com/magnusohle/openanki-backend/internal/api"
+    "github.com/magnusohle/openanki-backend/internal/auth"
     "github.com/magnusohle/openanki-backend/internal/database"
     "github.com/magnusohle/openanki-backend/internal/media"
 	"github.com/go-chi/chi/v5"
@@ -40,10 +43,23 @@ func main() {
         log.Fatalf("Failed to initialize sync schema: %v", err)
     }
 
-    // Initialize S3/R2 for media uploads
-    s3Service, err := media.NewS3Service()
+    // Access token keys (JWT_KEYS / JWT_SIGNING_KEY, or JWT_SECRET)
+    keys, err := auth.InitKeys()
     if err != nil {
-        log.Printf("Warning: S3/R2 not configured: %v", err)
+        log.Fatalf("Failed to load JWT keys: %v", err)
+    }
+    log.Printf("🔑 Signing access tokens with key %q (%s)", keys.Signing.ID, keys.Signing.Method.Alg())
+
+    // Blob storage for media, decks and exports (R2, S3/MinIO or local disk)
+    store, err := media.NewBlobStore()
+    if err != nil {
+        log.Fatalf("Failed to initialize storage: %v", err)
+    }
+
+    // Optional WebP/AVIF/Opus variants of uploaded media (MEDIA_TRANSCODE)
+    transcoder := media.NewTranscoderFromEnv(repo, store)
+    if transcoder != nil {
+        transcoder.Start(context.Background(), 1)
     }
 
 	r := chi.NewRouter()
@@ -61,17 +77,36 @@ func main() {
         w.Write([]byte("OK"))
     })
 
+    // Public keys of access tokens, also at /api/v1/auth/jwks.json
+    r.Get("/.well-known/jwks.json", (&api.AuthHandler{}).JWKS)
+
     // API Routes
     r.Route("/api/v1", func(r chi.Router) {
         r.Route("/auth", api.RegisterAuthRoutes)
-        r.Route("/users", api.RegisterProfileRoutes)
-        r.Route("/groups", api.RegisterGroupsRoutes)
-        r.Route("/decks", api.RegisterDecksRoutes)
-        api.RegisterSyncRoutes(r, repo, s3Service)
+        r.Route("/users", func(r chi.Router) {
+            api.RegisterProfileRoutes(r, repo, store)
+        })
+        r.Route("/groups", func(r chi.Router) {
+            api.RegisterGroupsRoutes(r, repo, store)
+        })
+        r.Route("/decks", func(r chi.Router) {
+            api.RegisterDecksRoutes(r, store)
+        })
+        api.RegisterSyncRoutes(r, repo, store, transcoder)
         r.Route("/leaderboard", api.RegisterLeaderboardRoutes)
         r.Route("/iap", api.RegisterIAPRoutes)
     })
 
+    // Anki desktop sync protocol (custom sync server URL: <host>/anki/)
+    r.Route("/anki", func(r chi.Router) {
+        api.RegisterAnkiSyncRoutes(r, repo, store, transcoder)
+    })
+
+    // Signed links of the local blob store are answered by the server itself
+    if local, ok := store.(*media.LocalStore); ok {
+        r.Handle(media.LocalBlobPath+"*", http.StripPrefix(strings.TrimSuffix(media.LocalBlobPath, "/"), local))
+    }
+
     // Serve static web files (Landing Page, Login, Account)
     webDir := http.Dir("./web/public")
     fileServer := http.FileServer(webDir)
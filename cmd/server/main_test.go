@@ -5,21 +5,24 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
-	"os"
+	"strings"
 	"testing"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/magnusohle/openanki-backend/internal/api"
+	"github.com/magnusohle/openanki-backend/internal/auth"
 	"github.com/magnusohle/openanki-backend/internal/database"
+	"github.com/magnusohle/openanki-backend/internal/testutil"
 )
 
 func TestMain(m *testing.M) {
-	// Setup test database
-	database.InitDB("./test_openanki.db")
-	code := m.Run()
-	// Cleanup
-	os.Remove("./test_openanki.db")
-	os.Exit(code)
+	testutil.Main(m, func(*database.Repository) {
+		ks, err := auth.ParseKeys("test=HS256:"+strings.Repeat("k", 32), "")
+		if err != nil {
+			panic(err)
+		}
+		auth.SetKeys(ks)
+	})
 }
 
 func setupTestRouter() *chi.Mux {
@@ -63,8 +66,8 @@ func TestRegister(t *testing.T) {
 
 	r.ServeHTTP(w, req)
 
-	if w.Code != http.StatusCreated && w.Code != http.StatusConflict {
-		t.Errorf("Expected status 201 or 409, got %d: %s", w.Code, w.Body.String())
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d: %s", w.Code, w.Body.String())
 	}
 
 	// Cleanup
@@ -87,23 +90,23 @@ func TestLogin(t *testing.T) {
 	w := httptest.NewRecorder()
 	r.ServeHTTP(w, req)
 
+	if w.Code != http.StatusOK {
+		t.Fatalf("Register: expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
 	// Login should work
-	if w.Code == http.StatusOK || w.Code == http.StatusCreated {
-		// Try login
-		loginBody := map[string]string{
-			"email":    email,
-			"password": "testpass123",
-		}
-		jsonBody, _ = json.Marshal(loginBody)
-		req = httptest.NewRequest("POST", "/api/v1/auth/login", bytes.NewBuffer(jsonBody))
-		req.Header.Set("Content-Type", "application/json")
-		w = httptest.NewRecorder()
-		r.ServeHTTP(w, req)
-
-		// Accept 200 or 500 (depends on DB state)
-		if w.Code != http.StatusOK && w.Code != http.StatusInternalServerError {
-			t.Errorf("Expected status 200 or 500, got %d: %s", w.Code, w.Body.String())
-		}
+	loginBody := map[string]string{
+		"email":    email,
+		"password": "testpass123",
+	}
+	jsonBody, _ = json.Marshal(loginBody)
+	req = httptest.NewRequest("POST", "/api/v1/auth/login", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d: %s", w.Code, w.Body.String())
 	}
 
 	// Cleanup
@@ -124,8 +127,7 @@ func TestLoginWrongPassword(t *testing.T) {
 	w := httptest.NewRecorder()
 	r.ServeHTTP(w, req)
 
-	// Should get 401 or 500 (DB might have issues)
-	if w.Code != http.StatusUnauthorized && w.Code != http.StatusInternalServerError {
-		t.Errorf("Expected status 401 or 500, got %d", w.Code)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401, got %d: %s", w.Code, w.Body.String())
 	}
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"log"
@@ -31,22 +32,23 @@ func main() {
     }
 
 	// 2. Initialize Service
-	log.Println("Initializing S3 Service...")
-	s3Service, err := media.InitS3()
+	log.Println("Initializing storage...")
+	store, err := media.NewBlobStore()
 	if err != nil {
-		log.Fatalf("❌ Failed to init S3 Service: %v", err)
+		log.Fatalf("❌ Failed to init storage: %v", err)
 	}
-	if !s3Service.IsConfigured {
-		log.Fatal("❌ S3 Service not configured (Environment variables missing?)")
+	s3Store, ok := store.(*media.S3Store)
+	if !ok {
+		log.Fatal("❌ Storage is not R2/S3 (Environment variables missing?)")
 	}
-	log.Printf("✅ S3 Service Valid (Bucket: %s, Endpoint: https://%s.r2.cloudflarestorage.com)\n", 
-        s3Service.Bucket, os.Getenv("R2_ACCOUNT_ID"))
+	log.Printf("✅ Storage Valid (Bucket: %s, Backend: %s)\n", 
+        s3Store.Bucket, os.Getenv("STORAGE_BACKEND"))
 
 	// 3. Test Presigned PUT
 	testKey := fmt.Sprintf("test_verification_%d.txt", time.Now().Unix())
 	log.Printf("Testing Upload for key: %s...", testKey)
 
-	putURL, err := s3Service.GetPresignedPutURL(testKey, "text/plain")
+	putURL, err := store.PresignPut(context.Background(), testKey, "text/plain", 15*time.Minute)
 	if err != nil {
 		log.Fatalf("❌ Failed to generate PUT URL: %v", err)
 	}
@@ -71,7 +73,7 @@ func main() {
 
 	// 4. Test Presigned GET
 	log.Println("Testing Download...")
-	getURL, err := s3Service.GetPresignedGetURL(testKey)
+	getURL, err := store.PresignGet(context.Background(), testKey, time.Hour)
 	if err != nil {
 		log.Fatalf("❌ Failed to generate GET URL: %v", err)
 	}
@@ -0,0 +1,274 @@
+package anki
+
+import (
+	"archive/zip"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Package formats, from the "meta" entry of an .apkg. Packages without one
+// are legacy.
+const (
+	packageLegacy1 = 1 // collection.anki2, schema 11
+	packageLegacy2 = 2 // collection.anki21, schema 11
+	packageLatest  = 3 // collection.anki21b, zstd compressed schema 18
+)
+
+// maxPackageCollection caps the unpacked size of a package's collection
+const maxPackageCollection = 1 << 30
+
+// ErrInvalidPackage is returned for files that are not Anki packages
+var ErrInvalidPackage = errors.New("not an Anki package")
+
+// Package is an opened .apkg (or .colpkg) file
+type Package struct {
+	Collection *Collection
+	Media      []PackageMedia
+}
+
+// PackageMedia is a media file bundled in a package
+type PackageMedia struct {
+	Filename string
+	SHA1     string // Hex; empty in legacy packages
+	Size     int64  // Unpacked size
+	file     *zip.File
+	zstd     bool
+}
+
+// Open returns the file's content
+func (m *PackageMedia) Open() (io.ReadCloser, error) {
+	rc, err := m.file.Open()
+	if err != nil || !m.zstd {
+		return rc, err
+	}
+	zr, err := zstd.NewReader(rc)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return &zstdReadCloser{zr, rc}, nil
+}
+
+type zstdReadCloser struct {
+	*zstd.Decoder
+	file io.Closer
+}
+
+func (z *zstdReadCloser) Close() error {
+	z.Decoder.Close()
+	return z.file.Close()
+}
+
+// ReadPackage opens the package held in r and reads its collection. Media
+// stays in the package until it is opened; files whose names are not plain
+// file names are left out.
+func ReadPackage(r io.ReaderAt, size int64) (*Package, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, ErrInvalidPackage
+	}
+	files := map[string]*zip.File{}
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	version := packageLegacy1
+	if f := files["meta"]; f != nil {
+		if version, err = readPackageVersion(f); err != nil {
+			return nil, err
+		}
+	} else if files["collection.anki21"] != nil {
+		version = packageLegacy2
+	}
+
+	var colFile *zip.File
+	switch version {
+	case packageLegacy1:
+		colFile = files["collection.anki2"]
+	case packageLegacy2:
+		colFile = files["collection.anki21"]
+	case packageLatest:
+		colFile = files["collection.anki21b"]
+	default:
+		return nil, fmt.Errorf("unsupported package version %d", version)
+	}
+	if colFile == nil {
+		return nil, ErrInvalidPackage
+	}
+
+	col, err := readPackageCollection(colFile, version == packageLatest)
+	if err != nil {
+		return nil, err
+	}
+	pkg := &Package{Collection: col}
+
+	if f := files["media"]; f != nil {
+		if version == packageLatest {
+			pkg.Media, err = readMediaEntries(f, files)
+		} else {
+			pkg.Media, err = readLegacyMediaMap(f, files)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read media list: %w", err)
+		}
+	}
+	return pkg, nil
+}
+
+// readPackageVersion decodes the PackageMetadata message of the "meta" entry
+func readPackageVersion(f *zip.File) (int, error) {
+	b, err := readZipFile(f, 1<<10)
+	if err != nil {
+		return 0, err
+	}
+	version := 0
+	err = walkProto(b, func(num protowire.Number, v uint64, _ []byte) {
+		if num == 1 {
+			version = int(v)
+		}
+	})
+	return version, err
+}
+
+// readPackageCollection unpacks the collection to a temporary file and reads it
+func readPackageCollection(f *zip.File, compressed bool) (*Collection, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	src := io.Reader(rc)
+	if compressed {
+		zr, err := zstd.NewReader(rc)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		src = zr
+	}
+
+	dir, err := os.MkdirTemp("", "anki-package-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "collection.anki2")
+	dst, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	n, err := io.Copy(dst, io.LimitReader(src, maxPackageCollection+1))
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to unpack collection: %w", err)
+	}
+	if n > maxPackageCollection {
+		return nil, errors.New("collection too large")
+	}
+	return ReadCollection(path)
+}
+
+// readLegacyMediaMap reads the JSON {"<zip entry>": "<filename>"} media map
+func readLegacyMediaMap(f *zip.File, files map[string]*zip.File) ([]PackageMedia, error) {
+	b, err := readZipFile(f, 64<<20)
+	if err != nil {
+		return nil, err
+	}
+	var entries map[string]string
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var out []PackageMedia
+	for _, name := range names {
+		if zf := files[name]; zf != nil && plainMediaName(entries[name]) {
+			out = append(out, PackageMedia{Filename: entries[name], Size: int64(zf.UncompressedSize64), file: zf})
+		}
+	}
+	return out, nil
+}
+
+// readMediaEntries reads the zstd compressed MediaEntries message of current
+// packages. Entry i is stored in the zip as "i".
+func readMediaEntries(f *zip.File, files map[string]*zip.File) ([]PackageMedia, error) {
+	b, err := readZipFile(f, 64<<20)
+	if err != nil {
+		return nil, err
+	}
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer dec.Close()
+	if b, err = dec.DecodeAll(b, nil); err != nil {
+		return nil, err
+	}
+
+	var out []PackageMedia
+	index := 0
+	err = walkProto(b, func(num protowire.Number, _ uint64, msg []byte) {
+		if num != 1 {
+			return
+		}
+		m := PackageMedia{zstd: true}
+		entry := strconv.Itoa(index)
+		index++
+		walkProto(msg, func(num protowire.Number, v uint64, b []byte) {
+			switch num {
+			case 1:
+				m.Filename = string(b)
+			case 2:
+				m.Size = int64(v)
+			case 3:
+				m.SHA1 = hex.EncodeToString(b)
+			case 255:
+				entry = strconv.FormatUint(v, 10)
+			}
+		})
+		if m.file = files[entry]; m.file != nil && plainMediaName(m.Filename) {
+			out = append(out, m)
+		}
+	})
+	return out, err
+}
+
+// plainMediaName reports whether name can be a media file name. Anki keeps
+// media in a single folder, so names with path separators, which could point
+// anywhere once written out, are dropped.
+func plainMediaName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\\\x00")
+}
+
+func readZipFile(f *zip.File, limit int64) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	b, err := io.ReadAll(io.LimitReader(rc, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > limit {
+		return nil, fmt.Errorf("%s too large", f.Name)
+	}
+	return b, nil
+}
@@ -0,0 +1,266 @@
+package anki
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// zipEntry is a file of a test package
+type zipEntry struct {
+	name string
+	data []byte
+}
+
+func buildZip(t *testing.T, entries ...zipEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		fw, err := zw.Create(e.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(e.data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func readFixture(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func zstdCompress(t *testing.T, data []byte) []byte {
+	t.Helper()
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	return enc.EncodeAll(data, nil)
+}
+
+func legacyMediaMap(t *testing.T, entries map[string]string) []byte {
+	t.Helper()
+	b, err := json.Marshal(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// packageMeta is the "meta" entry of a package of the given version
+func packageMeta(version int) []byte {
+	b := protowire.AppendTag(nil, 1, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(version))
+}
+
+// mediaEntries is the compressed MediaEntries message of a current package,
+// listing names in zip entries "0", "1", ...
+func mediaEntries(t *testing.T, names ...string) []byte {
+	t.Helper()
+	var msg []byte
+	for _, name := range names {
+		var entry []byte
+		entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+		entry = protowire.AppendString(entry, name)
+		entry = protowire.AppendTag(entry, 2, protowire.VarintType)
+		entry = protowire.AppendVarint(entry, 5)
+		msg = protowire.AppendTag(msg, 1, protowire.BytesType)
+		msg = protowire.AppendBytes(msg, entry)
+	}
+	return zstdCompress(t, msg)
+}
+
+func TestReadPackage(t *testing.T) {
+	col11 := readFixture(t, "testdata/collection11.anki2")
+	col18 := readFixture(t, "testdata/collection18.anki2")
+
+	tests := []struct {
+		name      string
+		zip       []byte
+		wantErr   error // nil for any error when wantFail is set
+		wantFail  bool
+		wantMedia map[string]string // filename to content
+	}{
+		{
+			name: "legacy",
+			zip: buildZip(t,
+				zipEntry{"collection.anki2", col11},
+				zipEntry{"media", legacyMediaMap(t, map[string]string{"0": "a.png", "1": "b.mp3"})},
+				zipEntry{"0", []byte("image")},
+				zipEntry{"1", []byte("sound")},
+			),
+			wantMedia: map[string]string{"a.png": "image", "b.mp3": "sound"},
+		},
+		{
+			name: "legacy anki21",
+			zip: buildZip(t,
+				// collection.anki21 takes precedence over the stub Anki adds
+				zipEntry{"collection.anki2", []byte("stub")},
+				zipEntry{"collection.anki21", col11},
+			),
+		},
+		{
+			name: "zstd",
+			zip: buildZip(t,
+				zipEntry{"meta", packageMeta(packageLatest)},
+				zipEntry{"collection.anki21b", zstdCompress(t, col18)},
+				zipEntry{"media", mediaEntries(t, "a.png")},
+				zipEntry{"0", zstdCompress(t, []byte("image"))},
+			),
+			wantMedia: map[string]string{"a.png": "image"},
+		},
+		{
+			name: "traversal entry names",
+			zip: buildZip(t,
+				zipEntry{"../collection.anki2", col11},
+				zipEntry{"../../media", legacyMediaMap(t, nil)},
+			),
+			wantErr: ErrInvalidPackage,
+		},
+		{
+			name: "traversal media names",
+			zip: buildZip(t,
+				zipEntry{"collection.anki2", col11},
+				zipEntry{"media", legacyMediaMap(t, map[string]string{
+					"0": "../../etc/cron.d/evil", "1": "..", "2": `dir\evil.png`, "3": "ok.png", "4": "",
+				})},
+				zipEntry{"0", []byte("x")}, zipEntry{"1", []byte("x")}, zipEntry{"2", []byte("x")},
+				zipEntry{"3", []byte("fine")}, zipEntry{"4", []byte("x")},
+			),
+			wantMedia: map[string]string{"ok.png": "fine"},
+		},
+		{
+			name: "traversal media names zstd",
+			zip: buildZip(t,
+				zipEntry{"meta", packageMeta(packageLatest)},
+				zipEntry{"collection.anki21b", zstdCompress(t, col18)},
+				zipEntry{"media", mediaEntries(t, "../evil.png", "ok.png")},
+				zipEntry{"0", zstdCompress(t, []byte("x"))},
+				zipEntry{"1", zstdCompress(t, []byte("fine"))},
+			),
+			wantMedia: map[string]string{"ok.png": "fine"},
+		},
+		{
+			name:    "missing collection",
+			zip:     buildZip(t, zipEntry{"media", legacyMediaMap(t, nil)}),
+			wantErr: ErrInvalidPackage,
+		},
+		{
+			name: "missing zstd collection",
+			zip: buildZip(t,
+				zipEntry{"meta", packageMeta(packageLatest)},
+				zipEntry{"collection.anki2", col11},
+			),
+			wantErr: ErrInvalidPackage,
+		},
+		{
+			name: "corrupt media map",
+			zip: buildZip(t,
+				zipEntry{"collection.anki2", col11},
+				zipEntry{"media", []byte(`{"0": "a.png"`)},
+			),
+			wantFail: true,
+		},
+		{
+			name: "corrupt media entries",
+			zip: buildZip(t,
+				zipEntry{"meta", packageMeta(packageLatest)},
+				zipEntry{"collection.anki21b", zstdCompress(t, col18)},
+				zipEntry{"media", []byte("not zstd")},
+			),
+			wantFail: true,
+		},
+		{
+			name:     "corrupt collection",
+			zip:      buildZip(t, zipEntry{"collection.anki2", []byte("not sqlite")}),
+			wantFail: true,
+		},
+		{
+			name:     "unknown version",
+			zip:      buildZip(t, zipEntry{"meta", packageMeta(9)}, zipEntry{"collection.anki2", col11}),
+			wantFail: true,
+		},
+		{
+			name:    "not a zip",
+			zip:     []byte("hello"),
+			wantErr: ErrInvalidPackage,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pkg, err := ReadPackage(bytes.NewReader(tt.zip), int64(len(tt.zip)))
+			if tt.wantErr != nil || tt.wantFail {
+				if err == nil {
+					t.Fatal("read succeeded")
+				}
+				if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Both fixtures hold the same collection
+			col := pkg.Collection
+			if len(col.Notes) != 3 || len(col.Cards) != 3 || len(col.Revlog) != 2 || len(col.NoteTypes) != 2 {
+				t.Errorf("collection has %d notes, %d cards, %d reviews, %d note types",
+					len(col.Notes), len(col.Cards), len(col.Revlog), len(col.NoteTypes))
+			}
+
+			got := map[string]string{}
+			for i := range pkg.Media {
+				m := &pkg.Media[i]
+				rc, err := m.Open()
+				if err != nil {
+					t.Fatal(err)
+				}
+				data, err := io.ReadAll(rc)
+				rc.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+				got[m.Filename] = string(data)
+			}
+			if len(got) != len(tt.wantMedia) {
+				t.Errorf("media = %v, want %v", got, tt.wantMedia)
+			}
+			for name, want := range tt.wantMedia {
+				if got[name] != want {
+					t.Errorf("media %q = %q, want %q", name, got[name], want)
+				}
+			}
+		})
+	}
+}
+
+func TestPlainMediaName(t *testing.T) {
+	var plain []string
+	for _, name := range []string{"a.png", "..a.png", "a..png", "", ".", "..", "../a.png", "a/b.png", `a\b.png`, "a\x00.png"} {
+		if plainMediaName(name) {
+			plain = append(plain, name)
+		}
+	}
+	if want := []string{"a.png", "..a.png", "a..png"}; !slices.Equal(plain, want) {
+		t.Errorf("plain names = %q, want %q", plain, want)
+	}
+}
@@ -0,0 +1,399 @@
+// Package anki reads and writes Anki collection files (collection.anki2) and
+// converts their contents to and from the sync tables' representation.
+package anki
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/magnusohle/openanki-backend/internal/database"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Collection is the content of an Anki collection file
+type Collection struct {
+	Crt         int64 // Creation, epoch seconds
+	Mod         int64 // Last change, epoch milliseconds
+	Scm         int64 // Last schema change, epoch milliseconds
+	USN         int
+	NoteTypes   []database.SyncNoteType
+	DeckConfigs []database.SyncDeckConfig
+	Decks       []database.SyncDeck
+	Notes       []database.SyncNote
+	Cards       []database.SyncCard
+	Revlog      []database.SyncRevlog
+}
+
+// Payload returns the collection's objects as a sync payload
+func (c *Collection) Payload() *database.SyncPayload {
+	return &database.SyncPayload{
+		NoteTypes:   c.NoteTypes,
+		DeckConfigs: c.DeckConfigs,
+		Decks:       c.Decks,
+		Notes:       c.Notes,
+		Cards:       c.Cards,
+		Revlog:      c.Revlog,
+	}
+}
+
+// ReadCollection reads the collection file at path. Both the schema 11 layout
+// and the schema 18 layout written by current Anki versions are supported.
+func ReadCollection(path string) (*Collection, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	col := &Collection{}
+	var models, decks, dconf string
+	err = db.QueryRow(`SELECT crt, mod, scm, usn, models, decks, dconf FROM col`).
+		Scan(&col.Crt, &col.Mod, &col.Scm, &col.USN, &models, &decks, &dconf)
+	if err != nil {
+		return nil, fmt.Errorf("not an Anki collection: %w", err)
+	}
+
+	var v18 bool
+	err = db.QueryRow(`SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = 'notetypes'`).Scan(&v18)
+	if err != nil {
+		return nil, err
+	}
+	if v18 {
+		err = readSchema18(db, col)
+	} else {
+		err = readSchema11(col, models, decks, dconf)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := readNotes(db, col); err != nil {
+		return nil, fmt.Errorf("failed to read notes: %w", err)
+	}
+	if err := readCards(db, col); err != nil {
+		return nil, fmt.Errorf("failed to read cards: %w", err)
+	}
+	if err := readRevlog(db, col); err != nil {
+		return nil, fmt.Errorf("failed to read revlog: %w", err)
+	}
+	return col, nil
+}
+
+func readSchema11(col *Collection, models, decks, dconf string) error {
+	noteTypes, err := decodeJSONMap[NoteType](models)
+	if err != nil {
+		return fmt.Errorf("failed to read note types: %w", err)
+	}
+	for i := range noteTypes {
+		col.NoteTypes = append(col.NoteTypes, ToSyncNoteType(&noteTypes[i]))
+	}
+	deckList, err := decodeJSONMap[Deck](decks)
+	if err != nil {
+		return fmt.Errorf("failed to read decks: %w", err)
+	}
+	for i := range deckList {
+		col.Decks = append(col.Decks, ToSyncDeck(&deckList[i]))
+	}
+	configs, err := decodeJSONMap[DeckConfig](dconf)
+	if err != nil {
+		return fmt.Errorf("failed to read deck options: %w", err)
+	}
+	for i := range configs {
+		col.DeckConfigs = append(col.DeckConfigs, ToSyncDeckConfig(&configs[i]))
+	}
+	return nil
+}
+
+func readNotes(db *sql.DB, col *Collection) error {
+	rows, err := db.Query(`SELECT id, guid, mid, mod, usn, tags, flds, CAST(sfld AS TEXT), csum, flags, data FROM notes`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var n database.SyncNote
+		if err := rows.Scan(&n.ID, &n.GUID, &n.MID, &n.Mod, &n.USN, &n.Tags, &n.Flds, &n.Sfld, &n.Csum, &n.Flags, &n.Data); err != nil {
+			return err
+		}
+		col.Notes = append(col.Notes, n)
+	}
+	return rows.Err()
+}
+
+func readCards(db *sql.DB, col *Collection) error {
+	rows, err := db.Query(`SELECT id, nid, did, ord, mod, usn, type, queue, due, ivl, factor, reps, lapses, left, odue, odid, flags, data FROM cards`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c database.SyncCard
+		err := rows.Scan(&c.ID, &c.NoteID, &c.DeckID, &c.Ordinal, &c.ModifiedAt, &c.USN, &c.State, &c.Queue,
+			&c.Due, &c.Interval, &c.EaseFactor, &c.Reps, &c.Lapses, &c.LeftCount, &c.OriginalDue,
+			&c.OriginalDeckID, &c.Flags, &c.Data)
+		if err != nil {
+			return err
+		}
+		stability, difficulty := MemoryState(c.Data)
+		c.Stability, c.Difficulty = &stability, &difficulty
+		col.Cards = append(col.Cards, c)
+	}
+	return rows.Err()
+}
+
+func readRevlog(db *sql.DB, col *Collection) error {
+	rows, err := db.Query(`SELECT id, cid, usn, ease, ivl, lastIvl, factor, time, type FROM revlog`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e database.SyncRevlog
+		if err := rows.Scan(&e.ID, &e.CID, &e.USN, &e.Ease, &e.Ivl, &e.LastIvl, &e.Factor, &e.Time, &e.Type); err != nil {
+			return err
+		}
+		col.Revlog = append(col.Revlog, e)
+	}
+	return rows.Err()
+}
+
+// MemoryState returns the FSRS stability and difficulty Anki keeps in the
+// card data column, or zeros
+func MemoryState(data string) (stability, difficulty float64) {
+	if data == "" {
+		return 0, 0
+	}
+	var state struct {
+		S float64 `json:"s"`
+		D float64 `json:"d"`
+	}
+	if json.Unmarshal([]byte(data), &state) != nil {
+		return 0, 0
+	}
+	return state.S, state.D
+}
+
+// CardData returns the data column for c, adding its FSRS memory state when
+// the card has one that the data does not carry yet
+func CardData(c *database.SyncCard) string {
+	if c.Stability == nil || *c.Stability == 0 || c.Data != "" {
+		return c.Data
+	}
+	difficulty := 0.0
+	if c.Difficulty != nil {
+		difficulty = *c.Difficulty
+	}
+	b, _ := json.Marshal(map[string]float64{"s": *c.Stability, "d": difficulty})
+	return string(b)
+}
+
+// schema11SQL creates an empty schema 11 collection. Anki upgrades it to its
+// current schema when the file is opened.
+const schema11SQL = `
+CREATE TABLE col (
+    id integer primary key,
+    crt integer not null,
+    mod integer not null,
+    scm integer not null,
+    ver integer not null,
+    dty integer not null,
+    usn integer not null,
+    ls integer not null,
+    conf text not null,
+    models text not null,
+    decks text not null,
+    dconf text not null,
+    tags text not null
+);
+CREATE TABLE notes (
+    id integer primary key,
+    guid text not null,
+    mid integer not null,
+    mod integer not null,
+    usn integer not null,
+    tags text not null,
+    flds text not null,
+    sfld integer not null,
+    csum integer not null,
+    flags integer not null,
+    data text not null
+);
+CREATE TABLE cards (
+    id integer primary key,
+    nid integer not null,
+    did integer not null,
+    ord integer not null,
+    mod integer not null,
+    usn integer not null,
+    type integer not null,
+    queue integer not null,
+    due integer not null,
+    ivl integer not null,
+    factor integer not null,
+    reps integer not null,
+    lapses integer not null,
+    left integer not null,
+    odue integer not null,
+    odid integer not null,
+    flags integer not null,
+    data text not null
+);
+CREATE TABLE revlog (
+    id integer primary key,
+    cid integer not null,
+    usn integer not null,
+    ease integer not null,
+    ivl integer not null,
+    lastIvl integer not null,
+    factor integer not null,
+    time integer not null,
+    type integer not null
+);
+CREATE TABLE graves (
+    usn integer not null,
+    oid integer not null,
+    type integer not null
+);
+CREATE INDEX ix_notes_usn ON notes (usn);
+CREATE INDEX ix_cards_usn ON cards (usn);
+CREATE INDEX ix_revlog_usn ON revlog (usn);
+CREATE INDEX ix_cards_nid ON cards (nid);
+CREATE INDEX ix_cards_sched ON cards (did, queue, due);
+CREATE INDEX ix_revlog_cid ON revlog (cid);
+CREATE INDEX ix_notes_csum ON notes (csum);
+`
+
+// defaultConf is the col.conf of a new collection
+const defaultConf = `{"nextPos":1,"estTimes":true,"activeDecks":[1],"sortType":"noteFld","timeLim":0,` +
+	`"sortBackwards":false,"addToCur":true,"curDeck":1,"newSpread":0,"dueCounts":true,"curModel":null,"collapseTime":1200}`
+
+// WriteCollection writes col as a new schema 11 collection file at path,
+// which must not exist yet. Object USNs are written as 0 (synced).
+func WriteCollection(path string, col *Collection) error {
+	cw, err := CreateCollection(path)
+	if err != nil {
+		return err
+	}
+	defer cw.Close()
+	if err := cw.WriteRecords(col); err != nil {
+		return err
+	}
+	return cw.Commit(col)
+}
+
+// CollectionWriter writes a collection file in parts, so a large collection
+// need not be held in memory at once. Nothing is stored unless Commit
+// succeeds.
+type CollectionWriter struct {
+	db *sql.DB
+	tx *sql.Tx
+}
+
+// CreateCollection starts a new schema 11 collection file at path, which must
+// not exist yet. Close the writer when done.
+func CreateCollection(path string) (*CollectionWriter, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=rwc")
+	if err != nil {
+		return nil, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if _, err := tx.Exec(schema11SQL); err != nil {
+		tx.Rollback()
+		db.Close()
+		return nil, err
+	}
+	return &CollectionWriter{db: db, tx: tx}, nil
+}
+
+// WriteRecords adds the notes, cards and review log of col. Object USNs are
+// written as 0 (synced).
+func (cw *CollectionWriter) WriteRecords(col *Collection) error {
+	for _, n := range col.Notes {
+		_, err := cw.tx.Exec(`INSERT INTO notes VALUES (?, ?, ?, ?, 0, ?, ?, ?, ?, ?, ?)`,
+			n.ID, n.GUID, n.MID, n.Mod, n.Tags, n.Flds, n.Sfld, n.Csum, n.Flags, n.Data)
+		if err != nil {
+			return fmt.Errorf("note %d: %w", n.ID, err)
+		}
+	}
+	for i := range col.Cards {
+		c := &col.Cards[i]
+		_, err := cw.tx.Exec(`INSERT INTO cards VALUES (?, ?, ?, ?, ?, 0, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			c.ID, c.NoteID, c.DeckID, c.Ordinal, c.ModifiedAt, c.State, c.Queue, c.Due, c.Interval,
+			c.EaseFactor, c.Reps, c.Lapses, c.LeftCount, c.OriginalDue, c.OriginalDeckID, c.Flags, CardData(c))
+		if err != nil {
+			return fmt.Errorf("card %d: %w", c.ID, err)
+		}
+	}
+	for _, e := range col.Revlog {
+		_, err := cw.tx.Exec(`INSERT OR IGNORE INTO revlog VALUES (?, ?, 0, ?, ?, ?, ?, ?, ?)`,
+			e.ID, e.CID, e.Ease, e.Ivl, e.LastIvl, e.Factor, e.Time, e.Type)
+		if err != nil {
+			return fmt.Errorf("revlog %d: %w", e.ID, err)
+		}
+	}
+	return nil
+}
+
+// Commit writes the collection row from col's timestamps, note types, decks
+// and deck option groups, and stores the file. Records of col are not
+// written; pass them to WriteRecords.
+func (cw *CollectionWriter) Commit(col *Collection) error {
+	models := make(map[string]NoteType, len(col.NoteTypes))
+	for i := range col.NoteTypes {
+		nt := FromSyncNoteType(&col.NoteTypes[i])
+		nt.USN = 0
+		models[strconv.FormatInt(int64(nt.ID), 10)] = nt
+	}
+	decks := make(map[string]Deck, len(col.Decks)+1)
+	for i := range col.Decks {
+		d := FromSyncDeck(&col.Decks[i])
+		d.USN = 0
+		decks[strconv.FormatInt(int64(d.ID), 10)] = d
+	}
+	if _, ok := decks["1"]; !ok {
+		decks["1"] = Deck{ID: 1, Name: "Default", Conf: 1}
+	}
+	dconf := make(map[string]DeckConfig, len(col.DeckConfigs)+1)
+	for i := range col.DeckConfigs {
+		c := FromSyncDeckConfig(&col.DeckConfigs[i])
+		c.USN = 0
+		dconf[strconv.FormatInt(int64(c.ID), 10)] = c
+	}
+	if _, ok := dconf["1"]; !ok {
+		dconf["1"] = FromSyncDeckConfig(&database.SyncDeckConfig{ID: 1, Name: "Default", Config: database.DeckConfigOptions{
+			NewPerDay: 20, ReviewsPerDay: 200, LearnSteps: []float64{1, 10}, RelearnSteps: []float64{10},
+			GraduatingInterval: 1, EasyInterval: 4, LeechThreshold: 8,
+		}})
+	}
+
+	modelsJSON, err := json.Marshal(models)
+	if err != nil {
+		return err
+	}
+	decksJSON, err := json.Marshal(decks)
+	if err != nil {
+		return err
+	}
+	dconfJSON, err := json.Marshal(dconf)
+	if err != nil {
+		return err
+	}
+	_, err = cw.tx.Exec(`INSERT INTO col VALUES (1, ?, ?, ?, 11, 0, ?, 0, ?, ?, ?, ?, '{}')`,
+		col.Crt, col.Mod, col.Scm, col.USN, defaultConf, string(modelsJSON), string(decksJSON), string(dconfJSON))
+	if err != nil {
+		return err
+	}
+	return cw.tx.Commit()
+}
+
+// Close releases the file, discarding everything unless Commit succeeded
+func (cw *CollectionWriter) Close() error {
+	cw.tx.Rollback()
+	return cw.db.Close()
+}
@@ -0,0 +1,247 @@
+package anki
+
+import (
+	"cmp"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/magnusohle/openanki-backend/internal/database"
+)
+
+// The fixtures hold the same collection in both layouts: a Basic and a Cloze
+// note type, the Default deck and Languages::Spanish with its own option
+// group, three notes with a card each and two reviews
+var fixtures = []string{"testdata/collection11.anki2", "testdata/collection18.anki2"}
+
+func floatPtr(f float64) *float64 { return &f }
+
+func TestReadCollection(t *testing.T) {
+	for _, path := range fixtures {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			col, err := ReadCollection(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			normalize(col)
+
+			if col.Crt != 1699920000 || col.Mod != 1700001000000 || col.Scm != 1700000000000 || col.USN != 42 {
+				t.Errorf("col = crt %d mod %d scm %d usn %d", col.Crt, col.Mod, col.Scm, col.USN)
+			}
+
+			if len(col.NoteTypes) != 2 {
+				t.Fatalf("got %d note types, want 2", len(col.NoteTypes))
+			}
+			basic, cloze := col.NoteTypes[0], col.NoteTypes[1]
+			if basic.Name != "Basic" || basic.Type != 0 || cloze.Name != "Cloze" || cloze.Type != 1 {
+				t.Errorf("note types = %s (%d), %s (%d)", basic.Name, basic.Type, cloze.Name, cloze.Type)
+			}
+			if names := fieldNames(basic); !slices.Equal(names, []string{"Front", "Back"}) {
+				t.Errorf("basic fields = %v", names)
+			}
+			if names := fieldNames(cloze); !slices.Equal(names, []string{"Text", "Back Extra"}) {
+				t.Errorf("cloze fields = %v", names)
+			}
+			if len(cloze.Templates) != 1 || cloze.Templates[0].Qfmt != "{{cloze:Text}}" {
+				t.Errorf("cloze templates = %+v", cloze.Templates)
+			}
+			if cloze.CSS == "" {
+				t.Error("cloze css is empty")
+			}
+
+			wantDecks := []database.SyncDeck{
+				{ID: 1, Name: "Default", ConfigID: 1, CreatedAt: 1700000200, ModifiedAt: 1700000200, USN: -1},
+				{ID: 1700000000100, Name: "Languages::Spanish", Description: "Words", ConfigID: 1700000000200,
+					CreatedAt: 1700000200, ModifiedAt: 1700000200, USN: -1},
+			}
+			if !reflect.DeepEqual(col.Decks, wantDecks) {
+				t.Errorf("decks = %+v, want %+v", col.Decks, wantDecks)
+			}
+
+			if len(col.DeckConfigs) != 2 {
+				t.Fatalf("got %d deck configs, want 2", len(col.DeckConfigs))
+			}
+			spanish := col.DeckConfigs[1].Config
+			if spanish.NewPerDay != 15 || spanish.ReviewsPerDay != 150 || spanish.StartingEase != 2300 ||
+				spanish.MaximumInterval != 3650 || spanish.GraduatingInterval != 2 || spanish.EasyInterval != 5 ||
+				spanish.LeechThreshold != 6 || spanish.DesiredRetention != 0.85 {
+				t.Errorf("spanish options = %+v", spanish)
+			}
+			if !slices.Equal(spanish.LearnSteps, []float64{1, 10, 60}) || !slices.Equal(spanish.RelearnSteps, []float64{10}) {
+				t.Errorf("spanish steps = %v, %v", spanish.LearnSteps, spanish.RelearnSteps)
+			}
+			if len(spanish.FSRSWeights) != 19 || spanish.FSRSWeights[0] != 0.40255 {
+				t.Errorf("spanish fsrs weights = %v", spanish.FSRSWeights)
+			}
+
+			if len(col.Notes) != 3 || col.Notes[1].Flds != "gato\x1fcat <img src=\"cat.jpg\">" || col.Notes[0].Sfld != "hola" {
+				t.Errorf("notes = %+v", col.Notes)
+			}
+			if len(col.Cards) != 3 {
+				t.Fatalf("got %d cards, want 3", len(col.Cards))
+			}
+			review := col.Cards[0]
+			if review.State != 2 || review.Due != 120 || review.Interval != 12 || review.EaseFactor != 2300 ||
+				*review.Stability != 12.5 || *review.Difficulty != 5.2 {
+				t.Errorf("review card = %+v", review)
+			}
+			if learning := col.Cards[2]; learning.LeftCount != 1002 || learning.Flags != 3 {
+				t.Errorf("learning card = %+v", learning)
+			}
+			if len(col.Revlog) != 2 || col.Revlog[1].Ivl != -600 {
+				t.Errorf("revlog = %+v", col.Revlog)
+			}
+		})
+	}
+}
+
+func TestWriteCollectionRoundTrip(t *testing.T) {
+	for _, path := range fixtures {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			col, err := ReadCollection(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := filepath.Join(t.TempDir(), "collection.anki2")
+			if err := WriteCollection(out, col); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ReadCollection(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			normalize(col)
+			normalize(got)
+
+			// Options we do not model come back from schema 11 files only
+			if filepath.Base(path) == "collection11.anki2" {
+				checkRawKey(t, "Front", got, func(c *Collection) any { return c.NoteTypes[0].Fields[0] }, "sticky", true)
+				checkRawKey(t, "Card 1", got, func(c *Collection) any { return c.NoteTypes[0].Templates[0] }, "bqfmt", "{{Front}}")
+				checkRawKey(t, "Spanish", got, func(c *Collection) any { return c.DeckConfigs[1].Config }, "new.bury", true)
+			}
+
+			stripForCompare(col)
+			stripForCompare(got)
+			if !reflect.DeepEqual(got, col) {
+				g, _ := json.MarshalIndent(got, "", " ")
+				w, _ := json.MarshalIndent(col, "", " ")
+				t.Errorf("round trip changed the collection:\ngot  %s\nwant %s", g, w)
+			}
+		})
+	}
+}
+
+func TestWriteCollectionAddsDefaults(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "collection.anki2")
+	if err := WriteCollection(out, &Collection{Crt: 1, Decks: []database.SyncDeck{{ID: 5, Name: "Only", ConfigID: 1}}}); err != nil {
+		t.Fatal(err)
+	}
+	col, err := ReadCollection(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	normalize(col)
+	if len(col.Decks) != 2 || col.Decks[0].Name != "Default" {
+		t.Errorf("decks = %+v, want Default added", col.Decks)
+	}
+	if len(col.DeckConfigs) != 1 || col.DeckConfigs[0].Config.NewPerDay != 20 {
+		t.Errorf("deck configs = %+v, want the default group", col.DeckConfigs)
+	}
+}
+
+func TestCardData(t *testing.T) {
+	tests := []struct {
+		name string
+		card database.SyncCard
+		want string
+	}{
+		{"no memory state", database.SyncCard{}, ""},
+		{"data is kept", database.SyncCard{Data: `{"s":1,"d":2,"pos":5}`, Stability: floatPtr(3)}, `{"s":1,"d":2,"pos":5}`},
+		{"memory state added", database.SyncCard{Stability: floatPtr(3.5), Difficulty: floatPtr(6)}, `{"d":6,"s":3.5}`},
+		{"zero stability", database.SyncCard{Stability: floatPtr(0), Difficulty: floatPtr(6)}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CardData(&tt.card); got != tt.want {
+				t.Errorf("CardData = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// normalize sorts the objects schema 11 keeps in JSON maps by id
+func normalize(col *Collection) {
+	slices.SortFunc(col.NoteTypes, func(a, b database.SyncNoteType) int { return cmp.Compare(a.ID, b.ID) })
+	slices.SortFunc(col.Decks, func(a, b database.SyncDeck) int { return cmp.Compare(a.ID, b.ID) })
+	slices.SortFunc(col.DeckConfigs, func(a, b database.SyncDeckConfig) int { return cmp.Compare(a.ID, b.ID) })
+}
+
+// stripForCompare clears what a written collection does not keep as read:
+// USNs, which are written as synced, and the Raw options, which schema 18
+// files do not fill and written files fill with defaults
+func stripForCompare(col *Collection) {
+	col.USN = 0
+	for i := range col.NoteTypes {
+		nt := &col.NoteTypes[i]
+		nt.USN = 0
+		for j := range nt.Fields {
+			nt.Fields[j].Raw = nil
+		}
+		for j := range nt.Templates {
+			nt.Templates[j].Raw = nil
+		}
+	}
+	for i := range col.Decks {
+		col.Decks[i].USN = 0
+	}
+	for i := range col.DeckConfigs {
+		c := &col.DeckConfigs[i]
+		c.USN = 0
+		c.Config.Raw = nil
+		if len(c.Config.FSRSWeights) == 0 {
+			c.Config.FSRSWeights = nil
+		}
+	}
+	for i := range col.Notes {
+		col.Notes[i].USN = 0
+	}
+	for i := range col.Cards {
+		col.Cards[i].USN = 0
+	}
+	for i := range col.Revlog {
+		col.Revlog[i].USN = 0
+	}
+}
+
+// checkRawKey checks the value at a dotted key path in the JSON encoding of
+// the object get returns
+func checkRawKey(t *testing.T, name string, col *Collection, get func(*Collection) any, path string, want any) {
+	t.Helper()
+	b, err := json.Marshal(get(col))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range strings.Split(path, ".") {
+		m, _ := v.(map[string]any)
+		v = m[key]
+	}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("%s: %s = %v, want %v", name, path, v, want)
+	}
+}
+
+func fieldNames(nt database.SyncNoteType) []string {
+	var names []string
+	for _, f := range nt.Fields {
+		names = append(names, f.Name)
+	}
+	return names
+}
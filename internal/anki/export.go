@@ -0,0 +1,195 @@
+package anki
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/magnusohle/openanki-backend/internal/database"
+)
+
+var (
+	// ErrDeckNotFound is returned by DeckSubset for unknown decks
+	ErrDeckNotFound = errors.New("deck not found")
+	// ErrSkipMedia is returned by WritePackage's read function to leave a
+	// media file out of the package
+	ErrSkipMedia = errors.New("skip media file")
+)
+
+// DeckSubset returns the part of the collection in the deck and its subdecks:
+// their cards, the notes of those cards and the note types, deck option groups
+// and review log entries these use
+func (c *Collection) DeckSubset(deckID int64) (*Collection, error) {
+	var root *database.SyncDeck
+	for i := range c.Decks {
+		if c.Decks[i].ID == deckID {
+			root = &c.Decks[i]
+		}
+	}
+	if root == nil {
+		return nil, ErrDeckNotFound
+	}
+
+	sub := &Collection{Crt: c.Crt, Mod: c.Mod, Scm: c.Scm, USN: c.USN}
+	decks := map[int64]bool{}
+	configs := map[int64]bool{}
+	for _, d := range c.Decks {
+		if d.ID == deckID || strings.HasPrefix(d.Name, root.Name+"::") {
+			decks[d.ID] = true
+			configs[int64(d.ConfigID)] = true
+			sub.Decks = append(sub.Decks, d)
+		}
+	}
+	for _, dc := range c.DeckConfigs {
+		if configs[dc.ID] {
+			sub.DeckConfigs = append(sub.DeckConfigs, dc)
+		}
+	}
+
+	notes := map[int64]bool{}
+	cards := map[int64]bool{}
+	for _, card := range c.Cards {
+		if decks[card.DeckID] || decks[card.OriginalDeckID] {
+			notes[card.NoteID] = true
+			cards[card.ID] = true
+			sub.Cards = append(sub.Cards, card)
+		}
+	}
+	noteTypes := map[int64]bool{}
+	for _, n := range c.Notes {
+		if notes[n.ID] {
+			noteTypes[n.MID] = true
+			sub.Notes = append(sub.Notes, n)
+		}
+	}
+	for _, nt := range c.NoteTypes {
+		if noteTypes[nt.ID] {
+			sub.NoteTypes = append(sub.NoteTypes, nt)
+		}
+	}
+	for _, e := range c.Revlog {
+		if cards[e.CID] {
+			sub.Revlog = append(sub.Revlog, e)
+		}
+	}
+	return sub, nil
+}
+
+// ResetScheduling turns all cards into new cards, ordered by note creation,
+// and drops the review log
+func (c *Collection) ResetScheduling() {
+	sort.SliceStable(c.Cards, func(i, j int) bool {
+		if c.Cards[i].NoteID != c.Cards[j].NoteID {
+			return c.Cards[i].NoteID < c.Cards[j].NoteID
+		}
+		return c.Cards[i].Ordinal < c.Cards[j].Ordinal
+	})
+	var due, lastNote int64
+	for i := range c.Cards {
+		card := &c.Cards[i]
+		if card.NoteID != lastNote || due == 0 {
+			due++
+			lastNote = card.NoteID
+		}
+		deckID := card.DeckID
+		if card.OriginalDeckID != 0 {
+			deckID = card.OriginalDeckID
+		}
+		*card = database.SyncCard{
+			ID:         card.ID,
+			NoteID:     card.NoteID,
+			DeckID:     deckID,
+			Ordinal:    card.Ordinal,
+			ModifiedAt: card.ModifiedAt,
+			Due:        due,
+		}
+	}
+	c.Revlog = nil
+}
+
+// MediaReferences returns the names of the media files the collection's notes
+// refer to, sorted and without duplicates
+func (c *Collection) MediaReferences() []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, n := range c.Notes {
+		for _, name := range MediaReferences(n.Flds) {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WritePackage writes col and the named media files as an .apkg to w. The
+// package uses the legacy collection.anki21 layout, which every Anki version
+// since 2.1 imports. read returns the content of a media file; it may return
+// ErrSkipMedia to leave the file out.
+func WritePackage(w io.Writer, col *Collection, media []string, read func(filename string) ([]byte, error)) error {
+	dir, err := os.MkdirTemp("", "anki-export-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "collection.anki21")
+	if err := WriteCollection(path, col); err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+	if err := addZipFile(zw, "collection.anki21", path); err != nil {
+		return err
+	}
+
+	mediaMap := map[string]string{}
+	for _, filename := range media {
+		data, err := read(filename)
+		if errors.Is(err, ErrSkipMedia) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		entry := strconv.Itoa(len(mediaMap))
+		fw, err := zw.Create(entry)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(data); err != nil {
+			return err
+		}
+		mediaMap[entry] = filename
+	}
+
+	fw, err := zw.Create("media")
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(fw).Encode(mediaMap); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func addZipFile(zw *zip.Writer, name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fw, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, f)
+	return err
+}
@@ -0,0 +1,173 @@
+package anki
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+
+	"github.com/magnusohle/openanki-backend/internal/database"
+)
+
+// exportFixture is the fixture collection with a subdeck of
+// Languages::Spanish holding one of its cards, and a deck whose name merely
+// starts like it
+func exportFixture(t *testing.T) *Collection {
+	t.Helper()
+	col, err := ReadCollection("testdata/collection11.anki2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	col.Decks = append(col.Decks,
+		database.SyncDeck{ID: 900, Name: "Languages::Spanish::Verbs", ConfigID: 1},
+		database.SyncDeck{ID: 901, Name: "Languages::Spanish Extra", ConfigID: 1},
+	)
+	col.Notes = append(col.Notes, database.SyncNote{ID: 1004, GUID: "extra", MID: 1700000000001, Flds: "a\x1fb"})
+	col.Cards = append(col.Cards, database.SyncCard{ID: 2004, NoteID: 1004, DeckID: 901})
+	for i := range col.Cards {
+		if col.Cards[i].ID == 1700000002002 {
+			col.Cards[i].DeckID = 900
+		}
+	}
+	return col
+}
+
+func cardIDs(cards []database.SyncCard) []int64 {
+	var ids []int64
+	for _, c := range cards {
+		ids = append(ids, c.ID)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func TestDeckSubset(t *testing.T) {
+	col := exportFixture(t)
+	sub, err := col.DeckSubset(1700000000100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decks []string
+	for _, d := range sub.Decks {
+		decks = append(decks, d.Name)
+	}
+	if want := []string{"Languages::Spanish", "Languages::Spanish::Verbs"}; !slices.Equal(decks, want) {
+		t.Errorf("decks = %q, want %q", decks, want)
+	}
+	if got, want := cardIDs(sub.Cards), []int64{1700000002001, 1700000002002}; !slices.Equal(got, want) {
+		t.Errorf("cards = %v, want %v", got, want)
+	}
+	var notes []int64
+	for _, n := range sub.Notes {
+		notes = append(notes, n.ID)
+	}
+	if want := []int64{1700000001001, 1700000001002}; !slices.Equal(notes, want) {
+		t.Errorf("notes = %v, want %v", notes, want)
+	}
+	if len(sub.NoteTypes) != 1 || sub.NoteTypes[0].Name != "Basic" {
+		t.Errorf("note types = %+v, want Basic only", sub.NoteTypes)
+	}
+	var configs []int64
+	for _, dc := range sub.DeckConfigs {
+		configs = append(configs, dc.ID)
+	}
+	slices.Sort(configs) // read from a JSON object, in no particular order
+	if want := []int64{1, 1700000000200}; !slices.Equal(configs, want) {
+		t.Errorf("deck configs = %v, want %v", configs, want)
+	}
+	if len(sub.Revlog) != 1 || sub.Revlog[0].CID != 1700000002001 {
+		t.Errorf("revlog = %+v, want the review of card 1700000002001 only", sub.Revlog)
+	}
+
+	if _, err := col.DeckSubset(12345); !errors.Is(err, ErrDeckNotFound) {
+		t.Errorf("unknown deck: err = %v, want ErrDeckNotFound", err)
+	}
+}
+
+func TestResetScheduling(t *testing.T) {
+	col := exportFixture(t)
+	col.Cards = append(col.Cards, database.SyncCard{ID: 2005, NoteID: 1700000001001, DeckID: 1, OriginalDeckID: 900, Ordinal: 1, Queue: 2, Due: 50, Interval: 3})
+	col.ResetScheduling()
+
+	if len(col.Revlog) != 0 {
+		t.Errorf("revlog kept %d entries", len(col.Revlog))
+	}
+	// Cards of one note share their due position, ordered by note
+	var dues []int64
+	for _, c := range col.Cards {
+		dues = append(dues, c.Due)
+		if c.Queue != 0 || c.Interval != 0 || c.Reps != 0 || c.OriginalDeckID != 0 {
+			t.Errorf("card %d still scheduled: %+v", c.ID, c)
+		}
+		if c.ID == 2005 && c.DeckID != 900 {
+			t.Errorf("filtered card moved to deck %d, want its home deck 900", c.DeckID)
+		}
+	}
+	if want := []int64{1, 2, 2, 3, 4}; !slices.Equal(dues, want) {
+		t.Errorf("dues = %v, want %v", dues, want)
+	}
+}
+
+func TestWritePackageRoundTrip(t *testing.T) {
+	col, err := exportFixture(t).DeckSubset(1700000000100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{"cat.jpg": "meow"}
+	read := func(name string) ([]byte, error) {
+		data, ok := files[name]
+		if !ok {
+			return nil, ErrSkipMedia
+		}
+		return []byte(data), nil
+	}
+
+	var buf bytes.Buffer
+	if err := WritePackage(&buf, col, []string{"cat.jpg", "missing.png"}, read); err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := ReadPackage(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := pkg.Collection
+	if got.Crt != col.Crt {
+		t.Errorf("crt = %d, want %d", got.Crt, col.Crt)
+	}
+	if !slices.Equal(cardIDs(got.Cards), cardIDs(col.Cards)) || len(got.Notes) != len(col.Notes) || len(got.Revlog) != len(col.Revlog) {
+		t.Errorf("package has cards %v, %d notes, %d reviews; want cards %v, %d notes, %d reviews",
+			cardIDs(got.Cards), len(got.Notes), len(got.Revlog), cardIDs(col.Cards), len(col.Notes), len(col.Revlog))
+	}
+	var decks []string
+	for _, d := range got.Decks {
+		decks = append(decks, d.Name)
+	}
+	if !slices.Contains(decks, "Languages::Spanish::Verbs") {
+		t.Errorf("decks = %q, want the subdeck", decks)
+	}
+
+	// The skipped file is left out
+	if len(pkg.Media) != 1 || pkg.Media[0].Filename != "cat.jpg" {
+		t.Fatalf("media = %+v, want cat.jpg only", pkg.Media)
+	}
+	rc, err := pkg.Media[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if data, err := io.ReadAll(rc); err != nil || string(data) != "meow" {
+		t.Errorf("cat.jpg = %q, %v", data, err)
+	}
+}
+
+func TestWritePackageReadError(t *testing.T) {
+	col := exportFixture(t)
+	fail := errors.New("storage down")
+	err := WritePackage(io.Discard, col, []string{"cat.jpg"}, func(string) ([]byte, error) { return nil, fail })
+	if !errors.Is(err, fail) {
+		t.Errorf("err = %v, want the read error", err)
+	}
+}
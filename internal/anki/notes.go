@@ -0,0 +1,63 @@
+package anki
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// FieldSeparator separates the fields in a note's flds column
+const FieldSeparator = "\x1f"
+
+var (
+	htmlTagRe = regexp.MustCompile(`(?s)<!--.*?-->|<[^>]*>`)
+	mediaRe   = regexp.MustCompile(`(?i)<img[^>]*\bsrc=["']?([^"' >]+)[^>]*>`)
+)
+
+// StripHTML removes markup from a field, keeping image file names as Anki
+// does for sort fields and checksums
+func StripHTML(field string) string {
+	field = mediaRe.ReplaceAllString(field, " $1 ")
+	field = htmlTagRe.ReplaceAllString(field, "")
+	return strings.TrimSpace(html.UnescapeString(field))
+}
+
+// SortFieldAndChecksum computes the sfld and csum columns of a note from its
+// fields. csum is the first 32 bits of the SHA-1 of the stripped first field.
+func SortFieldAndChecksum(flds string, sortIdx int) (string, int64) {
+	fields := strings.Split(flds, FieldSeparator)
+	sort := ""
+	if sortIdx >= 0 && sortIdx < len(fields) {
+		sort = StripHTML(fields[sortIdx])
+	}
+	sum := sha1.Sum([]byte(StripHTML(fields[0])))
+	return sort, int64(binary.BigEndian.Uint32(sum[:4]))
+}
+
+var mediaRefRe = regexp.MustCompile(`(?i)<(?:img|audio|video|source)\b[^>]*\bsrc=(?:"([^"]+)"|'([^']+)'|([^"' >]+))|\[sound:([^\]]+)\]`)
+
+// MediaReferences returns the media file names referenced by a note's fields.
+// Names in src attributes are percent-decoded, as Anki encodes them there;
+// [sound:] names are taken as written.
+func MediaReferences(flds string) []string {
+	var names []string
+	for _, m := range mediaRefRe.FindAllStringSubmatch(flds, -1) {
+		for i, name := range m[1:] {
+			if name == "" {
+				continue
+			}
+			name = html.UnescapeString(name)
+			if i < 3 {
+				if decoded, err := url.PathUnescape(name); err == nil {
+					name = decoded
+				}
+			}
+			names = append(names, name)
+			break
+		}
+	}
+	return names
+}
@@ -0,0 +1,26 @@
+package anki
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestMediaReferences(t *testing.T) {
+	tests := []struct {
+		flds string
+		want []string
+	}{
+		{`<img src="cat.png">`, []string{"cat.png"}},
+		{`<img src="my%20pic.png">`, []string{"my pic.png"}},
+		{`<img src='a&amp;b.png'>` + FieldSeparator + `<img src=c.jpg>`, []string{"a&b.png", "c.jpg"}},
+		{`<img src="100%.png">`, []string{"100%.png"}},
+		{`[sound:my%20song.mp3]`, []string{"my%20song.mp3"}},
+		{`<audio src="x.mp3"></audio><video><source src="y.mp4"></video>`, []string{"x.mp3", "y.mp4"}},
+		{`no media`, nil},
+	}
+	for _, tt := range tests {
+		if got := MediaReferences(tt.flds); !slices.Equal(got, tt.want) {
+			t.Errorf("MediaReferences(%q) = %q, want %q", tt.flds, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,376 @@
+package anki
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+
+	"github.com/magnusohle/openanki-backend/internal/database"
+)
+
+// Schema 11 is the JSON representation of note types, decks and deck option
+// groups stored in the col table of older collection files and exchanged by
+// the sync protocol. The options of fields, templates and deck option groups
+// we do not model are kept in their Raw JSON; anything else we do not store
+// is written with Anki's defaults.
+
+// Int is an integer that may be encoded as a JSON number or string, as Anki
+// does for ids and some note columns
+type Int int64
+
+func (i *Int) UnmarshalJSON(b []byte) error {
+	b = bytes.Trim(b, `"`)
+	if len(b) == 0 || string(b) == "null" {
+		*i = 0
+		return nil
+	}
+	n, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		f, ferr := strconv.ParseFloat(string(b), 64)
+		if ferr != nil {
+			return err
+		}
+		n = int64(f)
+	}
+	*i = Int(n)
+	return nil
+}
+
+// NoteType is a schema 11 note type ("model")
+type NoteType struct {
+	ID        Int        `json:"id"`
+	Name      string     `json:"name"`
+	Type      int        `json:"type"`
+	Mod       int64      `json:"mod"`
+	USN       int        `json:"usn"`
+	SortField int        `json:"sortf"`
+	DeckID    *Int       `json:"did"`
+	Templates []Template `json:"tmpls"`
+	Fields    []Field    `json:"flds"`
+	CSS       string     `json:"css"`
+	LatexPre  string     `json:"latexPre"`
+	LatexPost string     `json:"latexPost"`
+	LatexSVG  bool       `json:"latexsvg"`
+	Req       []any      `json:"req"`
+	Tags      []string   `json:"tags"`
+	Vers      []any      `json:"vers"`
+}
+
+// Field is a schema 11 note type field
+type Field struct {
+	Name              string `json:"name"`
+	Ord               int    `json:"ord"`
+	Sticky            bool   `json:"sticky"`
+	RTL               bool   `json:"rtl"`
+	Font              string `json:"font"`
+	Size              int    `json:"size"`
+	Description       string `json:"description"`
+	PlainText         bool   `json:"plainText"`
+	Collapsed         bool   `json:"collapsed"`
+	ExcludeFromSearch bool   `json:"excludeFromSearch"`
+	Media             []any  `json:"media"`
+}
+
+// Template is a schema 11 card template
+type Template struct {
+	Name            string `json:"name"`
+	Ord             int    `json:"ord"`
+	Qfmt            string `json:"qfmt"`
+	Afmt            string `json:"afmt"`
+	BrowserQfmt     string `json:"bqfmt"`
+	BrowserAfmt     string `json:"bafmt"`
+	DeckID          *Int   `json:"did"`
+	BrowserFont     string `json:"bfont"`
+	BrowserFontSize int    `json:"bsize"`
+}
+
+// Deck is a schema 11 deck
+type Deck struct {
+	ID               Int    `json:"id"`
+	Name             string `json:"name"`
+	Mod              int64  `json:"mod"`
+	USN              int    `json:"usn"`
+	Desc             string `json:"desc"`
+	Dyn              int    `json:"dyn"`
+	Conf             Int    `json:"conf"`
+	Collapsed        bool   `json:"collapsed"`
+	BrowserCollapsed bool   `json:"browserCollapsed"`
+	NewToday         [2]int `json:"newToday"`
+	RevToday         [2]int `json:"revToday"`
+	LrnToday         [2]int `json:"lrnToday"`
+	TimeToday        [2]int `json:"timeToday"`
+	ExtendNew        int    `json:"extendNew"`
+	ExtendRev        int    `json:"extendRev"`
+}
+
+// DeckConfig is a schema 11 deck option group
+type DeckConfig struct {
+	ID               Int             `json:"id"`
+	Name             string          `json:"name"`
+	Mod              int64           `json:"mod"`
+	USN              int             `json:"usn"`
+	MaxTaken         int             `json:"maxTaken"`
+	Autoplay         bool            `json:"autoplay"`
+	Timer            int             `json:"timer"`
+	Replayq          bool            `json:"replayq"`
+	Dyn              bool            `json:"dyn"`
+	New              DeckConfigNew   `json:"new"`
+	Lapse            DeckConfigLapse `json:"lapse"`
+	Rev              DeckConfigRev   `json:"rev"`
+	DesiredRetention float64         `json:"desiredRetention"`
+	FSRSWeights      []float64       `json:"fsrsWeights"`
+	FSRSParams5      []float64       `json:"fsrsParams5,omitempty"`
+}
+
+type DeckConfigNew struct {
+	Bury          bool      `json:"bury"`
+	Delays        []float64 `json:"delays"`
+	InitialFactor int       `json:"initialFactor"`
+	Ints          []int     `json:"ints"`
+	Order         int       `json:"order"`
+	PerDay        int       `json:"perDay"`
+}
+
+type DeckConfigLapse struct {
+	Delays      []float64 `json:"delays"`
+	LeechAction int       `json:"leechAction"`
+	LeechFails  int       `json:"leechFails"`
+	MinInt      int       `json:"minInt"`
+	Mult        float64   `json:"mult"`
+}
+
+type DeckConfigRev struct {
+	Bury       bool    `json:"bury"`
+	Ease4      float64 `json:"ease4"`
+	IvlFct     float64 `json:"ivlFct"`
+	MaxIvl     int     `json:"maxIvl"`
+	PerDay     int     `json:"perDay"`
+	HardFactor float64 `json:"hardFactor"`
+}
+
+const (
+	defaultLatexPre = "\\documentclass[12pt]{article}\n\\special{papersize=3in,5in}\n" +
+		"\\usepackage[utf8]{inputenc}\n\\usepackage{amssymb,amsmath}\n\\pagestyle{empty}\n" +
+		"\\setlength{\\parindent}{0in}\n\\begin{document}\n"
+	defaultLatexPost = "\\end{document}"
+)
+
+// ToSyncNoteType converts a schema 11 note type
+func ToSyncNoteType(nt *NoteType) database.SyncNoteType {
+	out := database.SyncNoteType{
+		ID:        int64(nt.ID),
+		Name:      nt.Name,
+		Type:      nt.Type,
+		Mod:       nt.Mod,
+		USN:       nt.USN,
+		SortField: nt.SortField,
+		CSS:       nt.CSS,
+		Fields:    make([]database.NoteTypeField, 0, len(nt.Fields)),
+		Templates: make([]database.NoteTypeTemplate, 0, len(nt.Templates)),
+	}
+	for _, f := range nt.Fields {
+		out.Fields = append(out.Fields, database.NoteTypeField{Name: f.Name, Ord: f.Ord, Raw: rawJSON(f)})
+	}
+	for _, t := range nt.Templates {
+		out.Templates = append(out.Templates, database.NoteTypeTemplate{
+			Name: t.Name,
+			Ord:  t.Ord,
+			Qfmt: t.Qfmt,
+			Afmt: t.Afmt,
+			Raw:  rawJSON(t),
+		})
+	}
+	return out
+}
+
+// FromSyncNoteType converts a note type to schema 11
+func FromSyncNoteType(nt *database.SyncNoteType) NoteType {
+	out := NoteType{
+		ID:        Int(nt.ID),
+		Name:      nt.Name,
+		Type:      nt.Type,
+		Mod:       nt.Mod,
+		USN:       nt.USN,
+		SortField: nt.SortField,
+		CSS:       nt.CSS,
+		LatexPre:  defaultLatexPre,
+		LatexPost: defaultLatexPost,
+		Templates: make([]Template, 0, len(nt.Templates)),
+		Fields:    make([]Field, 0, len(nt.Fields)),
+		Req:       []any{},
+		Tags:      []string{},
+		Vers:      []any{},
+	}
+	for _, f := range nt.Fields {
+		field := Field{Font: "Arial", Size: 20, Media: []any{}}
+		fromRawJSON(f.Raw, &field)
+		field.Name, field.Ord = f.Name, f.Ord
+		out.Fields = append(out.Fields, field)
+	}
+	for _, t := range nt.Templates {
+		var tmpl Template
+		fromRawJSON(t.Raw, &tmpl)
+		tmpl.Name, tmpl.Ord, tmpl.Qfmt, tmpl.Afmt = t.Name, t.Ord, t.Qfmt, t.Afmt
+		out.Templates = append(out.Templates, tmpl)
+	}
+	return out
+}
+
+// ToSyncDeck converts a schema 11 deck. Filtered decks are kept as regular
+// decks so their cards stay reachable from other clients.
+func ToSyncDeck(d *Deck) database.SyncDeck {
+	conf := int(d.Conf)
+	if conf == 0 {
+		conf = 1
+	}
+	return database.SyncDeck{
+		ID:          int64(d.ID),
+		Name:        d.Name,
+		Description: d.Desc,
+		ConfigID:    conf,
+		CreatedAt:   d.Mod,
+		ModifiedAt:  d.Mod,
+		USN:         d.USN,
+	}
+}
+
+// FromSyncDeck converts a deck to schema 11
+func FromSyncDeck(d *database.SyncDeck) Deck {
+	conf := d.ConfigID
+	if conf == 0 {
+		conf = 1
+	}
+	return Deck{
+		ID:   Int(d.ID),
+		Name: d.Name,
+		Mod:  d.ModifiedAt,
+		USN:  d.USN,
+		Desc: d.Description,
+		Conf: Int(conf),
+	}
+}
+
+// ToSyncDeckConfig converts a schema 11 deck option group
+func ToSyncDeckConfig(c *DeckConfig) database.SyncDeckConfig {
+	opts := database.DeckConfigOptions{
+		NewPerDay:        c.New.PerDay,
+		ReviewsPerDay:    c.Rev.PerDay,
+		LearnSteps:       c.New.Delays,
+		RelearnSteps:     c.Lapse.Delays,
+		MaximumInterval:  c.Rev.MaxIvl,
+		StartingEase:     c.New.InitialFactor,
+		LeechThreshold:   c.Lapse.LeechFails,
+		DesiredRetention: c.DesiredRetention,
+		FSRSWeights:      c.FSRSWeights,
+		Raw:              rawJSON(c, "id", "name", "mod", "usn"),
+	}
+	if len(c.FSRSParams5) > 0 {
+		opts.FSRSWeights = c.FSRSParams5
+	}
+	if len(c.New.Ints) > 0 {
+		opts.GraduatingInterval = c.New.Ints[0]
+	}
+	if len(c.New.Ints) > 1 {
+		opts.EasyInterval = c.New.Ints[1]
+	}
+	return database.SyncDeckConfig{
+		ID:     int64(c.ID),
+		Name:   c.Name,
+		Mod:    c.Mod,
+		USN:    c.USN,
+		Config: opts,
+	}
+}
+
+// FromSyncDeckConfig converts a deck option group to schema 11
+func FromSyncDeckConfig(c *database.SyncDeckConfig) DeckConfig {
+	o := c.Config
+	orEmpty := func(v []float64) []float64 {
+		if v == nil {
+			return []float64{}
+		}
+		return v
+	}
+	if o.StartingEase == 0 {
+		o.StartingEase = 2500
+	}
+	if o.MaximumInterval == 0 {
+		o.MaximumInterval = 36500
+	}
+	if o.DesiredRetention == 0 {
+		o.DesiredRetention = 0.9
+	}
+	out := DeckConfig{
+		MaxTaken: 60,
+		Autoplay: true,
+		Replayq:  true,
+		New:      DeckConfigNew{Order: 1},
+		Lapse:    DeckConfigLapse{LeechAction: 1, MinInt: 1},
+		Rev:      DeckConfigRev{Ease4: 1.3, IvlFct: 1, HardFactor: 1.2},
+	}
+	fromRawJSON(o.Raw, &out)
+	out.ID, out.Name, out.Mod, out.USN = Int(c.ID), c.Name, c.Mod, c.USN
+	out.New.Delays = orEmpty(o.LearnSteps)
+	out.New.InitialFactor = o.StartingEase
+	ints := []int{o.GraduatingInterval, o.EasyInterval, 0}
+	if len(out.New.Ints) > 2 {
+		ints[2] = out.New.Ints[2]
+	}
+	out.New.Ints = ints
+	out.New.PerDay = o.NewPerDay
+	out.Lapse.Delays = orEmpty(o.RelearnSteps)
+	out.Lapse.LeechFails = o.LeechThreshold
+	out.Rev.MaxIvl = o.MaximumInterval
+	out.Rev.PerDay = o.ReviewsPerDay
+	out.DesiredRetention = o.DesiredRetention
+	out.FSRSWeights = orEmpty(o.FSRSWeights)
+	// The weights we store replace both versions Anki keeps
+	out.FSRSParams5 = nil
+	return out
+}
+
+// rawJSON encodes a schema 11 object to keep as the Raw of the object it was
+// converted to, without the keys the latter has columns for
+func rawJSON(v any, drop ...string) json.RawMessage {
+	b, err := json.Marshal(v)
+	if err != nil || len(drop) == 0 {
+		return b
+	}
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(b, &m); err != nil {
+		return b
+	}
+	for _, key := range drop {
+		delete(m, key)
+	}
+	b, _ = json.Marshal(m)
+	return b
+}
+
+// fromRawJSON decodes the Raw kept by rawJSON over the defaults in v. Raw
+// written by other clients may not be schema 11 and is then ignored.
+func fromRawJSON(raw json.RawMessage, v any) {
+	if len(raw) > 0 {
+		json.Unmarshal(raw, v)
+	}
+}
+
+// decodeJSONMap decodes a col column holding a JSON object keyed by id
+func decodeJSONMap[T any](data string) ([]T, error) {
+	if data == "" {
+		return nil, nil
+	}
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(data), &m); err != nil {
+		return nil, err
+	}
+	out := make([]T, 0, len(m))
+	for _, raw := range m {
+		var v T
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
@@ -0,0 +1,149 @@
+package anki
+
+import (
+	"cmp"
+	"encoding/json"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/magnusohle/openanki-backend/internal/database"
+)
+
+func TestIntUnmarshal(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Int
+		wantErr bool
+	}{
+		{`1700000000001`, 1700000000001, false},
+		{`"1700000000001"`, 1700000000001, false},
+		{`1.7e12`, 1700000000000, false},
+		{`null`, 0, false},
+		{`""`, 0, false},
+		{`"abc"`, 0, true},
+	}
+	for _, tt := range tests {
+		var got Int
+		err := json.Unmarshal([]byte(tt.in), &got)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v", tt.in, err)
+		}
+		if err == nil && got != tt.want {
+			t.Errorf("%s = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNoteTypeRoundTrip(t *testing.T) {
+	in := NoteType{
+		ID: 5, Name: "Basic", Mod: 100, USN: 3, SortField: 1, CSS: ".card {}",
+		Fields: []Field{
+			{Name: "Front", Ord: 0, Sticky: true, Font: "Liberation Sans", Size: 28, Media: []any{}},
+			{Name: "Back", Ord: 1, RTL: true, Description: "answer", Font: "Arial", Size: 20, Media: []any{}},
+		},
+		Templates: []Template{{Name: "Card 1", Qfmt: "{{Front}}", Afmt: "{{Back}}", BrowserQfmt: "Q", BrowserFontSize: 12}},
+	}
+	synced := ToSyncNoteType(&in)
+
+	// The sync payload carries the options through JSON
+	b, err := json.Marshal(synced)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded database.SyncNoteType
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	out := FromSyncNoteType(&decoded)
+
+	if !reflect.DeepEqual(out.Fields, in.Fields) {
+		t.Errorf("fields = %+v, want %+v", out.Fields, in.Fields)
+	}
+	if !reflect.DeepEqual(out.Templates, in.Templates) {
+		t.Errorf("templates = %+v, want %+v", out.Templates, in.Templates)
+	}
+	if out.ID != in.ID || out.SortField != in.SortField || out.CSS != in.CSS || out.LatexPre != defaultLatexPre {
+		t.Errorf("note type = %+v", out)
+	}
+}
+
+func TestFromSyncNoteTypeDefaults(t *testing.T) {
+	out := FromSyncNoteType(&database.SyncNoteType{
+		Fields:    []database.NoteTypeField{{Name: "Front"}},
+		Templates: []database.NoteTypeTemplate{{Name: "Card 1", Qfmt: "{{Front}}"}},
+	})
+	want := Field{Name: "Front", Font: "Arial", Size: 20, Media: []any{}}
+	if !reflect.DeepEqual(out.Fields[0], want) {
+		t.Errorf("field = %+v, want %+v", out.Fields[0], want)
+	}
+	if out.Req == nil || out.Tags == nil || out.Vers == nil {
+		t.Errorf("note type has null lists: %+v", out)
+	}
+}
+
+func TestDeckConfigRoundTrip(t *testing.T) {
+	in := DeckConfig{
+		ID: 7, Name: "Spanish", Mod: 100, MaxTaken: 30, Timer: 1,
+		New:              DeckConfigNew{Bury: true, Delays: []float64{1, 10}, InitialFactor: 2300, Ints: []int{2, 5, 7}, PerDay: 15},
+		Lapse:            DeckConfigLapse{Delays: []float64{10}, LeechFails: 6, MinInt: 2, Mult: 0.5},
+		Rev:              DeckConfigRev{Bury: true, Ease4: 1.4, IvlFct: 1, MaxIvl: 3650, PerDay: 150, HardFactor: 1.1},
+		DesiredRetention: 0.85,
+		FSRSWeights:      []float64{1, 2},
+		FSRSParams5:      []float64{3, 4},
+	}
+	synced := ToSyncDeckConfig(&in)
+	if o := synced.Config; o.GraduatingInterval != 2 || o.EasyInterval != 5 || !slices.Equal(o.FSRSWeights, []float64{3, 4}) {
+		t.Errorf("synced options = %+v", o)
+	}
+
+	b, err := json.Marshal(synced)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded database.SyncDeckConfig
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	out := FromSyncDeckConfig(&decoded)
+
+	want := in
+	// FSRS 5 parameters replace the older ones and are written as such
+	want.FSRSWeights, want.FSRSParams5 = []float64{3, 4}, nil
+	if !reflect.DeepEqual(out, want) {
+		t.Errorf("round trip = %+v\nwant %+v", out, want)
+	}
+}
+
+func TestFromSyncDeckConfigDefaults(t *testing.T) {
+	out := FromSyncDeckConfig(&database.SyncDeckConfig{ID: 1, Name: "Default"})
+	if out.New.InitialFactor != 2500 || out.Rev.MaxIvl != 36500 || out.DesiredRetention != 0.9 {
+		t.Errorf("defaults = %+v", out)
+	}
+	if out.New.Delays == nil || out.Lapse.Delays == nil || out.FSRSWeights == nil {
+		t.Errorf("deck config has null lists: %+v", out)
+	}
+	if !slices.Equal(out.New.Ints, []int{0, 0, 0}) {
+		t.Errorf("ints = %v", out.New.Ints)
+	}
+}
+
+func TestDecodeJSONMap(t *testing.T) {
+	decks, err := decodeJSONMap[Deck](`{"1": {"id": 1, "name": "Default"}, "2": {"id": "2", "name": "B", "conf": "3"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.SortFunc(decks, func(a, b Deck) int { return cmp.Compare(a.ID, b.ID) })
+	if len(decks) != 2 || decks[1].ID != 2 || decks[1].Conf != 3 {
+		t.Errorf("decks = %+v", decks)
+	}
+	if got := ToSyncDeck(&decks[0]); got.ConfigID != 1 {
+		t.Errorf("deck without conf has config %d, want 1", got.ConfigID)
+	}
+	if decks, err := decodeJSONMap[Deck](""); err != nil || decks != nil {
+		t.Errorf("empty column = %v, %v", decks, err)
+	}
+	if _, err := decodeJSONMap[Deck](`{"1": []}`); err == nil {
+		t.Error("malformed deck decoded")
+	}
+}
@@ -0,0 +1,286 @@
+package anki
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/magnusohle/openanki-backend/internal/database"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Schema 18 collections keep note types, decks and deck option groups in
+// their own tables, with settings encoded as protobuf messages (see Anki's
+// proto/anki/notetypes.proto, decks.proto and deck_config.proto). Only the
+// fields the sync tables store are decoded.
+
+// Field numbers of the messages we read
+const (
+	notetypeConfigKind      = 1
+	notetypeConfigSortField = 2
+	notetypeConfigCSS       = 3
+
+	templateConfigQfmt = 1
+	templateConfigAfmt = 2
+
+	deckKindNormal          = 1
+	deckNormalConfigID      = 1
+	deckNormalDescription   = 4
+	deckConfigLearnSteps    = 1
+	deckConfigRelearnSteps  = 2
+	deckConfigFSRSParams4   = 3
+	deckConfigFSRSParams5   = 5
+	deckConfigNewPerDay     = 9
+	deckConfigReviewsPerDay = 10
+	deckConfigInitialEase   = 11
+	deckConfigMaxInterval   = 16
+	deckConfigGraduating    = 18
+	deckConfigEasyInterval  = 19
+	deckConfigLeechFails    = 22
+	deckConfigRetention     = 37
+)
+
+func readSchema18(db *sql.DB, col *Collection) error {
+	if err := readNoteTypes18(db, col); err != nil {
+		return fmt.Errorf("failed to read note types: %w", err)
+	}
+	if err := readDecks18(db, col); err != nil {
+		return fmt.Errorf("failed to read decks: %w", err)
+	}
+	if err := readDeckConfigs18(db, col); err != nil {
+		return fmt.Errorf("failed to read deck options: %w", err)
+	}
+	return nil
+}
+
+func readNoteTypes18(db *sql.DB, col *Collection) error {
+	rows, err := db.Query(`SELECT id, name, mtime_secs, usn, config FROM notetypes ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	index := map[int64]int{}
+	for rows.Next() {
+		var nt database.SyncNoteType
+		var config []byte
+		if err := rows.Scan(&nt.ID, &nt.Name, &nt.Mod, &nt.USN, &config); err != nil {
+			rows.Close()
+			return err
+		}
+		err := walkProto(config, func(num protowire.Number, v uint64, b []byte) {
+			switch num {
+			case notetypeConfigKind:
+				nt.Type = int(v)
+			case notetypeConfigSortField:
+				nt.SortField = int(v)
+			case notetypeConfigCSS:
+				nt.CSS = string(b)
+			}
+		})
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("note type %d: %w", nt.ID, err)
+		}
+		nt.Fields = []database.NoteTypeField{}
+		nt.Templates = []database.NoteTypeTemplate{}
+		index[nt.ID] = len(col.NoteTypes)
+		col.NoteTypes = append(col.NoteTypes, nt)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	fields, err := db.Query(`SELECT ntid, ord, name FROM fields ORDER BY ntid, ord`)
+	if err != nil {
+		return err
+	}
+	defer fields.Close()
+	for fields.Next() {
+		var ntid int64
+		var f database.NoteTypeField
+		if err := fields.Scan(&ntid, &f.Ord, &f.Name); err != nil {
+			return err
+		}
+		if i, ok := index[ntid]; ok {
+			col.NoteTypes[i].Fields = append(col.NoteTypes[i].Fields, f)
+		}
+	}
+	if err := fields.Err(); err != nil {
+		return err
+	}
+
+	templates, err := db.Query(`SELECT ntid, ord, name, config FROM templates ORDER BY ntid, ord`)
+	if err != nil {
+		return err
+	}
+	defer templates.Close()
+	for templates.Next() {
+		var ntid int64
+		var t database.NoteTypeTemplate
+		var config []byte
+		if err := templates.Scan(&ntid, &t.Ord, &t.Name, &config); err != nil {
+			return err
+		}
+		err := walkProto(config, func(num protowire.Number, _ uint64, b []byte) {
+			switch num {
+			case templateConfigQfmt:
+				t.Qfmt = string(b)
+			case templateConfigAfmt:
+				t.Afmt = string(b)
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("template %d/%d: %w", ntid, t.Ord, err)
+		}
+		if i, ok := index[ntid]; ok {
+			col.NoteTypes[i].Templates = append(col.NoteTypes[i].Templates, t)
+		}
+	}
+	return templates.Err()
+}
+
+func readDecks18(db *sql.DB, col *Collection) error {
+	rows, err := db.Query(`SELECT id, name, mtime_secs, usn, kind FROM decks`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d database.SyncDeck
+		var kind []byte
+		if err := rows.Scan(&d.ID, &d.Name, &d.ModifiedAt, &d.USN, &kind); err != nil {
+			return err
+		}
+		// Schema 18 separates deck name components with 0x1f
+		d.Name = strings.ReplaceAll(d.Name, "\x1f", "::")
+		d.CreatedAt = d.ModifiedAt
+		d.ConfigID = 1
+		err := walkProto(kind, func(num protowire.Number, _ uint64, b []byte) {
+			if num != deckKindNormal {
+				return
+			}
+			walkProto(b, func(num protowire.Number, v uint64, b []byte) {
+				switch num {
+				case deckNormalConfigID:
+					d.ConfigID = int(v)
+				case deckNormalDescription:
+					d.Description = string(b)
+				}
+			})
+		})
+		if err != nil {
+			return fmt.Errorf("deck %d: %w", d.ID, err)
+		}
+		col.Decks = append(col.Decks, d)
+	}
+	return rows.Err()
+}
+
+func readDeckConfigs18(db *sql.DB, col *Collection) error {
+	rows, err := db.Query(`SELECT id, name, mtime_secs, usn, config FROM deck_config`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c database.SyncDeckConfig
+		var config []byte
+		if err := rows.Scan(&c.ID, &c.Name, &c.Mod, &c.USN, &config); err != nil {
+			return err
+		}
+		var params4, params5 []float64
+		o := &c.Config
+		err := walkProto(config, func(num protowire.Number, v uint64, b []byte) {
+			switch num {
+			case deckConfigLearnSteps:
+				o.LearnSteps = appendFloats(o.LearnSteps, v, b)
+			case deckConfigRelearnSteps:
+				o.RelearnSteps = appendFloats(o.RelearnSteps, v, b)
+			case deckConfigFSRSParams4:
+				params4 = appendFloats(params4, v, b)
+			case deckConfigFSRSParams5:
+				params5 = appendFloats(params5, v, b)
+			case deckConfigNewPerDay:
+				o.NewPerDay = int(v)
+			case deckConfigReviewsPerDay:
+				o.ReviewsPerDay = int(v)
+			case deckConfigInitialEase:
+				o.StartingEase = int(math.Round(float64(math.Float32frombits(uint32(v))) * 1000))
+			case deckConfigMaxInterval:
+				o.MaximumInterval = int(v)
+			case deckConfigGraduating:
+				o.GraduatingInterval = int(v)
+			case deckConfigEasyInterval:
+				o.EasyInterval = int(v)
+			case deckConfigLeechFails:
+				o.LeechThreshold = int(v)
+			case deckConfigRetention:
+				o.DesiredRetention = roundFloat32(math.Float32frombits(uint32(v)))
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("deck config %d: %w", c.ID, err)
+		}
+		o.FSRSWeights = params4
+		if len(params5) > 0 {
+			o.FSRSWeights = params5
+		}
+		col.DeckConfigs = append(col.DeckConfigs, c)
+	}
+	return rows.Err()
+}
+
+// walkProto calls fn for every field of a protobuf message with the value of
+// varint and fixed32/64 fields in v and the payload of length-delimited ones
+// in b
+func walkProto(msg []byte, fn func(num protowire.Number, v uint64, b []byte)) error {
+	for len(msg) > 0 {
+		num, typ, n := protowire.ConsumeTag(msg)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		msg = msg[n:]
+
+		var v uint64
+		var b []byte
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(msg)
+		case protowire.Fixed32Type:
+			var v32 uint32
+			v32, n = protowire.ConsumeFixed32(msg)
+			v = uint64(v32)
+		case protowire.Fixed64Type:
+			v, n = protowire.ConsumeFixed64(msg)
+		case protowire.BytesType:
+			b, n = protowire.ConsumeBytes(msg)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, msg)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		msg = msg[n:]
+		fn(num, v, b)
+	}
+	return nil
+}
+
+// appendFloats appends a repeated float field, which is either packed (b) or
+// a single fixed32 value (v)
+func appendFloats(dst []float64, v uint64, b []byte) []float64 {
+	if b == nil {
+		return append(dst, roundFloat32(math.Float32frombits(uint32(v))))
+	}
+	for ; len(b) >= 4; b = b[4:] {
+		dst = append(dst, roundFloat32(math.Float32frombits(binary.LittleEndian.Uint32(b))))
+	}
+	return dst
+}
+
+// roundFloat32 widens f without float32 noise (0.9 instead of 0.899999976)
+func roundFloat32(f float32) float64 {
+	return math.Round(float64(f)*1e6) / 1e6
+}
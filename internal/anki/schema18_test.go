@@ -0,0 +1,79 @@
+package anki
+
+import (
+	"encoding/binary"
+	"math"
+	"slices"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestWalkProto(t *testing.T) {
+	var msg []byte
+	msg = protowire.AppendTag(msg, 1, protowire.VarintType)
+	msg = protowire.AppendVarint(msg, 300)
+	msg = protowire.AppendTag(msg, 2, protowire.BytesType)
+	msg = protowire.AppendString(msg, "css")
+	msg = protowire.AppendTag(msg, 3, protowire.Fixed32Type)
+	msg = protowire.AppendFixed32(msg, math.Float32bits(0.9))
+	msg = protowire.AppendTag(msg, 4, protowire.Fixed64Type)
+	msg = protowire.AppendFixed64(msg, 7)
+	// Groups are skipped
+	msg = protowire.AppendTag(msg, 5, protowire.StartGroupType)
+	msg = protowire.AppendTag(msg, 5, protowire.EndGroupType)
+
+	var nums []protowire.Number
+	err := walkProto(msg, func(num protowire.Number, v uint64, b []byte) {
+		nums = append(nums, num)
+		switch num {
+		case 1:
+			if v != 300 {
+				t.Errorf("varint = %d", v)
+			}
+		case 2:
+			if string(b) != "css" {
+				t.Errorf("bytes = %q", b)
+			}
+		case 3:
+			if got := roundFloat32(math.Float32frombits(uint32(v))); got != 0.9 {
+				t.Errorf("fixed32 = %v", got)
+			}
+		case 4:
+			if v != 7 {
+				t.Errorf("fixed64 = %d", v)
+			}
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(nums, []protowire.Number{1, 2, 3, 4, 5}) {
+		t.Errorf("fields = %v", nums)
+	}
+
+	for _, bad := range [][]byte{msg[:len(msg)-1], {0x0a, 0x05, 'a'}, {0x08}} {
+		if err := walkProto(bad, func(protowire.Number, uint64, []byte) {}); err == nil {
+			t.Errorf("%x: no error", bad)
+		}
+	}
+}
+
+func TestAppendFloats(t *testing.T) {
+	packed := func(fs ...float32) []byte {
+		var b []byte
+		for _, f := range fs {
+			b = binary.LittleEndian.AppendUint32(b, math.Float32bits(f))
+		}
+		return b
+	}
+	got := appendFloats(nil, 0, packed(1, 10, 1.5))
+	got = appendFloats(got, uint64(math.Float32bits(0.1)), nil)
+	if want := []float64{1, 10, 1.5, 0.1}; !slices.Equal(got, want) {
+		t.Errorf("floats = %v, want %v", got, want)
+	}
+	// A trailing partial value is dropped
+	if got := appendFloats(nil, 0, append(packed(2), 0, 0)); !slices.Equal(got, []float64{2}) {
+		t.Errorf("floats = %v", got)
+	}
+}
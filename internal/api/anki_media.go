@@ -0,0 +1,293 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/magnusohle/openanki-backend/internal/database"
+	"github.com/magnusohle/openanki-backend/internal/media"
+)
+
+// Media sync of the Anki protocol. Files are stored like UploadMedia does,
+// keyed by the SHA-1 of their content.
+
+const (
+	// ankiMediaBatch is the number of changes returned per mediaChanges call
+	ankiMediaBatch = 250
+	// maxAnkiMediaFile caps a single file of an uploaded zip
+	maxAnkiMediaFile = 100 << 20
+)
+
+// ankiMediaResult wraps every media sync response
+type ankiMediaResult struct {
+	Data any    `json:"data"`
+	Err  string `json:"err"`
+}
+
+// MediaSync dispatches media sync requests
+func (h *AnkiSyncHandler) MediaSync(w http.ResponseWriter, r *http.Request) {
+	hdr, body, ok := readAnkiRequest(w, r, maxAnkiRequestBytes)
+	if !ok {
+		return
+	}
+	userID, ok := h.authenticate(w, hdr)
+	if !ok {
+		return
+	}
+
+	switch chi.URLParam(r, "method") {
+	case "begin":
+		h.mediaBegin(w, userID, hdr)
+	case "mediaChanges":
+		h.mediaChanges(w, userID, body)
+	case "uploadChanges":
+		h.uploadMediaChanges(w, userID, body)
+	case "downloadFiles":
+		h.downloadMediaFiles(w, userID, body)
+	case "mediaSanity":
+		h.mediaSanity(w, userID, body)
+	default:
+		http.Error(w, "Unknown sync method", http.StatusNotFound)
+	}
+}
+
+// mediaBegin returns the media USN. The host key doubles as the media
+// session key.
+func (h *AnkiSyncHandler) mediaBegin(w http.ResponseWriter, userID int, hdr ankiHeader) {
+	if !h.requireSubscription(w, userID) {
+		return
+	}
+	usn, err := h.Repo.GetMediaUSN(userID)
+	if err != nil {
+		log.Printf("❌ Error GetMediaUSN: %v", err)
+		http.Error(w, "Failed to start media sync", http.StatusInternalServerError)
+		return
+	}
+	writeAnkiJSON(w, ankiMediaResult{Data: map[string]any{"sk": hdr.Key, "usn": usn}})
+}
+
+type ankiMediaChangesRequest struct {
+	LastUSN int `json:"lastUsn"`
+}
+
+// mediaChanges lists [filename, usn, sha1] of files changed or deleted after
+// lastUsn
+func (h *AnkiSyncHandler) mediaChanges(w http.ResponseWriter, userID int, body []byte) {
+	var req ankiMediaChangesRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	changes, err := h.Repo.GetMediaSince(userID, req.LastUSN, ankiMediaBatch)
+	if err != nil {
+		log.Printf("❌ Error GetMediaSince: %v", err)
+		http.Error(w, "Failed to list media", http.StatusInternalServerError)
+		return
+	}
+	out := make([][]any, 0, len(changes))
+	for _, c := range changes {
+		// Deletions are sent with an empty checksum
+		sha1 := c.Hash
+		if c.Deleted {
+			sha1 = ""
+		}
+		out = append(out, []any{c.Filename, c.USN, sha1})
+	}
+	writeAnkiJSON(w, ankiMediaResult{Data: out})
+}
+
+// uploadMediaChanges stores a zip of added files and applies deletions. The
+// zip's "_meta" entry lists [filename, zip entry] pairs, with a null entry
+// for deleted files.
+func (h *AnkiSyncHandler) uploadMediaChanges(w http.ResponseWriter, userID int, body []byte) {
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		http.Error(w, "Invalid zip file", http.StatusBadRequest)
+		return
+	}
+	files := map[string]*zip.File{}
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	metaFile := files["_meta"]
+	if metaFile == nil {
+		http.Error(w, "Missing _meta", http.StatusBadRequest)
+		return
+	}
+	var entries [][2]*string
+	if err := readZipJSON(metaFile, &entries); err != nil {
+		http.Error(w, "Invalid _meta", http.StatusBadRequest)
+		return
+	}
+
+	var added int64
+	for _, entry := range entries {
+		if entry[1] != nil && files[*entry[1]] != nil {
+			added += int64(files[*entry[1]].UncompressedSize64)
+		}
+	}
+	if !checkQuota(w, h.Repo, userID, added) {
+		return
+	}
+
+	processed := 0
+	usn := 0
+	for _, entry := range entries {
+		if entry[0] == nil || *entry[0] == "" {
+			continue
+		}
+		filename := *entry[0]
+		if entry[1] == nil {
+			usn, err = h.Repo.DeleteMedia(userID, filename)
+		} else {
+			f := files[*entry[1]]
+			if f == nil {
+				http.Error(w, "Missing file "+*entry[1], http.StatusBadRequest)
+				return
+			}
+			usn, err = h.storeMediaFile(userID, filename, f)
+		}
+		if err != nil {
+			log.Printf("❌ Error storing media %q: %v", filename, err)
+			http.Error(w, "Failed to store media", http.StatusInternalServerError)
+			return
+		}
+		processed++
+	}
+
+	if processed == 0 {
+		if usn, err = h.Repo.GetMediaUSN(userID); err != nil {
+			http.Error(w, "Failed to store media", http.StatusInternalServerError)
+			return
+		}
+	}
+	writeAnkiJSON(w, ankiMediaResult{Data: []int{processed, usn}})
+}
+
+func (h *AnkiSyncHandler) storeMediaFile(userID int, filename string, f *zip.File) (int, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, maxAnkiMediaFile+1))
+	if err != nil {
+		return 0, err
+	}
+	if len(data) > maxAnkiMediaFile {
+		return 0, errAnkiBodyTooLarge
+	}
+
+	sum := sha1.Sum(data)
+	blob, err := media.StoreContent(context.Background(), h.Repo, h.Store, data)
+	if err != nil {
+		return 0, err
+	}
+	file := database.MediaFile{
+		Filename: filename,
+		Hash:     hex.EncodeToString(sum[:]),
+		Size:     int64(len(data)),
+		Blob:     blob,
+	}
+	usn, err := h.Repo.PutMedia(userID, file)
+	if err != nil {
+		return 0, err
+	}
+	h.Transcoder.Enqueue(userID, file)
+	return usn, nil
+}
+
+type ankiDownloadFilesRequest struct {
+	Files []string `json:"files"`
+}
+
+// downloadMediaFiles sends the requested files as a zip whose "_meta" entry
+// maps zip entries to file names
+func (h *AnkiSyncHandler) downloadMediaFiles(w http.ResponseWriter, userID int, body []byte) {
+	var req ankiDownloadFilesRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	meta := map[string]string{}
+	for i, filename := range req.Files {
+		file, err := h.Repo.GetMediaFile(userID, filename)
+		if errors.Is(err, database.ErrMediaNotFound) {
+			continue
+		}
+		if err != nil {
+			http.Error(w, "Failed to read media", http.StatusInternalServerError)
+			return
+		}
+		data, err := h.Store.Get(context.Background(), media.FileKey(userID, file))
+		if err != nil {
+			log.Printf("⚠️ Media %q of user %d is missing from storage: %v", filename, userID, err)
+			continue
+		}
+		name := strconv.Itoa(i)
+		fw, err := zw.Create(name)
+		if err != nil {
+			http.Error(w, "Failed to build zip", http.StatusInternalServerError)
+			return
+		}
+		fw.Write(data)
+		meta[name] = filename
+	}
+	fw, err := zw.Create("_meta")
+	if err == nil {
+		err = json.NewEncoder(fw).Encode(meta)
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		http.Error(w, "Failed to build zip", http.StatusInternalServerError)
+		return
+	}
+	writeAnki(w, buf.Bytes())
+}
+
+type ankiMediaSanityRequest struct {
+	Local int `json:"local"`
+}
+
+// mediaSanity compares file counts. On "FAILED" the client resyncs all media.
+func (h *AnkiSyncHandler) mediaSanity(w http.ResponseWriter, userID int, body []byte) {
+	var req ankiMediaSanityRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	count, err := h.Repo.CountMedia(userID)
+	if err != nil {
+		http.Error(w, "Failed to count media", http.StatusInternalServerError)
+		return
+	}
+	status := "OK"
+	if count != req.Local {
+		status = "FAILED"
+	}
+	writeAnkiJSON(w, ankiMediaResult{Data: status})
+}
+
+func readZipJSON(f *zip.File, v any) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return json.NewDecoder(rc).Decode(v)
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/magnusohle/openanki-backend/internal/anki"
+	"github.com/magnusohle/openanki-backend/internal/database"
+)
+
+// Wire formats of the Anki sync protocol. Records travel as JSON arrays in
+// the column order of Anki's tables, with USNs shifted by one (see
+// AnkiSyncHandler).
+
+// ankiGraves lists deleted objects by type
+type ankiGraves struct {
+	Cards []anki.Int `json:"cards"`
+	Notes []anki.Int `json:"notes"`
+	Decks []anki.Int `json:"decks"`
+}
+
+func (g *ankiGraves) toSync() []database.SyncGrave {
+	var out []database.SyncGrave
+	for _, id := range g.Cards {
+		out = append(out, database.SyncGrave{OID: int64(id), Type: 0})
+	}
+	for _, id := range g.Notes {
+		out = append(out, database.SyncGrave{OID: int64(id), Type: 1})
+	}
+	for _, id := range g.Decks {
+		out = append(out, database.SyncGrave{OID: int64(id), Type: 2})
+	}
+	return out
+}
+
+// toAnkiGraves converts graves, dropping note type and deck config graves
+// which Anki has no counterpart for
+func toAnkiGraves(graves []database.SyncGrave) ankiGraves {
+	out := ankiGraves{Cards: []anki.Int{}, Notes: []anki.Int{}, Decks: []anki.Int{}}
+	for _, g := range graves {
+		switch g.Type {
+		case 0:
+			out.Cards = append(out.Cards, anki.Int(g.OID))
+		case 1:
+			out.Notes = append(out.Notes, anki.Int(g.OID))
+		case 2:
+			out.Decks = append(out.Decks, anki.Int(g.OID))
+		}
+	}
+	return out
+}
+
+// ankiNote is [id, guid, mid, mod, usn, tags, flds, sfld, csum, flags, data]
+type ankiNote database.SyncNote
+
+func (n ankiNote) MarshalJSON() ([]byte, error) {
+	// Current clients recompute sfld and csum and expect them empty
+	return json.Marshal([]any{n.ID, n.GUID, n.MID, n.Mod, n.USN + 1, n.Tags, n.Flds, "", "", n.Flags, n.Data})
+}
+
+func (n *ankiNote) UnmarshalJSON(b []byte) error {
+	var usn int
+	var sfld json.RawMessage
+	var csum anki.Int
+	if err := unmarshalTuple(b, &n.ID, &n.GUID, &n.MID, &n.Mod, &usn, &n.Tags, &n.Flds, &sfld, &csum, &n.Flags, &n.Data); err != nil {
+		return err
+	}
+	n.Sfld, n.Csum = anki.SortFieldAndChecksum(n.Flds, 0)
+	var s string
+	if json.Unmarshal(sfld, &s) == nil && s != "" {
+		n.Sfld = s
+	}
+	if csum != 0 {
+		n.Csum = int64(csum)
+	}
+	return nil
+}
+
+// ankiCard is [id, nid, did, ord, mod, usn, type, queue, due, ivl, factor,
+// reps, lapses, left, odue, odid, flags, data]
+type ankiCard database.SyncCard
+
+func (c ankiCard) MarshalJSON() ([]byte, error) {
+	card := database.SyncCard(c)
+	return json.Marshal([]any{c.ID, c.NoteID, c.DeckID, c.Ordinal, c.ModifiedAt, c.USN + 1, c.State, c.Queue,
+		c.Due, c.Interval, c.EaseFactor, c.Reps, c.Lapses, c.LeftCount, c.OriginalDue, c.OriginalDeckID,
+		c.Flags, anki.CardData(&card)})
+}
+
+func (c *ankiCard) UnmarshalJSON(b []byte) error {
+	var usn int
+	err := unmarshalTuple(b, &c.ID, &c.NoteID, &c.DeckID, &c.Ordinal, &c.ModifiedAt, &usn, &c.State, &c.Queue,
+		&c.Due, &c.Interval, &c.EaseFactor, &c.Reps, &c.Lapses, &c.LeftCount, &c.OriginalDue, &c.OriginalDeckID,
+		&c.Flags, &c.Data)
+	if err != nil {
+		return err
+	}
+	stability, difficulty := anki.MemoryState(c.Data)
+	c.Stability, c.Difficulty = &stability, &difficulty
+	return nil
+}
+
+// ankiRevlog is [id, cid, usn, ease, ivl, lastIvl, factor, time, type]
+type ankiRevlog database.SyncRevlog
+
+func (e ankiRevlog) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]any{e.ID, e.CID, e.USN + 1, e.Ease, e.Ivl, e.LastIvl, e.Factor, e.Time, e.Type})
+}
+
+func (e *ankiRevlog) UnmarshalJSON(b []byte) error {
+	var usn int
+	return unmarshalTuple(b, &e.ID, &e.CID, &usn, &e.Ease, &e.Ivl, &e.LastIvl, &e.Factor, &e.Time, &e.Type)
+}
+
+// unmarshalTuple decodes a JSON array element by element into dst
+func unmarshalTuple(b []byte, dst ...any) error {
+	var items []json.RawMessage
+	if err := json.Unmarshal(b, &items); err != nil {
+		return err
+	}
+	if len(items) < len(dst) {
+		return fmt.Errorf("expected %d columns, got %d", len(dst), len(items))
+	}
+	for i, d := range dst {
+		if err := json.Unmarshal(items[i], d); err != nil {
+			return fmt.Errorf("column %d: %w", i, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,772 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/klauspost/compress/zstd"
+	"github.com/magnusohle/openanki-backend/internal/anki"
+	"github.com/magnusohle/openanki-backend/internal/database"
+	"github.com/magnusohle/openanki-backend/internal/media"
+)
+
+// AnkiSyncHandler speaks the sync protocol of Anki desktop (version 11 and
+// later), so the same account can be synced from Anki by setting
+// "<server>/anki/" as its custom sync server. Everything is mapped onto the
+// tables used by /api/v1/sync; an Anki USN is our USN + 1.
+type AnkiSyncHandler struct {
+	Repo  *database.Repository
+	Store media.BlobStore
+	// Transcoder makes variants of uploaded media, nil when disabled
+	Transcoder *media.Transcoder
+	// ConflictPolicy controls client changes to objects changed on the
+	// server since the client's last sync. With ConflictReject the sync
+	// fails, keeping what its earlier requests applied, and the user has to
+	// resolve it with a one-way (full) sync.
+	ConflictPolicy database.ConflictPolicy
+
+	mu       sync.Mutex
+	sessions map[int]*ankiSession
+}
+
+// ankiSession is the state of a normal (incremental) sync between the start
+// and finish requests
+type ankiSession struct {
+	// mu is held by the request working on the session, see session
+	mu      sync.Mutex
+	key     string             // client session key
+	since   int                // newest USN the client had seen
+	until   int                // newest USN sent to the client
+	usn     int                // USN client changes are stamped with
+	pending *database.PullPage // unchunked changes for applyChanges
+	cursor  string             // position of the next chunk
+}
+
+const (
+	// ankiSyncVersion is the oldest protocol version accepted
+	ankiSyncVersion = 11
+	// ankiChunkSize is the number of records sent per chunk
+	ankiChunkSize = 250
+	// maxAnkiRequestBytes caps decompressed request bodies
+	maxAnkiRequestBytes = 100 << 20
+	// maxAnkiUploadBytes caps full collection uploads
+	maxAnkiUploadBytes = 300 << 20
+)
+
+// errAnkiBodyTooLarge is returned when a request exceeds its size limit
+var errAnkiBodyTooLarge = errors.New("request body too large")
+
+// ankiHeader is the JSON "anki-sync" request header
+type ankiHeader struct {
+	Version int    `json:"v"`
+	Key     string `json:"k"`
+	Client  string `json:"c"`
+	Session string `json:"s"`
+}
+
+func RegisterAnkiSyncRoutes(r chi.Router, repo *database.Repository, store media.BlobStore, transcoder *media.Transcoder) {
+	handler := &AnkiSyncHandler{
+		Repo:           repo,
+		Store:          store,
+		Transcoder:     transcoder,
+		ConflictPolicy: database.ParseConflictPolicy(os.Getenv("SYNC_CONFLICT_POLICY")),
+		sessions:       map[int]*ankiSession{},
+	}
+	// Host keys are checked by the handlers, so clients are told apart by IP
+	r.Use(rateLimit("anki"))
+	r.Post("/sync/{method}", handler.Sync)
+	r.Post("/msync/{method}", handler.MediaSync)
+}
+
+// Sync dispatches collection sync requests
+func (h *AnkiSyncHandler) Sync(w http.ResponseWriter, r *http.Request) {
+	method := chi.URLParam(r, "method")
+	limit := int64(maxAnkiRequestBytes)
+	if method == "upload" {
+		limit = maxAnkiUploadBytes
+	}
+	hdr, body, ok := readAnkiRequest(w, r, limit)
+	if !ok {
+		return
+	}
+
+	if method == "hostKey" {
+		h.hostKey(w, r, body)
+		return
+	}
+	userID, ok := h.authenticate(w, hdr)
+	if !ok {
+		return
+	}
+
+	switch method {
+	case "meta":
+		h.meta(w, userID)
+	case "start":
+		h.start(w, userID, hdr, body)
+	case "applyGraves":
+		h.applyGraves(w, userID, hdr, body)
+	case "applyChanges":
+		h.applyChanges(w, userID, hdr, body)
+	case "chunk":
+		h.chunk(w, userID, hdr)
+	case "applyChunk":
+		h.applyChunk(w, userID, hdr, body)
+	case "sanityCheck2":
+		h.sanityCheck(w, userID, body)
+	case "finish":
+		h.finish(w, userID, hdr)
+	case "abort":
+		h.endSession(userID)
+		writeAnkiJSON(w, nil)
+	case "upload":
+		h.upload(w, userID, body)
+	case "download":
+		h.download(w, userID)
+	default:
+		http.Error(w, "Unknown sync method", http.StatusNotFound)
+	}
+}
+
+type ankiHostKeyRequest struct {
+	Username string `json:"u"`
+	Password string `json:"p"`
+}
+
+// hostKey logs a client in with the account's email and password
+func (h *AnkiSyncHandler) hostKey(w http.ResponseWriter, r *http.Request, body []byte) {
+	var req ankiHostKeyRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Same limits as /auth/login, which takes the same password
+	attempt := loginLimit.begin(w, r, req.Username)
+	if attempt == nil {
+		return
+	}
+	user, err := database.GetUserByEmail(req.Username)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil || !checkPassword(user, req.Password) {
+		attempt.failed()
+		http.Error(w, "Invalid credentials", http.StatusForbidden)
+		return
+	}
+	attempt.succeeded()
+	if !checkEmailVerified(w, user.ID, "sync") {
+		return
+	}
+
+	key, err := h.Repo.CreateHostKey(user.ID)
+	if err != nil {
+		log.Printf("❌ Error CreateHostKey: %v", err)
+		http.Error(w, "Failed to log in", http.StatusInternalServerError)
+		return
+	}
+	writeAnkiJSON(w, map[string]string{"key": key})
+}
+
+type ankiMeta struct {
+	Mod      int64  `json:"mod"`
+	Scm      int64  `json:"scm"`
+	USN      int    `json:"usn"`
+	TS       int64  `json:"ts"`
+	Msg      string `json:"msg"`
+	Cont     bool   `json:"cont"`
+	HostNum  int    `json:"hostNum"`
+	Empty    bool   `json:"empty"`
+	Username string `json:"uname,omitempty"`
+}
+
+// meta reports the collection state the client uses to pick between a
+// normal and a full sync
+func (h *AnkiSyncHandler) meta(w http.ResponseWriter, userID int) {
+	user, err := database.GetUserByID(userID)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusInternalServerError)
+		return
+	}
+	state, err := h.Repo.GetCollectionState(userID)
+	if err != nil {
+		log.Printf("❌ Error GetCollectionState: %v", err)
+		http.Error(w, "Failed to get sync meta", http.StatusInternalServerError)
+		return
+	}
+
+	meta := ankiMeta{
+		Mod:      state.Mod,
+		Scm:      state.Scm,
+		USN:      state.USN + 1,
+		TS:       time.Now().Unix(),
+		Cont:     true,
+		Empty:    state.Empty,
+		Username: user.Email,
+	}
+	if user.SubscriptionStatus == "free" {
+		meta.Cont = false
+		meta.Msg = "Sync requires a subscription"
+	}
+	writeAnkiJSON(w, meta)
+}
+
+type ankiStartRequest struct {
+	MinUSN     int         `json:"minUsn"`
+	LocalNewer bool        `json:"lnewer"`
+	Graves     *ankiGraves `json:"graves"`
+}
+
+// start begins a normal sync and returns the server's graves
+func (h *AnkiSyncHandler) start(w http.ResponseWriter, userID int, hdr ankiHeader, body []byte) {
+	var req ankiStartRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !h.requireSubscription(w, userID) {
+		return
+	}
+
+	// Client changes get a USN of their own, so the chunks sent back below
+	// it never echo them
+	usn, err := h.Repo.IncrementUSN(userID)
+	if err != nil {
+		log.Printf("❌ Error IncrementUSN: %v", err)
+		http.Error(w, "Failed to start sync", http.StatusInternalServerError)
+		return
+	}
+	sess := &ankiSession{key: hdr.Session, since: max(req.MinUSN-1, 0), until: usn - 1, usn: usn}
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.since > sess.until {
+		sess.since = sess.until
+	}
+
+	sess.pending, err = h.Repo.PullSchemaChanges(userID, sess.since, sess.until)
+	if err != nil {
+		log.Printf("❌ Error PullSchemaChanges: %v", err)
+		http.Error(w, "Failed to start sync", http.StatusInternalServerError)
+		return
+	}
+	if req.Graves != nil && !h.apply(w, userID, sess, &database.SyncPayload{Graves: req.Graves.toSync()}) {
+		return
+	}
+	// Only a sync whose start succeeded can be continued
+	h.mu.Lock()
+	h.sessions[userID] = sess
+	h.mu.Unlock()
+	writeAnkiJSON(w, toAnkiGraves(sess.pending.Graves))
+}
+
+type ankiApplyGravesRequest struct {
+	Chunk ankiGraves `json:"chunk"`
+}
+
+func (h *AnkiSyncHandler) applyGraves(w http.ResponseWriter, userID int, hdr ankiHeader, body []byte) {
+	sess, ok := h.session(w, userID, hdr)
+	if !ok {
+		return
+	}
+	defer sess.mu.Unlock()
+	var req ankiApplyGravesRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if h.apply(w, userID, sess, &database.SyncPayload{Graves: req.Chunk.toSync()}) {
+		writeAnkiJSON(w, nil)
+	}
+}
+
+// ankiChanges are the objects a sync exchanges outside of chunks. Decks holds
+// the deck list and the deck option group list.
+type ankiChanges struct {
+	Models []anki.NoteType    `json:"models"`
+	Decks  [2]json.RawMessage `json:"decks"`
+	Tags   []string           `json:"tags"`
+}
+
+type ankiApplyChangesRequest struct {
+	Changes ankiChanges `json:"changes"`
+}
+
+// applyChanges stores the client's note types and decks and returns the
+// server's
+func (h *AnkiSyncHandler) applyChanges(w http.ResponseWriter, userID int, hdr ankiHeader, body []byte) {
+	sess, ok := h.session(w, userID, hdr)
+	if !ok {
+		return
+	}
+	defer sess.mu.Unlock()
+	var req ankiApplyChangesRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var decks []anki.Deck
+	var configs []anki.DeckConfig
+	if err := unmarshalOptional(req.Changes.Decks[0], &decks); err != nil {
+		http.Error(w, "Invalid decks", http.StatusBadRequest)
+		return
+	}
+	if err := unmarshalOptional(req.Changes.Decks[1], &configs); err != nil {
+		http.Error(w, "Invalid deck options", http.StatusBadRequest)
+		return
+	}
+
+	payload := &database.SyncPayload{}
+	for i := range req.Changes.Models {
+		payload.NoteTypes = append(payload.NoteTypes, anki.ToSyncNoteType(&req.Changes.Models[i]))
+	}
+	for i := range configs {
+		payload.DeckConfigs = append(payload.DeckConfigs, anki.ToSyncDeckConfig(&configs[i]))
+	}
+	for i := range decks {
+		payload.Decks = append(payload.Decks, anki.ToSyncDeck(&decks[i]))
+	}
+	if !h.apply(w, userID, sess, payload) {
+		return
+	}
+
+	page := sess.pending
+	models := make([]anki.NoteType, 0, len(page.NoteTypes))
+	for i := range page.NoteTypes {
+		nt := anki.FromSyncNoteType(&page.NoteTypes[i])
+		nt.USN++
+		models = append(models, nt)
+	}
+	outDecks := make([]anki.Deck, 0, len(page.Decks))
+	for i := range page.Decks {
+		d := anki.FromSyncDeck(&page.Decks[i])
+		d.USN++
+		outDecks = append(outDecks, d)
+	}
+	outConfigs := make([]anki.DeckConfig, 0, len(page.DeckConfigs))
+	for i := range page.DeckConfigs {
+		c := anki.FromSyncDeckConfig(&page.DeckConfigs[i])
+		c.USN++
+		outConfigs = append(outConfigs, c)
+	}
+	decksJSON, _ := json.Marshal(outDecks)
+	configsJSON, _ := json.Marshal(outConfigs)
+	writeAnkiJSON(w, ankiChanges{
+		Models: models,
+		Decks:  [2]json.RawMessage{decksJSON, configsJSON},
+		Tags:   []string{},
+	})
+}
+
+// ankiChunk is a batch of records. Records are JSON arrays in column order.
+type ankiChunk struct {
+	Done   bool         `json:"done"`
+	Revlog []ankiRevlog `json:"revlog,omitempty"`
+	Cards  []ankiCard   `json:"cards,omitempty"`
+	Notes  []ankiNote   `json:"notes,omitempty"`
+}
+
+// chunk sends the next batch of the server's notes, cards and review log
+func (h *AnkiSyncHandler) chunk(w http.ResponseWriter, userID int, hdr ankiHeader) {
+	sess, ok := h.session(w, userID, hdr)
+	if !ok {
+		return
+	}
+	defer sess.mu.Unlock()
+	page, err := h.Repo.PullRecords(userID, sess.since, sess.until, sess.cursor, ankiChunkSize)
+	if err != nil {
+		log.Printf("❌ Error PullRecords: %v", err)
+		http.Error(w, "Failed to read changes", http.StatusInternalServerError)
+		return
+	}
+	sess.cursor = page.Cursor
+
+	out := ankiChunk{Done: !page.HasMore}
+	for i := range page.Notes {
+		out.Notes = append(out.Notes, ankiNote(page.Notes[i]))
+	}
+	for i := range page.Cards {
+		out.Cards = append(out.Cards, ankiCard(page.Cards[i]))
+	}
+	for i := range page.Revlog {
+		out.Revlog = append(out.Revlog, ankiRevlog(page.Revlog[i]))
+	}
+	writeAnkiJSON(w, out)
+}
+
+type ankiApplyChunkRequest struct {
+	Chunk ankiChunk `json:"chunk"`
+}
+
+func (h *AnkiSyncHandler) applyChunk(w http.ResponseWriter, userID int, hdr ankiHeader, body []byte) {
+	sess, ok := h.session(w, userID, hdr)
+	if !ok {
+		return
+	}
+	defer sess.mu.Unlock()
+	var req ankiApplyChunkRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	payload := &database.SyncPayload{}
+	for _, n := range req.Chunk.Notes {
+		payload.Notes = append(payload.Notes, database.SyncNote(n))
+	}
+	for _, c := range req.Chunk.Cards {
+		payload.Cards = append(payload.Cards, database.SyncCard(c))
+	}
+	for _, e := range req.Chunk.Revlog {
+		payload.Revlog = append(payload.Revlog, database.SyncRevlog(e))
+	}
+	if h.apply(w, userID, sess, payload) {
+		writeAnkiJSON(w, nil)
+	}
+}
+
+type ankiSanityRequest struct {
+	Client json.RawMessage `json:"client"`
+}
+
+// sanityCheck compares the client's card and note counts with ours. A
+// mismatch makes the client ask for a full sync.
+func (h *AnkiSyncHandler) sanityCheck(w http.ResponseWriter, userID int, body []byte) {
+	var req ankiSanityRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	cards, notes, err := h.Repo.CountCollection(userID)
+	if err != nil {
+		log.Printf("❌ Error CountCollection: %v", err)
+		http.Error(w, "Failed to check collection", http.StatusInternalServerError)
+		return
+	}
+
+	status := "ok"
+	if clientCards, clientNotes, ok := parseSanityCounts(req.Client); ok && (clientCards != cards || clientNotes != notes) {
+		log.Printf("⚠️ Anki sanity check failed for user %d: client %d cards/%d notes, server %d/%d",
+			userID, clientCards, clientNotes, cards, notes)
+		status = "bad"
+	}
+	writeAnkiJSON(w, map[string]any{"status": status, "c": nil, "s": nil})
+}
+
+// parseSanityCounts extracts the card and note counts from the client's
+// sanity check counts, sent as [dueCounts, cards, notes, revlog, ...]
+func parseSanityCounts(raw json.RawMessage) (cards, notes int, ok bool) {
+	var list []json.RawMessage
+	if json.Unmarshal(raw, &list) == nil && len(list) >= 3 {
+		ok = json.Unmarshal(list[1], &cards) == nil && json.Unmarshal(list[2], &notes) == nil
+		return cards, notes, ok
+	}
+	var obj struct {
+		Cards *int `json:"cards"`
+		Notes *int `json:"notes"`
+	}
+	if json.Unmarshal(raw, &obj) == nil && obj.Cards != nil && obj.Notes != nil {
+		return *obj.Cards, *obj.Notes, true
+	}
+	return 0, 0, false
+}
+
+// finish ends a normal sync and returns the new collection modification time
+func (h *AnkiSyncHandler) finish(w http.ResponseWriter, userID int, hdr ankiHeader) {
+	sess, ok := h.session(w, userID, hdr)
+	if !ok {
+		return
+	}
+	defer sess.mu.Unlock()
+	mod, err := h.Repo.TouchCollection(userID)
+	if err != nil {
+		log.Printf("❌ Error TouchCollection: %v", err)
+		http.Error(w, "Failed to finish sync", http.StatusInternalServerError)
+		return
+	}
+	h.endSession(userID)
+	writeAnkiJSON(w, mod)
+}
+
+// upload replaces the user's collection with an uploaded collection file
+func (h *AnkiSyncHandler) upload(w http.ResponseWriter, userID int, body []byte) {
+	if !h.requireSubscription(w, userID) {
+		return
+	}
+	h.endSession(userID)
+
+	dir, err := os.MkdirTemp("", "anki-upload-")
+	if err != nil {
+		http.Error(w, "Failed to store upload", http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "collection.anki2")
+	if err := os.WriteFile(path, body, 0600); err != nil {
+		http.Error(w, "Failed to store upload", http.StatusInternalServerError)
+		return
+	}
+
+	col, err := anki.ReadCollection(path)
+	if err != nil {
+		log.Printf("❌ Error reading uploaded collection: %v", err)
+		http.Error(w, "Invalid collection file", http.StatusBadRequest)
+		return
+	}
+	if _, err := h.Repo.ReplaceAnkiCollection(userID, col.Payload(), col.Scm, col.Crt); err != nil {
+		log.Printf("❌ Error ReplaceAnkiCollection: %v", err)
+		http.Error(w, "Failed to replace collection", http.StatusInternalServerError)
+		return
+	}
+	if _, err := h.Repo.TouchCollection(userID); err != nil {
+		log.Printf("❌ Error TouchCollection: %v", err)
+	}
+	writeAnki(w, []byte("OK"))
+}
+
+// download sends the user's collection as a collection file. The file is
+// built a page of records at a time and streamed from disk.
+func (h *AnkiSyncHandler) download(w http.ResponseWriter, userID int) {
+	if !h.requireSubscription(w, userID) {
+		return
+	}
+	h.endSession(userID)
+
+	// Read before the records, so changes made during the download are
+	// newer than the USN the client is given and reach it on its next sync
+	state, err := h.Repo.GetCollectionState(userID)
+	if err != nil {
+		log.Printf("❌ Error GetCollectionState: %v", err)
+		http.Error(w, "Failed to read collection", http.StatusInternalServerError)
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "anki-download-")
+	if err != nil {
+		http.Error(w, "Failed to build collection", http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "collection.anki2")
+	if err := h.writeCollection(path, userID, state); err != nil {
+		log.Printf("❌ Error building collection for user %d: %v", userID, err)
+		http.Error(w, "Failed to build collection", http.StatusInternalServerError)
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		http.Error(w, "Failed to build collection", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, "Failed to build collection", http.StatusInternalServerError)
+		return
+	}
+	writeAnkiStream(w, f, info.Size())
+}
+
+// writeCollection writes the user's collection to a new file at path,
+// pulling it a page at a time
+func (h *AnkiSyncHandler) writeCollection(path string, userID int, state *database.CollectionState) error {
+	cw, err := anki.CreateCollection(path)
+	if err != nil {
+		return err
+	}
+	defer cw.Close()
+
+	col := &anki.Collection{Crt: state.Crt, Mod: state.Mod, Scm: state.Scm, USN: state.USN + 1}
+	cursor := ""
+	for {
+		page, err := h.Repo.PullChanges(userID, -1, cursor, maxPullLimit)
+		if err != nil {
+			return err
+		}
+		// Note types, decks and option groups go into the collection row
+		col.NoteTypes = append(col.NoteTypes, page.NoteTypes...)
+		col.DeckConfigs = append(col.DeckConfigs, page.DeckConfigs...)
+		col.Decks = append(col.Decks, page.Decks...)
+		err = cw.WriteRecords(&anki.Collection{Notes: page.Notes, Cards: page.Cards, Revlog: page.Revlog})
+		if err != nil {
+			return err
+		}
+		if !page.HasMore {
+			break
+		}
+		cursor = page.Cursor
+	}
+	return cw.Commit(col)
+}
+
+// apply stores client changes of a normal sync, writing an error on failure
+func (h *AnkiSyncHandler) apply(w http.ResponseWriter, userID int, sess *ankiSession, payload *database.SyncPayload) bool {
+	since := sess.since
+	payload.ClientUSN = &since
+	_, err := h.Repo.ApplyChanges(userID, payload, sess.usn, h.ConflictPolicy)
+	var conflictErr *database.ConflictError
+	if errors.As(err, &conflictErr) {
+		h.endSession(userID)
+		http.Error(w, "Changes conflict with newer changes on the server; sync with a one-way (full) sync", http.StatusConflict)
+		return false
+	}
+	if err != nil {
+		log.Printf("❌ Error ApplyChanges (anki): %v", err)
+		http.Error(w, "Failed to apply changes", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// session returns the user's sync in progress, which must have been started
+// by the same client session. The session is returned locked, so requests
+// sent in parallel take their steps one after the other; the caller unlocks
+// it when done.
+func (h *AnkiSyncHandler) session(w http.ResponseWriter, userID int, hdr ankiHeader) (*ankiSession, bool) {
+	h.mu.Lock()
+	sess := h.sessions[userID]
+	h.mu.Unlock()
+	if sess == nil || sess.key != hdr.Session {
+		http.Error(w, "No sync in progress", http.StatusConflict)
+		return nil, false
+	}
+	sess.mu.Lock()
+	// The sync may have finished or restarted while we waited
+	h.mu.Lock()
+	current := h.sessions[userID] == sess
+	h.mu.Unlock()
+	if !current {
+		sess.mu.Unlock()
+		http.Error(w, "No sync in progress", http.StatusConflict)
+		return nil, false
+	}
+	return sess, true
+}
+
+func (h *AnkiSyncHandler) endSession(userID int) {
+	h.mu.Lock()
+	delete(h.sessions, userID)
+	h.mu.Unlock()
+}
+
+// authenticate resolves the request's host key
+func (h *AnkiSyncHandler) authenticate(w http.ResponseWriter, hdr ankiHeader) (int, bool) {
+	userID, err := h.Repo.GetHostKeyUser(hdr.Key)
+	if errors.Is(err, database.ErrHostKeyNotFound) {
+		http.Error(w, "Invalid host key", http.StatusForbidden)
+		return 0, false
+	}
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return 0, false
+	}
+	// Host keys handed out before verification was required stop working too
+	if !checkEmailVerified(w, userID, "sync") {
+		return 0, false
+	}
+	return userID, true
+}
+
+// requireSubscription writes a 403 and returns false for users without a paid plan
+func (h *AnkiSyncHandler) requireSubscription(w http.ResponseWriter, userID int) bool {
+	user, err := database.GetUserByID(userID)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusInternalServerError)
+		return false
+	}
+	if user.SubscriptionStatus == "free" {
+		http.Error(w, "Sync requires a subscription", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// readAnkiRequest parses the anki-sync header and returns the decompressed
+// body, writing an error and returning false on failure
+func readAnkiRequest(w http.ResponseWriter, r *http.Request, limit int64) (ankiHeader, []byte, bool) {
+	var hdr ankiHeader
+	if err := json.Unmarshal([]byte(r.Header.Get("anki-sync")), &hdr); err != nil {
+		http.Error(w, "Missing anki-sync header", http.StatusBadRequest)
+		return hdr, nil, false
+	}
+	if hdr.Version < ankiSyncVersion {
+		http.Error(w, "Please update Anki to sync with this server", http.StatusNotImplemented)
+		return hdr, nil, false
+	}
+
+	zr, err := newZstdReader(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return hdr, nil, false
+	}
+	defer zr.Close()
+	body, err := io.ReadAll(io.LimitReader(zr, limit+1))
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) || int64(len(body)) > limit {
+		http.Error(w, errAnkiBodyTooLarge.Error(), http.StatusRequestEntityTooLarge)
+		return hdr, nil, false
+	}
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return hdr, nil, false
+	}
+	return hdr, body, true
+}
+
+// writeAnki sends a zstd compressed response body
+func writeAnki(w http.ResponseWriter, data []byte) {
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	defer enc.Close()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("anki-original-size", strconv.Itoa(len(data)))
+	w.Write(enc.EncodeAll(data, nil))
+}
+
+// writeAnkiStream sends size bytes read from r as a zstd compressed
+// response body
+func writeAnkiStream(w http.ResponseWriter, r io.Reader, size int64) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("anki-original-size", strconv.FormatInt(size, 10))
+	enc, err := zstd.NewWriter(w)
+	if err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	if _, err := io.Copy(enc, io.LimitReader(r, size)); err != nil {
+		log.Printf("⚠️ Failed to send collection: %v", err)
+	}
+	enc.Close()
+}
+
+func writeAnkiJSON(w http.ResponseWriter, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	writeAnki(w, data)
+}
+
+func unmarshalOptional(raw json.RawMessage, v any) error {
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return nil
+	}
+	return json.Unmarshal(raw, v)
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/magnusohle/openanki-backend/internal/anki"
+	"github.com/magnusohle/openanki-backend/internal/database"
+	"github.com/magnusohle/openanki-backend/internal/testutil"
+)
+
+func newTestAnkiSyncHandler(policy database.ConflictPolicy) *AnkiSyncHandler {
+	return &AnkiSyncHandler{Repo: testutil.Repo, ConflictPolicy: policy, sessions: map[int]*ankiSession{}}
+}
+
+// ankiRequest sends an Anki sync protocol request with a zstd compressed
+// JSON body
+func ankiRequest(t *testing.T, h *AnkiSyncHandler, key, method string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, _ := zstd.NewWriter(nil)
+	defer enc.Close()
+	req := httptest.NewRequest(http.MethodPost, "/sync/"+method, bytes.NewReader(enc.EncodeAll(data, nil)))
+	hdr, _ := json.Marshal(ankiHeader{Version: ankiSyncVersion, Key: key, Session: "s1"})
+	req.Header.Set("anki-sync", string(hdr))
+
+	r := chi.NewRouter()
+	r.Post("/sync/{method}", h.Sync)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// createAnkiUser adds a user who may sync and returns their host key
+func createAnkiUser(t *testing.T) (int, string) {
+	t.Helper()
+	userID := createSyncUser(t)
+	key, err := testutil.Repo.CreateHostKey(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return userID, key
+}
+
+func TestAnkiStartRegistersSessionOnlyAfterApplying(t *testing.T) {
+	for _, tt := range []struct {
+		policy database.ConflictPolicy
+		want   int
+	}{
+		{database.ConflictReject, http.StatusConflict},
+		{database.ConflictLastWriteWins, http.StatusOK},
+	} {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			h := newTestAnkiSyncHandler(tt.policy)
+			userID, key := createAnkiUser(t)
+			// A deck changed on the server after the client's last sync
+			deck := database.SyncDeck{ID: 10, Name: "Server", CreatedAt: 1, ModifiedAt: 1}
+			if _, err := testutil.Repo.PushSyncSafe(userID, &database.SyncPayload{Decks: []database.SyncDeck{deck}}, tt.policy); err != nil {
+				t.Fatal(err)
+			}
+
+			w := ankiRequest(t, h, key, "start", map[string]any{"minUsn": 1, "graves": map[string]any{"decks": []int64{10}}})
+			if w.Code != tt.want {
+				t.Fatalf("start: status %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			h.mu.Lock()
+			registered := h.sessions[userID] != nil
+			h.mu.Unlock()
+			if registered != (tt.want == http.StatusOK) {
+				t.Errorf("session registered = %v after status %d", registered, w.Code)
+			}
+			decks, err := testutil.Repo.GetDecksSince(userID, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if kept := len(decks) == 1; kept != (tt.policy == database.ConflictReject) {
+				t.Errorf("%s: decks = %+v", tt.policy, decks)
+			}
+		})
+	}
+}
+
+func TestAnkiDownload(t *testing.T) {
+	h := newTestAnkiSyncHandler(database.ConflictLastWriteWins)
+	userID, key := createAnkiUser(t)
+
+	// More review log entries than fit in one pull page
+	payload := &database.SyncPayload{
+		Decks: []database.SyncDeck{{ID: 10, Name: "Spanish", ConfigID: 1, CreatedAt: 1, ModifiedAt: 1}},
+		Notes: []database.SyncNote{{ID: 20, GUID: "g", MID: 30, Mod: 1, Flds: "ser\x1fto be", Sfld: "ser"}},
+		Cards: []database.SyncCard{{ID: 40, NoteID: 20, DeckID: 10, ModifiedAt: 1}},
+	}
+	for i := range maxPullLimit + 1 {
+		payload.Revlog = append(payload.Revlog, database.SyncRevlog{ID: int64(1700000000000 + i), CID: 40, Ease: 3, Type: 1})
+	}
+	if _, err := testutil.Repo.PushSyncSafe(userID, payload, database.ConflictLastWriteWins); err != nil {
+		t.Fatal(err)
+	}
+
+	w := ankiRequest(t, h, key, "download", map[string]any{})
+	if w.Code != http.StatusOK {
+		t.Fatalf("download: status %d: %s", w.Code, w.Body)
+	}
+	dec, err := zstd.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	data, err := io.ReadAll(dec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := w.Header().Get("anki-original-size"); size != strconv.Itoa(len(data)) {
+		t.Errorf("anki-original-size = %s, body has %d bytes", size, len(data))
+	}
+
+	path := filepath.Join(t.TempDir(), "collection.anki2")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	col, err := anki.ReadCollection(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := fmt.Sprintf("%d notes, %d cards, %d reviews", len(col.Notes), len(col.Cards), len(col.Revlog))
+	if want := fmt.Sprintf("1 notes, 1 cards, %d reviews", maxPullLimit+1); got != want {
+		t.Errorf("downloaded %s, want %s", got, want)
+	}
+	found := false
+	for _, d := range col.Decks {
+		found = found || d.Name == "Spanish"
+	}
+	if !found {
+		t.Errorf("decks = %+v, want Spanish", col.Decks)
+	}
+}
@@ -2,43 +2,58 @@ package api
 
 import (
     "encoding/json"
+    "errors"
+    "log"
     "net/http"
-    "crypto/sha256"
-    "encoding/hex"
+    "strings"
 
     "github.com/go-chi/chi/v5"
     "github.com/magnusohle/openanki-backend/internal/auth"
     "github.com/magnusohle/openanki-backend/internal/database"
     "github.com/magnusohle/openanki-backend/internal/mailer"
-    "math/rand"
-    "time"
+    "github.com/magnusohle/openanki-backend/internal/oidc"
 )
 
-// Simple hash implementation (replace with bcrypt in production if dependencies allow)
-func hashPassword(password string) string {
-    hash := sha256.Sum256([]byte(password))
-    return hex.EncodeToString(hash[:])
-}
-
-const charset = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789" // Exclude confusing chars 0,O,1,I
-
-func generateResetCode() string {
-    seededRand := rand.New(rand.NewSource(time.Now().UnixNano()))
-    b := make([]byte, 6)
-    for i := range b {
-        b[i] = charset[seededRand.Intn(len(charset))]
+// checkPassword reports whether password is the user's. A hash in the legacy
+// SHA-256 scheme (or with outdated parameters) is replaced by an argon2id one
+// on success, so old accounts upgrade as they sign in.
+func checkPassword(user *database.User, password string) bool {
+    ok, rehash := auth.VerifyPassword(password, user.PasswordHash)
+    if !ok {
+        return false
     }
-    return string(b)
+    if rehash {
+        hash, err := auth.HashPassword(password)
+        if err == nil {
+            err = database.UpdateUserPassword(user.Email, hash)
+        }
+        if err != nil {
+            // Still signed in; the upgrade is retried next time
+            log.Printf("⚠️ Failed to upgrade password hash of user %d: %v", user.ID, err)
+        } else {
+            user.PasswordHash = hash
+        }
+    }
+    return true
 }
 
-type AuthHandler struct{}
+type AuthHandler struct{
+    // Providers verify Sign in with Apple / Google tokens, by provider name
+    Providers map[string]*oidc.Provider
+}
 
 func RegisterAuthRoutes(r chi.Router) {
-    handler := &AuthHandler{}
+    handler := &AuthHandler{Providers: identityProviders()}
     r.Post("/register", handler.Register)
     r.Post("/login", handler.Login)
+    r.Post("/apple", handler.SignInWith("apple"))
+    r.Post("/google", handler.SignInWith("google"))
+    r.Post("/refresh", handler.Refresh)
+    r.Get("/jwks.json", handler.JWKS)
     r.Post("/forgot-password", handler.ForgotPassword)
     r.Post("/reset-password", handler.ResetPassword)
+    r.Post("/verify-email", handler.VerifyEmail)
+    r.With(auth.Middleware).Post("/resend-verification", handler.ResendVerification)
 }
 
 // ... existing Register/Login ...
@@ -58,13 +73,22 @@ func (h *AuthHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
         return
     }
 
+    // Limit how many emails one client or account can trigger
+    attempt := forgotLimit.begin(w, r, req.Email)
+    if attempt == nil {
+        return
+    }
+
     // Verify user exists
     user, err := database.GetUserByEmail(req.Email)
     if err != nil {
+        attempt.abandoned()
         http.Error(w, "Database error", http.StatusInternalServerError)
         return
     }
     if user == nil {
+        // Probing for accounts counts as a failure
+        attempt.failed()
         // Return OK to prevent email enumeration? 
         // User asked for "easiest", but security best practice is to lie.
         // But for UX, knowing it failed is helpful if they typod.
@@ -74,19 +98,29 @@ func (h *AuthHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    code := generateResetCode()
-    if err := database.SaveResetCode(req.Email, code); err != nil {
+    // Codes are kept under the address as the account stores it
+    code, err := generateCode()
+    if err != nil {
+        log.Printf("❌ Error generating reset code: %v", err)
+        attempt.abandoned()
+        http.Error(w, "Failed to save code", http.StatusInternalServerError)
+        return
+    }
+    if err := database.SaveResetCode(user.Email, code); err != nil {
+        attempt.abandoned()
         http.Error(w, "Failed to save code", http.StatusInternalServerError)
         return
     }
 
     // Send Email
-    if err := mailer.SendResetEmail(req.Email, code); err != nil {
+    if err := mailer.SendResetEmail(user.Email, code); err != nil {
         // Log error but don't fail request to client?
         // Or fail so they can retry.
+        attempt.abandoned()
         http.Error(w, "Failed to send email: "+err.Error(), http.StatusInternalServerError)
         return
     }
+    attempt.sent()
 
     w.WriteHeader(http.StatusOK)
     json.NewEncoder(w).Encode(map[string]string{"message": "Email sent"})
@@ -109,17 +143,59 @@ func (h *AuthHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    if !database.VerifyAndConsumeResetCode(req.Email, req.Code) {
+    attempt := resetLimit.begin(w, r, req.Email)
+    if attempt == nil {
+        return
+    }
+    user, err := database.GetUserByEmail(req.Email)
+    if err != nil {
+        http.Error(w, "Database error", http.StatusInternalServerError)
+        return
+    }
+    email := req.Email
+    if user != nil {
+        email = user.Email
+    }
+    valid, err := database.VerifyAndConsumeResetCode(email, req.Code)
+    if err != nil {
+        log.Printf("❌ Error checking reset code: %v", err)
+        http.Error(w, "Database error", http.StatusInternalServerError)
+        return
+    }
+    if !valid {
+        attempt.failed()
         http.Error(w, "Invalid or expired code", http.StatusBadRequest)
         return
     }
+    attempt.succeeded()
 
-    hashedPwd := hashPassword(req.NewPassword)
-    if err := database.UpdateUserPassword(req.Email, hashedPwd); err != nil {
+    hashedPwd, err := auth.HashPassword(req.NewPassword)
+    if err != nil {
+        http.Error(w, "Failed to update password", http.StatusInternalServerError)
+        return
+    }
+    if err := database.UpdateUserPassword(email, hashedPwd); err != nil {
         http.Error(w, "Failed to update password", http.StatusInternalServerError)
         return
     }
 
+    // Sign out every device that used the old password
+    if user == nil {
+        log.Printf("⚠️ No user %s to revoke the sessions of", req.Email)
+    } else {
+        if err := database.RevokeUserSessions(user.ID); err != nil {
+            log.Printf("⚠️ Failed to revoke sessions of user %d: %v", user.ID, err)
+        }
+        // The emailed reset code proves the address is theirs
+        if err := database.MarkEmailVerified(user.ID); err != nil {
+            log.Printf("⚠️ Failed to mark email of user %d verified: %v", user.ID, err)
+        }
+    }
+    // Sign-in attempts with the old password no longer count against them
+    if err := loginLimit.account.Reset(strings.ToLower(strings.TrimSpace(req.Email))); err != nil {
+        log.Printf("⚠️ Failed to reset sign-in attempts at %s: %v", req.Email, err)
+    }
+
     w.WriteHeader(http.StatusOK)
     json.NewEncoder(w).Encode(map[string]string{"message": "Password updated successfully"})
 }
@@ -128,6 +204,8 @@ type registerRequest struct {
     Email    string `json:"email"`
     Password string `json:"password"`
     Username string `json:"username"`
+    // DeviceName labels the session in the session list
+    DeviceName string `json:"device_name"`
 }
 
 func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
@@ -142,7 +220,11 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    hashedPwd := hashPassword(req.Password)
+    hashedPwd, err := auth.HashPassword(req.Password)
+    if err != nil {
+        http.Error(w, "Failed to create user", http.StatusInternalServerError)
+        return
+    }
     user, err := database.CreateUser(req.Email, hashedPwd, req.Username)
     if err != nil {
         // Simplified error handling
@@ -150,18 +232,19 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    token, _ := auth.GenerateToken(user.ID, user.Email)
-    
-    w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(map[string]interface{}{
-        "user": user,
-        "token": token,
-    })
+    // The account works without a verified email; the user can ask for
+    // another code if this one does not arrive
+    if err := sendVerificationCode(user); err != nil {
+        log.Printf("⚠️ Failed to send verification email to user %d: %v", user.ID, err)
+    }
+
+    writeNewSession(w, r, user, req.DeviceName)
 }
 
 type loginRequest struct {
-    Email    string `json:"email"`
-    Password string `json:"password"`
+    Email      string `json:"email"`
+    Password   string `json:"password"`
+    DeviceName string `json:"device_name"`
 }
 
 func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
@@ -171,26 +254,92 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
         return
     }
 
+    attempt := loginLimit.begin(w, r, req.Email)
+    if attempt == nil {
+        return
+    }
+
     user, err := database.GetUserByEmail(req.Email)
     if err != nil {
         http.Error(w, "Database error", http.StatusInternalServerError)
         return
     }
-    if user == nil {
+    if user == nil || !checkPassword(user, req.Password) {
+        attempt.failed()
         http.Error(w, "Invalid credentials", http.StatusUnauthorized)
         return
     }
+    attempt.succeeded()
 
-    if hashPassword(req.Password) != user.PasswordHash {
-        http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+    writeNewSession(w, r, user, req.DeviceName)
+}
+
+// maxDeviceName caps the stored device name of a session
+const maxDeviceName = 100
+
+// writeNewSession starts a session for the signed-in user and responds with
+// the user and the session's tokens. Without a device name the User-Agent
+// labels the session.
+func writeNewSession(w http.ResponseWriter, r *http.Request, user *database.User, deviceName string) {
+    if deviceName == "" {
+        deviceName = r.UserAgent()
+    }
+    if len(deviceName) > maxDeviceName {
+        deviceName = strings.ToValidUTF8(deviceName[:maxDeviceName], "")
+    }
+    tokens, err := auth.StartSession(user, deviceName)
+    if err != nil {
+        log.Printf("❌ Error StartSession: %v", err)
+        http.Error(w, "Failed to start session", http.StatusInternalServerError)
         return
     }
 
-    token, _ := auth.GenerateToken(user.ID, user.Email)
-
     w.Header().Set("Content-Type", "application/json")
     json.NewEncoder(w).Encode(map[string]interface{}{
-        "user": user,
-        "token": token,
+        "user":          user,
+        "token":         tokens.AccessToken,
+        "refresh_token": tokens.RefreshToken,
+        "expires_in":    tokens.ExpiresIn,
     })
 }
+
+// Refresh exchanges a refresh token for a new access token and refresh token.
+// Each refresh token works once; reusing one signs its session out.
+func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+    var req struct {
+        RefreshToken string `json:"refresh_token"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "Invalid request body", http.StatusBadRequest)
+        return
+    }
+
+    tokens, err := auth.RefreshSession(req.RefreshToken)
+    if errors.Is(err, auth.ErrInvalidRefreshToken) {
+        http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+        return
+    }
+    if err != nil {
+        log.Printf("❌ Error RefreshSession: %v", err)
+        http.Error(w, "Failed to refresh session", http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(tokens)
+}
+
+// JWKS publishes the public keys access tokens are signed with, for other
+// services verifying them. HS256 keys are never published.
+func (h *AuthHandler) JWKS(w http.ResponseWriter, r *http.Request) {
+    keys, err := auth.PublicJWKS()
+    if err != nil {
+        log.Printf("❌ Error loading JWT keys: %v", err)
+        http.Error(w, "Failed to load keys", http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Cache-Control", "public, max-age=300")
+    json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+}
@@ -0,0 +1,228 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Sync endpoints negotiate their wire format. JSON stays the default; clients
+// may send and accept MessagePack (same field names as the JSON schema) and
+// gzip or zstd compressed bodies.
+const (
+	contentTypeJSON    = "application/json"
+	contentTypeMsgpack = "application/msgpack"
+)
+
+// maxSyncBodyBytes caps push and full sync bodies, before and after
+// decompression
+const maxSyncBodyBytes = 64 << 20
+
+var (
+	// errUnsupportedEncoding is returned by decodeSyncBody for unknown
+	// Content-Type or Content-Encoding values
+	errUnsupportedEncoding = errors.New("unsupported content type or encoding")
+	// errBodyTooLarge is returned by decodeSyncBody for bodies that
+	// decompress to more than its limit
+	errBodyTooLarge = errors.New("request body too large")
+)
+
+// decodeSyncBody decodes a request body according to its Content-Type and
+// Content-Encoding headers. The body may be at most limit bytes, compressed
+// and decompressed.
+func decodeSyncBody(w http.ResponseWriter, r *http.Request, v any, limit int64) error {
+	body := io.Reader(http.MaxBytesReader(w, r.Body, limit))
+	switch strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))) {
+	case "", "identity":
+	case "gzip":
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		body = &capReader{r: zr, n: limit}
+	case "zstd":
+		zr, err := newZstdReader(body)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		body = &capReader{r: zr, n: limit}
+	default:
+		return errUnsupportedEncoding
+	}
+
+	switch mediaType(r.Header.Get("Content-Type")) {
+	case "", contentTypeJSON:
+		return json.NewDecoder(body).Decode(v)
+	case contentTypeMsgpack, "application/x-msgpack":
+		dec := msgpack.NewDecoder(body)
+		dec.SetCustomStructTag("json")
+		return dec.Decode(v)
+	default:
+		return errUnsupportedEncoding
+	}
+}
+
+// maxZstdWindow bounds the window a zstd frame from a client may ask for, so
+// the decoder's memory does not depend on the request. It is the window size
+// the format asks every decoder to support.
+const maxZstdWindow = 8 << 20
+
+// newZstdReader decompresses an untrusted zstd stream with bounded memory and
+// a single decoding goroutine
+func newZstdReader(r io.Reader) (*zstd.Decoder, error) {
+	return zstd.NewReader(r, zstd.WithDecoderMaxWindow(maxZstdWindow), zstd.WithDecoderConcurrency(1))
+}
+
+// capReader reads up to n bytes from r and fails with errBodyTooLarge when r
+// has more. Unlike io.LimitReader's EOF, decoders cannot take that for the
+// end of a valid body.
+type capReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *capReader) Read(p []byte) (int, error) {
+	if c.n <= 0 {
+		var b [1]byte
+		n, err := c.r.Read(b[:])
+		if n > 0 {
+			return 0, errBodyTooLarge
+		}
+		return 0, err
+	}
+	if int64(len(p)) > c.n {
+		p = p[:c.n]
+	}
+	n, err := c.r.Read(p)
+	c.n -= int64(n)
+	return n, err
+}
+
+// writeSyncResponse encodes v in the format and compression preferred by the
+// request's Accept and Accept-Encoding headers
+func writeSyncResponse(w http.ResponseWriter, r *http.Request, status int, v any) {
+	var buf bytes.Buffer
+	contentType := contentTypeJSON
+	if acceptsMsgpack(r.Header.Get("Accept")) {
+		contentType = contentTypeMsgpack
+		enc := msgpack.NewEncoder(&buf)
+		enc.SetCustomStructTag("json")
+		if err := enc.Encode(v); err != nil {
+			http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+			return
+		}
+	} else if err := json.NewEncoder(&buf).Encode(v); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Add("Vary", "Accept, Accept-Encoding")
+
+	var out io.WriteCloser
+	switch encoding := preferredEncoding(r.Header.Get("Accept-Encoding")); encoding {
+	case "zstd":
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			break
+		}
+		w.Header().Set("Content-Encoding", encoding)
+		out = zw
+	case "gzip":
+		w.Header().Set("Content-Encoding", encoding)
+		out = gzip.NewWriter(w)
+	}
+
+	w.WriteHeader(status)
+	if out == nil {
+		w.Write(buf.Bytes())
+		return
+	}
+	out.Write(buf.Bytes())
+	out.Close()
+}
+
+// writeDecodeError reports a decodeSyncBody failure
+func writeDecodeError(w http.ResponseWriter, err error) {
+	var maxErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, errUnsupportedEncoding):
+		http.Error(w, "Unsupported Content-Type or Content-Encoding", http.StatusUnsupportedMediaType)
+	case errors.As(err, &maxErr), errors.Is(err, errBodyTooLarge):
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+	default:
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	}
+}
+
+func mediaType(header string) string {
+	t, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(header))
+	}
+	return t
+}
+
+// acceptsMsgpack reports whether an Accept header prefers MessagePack to
+// JSON. MessagePack must be named; JSON is also accepted through wildcards and
+// wins ties.
+func acceptsMsgpack(accept string) bool {
+	q := acceptValues(accept)
+	msgpackQ := q[contentTypeMsgpack]
+	if x := q["application/x-msgpack"]; x > msgpackQ {
+		msgpackQ = x
+	}
+	jsonQ, ok := q[contentTypeJSON]
+	if !ok {
+		if jsonQ, ok = q["application/*"]; !ok {
+			jsonQ = q["*/*"]
+		}
+	}
+	return msgpackQ > 0 && msgpackQ > jsonQ
+}
+
+// preferredEncoding picks the zstd or gzip encoding with the highest q value
+// in an Accept-Encoding header, zstd on a tie. It returns "" when neither is
+// acceptable.
+func preferredEncoding(header string) string {
+	q := acceptValues(header)
+	switch {
+	case q["zstd"] > 0 && q["zstd"] >= q["gzip"]:
+		return "zstd"
+	case q["gzip"] > 0:
+		return "gzip"
+	}
+	return ""
+}
+
+// acceptValues maps the lowercased values of an Accept or Accept-Encoding
+// header to their q values (1 when absent). Entries that fail to parse or
+// have a q value outside [0, 1] are left out.
+func acceptValues(header string) map[string]float64 {
+	values := map[string]float64{}
+	for _, entry := range strings.Split(header, ",") {
+		value, params, err := mime.ParseMediaType(strings.TrimSpace(entry))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil || q < 0 || q > 1 {
+				continue
+			}
+		}
+		values[value] = q
+	}
+	return values
+}
@@ -0,0 +1,176 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func compress(t *testing.T, encoding string, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	switch encoding {
+	case "gzip":
+		zw := gzip.NewWriter(&buf)
+		zw.Write(data)
+		zw.Close()
+	case "zstd":
+		zw, err := zstd.NewWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zw.Write(data)
+		zw.Close()
+	default:
+		buf.Write(data)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeSyncBodyLimit(t *testing.T) {
+	const limit = 1024
+	// A JSON string of n bytes in total
+	body := func(n int) []byte {
+		return []byte(`"` + strings.Repeat("a", n-2) + `"`)
+	}
+	tests := []struct {
+		name     string
+		encoding string
+		size     int
+		wantErr  bool
+		want413  bool
+	}{
+		{"plain at limit", "", limit, false, false},
+		{"plain over limit", "", limit + 1, true, true},
+		{"gzip at limit", "gzip", limit, false, false},
+		{"gzip bomb", "gzip", 100 * limit, true, true},
+		{"zstd at limit", "zstd", limit, false, false},
+		{"zstd bomb", "zstd", 100 * limit, true, true},
+		{"unknown encoding", "br", 10, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/sync/push", bytes.NewReader(compress(t, tt.encoding, body(tt.size))))
+			if tt.encoding != "" {
+				r.Header.Set("Content-Encoding", tt.encoding)
+			}
+			w := httptest.NewRecorder()
+			var v string
+			err := decodeSyncBody(w, r, &v, limit)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil {
+				if len(v) != tt.size-2 {
+					t.Errorf("decoded %d bytes, want %d", len(v), tt.size-2)
+				}
+				return
+			}
+			writeDecodeError(w, err)
+			if got := w.Code == http.StatusRequestEntityTooLarge; got != tt.want413 {
+				t.Errorf("status = %d for %v", w.Code, err)
+			}
+		})
+	}
+}
+
+func TestCapReader(t *testing.T) {
+	r := &capReader{r: strings.NewReader("abcdef"), n: 4}
+	buf := make([]byte, 10)
+	n, err := r.Read(buf)
+	if n != 4 || err != nil {
+		t.Fatalf("first read = %d, %v", n, err)
+	}
+	if _, err := r.Read(buf); !errors.Is(err, errBodyTooLarge) {
+		t.Errorf("read past limit: err = %v, want errBodyTooLarge", err)
+	}
+}
+
+func TestPreferredEncoding(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"gzip, zstd", "zstd"},
+		{"zstd;q=0", ""},
+		{"zstd;q=0.0, gzip", "gzip"},
+		{"zstd; q=0.000, gzip;q=0.5", "gzip"},
+		{"zstd;q=0.5, gzip;q=0.8", "gzip"},
+		{"zstd;q=0.8, gzip;q=0.8", "zstd"},
+		{"GZIP;Q=1", "gzip"},
+		{"zstd;q=abc, gzip", "gzip"},
+		{"zstd;q=2, gzip", "gzip"},
+		{"br, deflate", ""},
+	}
+	for _, tt := range tests {
+		if got := preferredEncoding(tt.header); got != tt.want {
+			t.Errorf("preferredEncoding(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestAcceptsMsgpack(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"application/json", false},
+		{"application/msgpack", true},
+		{"application/x-msgpack, application/json;q=0.5", true},
+		{"application/msgpack;q=0.0", false},
+		{"application/*", false},
+		{"*/*", false},
+		{"application/json, application/msgpack;q=0.1", false},
+		{"application/msgpack, application/json", false},
+		{"application/msgpack, */*;q=0.1", true},
+		{"application/msgpack;q=0.5, application/*;q=0.8", false},
+		{"application/msgpack, application/json;q=0", true},
+	}
+	for _, tt := range tests {
+		if got := acceptsMsgpack(tt.header); got != tt.want {
+			t.Errorf("acceptsMsgpack(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+// zstdFrame is a frame holding data in one raw block, declaring a window of
+// 1<<log2Window bytes
+func zstdFrame(log2Window int, data []byte) []byte {
+	frame := []byte{0x28, 0xb5, 0x2f, 0xfd, 0, byte(log2Window-10) << 3}
+	header := uint32(len(data))<<3 | 1 // last raw block
+	frame = append(frame, byte(header), byte(header>>8), byte(header>>16))
+	return append(frame, data...)
+}
+
+func TestZstdReaderWindow(t *testing.T) {
+	for _, tt := range []struct {
+		log2Window int
+		wantErr    bool
+	}{
+		{23, false}, // maxZstdWindow
+		{26, true},
+	} {
+		zr, err := newZstdReader(bytes.NewReader(zstdFrame(tt.log2Window, []byte("payload"))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(zr)
+		zr.Close()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("window 1<<%d: err = %v, want error %v", tt.log2Window, err, tt.wantErr)
+		}
+		if err == nil && string(data) != "payload" {
+			t.Errorf("window 1<<%d: decoded %q", tt.log2Window, data)
+		}
+	}
+}
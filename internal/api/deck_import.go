@@ -0,0 +1,61 @@
+package api
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/magnusohle/openanki-backend/internal/anki"
+	"github.com/magnusohle/openanki-backend/internal/database"
+	"github.com/magnusohle/openanki-backend/internal/media"
+)
+
+// errInvalidDeckPackage is returned by importPackage for unreadable packages
+var errInvalidDeckPackage = errors.New("invalid deck package")
+
+// importPackage merges the .apkg of the given size read from r into the
+// user's collection. Media content is stored first; files are hashed with
+// SHA-1 like Anki media sync does.
+func importPackage(ctx context.Context, repo *database.Repository, store media.BlobStore, userID int, r io.ReaderAt, size int64) (*database.ImportResult, error) {
+	pkg, err := anki.ReadPackage(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidDeckPackage, err)
+	}
+
+	files := make([]database.MediaFile, 0, len(pkg.Media))
+	for i := range pkg.Media {
+		m := &pkg.Media[i]
+		file, err := storePackageMedia(ctx, repo, store, m)
+		if err != nil {
+			return nil, fmt.Errorf("media %q: %w", m.Filename, err)
+		}
+		files = append(files, file)
+	}
+
+	return repo.ImportCollection(userID, pkg.Collection.Payload(), files)
+}
+
+func storePackageMedia(ctx context.Context, repo *database.Repository, store media.BlobStore, m *anki.PackageMedia) (database.MediaFile, error) {
+	rc, err := m.Open()
+	if err != nil {
+		return database.MediaFile{}, err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, maxAnkiMediaFile+1))
+	if err != nil {
+		return database.MediaFile{}, err
+	}
+	if len(data) > maxAnkiMediaFile {
+		return database.MediaFile{}, fmt.Errorf("%w: media file too large", errInvalidDeckPackage)
+	}
+
+	sum := sha1.Sum(data)
+	blob, err := media.StoreContent(ctx, repo, store, data)
+	if err != nil {
+		return database.MediaFile{}, err
+	}
+	return database.MediaFile{Filename: m.Filename, Hash: hex.EncodeToString(sum[:]), Size: int64(len(data)), Blob: blob}, nil
+}
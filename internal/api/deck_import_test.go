@@ -0,0 +1,104 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/magnusohle/openanki-backend/internal/database"
+	"github.com/magnusohle/openanki-backend/internal/media"
+	"github.com/magnusohle/openanki-backend/internal/testutil"
+)
+
+// testPackage is a legacy .apkg of the anki fixture collection with media
+// files by name
+func testPackage(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	col, err := os.ReadFile("internal/anki/testdata/collection11.anki2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	write := func(name string, data []byte) {
+		fw, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(data)
+	}
+	write("collection.anki2", col)
+	mediaMap := map[string]string{}
+	for name, content := range files {
+		entry := strconv.Itoa(len(mediaMap))
+		mediaMap[entry] = name
+		write(entry, []byte(content))
+	}
+	b, err := json.Marshal(mediaMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write("media", b)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// importTestPackage imports the package in data
+func importTestPackage(h *SyncHandler, userID int, data []byte) (*database.ImportResult, error) {
+	return importPackage(context.Background(), h.Repo, h.Store, userID, bytes.NewReader(data), int64(len(data)))
+}
+
+func TestImportPackage(t *testing.T) {
+	h := newTestSyncHandler(t)
+	userID := testutil.CreateUser(t).ID
+
+	result, err := importTestPackage(h, userID, testPackage(t, map[string]string{"a.png": "image"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Notes != 3 || result.Cards != 3 || result.Media != 1 {
+		t.Errorf("result = %+v, want 3 notes, 3 cards and 1 media file", result)
+	}
+
+	file, err := testutil.Repo.GetMediaFile(userID, "a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha1.Sum([]byte("image"))
+	if file.Hash != hex.EncodeToString(sum[:]) {
+		t.Errorf("hash = %s, want the SHA-1 of the content", file.Hash)
+	}
+	data, err := h.Store.Get(context.Background(), media.FileKey(userID, file))
+	if err != nil || string(data) != "image" {
+		t.Errorf("stored media = %q, %v", data, err)
+	}
+}
+
+func TestImportPackageInvalid(t *testing.T) {
+	h := newTestSyncHandler(t)
+	userID := testutil.CreateUser(t).ID
+
+	for name, data := range map[string][]byte{
+		"not a zip": []byte("hello"),
+		"missing collection": func() []byte {
+			var buf bytes.Buffer
+			zw := zip.NewWriter(&buf)
+			zw.Create("media")
+			zw.Close()
+			return buf.Bytes()
+		}(),
+	} {
+		if _, err := importTestPackage(h, userID, data); !errors.Is(err, errInvalidDeckPackage) {
+			t.Errorf("%s: err = %v, want errInvalidDeckPackage", name, err)
+		}
+	}
+}
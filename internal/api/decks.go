@@ -4,23 +4,31 @@ import (
     "encoding/json"
     "fmt"
     "io"
+    "log"
     "net/http"
     "os"
-    "path/filepath"
+    "path"
     "strconv"
+    "strings"
     "time"
 
     "github.com/go-chi/chi/v5"
     "github.com/magnusohle/openanki-backend/internal/auth"
     "github.com/magnusohle/openanki-backend/internal/database"
+    "github.com/magnusohle/openanki-backend/internal/media"
 )
 
-type DecksHandler struct{}
+type DecksHandler struct {
+    Store media.BlobStore
+}
 
-func RegisterDecksRoutes(r chi.Router) {
-    handler := &DecksHandler{}
+func RegisterDecksRoutes(r chi.Router, store media.BlobStore) {
+    handler := &DecksHandler{Store: store}
     r.Group(func(r chi.Router) {
         r.Use(auth.Middleware)
+        // Decks are shared through groups
+        r.Use(rateLimit("groups"))
+        r.Use(requireVerifiedEmail("groups"))
         r.Post("/upload", handler.UploadDeck)
         r.Get("/group/{groupID}", handler.ListGroupDecks)
         r.Get("/{id}/download", handler.DownloadDeck)
@@ -63,22 +71,20 @@ func (h *DecksHandler) UploadDeck(w http.ResponseWriter, r *http.Request) {
     }
 
     // Save File
-    uploadDir := "./data/uploads"
-    os.MkdirAll(uploadDir, 0755)
-    
-    filename := fmt.Sprintf("%d_%d_%s", userID, time.Now().Unix(), handler.Filename)
-    filePath := filepath.Join(uploadDir, filename)
-    
-    dst, err := os.Create(filePath)
+    data, err := io.ReadAll(file)
     if err != nil {
+        http.Error(w, "Failed to read file", http.StatusBadRequest)
+        return
+    }
+    key := fmt.Sprintf("uploads/%d_%d_%s", userID, time.Now().Unix(), path.Base(handler.Filename))
+    if err := h.Store.Put(r.Context(), key, data); err != nil {
+        log.Printf("❌ Error storing deck: %v", err)
         http.Error(w, "Failed to save file", http.StatusInternalServerError)
         return
     }
-    defer dst.Close()
-    io.Copy(dst, file)
 
     // Save Metadata
-    deck, err := database.CreateSharedDeck(title, description, filePath, userID, &groupID)
+    deck, err := database.CreateSharedDeck(title, description, key, userID, &groupID)
     if err != nil {
         http.Error(w, "Database error", http.StatusInternalServerError)
         return
@@ -115,16 +121,24 @@ func (h *DecksHandler) DownloadDeck(w http.ResponseWriter, r *http.Request) {
     // Check permissions? Assuming if you have link or are in group you can download.
     // Ideally check if user is in deck.GroupID
     
-    f, err := os.Open(deck.FilePath)
+    data, err := h.readDeck(r, deck.FilePath)
     if err != nil {
         http.Error(w, "File not found on server", http.StatusInternalServerError)
         return
     }
-    defer f.Close()
 
     database.IncrementDownloads(id)
     
     w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.apkg\"", deck.Title))
     w.Header().Set("Content-Type", "application/octet-stream")
-    io.Copy(w, f)
+    w.Write(data)
+}
+
+// readDeck loads a shared deck file. Decks uploaded before the blob store
+// was introduced are still referenced by their path under ./data/uploads.
+func (h *DecksHandler) readDeck(r *http.Request, filePath string) ([]byte, error) {
+    if strings.HasPrefix(filePath, "./data/") || strings.HasPrefix(filePath, "data/") {
+        return os.ReadFile(filePath)
+    }
+    return h.Store.Get(r.Context(), filePath)
 }
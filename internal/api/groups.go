@@ -1,10 +1,16 @@
 package api
 
 import (
+    "context"
+    "database/sql"
     "encoding/json"
+    "errors"
+    "io"
     "log"
     "net/http"
+    "os"
     "strconv"
+    "strings"
     "time"
 
     "github.com/go-chi/chi/v5"
@@ -13,20 +19,27 @@ import (
     "github.com/magnusohle/openanki-backend/internal/media"
 )
 
-type GroupsHandler struct{}
+type GroupsHandler struct {
+    Repo  *database.Repository
+    Store media.BlobStore
+}
 
-func RegisterGroupsRoutes(r chi.Router) {
-    handler := &GroupsHandler{}
+func RegisterGroupsRoutes(r chi.Router, repo *database.Repository, store media.BlobStore) {
+    handler := &GroupsHandler{Repo: repo, Store: store}
     r.Group(func(r chi.Router) {
         r.Use(auth.Middleware)
+        r.Use(rateLimit("groups"))
+        r.Use(requireVerifiedEmail("groups"))
         r.Post("/", handler.CreateGroup)
         r.Get("/", handler.ListGroups)
         r.Post("/{id}/join", handler.JoinGroup)
         r.Post("/join", handler.JoinWithCode) // New endpoint for code-based join
         // Deck sharing
         r.Post("/{id}/decks", handler.UploadDeck)
+        r.Post("/{id}/decks/{deckId}/confirm", handler.ConfirmDeck)
         r.Get("/{id}/decks", handler.ListGroupDecks)
         r.Get("/{id}/decks/{deckId}", handler.DownloadDeck)
+        r.Post("/{id}/decks/{deckId}/subscribe", handler.SubscribeDeck)
     })
 }
 
@@ -159,6 +172,7 @@ func (h *GroupsHandler) UploadDeck(w http.ResponseWriter, r *http.Request) {
     var req struct {
         Name      string `json:"name"`
         CardCount int    `json:"card_count"`
+        Size      int64  `json:"size"` // Optional, checked against the quota up front
         // DeckData removed - client uploads directly to R2
     }
     if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -166,19 +180,29 @@ func (h *GroupsHandler) UploadDeck(w http.ResponseWriter, r *http.Request) {
         return
     }
     
-    // Generate unique R2 key
-    // Format: groups/{groupID}/decks/{uuid}.apkg
+    // Generate unique R2 key. The client uploads to a staging key;
+    // ConfirmDeck moves the measured file to the published one.
+    // Format: groups/{groupID}/uploads/{uuid}.apkg
     uuid := database.GenerateRandomString(12) // Reusing existing helper or just simple random
-    r2Key := "groups/" + strconv.Itoa(groupID) + "/decks/" + uuid + ".apkg"
+    r2Key := "groups/" + strconv.Itoa(groupID) + "/uploads/" + uuid + ".apkg"
     
-    // Generate Presigned PUT URL
-    s3Service, err := media.NewS3Service()
-    if err != nil {
-        http.Error(w, "Storage unavailable", http.StatusInternalServerError)
+    h.dropStaleUploads(r.Context(), userID)
+
+    // The stored size counts against the uploader's quota once measured
+    if req.Size < 0 {
+        http.Error(w, "Invalid size", http.StatusBadRequest)
+        return
+    }
+    if !checkQuota(w, h.Repo, userID, req.Size) {
         return
     }
     
-    uploadURL, err := s3Service.GeneratePresignedPutURL(r2Key, "application/octet-stream", 15*time.Minute) // 15 min expiry
+    // Generate Presigned PUT URL
+    uploadURL, err := h.Store.PresignPut(r.Context(), r2Key, "application/octet-stream", groupDeckUploadExpiry)
+    if errors.Is(err, media.ErrPresignUnsupported) {
+        http.Error(w, "Deck uploads are not available with this storage backend", http.StatusNotImplemented)
+        return
+    }
     if err != nil {
         http.Error(w, "Failed to generate upload URL", http.StatusInternalServerError)
         return
@@ -204,6 +228,134 @@ func (h *GroupsHandler) UploadDeck(w http.ResponseWriter, r *http.Request) {
     json.NewEncoder(w).Encode(resp)
 }
 
+// groupDeckUploadExpiry is how long an UploadDeck URL stays valid. Decks not
+// confirmed within staleGroupDeckAge are dropped.
+const (
+    groupDeckUploadExpiry = 15 * time.Minute
+    staleGroupDeckAge     = time.Hour
+)
+
+// ConfirmDeck - publish an uploaded deck (Step 2). The upload is copied to
+// its published key inside the store, so later uploads to the staging URL
+// cannot change what the group sees. The copy is measured once here and
+// counted against the uploader's quota; one over the quota is deleted.
+func (h *GroupsHandler) ConfirmDeck(w http.ResponseWriter, r *http.Request) {
+    userID := r.Context().Value("user_id").(int)
+    groupID, _ := strconv.Atoi(chi.URLParam(r, "id"))
+    deckID, _ := strconv.Atoi(chi.URLParam(r, "deckId"))
+
+    deck, err := database.GetPendingGroupDeck(deckID, userID)
+    if errors.Is(err, sql.ErrNoRows) || (err == nil && deck.GroupID != groupID) {
+        http.Error(w, "Deck not found", http.StatusNotFound)
+        return
+    }
+    if err != nil {
+        log.Printf("❌ Error GetPendingGroupDeck: %v", err)
+        http.Error(w, "Failed to confirm deck", http.StatusInternalServerError)
+        return
+    }
+
+    published := strings.Replace(deck.R2Key, "/uploads/", "/decks/", 1)
+    err = h.Store.Copy(r.Context(), deck.R2Key, published)
+    if errors.Is(err, media.ErrObjectNotFound) {
+        http.Error(w, "Deck file not uploaded", http.StatusConflict)
+        return
+    }
+    if err != nil {
+        log.Printf("❌ Error storing group deck %d: %v", deck.ID, err)
+        http.Error(w, "Failed to confirm deck", http.StatusInternalServerError)
+        return
+    }
+    info, err := h.Store.Head(r.Context(), published)
+    if err != nil {
+        log.Printf("❌ Error measuring group deck %d: %v", deck.ID, err)
+        http.Error(w, "Failed to confirm deck", http.StatusInternalServerError)
+        return
+    }
+    usage, err := h.Repo.GetStorageUsage(userID)
+    if err != nil {
+        log.Printf("❌ Error checking storage quota: %v", err)
+        http.Error(w, "Failed to check storage quota", http.StatusInternalServerError)
+        return
+    }
+    if usage.UsedBytes()+info.Size > usage.QuotaBytes {
+        if err := h.Store.Delete(r.Context(), published); err != nil {
+            log.Printf("⚠️ Failed to delete group deck copy %s: %v", published, err)
+        }
+        h.discardDeck(r.Context(), deck)
+        writeQuotaExceeded(w, usage)
+        return
+    }
+
+    if err := database.PublishGroupDeck(deck.ID, published, info.Size); err != nil {
+        // A concurrent confirmation may have published the deck under the
+        // same key; otherwise nothing refers to the copy
+        if current, gerr := database.GetGroupDeck(deck.ID); gerr == nil && current.R2Key == published {
+            http.Error(w, "Deck already confirmed", http.StatusConflict)
+            return
+        }
+        if derr := h.Store.Delete(r.Context(), published); derr != nil {
+            log.Printf("⚠️ Failed to delete unpublished group deck %s: %v", published, derr)
+        }
+        log.Printf("❌ Error PublishGroupDeck: %v", err)
+        http.Error(w, "Failed to confirm deck", http.StatusInternalServerError)
+        return
+    }
+    if err := h.Store.Delete(r.Context(), deck.R2Key); err != nil {
+        log.Printf("⚠️ Failed to delete staged group deck %s: %v", deck.R2Key, err)
+    }
+
+    deck.R2Key = ""
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(deck)
+}
+
+// fetchDeck copies the deck stored at key to a temporary file, so packages
+// are read from disk rather than memory. The caller removes the file.
+func (h *GroupsHandler) fetchDeck(ctx context.Context, key string) (*os.File, int64, error) {
+    body, err := h.Store.Open(ctx, key)
+    if err != nil {
+        return nil, 0, err
+    }
+    defer body.Close()
+    f, err := os.CreateTemp("", "group-deck-*.apkg")
+    if err != nil {
+        return nil, 0, err
+    }
+    size, err := io.Copy(f, body)
+    if err != nil {
+        f.Close()
+        os.Remove(f.Name())
+        return nil, 0, err
+    }
+    return f, size, nil
+}
+
+// dropStaleUploads deletes the user's decks that were never confirmed
+func (h *GroupsHandler) dropStaleUploads(ctx context.Context, userID int) {
+    decks, err := database.ListStaleGroupDecks(userID, time.Now().Add(-staleGroupDeckAge))
+    if err != nil {
+        log.Printf("⚠️ Failed to list stale group decks: %v", err)
+        return
+    }
+    for i := range decks {
+        h.discardDeck(ctx, &decks[i])
+    }
+}
+
+// discardDeck deletes an unpublished deck and its upload
+func (h *GroupsHandler) discardDeck(ctx context.Context, deck *database.GroupDeck) {
+    if deck.R2Key != "" {
+        if err := h.Store.Delete(ctx, deck.R2Key); err != nil {
+            log.Printf("⚠️ Failed to delete group deck upload %s: %v", deck.R2Key, err)
+            return
+        }
+    }
+    if err := database.DeleteGroupDeck(deck.ID); err != nil {
+        log.Printf("⚠️ Failed to delete group deck %d: %v", deck.ID, err)
+    }
+}
+
 // ListGroupDecks - get all shared decks in a group
 func (h *GroupsHandler) ListGroupDecks(w http.ResponseWriter, r *http.Request) {
     userID := r.Context().Value("user_id").(int)
@@ -247,13 +399,11 @@ func (h *GroupsHandler) DownloadDeck(w http.ResponseWriter, r *http.Request) {
     }
 
     // Generate Presigned GET URL
-    s3Service, err := media.NewS3Service()
-    if err != nil {
-        http.Error(w, "Storage unavailable", http.StatusInternalServerError)
+    downloadURL, err := h.Store.PresignGet(r.Context(), deck.R2Key, 1*time.Hour) // 1 hour expiry
+    if errors.Is(err, media.ErrPresignUnsupported) {
+        http.Error(w, "Deck downloads are not available with this storage backend", http.StatusNotImplemented)
         return
     }
-    
-    downloadURL, err := s3Service.GeneratePresignedGetURL(deck.R2Key, 1*time.Hour) // 1 hour expiry
     if err != nil {
         http.Error(w, "Failed to generate download URL", http.StatusInternalServerError)
         return
@@ -273,3 +423,65 @@ func (h *GroupsHandler) DownloadDeck(w http.ResponseWriter, r *http.Request) {
     w.Header().Set("Content-Type", "application/json")
     json.NewEncoder(w).Encode(resp)
 }
+
+// SubscribeDeck - import a group deck into the user's synced collection
+func (h *GroupsHandler) SubscribeDeck(w http.ResponseWriter, r *http.Request) {
+    userID := r.Context().Value("user_id").(int)
+    groupID, _ := strconv.Atoi(chi.URLParam(r, "id"))
+    deckID, _ := strconv.Atoi(chi.URLParam(r, "deckId"))
+
+    isMember, _ := database.IsMember(groupID, userID)
+    if !isMember {
+        http.Error(w, "Not a member of this group", http.StatusForbidden)
+        return
+    }
+
+    // The collection is only reachable through sync, a paid feature
+    user, err := database.GetUserByID(userID)
+    if err != nil {
+        http.Error(w, "Failed to get user", http.StatusInternalServerError)
+        return
+    }
+    if user.SubscriptionStatus == "free" {
+        http.Error(w, "Sync requires a subscription", http.StatusForbidden)
+        return
+    }
+
+    deck, err := database.GetGroupDeck(deckID)
+    if err != nil || deck.GroupID != groupID {
+        http.Error(w, "Deck not found", http.StatusNotFound)
+        return
+    }
+
+    f, size, err := h.fetchDeck(r.Context(), deck.R2Key)
+    if errors.Is(err, media.ErrObjectNotFound) {
+        http.Error(w, "Deck file not found", http.StatusNotFound)
+        return
+    }
+    if err != nil {
+        log.Printf("❌ Error fetching group deck %d: %v", deck.ID, err)
+        http.Error(w, "Failed to fetch deck", http.StatusInternalServerError)
+        return
+    }
+    defer os.Remove(f.Name())
+    defer f.Close()
+    // The package size bounds the media the import adds
+    if !checkQuota(w, h.Repo, userID, size) {
+        return
+    }
+
+    result, err := importPackage(r.Context(), h.Repo, h.Store, userID, f, size)
+    if errors.Is(err, errInvalidDeckPackage) {
+        log.Printf("⚠️ Group deck %d is not a valid package: %v", deck.ID, err)
+        http.Error(w, "Deck file is not a valid Anki package", http.StatusUnprocessableEntity)
+        return
+    }
+    if err != nil {
+        log.Printf("❌ Error importing group deck %d: %v", deck.ID, err)
+        http.Error(w, "Failed to import deck", http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(result)
+}
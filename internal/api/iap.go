@@ -18,6 +18,8 @@ func RegisterIAPRoutes(r chi.Router) {
 	handler := &IAPHandler{}
 	r.Group(func(r chi.Router) {
 		r.Use(auth.Middleware)
+		r.Use(rateLimit("iap"))
+		r.Use(requireVerifiedEmail("iap"))
 		r.Post("/verify", handler.VerifyPurchase)
 	})
 	// Webhook doesn't need auth - Apple sends it
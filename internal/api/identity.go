@@ -0,0 +1,235 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/magnusohle/openanki-backend/internal/database"
+	"github.com/magnusohle/openanki-backend/internal/oidc"
+)
+
+// identityProviders are the sign-in providers configured by the environment,
+// shared by all handlers so their key caches are too
+var identityProviders = sync.OnceValue(func() map[string]*oidc.Provider {
+	return map[string]*oidc.Provider{
+		"apple":  oidc.Apple(),
+		"google": oidc.Google(),
+	}
+})
+
+type identityTokenRequest struct {
+	IDToken string `json:"id_token"`
+	// Nonce must equal the token's nonce claim when set
+	Nonce string `json:"nonce"`
+}
+
+// verifyIdentity checks the identity token of the named provider, writing an
+// error response when it is not valid
+func verifyIdentity(ctx context.Context, w http.ResponseWriter, providers map[string]*oidc.Provider, name string, req identityTokenRequest) *oidc.Identity {
+	provider, ok := providers[name]
+	if !ok {
+		http.Error(w, "Unknown provider", http.StatusNotFound)
+		return nil
+	}
+	if req.IDToken == "" {
+		http.Error(w, "id_token required", http.StatusBadRequest)
+		return nil
+	}
+	identity, err := provider.Verify(ctx, req.IDToken, req.Nonce)
+	if errors.Is(err, oidc.ErrNotConfigured) {
+		http.Error(w, "Sign in with "+name+" is not configured", http.StatusNotImplemented)
+		return nil
+	}
+	if errors.Is(err, oidc.ErrInvalidToken) {
+		http.Error(w, "Invalid identity token", http.StatusUnauthorized)
+		return nil
+	}
+	if err != nil {
+		log.Printf("❌ Error verifying %s identity token: %v", name, err)
+		http.Error(w, "Failed to verify identity token", http.StatusBadGateway)
+		return nil
+	}
+	return identity
+}
+
+// SignInWith signs in with an identity token of the provider. The first
+// sign-in links the provider account to the account with the same email
+// when the provider and the account have both verified it, or creates an
+// account without a password.
+func (h *AuthHandler) SignInWith(provider string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			identityTokenRequest
+			// Username is used for a new account, derived from the email
+			// when empty or taken
+			Username   string `json:"username"`
+			DeviceName string `json:"device_name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		identity := verifyIdentity(r.Context(), w, h.Providers, provider, req.identityTokenRequest)
+		if identity == nil {
+			return
+		}
+
+		user, err := database.GetIdentityUser(provider, identity.Subject)
+		if errors.Is(err, database.ErrIdentityNotFound) {
+			user, err = linkOrCreateUser(w, identity, req.Username)
+			if user == nil && err == nil {
+				return
+			}
+		}
+		if err != nil {
+			log.Printf("❌ Error signing in with %s: %v", provider, err)
+			http.Error(w, "Failed to sign in", http.StatusInternalServerError)
+			return
+		}
+		trustIdentityEmail(user, identity)
+
+		writeNewSession(w, r, user, req.DeviceName)
+	}
+}
+
+// linkOrCreateUser finds the user of a provider account signing in for the
+// first time. It returns a nil user after writing an error response.
+func linkOrCreateUser(w http.ResponseWriter, identity *oidc.Identity, username string) (*database.User, error) {
+	if identity.Email == "" {
+		http.Error(w, "The identity token has no email", http.StatusBadRequest)
+		return nil, nil
+	}
+
+	existing, err := database.GetUserByEmail(identity.Email)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		// Both sides must have proven the address, or whoever registered it
+		// first (or holds the provider account) would take over the other
+		if !identity.EmailVerified || !existing.EmailVerified {
+			http.Error(w, "An account with this email exists; sign in to it and link "+identity.Provider, http.StatusConflict)
+			return nil, nil
+		}
+		err := database.LinkIdentity(existing.ID, identity.Provider, identity.Subject, identity.Email)
+		if errors.Is(err, database.ErrProviderLinked) {
+			http.Error(w, "The account with this email is linked to another "+identity.Provider+" account", http.StatusConflict)
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return existing, nil
+	}
+
+	// Usernames are unique; retry taken ones with a numeric suffix
+	base := usernameFor(username, identity.Email)
+	candidate := base
+	for attempt := 0; attempt < 5; attempt++ {
+		user, err := database.CreateIdentityUser(identity.Email, candidate, identity.Provider, identity.Subject)
+		if err == nil {
+			return user, nil
+		}
+		if !strings.Contains(err.Error(), "UNIQUE") {
+			return nil, err
+		}
+		candidate = fmt.Sprintf("%s%04d", base, rand.Intn(10000))
+	}
+	http.Error(w, "Could not pick a username; choose one", http.StatusConflict)
+	return nil, nil
+}
+
+// usernameFor is the requested username, or the email's local part
+func usernameFor(requested, email string) string {
+	if requested = strings.TrimSpace(requested); requested != "" {
+		return requested
+	}
+	local, _, _ := strings.Cut(email, "@")
+	var b strings.Builder
+	for _, c := range local {
+		if c < 128 && (c == '_' || c == '.' || c == '-' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			b.WriteRune(c)
+		}
+	}
+	if b.Len() < 3 {
+		return "user"
+	}
+	return b.String()
+}
+
+// ListMyIdentities lists the provider accounts the user can sign in with
+func (h *ProfileHandler) ListMyIdentities(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+	identities, err := database.ListIdentities(userID)
+	if err != nil {
+		log.Printf("❌ Error ListIdentities: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"identities": identities})
+}
+
+// LinkIdentity links the provider account of an identity token to the user
+func (h *ProfileHandler) LinkIdentity(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+	provider := chi.URLParam(r, "provider")
+	var req identityTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	identity := verifyIdentity(r.Context(), w, h.Providers, provider, req)
+	if identity == nil {
+		return
+	}
+
+	err := database.LinkIdentity(userID, provider, identity.Subject, identity.Email)
+	if errors.Is(err, database.ErrIdentityTaken) {
+		http.Error(w, "This "+provider+" account is linked to another user", http.StatusConflict)
+		return
+	}
+	if errors.Is(err, database.ErrProviderLinked) {
+		http.Error(w, "Another "+provider+" account is linked; unlink it first", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("❌ Error LinkIdentity: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if user, err := database.GetUserByID(userID); err == nil && user != nil {
+		trustIdentityEmail(user, identity)
+	}
+	h.ListMyIdentities(w, r)
+}
+
+// UnlinkIdentity removes the user's provider account. The last way to sign
+// in of a user without a password cannot be removed.
+func (h *ProfileHandler) UnlinkIdentity(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+	err := database.UnlinkIdentity(userID, chi.URLParam(r, "provider"))
+	if errors.Is(err, database.ErrIdentityNotFound) {
+		http.Error(w, "No such linked account", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, database.ErrLastSignInMethod) {
+		http.Error(w, "Set a password (see forgot-password) before unlinking your only sign-in method", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("❌ Error UnlinkIdentity: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,28 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/magnusohle/openanki-backend/internal/database"
+	"github.com/magnusohle/openanki-backend/internal/media"
+	"github.com/magnusohle/openanki-backend/internal/testutil"
+)
+
+func TestMain(m *testing.M) {
+	testutil.Main(m, nil)
+}
+
+// newTestSyncHandler serves sync requests from a local store under a
+// temporary directory
+func newTestSyncHandler(t *testing.T) *SyncHandler {
+	t.Helper()
+	store := media.NewLocalStore(t.TempDir(), "http://localhost/blobs", []byte("secret"))
+	return &SyncHandler{Repo: testutil.Repo, Store: store, ConflictPolicy: database.ConflictReject}
+}
+
+// asUser makes r look authenticated as userID
+func asUser(r *http.Request, userID int) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), "user_id", userID))
+}
@@ -2,22 +2,39 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/magnusohle/openanki-backend/internal/auth"
 	"github.com/magnusohle/openanki-backend/internal/database"
+	"github.com/magnusohle/openanki-backend/internal/media"
+	"github.com/magnusohle/openanki-backend/internal/oidc"
 )
 
-type ProfileHandler struct{}
+type ProfileHandler struct {
+	Repo  *database.Repository
+	Store media.BlobStore
+	// Providers verify the identity tokens of accounts being linked
+	Providers map[string]*oidc.Provider
+}
 
-func RegisterProfileRoutes(r chi.Router) {
-	handler := &ProfileHandler{}
+func RegisterProfileRoutes(r chi.Router, repo *database.Repository, store media.BlobStore) {
+	handler := &ProfileHandler{Repo: repo, Store: store, Providers: identityProviders()}
 	r.Group(func(r chi.Router) {
 		r.Use(auth.Middleware)
+		r.Use(rateLimit("users"))
 		r.Get("/me", handler.GetMyProfile)
+		r.Get("/me/usage", handler.GetMyUsage)
 		r.Put("/me", handler.UpdateMyProfile)
 		r.Delete("/me", handler.DeleteMyAccount)
+		r.Get("/me/sessions", handler.ListMySessions)
+		r.Delete("/me/sessions", handler.DeleteOtherSessions)
+		r.Delete("/me/sessions/{id}", handler.DeleteMySession)
+		r.Get("/me/identities", handler.ListMyIdentities)
+		r.Post("/me/identities/{provider}", handler.LinkIdentity)
+		r.Delete("/me/identities/{provider}", handler.UnlinkIdentity)
 		r.Post("/upgrade-dev", handler.DevUpgrade) // Temporary
 	})
 }
@@ -37,6 +54,12 @@ func (h *ProfileHandler) DevUpgrade(w http.ResponseWriter, r *http.Request) {
 
 func (h *ProfileHandler) DeleteMyAccount(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value("user_id").(int)
+	// Sign out every device, including Anki desktop
+	if err := database.RevokeUserSessions(userID); err != nil {
+		log.Printf("❌ Error RevokeUserSessions: %v", err)
+		http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+		return
+	}
 	err := database.DeleteUser(userID)
 	if err != nil {
 		http.Error(w, "Failed to delete account", http.StatusInternalServerError)
@@ -46,6 +69,55 @@ func (h *ProfileHandler) DeleteMyAccount(w http.ResponseWriter, r *http.Request)
 	json.NewEncoder(w).Encode(map[string]string{"message": "Account deleted successfully"})
 }
 
+// ListMySessions lists the devices the user is signed in on
+func (h *ProfileHandler) ListMySessions(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+	current, _ := r.Context().Value("session_id").(string)
+	sessions, err := database.ListSessions(userID)
+	if err != nil {
+		log.Printf("❌ Error ListSessions: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == current
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"sessions": sessions})
+}
+
+// DeleteOtherSessions signs the user out on every device but this one
+func (h *ProfileHandler) DeleteOtherSessions(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+	current, _ := r.Context().Value("session_id").(string)
+	revoked, err := database.DeleteOtherSessions(userID, current)
+	if err != nil {
+		log.Printf("❌ Error DeleteOtherSessions: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"revoked": revoked})
+}
+
+// DeleteMySession signs one device out, possibly this one
+func (h *ProfileHandler) DeleteMySession(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+	err := database.DeleteSession(userID, chi.URLParam(r, "id"))
+	if errors.Is(err, database.ErrSessionNotFound) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("❌ Error DeleteSession: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (h *ProfileHandler) GetMyProfile(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value("user_id").(int)
 	user, err := database.GetUserByID(userID)
@@ -62,6 +134,20 @@ func (h *ProfileHandler) GetMyProfile(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(user)
 }
 
+// GetMyUsage reports the user's storage use against their plan's quota
+func (h *ProfileHandler) GetMyUsage(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+	usage, err := h.Repo.GetStorageUsage(userID)
+	if err != nil {
+		log.Printf("❌ Error GetMyUsage: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(usage)
+}
+
 type updateProfileRequest struct {
 	AvatarURL  string `json:"avatar_url"`
 	University string `json:"university"`
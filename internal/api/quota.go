@@ -0,0 +1,30 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/magnusohle/openanki-backend/internal/database"
+)
+
+// checkQuota reports whether the user can store size more bytes, writing a
+// 413 response when that would exceed their plan's quota
+func checkQuota(w http.ResponseWriter, repo *database.Repository, userID int, size int64) bool {
+	usage, err := repo.GetStorageUsage(userID)
+	if err != nil {
+		log.Printf("❌ Error checking storage quota: %v", err)
+		http.Error(w, "Failed to check storage quota", http.StatusInternalServerError)
+		return false
+	}
+	if usage.UsedBytes()+size > usage.QuotaBytes {
+		writeQuotaExceeded(w, usage)
+		return false
+	}
+	return true
+}
+
+func writeQuotaExceeded(w http.ResponseWriter, usage *database.StorageUsage) {
+	http.Error(w, fmt.Sprintf("Storage quota exceeded: %d of %d bytes used on the %s plan",
+		usage.UsedBytes(), usage.QuotaBytes, usage.Plan), http.StatusRequestEntityTooLarge)
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/magnusohle/openanki-backend/internal/ratelimit"
+)
+
+// RateLimitStore holds the rate limit buckets of every route group. Replace
+// it before registering routes, e.g. with a store shared between servers.
+var RateLimitStore ratelimit.Store = ratelimit.NewMemoryStore()
+
+// rateLimits are the route groups' default limits, overridden by
+// RATE_LIMIT_<GROUP> (e.g. RATE_LIMIT_SYNC=600/m, or off)
+var rateLimits = map[string]ratelimit.Limit{
+	"sync": {Rate: 300, Per: time.Minute},
+	// Each media upload hands out presigned URLs
+	"media":  {Rate: 120, Per: time.Minute},
+	"iap":    {Rate: 10, Per: time.Minute},
+	"groups": {Rate: 60, Per: time.Minute},
+	"users":  {Rate: 60, Per: time.Minute},
+	"anki":   {Rate: 600, Per: time.Minute},
+}
+
+// rateLimit is middleware limiting the route group's requests per user, or
+// per IP for requests without one. Behind auth.Middleware it counts users.
+func rateLimit(group string) func(http.Handler) http.Handler {
+	limit := rateLimits[group]
+	env := "RATE_LIMIT_" + strings.ToUpper(group)
+	if v := os.Getenv(env); v != "" {
+		parsed, err := ratelimit.ParseLimit(v)
+		if err != nil {
+			log.Printf("⚠️ Ignoring %s: %v", env, err)
+		} else {
+			limit = parsed
+		}
+	}
+	limiter := &ratelimit.Limiter{Name: group, Limit: limit, Store: RateLimitStore, Key: rateLimitKey}
+	return limiter.Handler
+}
+
+func rateLimitKey(r *http.Request) string {
+	if userID, ok := r.Context().Value("user_id").(int); ok {
+		return "user:" + strconv.Itoa(userID)
+	}
+	return "ip:" + clientIP(r)
+}
+
+// forRoute applies middleware only to requests with the method and a path
+// ending in suffix, for single routes of generated routers
+func forRoute(method, suffix string, middleware func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		limited := middleware(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == method && strings.HasSuffix(r.URL.Path, suffix) {
+				limited.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
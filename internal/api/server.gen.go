@@ -8,6 +8,7 @@ import (
 	"compress/gzip"
 	"context"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -25,6 +26,85 @@ const (
 	BearerAuthScopes = "bearerAuth.Scopes"
 )
 
+// Defines values for ExportCollectionParamsDelivery.
+const (
+	Stream ExportCollectionParamsDelivery = "stream"
+	Url    ExportCollectionParamsDelivery = "url"
+)
+
+// DeckConfigOptions Other keys are kept and returned as sent
+type DeckConfigOptions struct {
+	DesiredRetention float64 `json:"desired_retention"`
+
+	// EasyInterval Days
+	EasyInterval int        `json:"easy_interval"`
+	FsrsWeights  *[]float64 `json:"fsrs_weights,omitempty"`
+
+	// GraduatingInterval Days
+	GraduatingInterval int `json:"graduating_interval"`
+
+	// LearnSteps Steps in minutes
+	LearnSteps     []float64 `json:"learn_steps"`
+	LeechThreshold int       `json:"leech_threshold"`
+
+	// MaximumInterval Days
+	MaximumInterval int `json:"maximum_interval"`
+	NewPerDay       int `json:"new_per_day"`
+
+	// RelearnSteps Steps in minutes
+	RelearnSteps  []float64 `json:"relearn_steps"`
+	ReviewsPerDay int       `json:"reviews_per_day"`
+
+	// StartingEase Permille, e.g. 2500
+	StartingEase         int                    `json:"starting_ease"`
+	AdditionalProperties map[string]interface{} `json:"-"`
+}
+
+// ExportURLResponse defines model for ExportURLResponse.
+type ExportURLResponse struct {
+	// DownloadUrl Presigned URL of the staged .apkg
+	DownloadUrl *string    `json:"download_url,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+// FullUploadCommitRequest defines model for FullUploadCommitRequest.
+type FullUploadCommitRequest struct {
+	// Chunks Number of chunks uploaded (seq 0 to chunks-1)
+	Chunks int `json:"chunks"`
+}
+
+// FullUploadSession defines model for FullUploadSession.
+type FullUploadSession struct {
+	UploadId string `json:"upload_id"`
+}
+
+// MediaChange defines model for MediaChange.
+type MediaChange struct {
+	// Deleted The file was deleted; hash is the content it last had
+	Deleted  bool   `json:"deleted"`
+	Filename string `json:"filename"`
+	Hash     string `json:"hash"`
+
+	// Size File size in bytes, 0 for deletions
+	Size *int64 `json:"size,omitempty"`
+	Usn  int    `json:"usn"`
+}
+
+// MediaChangesResponse defines model for MediaChangesResponse.
+type MediaChangesResponse struct {
+	// Changes Changes in USN order. A file appears at most once, with its latest state.
+	Changes *[]MediaChange `json:"changes,omitempty"`
+
+	// HasMore More changes remain; request them with next_cursor
+	HasMore *bool `json:"has_more,omitempty"`
+
+	// NextCursor Continuation token for the next page, only set when has_more is true
+	NextCursor *string `json:"next_cursor,omitempty"`
+
+	// ServerUsn USN the listing is bounded by. Use it as the next since once has_more is false.
+	ServerUsn *int `json:"server_usn,omitempty"`
+}
+
 // MediaItem defines model for MediaItem.
 type MediaItem struct {
 	Filename *string `json:"filename,omitempty"`
@@ -36,34 +116,71 @@ type MediaListResponse struct {
 	Media *[]MediaItem `json:"media,omitempty"`
 }
 
+// MediaUploadRequest defines model for MediaUploadRequest.
+type MediaUploadRequest struct {
+	Filename string `json:"filename"`
+
+	// Hash Hex SHA-1 or SHA-256 of the file content
+	Hash string `json:"hash"`
+
+	// Size Size in bytes, checked against the storage quota. Required unless the user already has the content; the uploaded object must match it exactly.
+	Size *int64 `json:"size,omitempty"`
+}
+
 // MediaUploadResponse defines model for MediaUploadResponse.
 type MediaUploadResponse struct {
-	Hash   *string `json:"hash,omitempty"`
+	Hash *string `json:"hash,omitempty"`
+
+	// Status ok once stored, pending while waiting for ConfirmMedia
 	Status *string `json:"status,omitempty"`
+
+	// UploadUrl Presigned URL to PUT the file to, absent when the user already has a file with this content
+	UploadUrl *string `json:"upload_url,omitempty"`
 }
 
 // SyncCard defines model for SyncCard.
 type SyncCard struct {
-	Data           string `json:"data"`
-	DeckId         int64  `json:"deck_id"`
-	Due            int64  `json:"due"`
-	EaseFactor     int    `json:"ease_factor"`
-	Flags          int    `json:"flags"`
-	Id             int64  `json:"id"`
-	Interval       int    `json:"interval"`
-	Lapses         int    `json:"lapses"`
-	LeftCount      int    `json:"left_count"`
-	ModifiedAt     int64  `json:"modified_at"`
-	NoteId         int64  `json:"note_id"`
-	Ordinal        int    `json:"ordinal"`
-	OriginalDeckId int64  `json:"original_deck_id"`
-	OriginalDue    int64  `json:"original_due"`
-	Queue          int    `json:"queue"`
-	Reps           int    `json:"reps"`
-	State          int     `json:"state"`
-	Usn            int     `json:"usn"`
-	Stability      float64 `json:"stability"`
-	Difficulty     float64 `json:"difficulty"`
+	Data   string `json:"data"`
+	DeckId int64  `json:"deck_id"`
+
+	// Difficulty FSRS memory difficulty. Omitted on push keeps the server's value.
+	Difficulty     *float64 `json:"difficulty,omitempty"`
+	Due            int64    `json:"due"`
+	EaseFactor     int      `json:"ease_factor"`
+	Flags          int      `json:"flags"`
+	Id             int64    `json:"id"`
+	Interval       int      `json:"interval"`
+	Lapses         int      `json:"lapses"`
+	LeftCount      int      `json:"left_count"`
+	ModifiedAt     int64    `json:"modified_at"`
+	NoteId         int64    `json:"note_id"`
+	Ordinal        int      `json:"ordinal"`
+	OriginalDeckId int64    `json:"original_deck_id"`
+	OriginalDue    int64    `json:"original_due"`
+	Queue          int      `json:"queue"`
+	Reps           int      `json:"reps"`
+
+	// Stability FSRS memory stability. Omitted on push keeps the server's value.
+	Stability *float64 `json:"stability,omitempty"`
+	State     int      `json:"state"`
+	Usn       int      `json:"usn"`
+}
+
+// SyncConflictResponse defines model for SyncConflictResponse.
+type SyncConflictResponse struct {
+	// Conflicts Object IDs changed on the server after the client's last seen USN
+	Conflicts SyncConflicts `json:"conflicts"`
+	Error     string        `json:"error"`
+	ServerUsn int           `json:"server_usn"`
+}
+
+// SyncConflicts Object IDs changed on the server after the client's last seen USN
+type SyncConflicts struct {
+	Cards       *[]int64 `json:"cards,omitempty"`
+	DeckConfigs *[]int64 `json:"deck_configs,omitempty"`
+	Decks       *[]int64 `json:"decks,omitempty"`
+	Notes       *[]int64 `json:"notes,omitempty"`
+	Notetypes   *[]int64 `json:"notetypes,omitempty"`
 }
 
 // SyncDeck defines model for SyncDeck.
@@ -77,11 +194,21 @@ type SyncDeck struct {
 	Usn         int     `json:"usn"`
 }
 
+// SyncDeckConfig Deck option group, referenced by SyncDeck.config_id
+type SyncDeckConfig struct {
+	// Config Other keys are kept and returned as sent
+	Config DeckConfigOptions `json:"config"`
+	Id     int64             `json:"id"`
+	Mod    int64             `json:"mod"`
+	Name   string            `json:"name"`
+	Usn    int               `json:"usn"`
+}
+
 // SyncGrave defines model for SyncGrave.
 type SyncGrave struct {
 	Oid int64 `json:"oid"`
 
-	// Type 0=card, 1=note, 2=deck
+	// Type 0=card, 1=note, 2=deck, 3=notetype, 4=deck config
 	Type int `json:"type"`
 }
 
@@ -107,27 +234,127 @@ type SyncNote struct {
 	Usn   int    `json:"usn"`
 }
 
+// SyncNoteType Note type (Anki model), referenced by SyncNote.mid
+type SyncNoteType struct {
+	Css       string                 `json:"css"`
+	Data      string                 `json:"data"`
+	Fields    []SyncNoteTypeField    `json:"fields"`
+	Id        int64                  `json:"id"`
+	Mod       int64                  `json:"mod"`
+	Name      string                 `json:"name"`
+	SortField int                    `json:"sort_field"`
+	Templates []SyncNoteTypeTemplate `json:"templates"`
+
+	// Type 0=standard, 1=cloze
+	Type int `json:"type"`
+	Usn  int `json:"usn"`
+}
+
+// SyncNoteTypeField Other keys are kept and returned as sent
+type SyncNoteTypeField struct {
+	Name                 string                 `json:"name"`
+	Ord                  int                    `json:"ord"`
+	AdditionalProperties map[string]interface{} `json:"-"`
+}
+
+// SyncNoteTypeTemplate Other keys are kept and returned as sent
+type SyncNoteTypeTemplate struct {
+	// Afmt Answer format
+	Afmt string `json:"afmt"`
+	Name string `json:"name"`
+	Ord  int    `json:"ord"`
+
+	// Qfmt Question format
+	Qfmt                 string                 `json:"qfmt"`
+	AdditionalProperties map[string]interface{} `json:"-"`
+}
+
 // SyncPullResponse defines model for SyncPullResponse.
 type SyncPullResponse struct {
-	Cards     *[]SyncCard  `json:"cards,omitempty"`
-	Decks     *[]SyncDeck  `json:"decks,omitempty"`
-	Graves    *[]SyncGrave `json:"graves,omitempty"`
-	Notes     *[]SyncNote  `json:"notes,omitempty"`
-	ServerUsn *int         `json:"server_usn,omitempty"`
+	Cards       *[]SyncCard       `json:"cards,omitempty"`
+	DeckConfigs *[]SyncDeckConfig `json:"deck_configs,omitempty"`
+	Decks       *[]SyncDeck       `json:"decks,omitempty"`
+	Graves      *[]SyncGrave      `json:"graves,omitempty"`
+
+	// HasMore More changes remain; request them with next_cursor
+	HasMore *bool `json:"has_more,omitempty"`
+
+	// NextCursor Continuation token for the next page, only set when has_more is true
+	NextCursor *string         `json:"next_cursor,omitempty"`
+	Notes      *[]SyncNote     `json:"notes,omitempty"`
+	Notetypes  *[]SyncNoteType `json:"notetypes,omitempty"`
+	Revlog     *[]SyncRevlog   `json:"revlog,omitempty"`
+
+	// ServerUsn USN the pull is bounded by. Use it as the next since once has_more is false.
+	ServerUsn *int `json:"server_usn,omitempty"`
 }
 
 // SyncPushRequest defines model for SyncPushRequest.
 type SyncPushRequest struct {
-	Cards     *[]SyncCard  `json:"cards,omitempty"`
-	ClientUsn *int         `json:"client_usn,omitempty"`
-	Decks     *[]SyncDeck  `json:"decks,omitempty"`
-	Graves    *[]SyncGrave `json:"graves,omitempty"`
-	Notes     *[]SyncNote  `json:"notes,omitempty"`
+	Cards *[]SyncCard `json:"cards,omitempty"`
+
+	// ClientUsn Last server USN seen by the client. Enables conflict detection on push.
+	ClientUsn   *int              `json:"client_usn,omitempty"`
+	DeckConfigs *[]SyncDeckConfig `json:"deck_configs,omitempty"`
+	Decks       *[]SyncDeck       `json:"decks,omitempty"`
+	Graves      *[]SyncGrave      `json:"graves,omitempty"`
+	Notes       *[]SyncNote       `json:"notes,omitempty"`
+	Notetypes   *[]SyncNoteType   `json:"notetypes,omitempty"`
+	Revlog      *[]SyncRevlog     `json:"revlog,omitempty"`
+}
+
+// SyncRevlog Review log entry. Append-only, an id already stored is never overwritten.
+type SyncRevlog struct {
+	Cid    int64 `json:"cid"`
+	Ease   int   `json:"ease"`
+	Factor int   `json:"factor"`
+
+	// Id Review time in epoch milliseconds
+	Id      int64 `json:"id"`
+	Ivl     int   `json:"ivl"`
+	LastIvl int   `json:"last_ivl"`
+
+	// Time Answer time in milliseconds
+	Time int `json:"time"`
+
+	// Type 0=learn, 1=review, 2=relearn, 3=filtered, 4=manual
+	Type int `json:"type"`
+	Usn  int `json:"usn"`
 }
 
 // USNResponse defines model for USNResponse.
 type USNResponse struct {
 	ServerUsn *int `json:"server_usn,omitempty"`
+
+	// Skipped Object IDs changed on the server after the client's last seen USN
+	Skipped *SyncConflicts `json:"skipped,omitempty"`
+}
+
+// ExportCollectionParams defines parameters for ExportCollection.
+type ExportCollectionParams struct {
+	// DeckId Export only this deck and its subdecks
+	DeckId *int64 `form:"deck_id,omitempty" json:"deck_id,omitempty"`
+
+	// Scheduling Include scheduling information and review history
+	Scheduling *bool `form:"scheduling,omitempty" json:"scheduling,omitempty"`
+
+	// Delivery stream returns the package, url stages it in storage and returns a download link
+	Delivery *ExportCollectionParamsDelivery `form:"delivery,omitempty" json:"delivery,omitempty"`
+}
+
+// ExportCollectionParamsDelivery defines parameters for ExportCollection.
+type ExportCollectionParamsDelivery string
+
+// GetMediaChangesParams defines parameters for GetMediaChanges.
+type GetMediaChangesParams struct {
+	// Since Last known media USN
+	Since *int `form:"since,omitempty" json:"since,omitempty"`
+
+	// Limit Maximum number of changes per page. Omit to receive all changes at once.
+	Limit *int `form:"limit,omitempty" json:"limit,omitempty"`
+
+	// Cursor Continuation token from a previous page's next_cursor. When set, since is ignored.
+	Cursor *string `form:"cursor,omitempty" json:"cursor,omitempty"`
 }
 
 // UploadMediaMultipartBody defines parameters for UploadMedia.
@@ -140,31 +367,456 @@ type UploadMediaMultipartBody struct {
 type PullSyncParams struct {
 	// Since Last known USN
 	Since *int `form:"since,omitempty" json:"since,omitempty"`
+
+	// Limit Maximum number of objects per page. Omit to receive all changes at once.
+	Limit *int `form:"limit,omitempty" json:"limit,omitempty"`
+
+	// Cursor Continuation token from a previous page's next_cursor. When set, since is ignored.
+	Cursor *string `form:"cursor,omitempty" json:"cursor,omitempty"`
 }
 
 // FullSyncJSONRequestBody defines body for FullSync for application/json ContentType.
 type FullSyncJSONRequestBody = SyncPushRequest
 
+// UploadFullChunkJSONRequestBody defines body for UploadFullChunk for application/json ContentType.
+type UploadFullChunkJSONRequestBody = SyncPushRequest
+
+// CommitFullUploadJSONRequestBody defines body for CommitFullUpload for application/json ContentType.
+type CommitFullUploadJSONRequestBody = FullUploadCommitRequest
+
+// UploadMediaJSONRequestBody defines body for UploadMedia for application/json ContentType.
+type UploadMediaJSONRequestBody = MediaUploadRequest
+
 // UploadMediaMultipartRequestBody defines body for UploadMedia for multipart/form-data ContentType.
 type UploadMediaMultipartRequestBody UploadMediaMultipartBody
 
 // PushSyncJSONRequestBody defines body for PushSync for application/json ContentType.
 type PushSyncJSONRequestBody = SyncPushRequest
 
+// Getter for additional properties for DeckConfigOptions. Returns the specified
+// element and whether it was found
+func (a DeckConfigOptions) Get(fieldName string) (value interface{}, found bool) {
+	if a.AdditionalProperties != nil {
+		value, found = a.AdditionalProperties[fieldName]
+	}
+	return
+}
+
+// Setter for additional properties for DeckConfigOptions
+func (a *DeckConfigOptions) Set(fieldName string, value interface{}) {
+	if a.AdditionalProperties == nil {
+		a.AdditionalProperties = make(map[string]interface{})
+	}
+	a.AdditionalProperties[fieldName] = value
+}
+
+// Override default JSON handling for DeckConfigOptions to handle AdditionalProperties
+func (a *DeckConfigOptions) UnmarshalJSON(b []byte) error {
+	object := make(map[string]json.RawMessage)
+	err := json.Unmarshal(b, &object)
+	if err != nil {
+		return err
+	}
+
+	if raw, found := object["desired_retention"]; found {
+		err = json.Unmarshal(raw, &a.DesiredRetention)
+		if err != nil {
+			return fmt.Errorf("error reading 'desired_retention': %w", err)
+		}
+		delete(object, "desired_retention")
+	}
+
+	if raw, found := object["easy_interval"]; found {
+		err = json.Unmarshal(raw, &a.EasyInterval)
+		if err != nil {
+			return fmt.Errorf("error reading 'easy_interval': %w", err)
+		}
+		delete(object, "easy_interval")
+	}
+
+	if raw, found := object["fsrs_weights"]; found {
+		err = json.Unmarshal(raw, &a.FsrsWeights)
+		if err != nil {
+			return fmt.Errorf("error reading 'fsrs_weights': %w", err)
+		}
+		delete(object, "fsrs_weights")
+	}
+
+	if raw, found := object["graduating_interval"]; found {
+		err = json.Unmarshal(raw, &a.GraduatingInterval)
+		if err != nil {
+			return fmt.Errorf("error reading 'graduating_interval': %w", err)
+		}
+		delete(object, "graduating_interval")
+	}
+
+	if raw, found := object["learn_steps"]; found {
+		err = json.Unmarshal(raw, &a.LearnSteps)
+		if err != nil {
+			return fmt.Errorf("error reading 'learn_steps': %w", err)
+		}
+		delete(object, "learn_steps")
+	}
+
+	if raw, found := object["leech_threshold"]; found {
+		err = json.Unmarshal(raw, &a.LeechThreshold)
+		if err != nil {
+			return fmt.Errorf("error reading 'leech_threshold': %w", err)
+		}
+		delete(object, "leech_threshold")
+	}
+
+	if raw, found := object["maximum_interval"]; found {
+		err = json.Unmarshal(raw, &a.MaximumInterval)
+		if err != nil {
+			return fmt.Errorf("error reading 'maximum_interval': %w", err)
+		}
+		delete(object, "maximum_interval")
+	}
+
+	if raw, found := object["new_per_day"]; found {
+		err = json.Unmarshal(raw, &a.NewPerDay)
+		if err != nil {
+			return fmt.Errorf("error reading 'new_per_day': %w", err)
+		}
+		delete(object, "new_per_day")
+	}
+
+	if raw, found := object["relearn_steps"]; found {
+		err = json.Unmarshal(raw, &a.RelearnSteps)
+		if err != nil {
+			return fmt.Errorf("error reading 'relearn_steps': %w", err)
+		}
+		delete(object, "relearn_steps")
+	}
+
+	if raw, found := object["reviews_per_day"]; found {
+		err = json.Unmarshal(raw, &a.ReviewsPerDay)
+		if err != nil {
+			return fmt.Errorf("error reading 'reviews_per_day': %w", err)
+		}
+		delete(object, "reviews_per_day")
+	}
+
+	if raw, found := object["starting_ease"]; found {
+		err = json.Unmarshal(raw, &a.StartingEase)
+		if err != nil {
+			return fmt.Errorf("error reading 'starting_ease': %w", err)
+		}
+		delete(object, "starting_ease")
+	}
+
+	if len(object) != 0 {
+		a.AdditionalProperties = make(map[string]interface{})
+		for fieldName, fieldBuf := range object {
+			var fieldVal interface{}
+			err := json.Unmarshal(fieldBuf, &fieldVal)
+			if err != nil {
+				return fmt.Errorf("error unmarshaling field %s: %w", fieldName, err)
+			}
+			a.AdditionalProperties[fieldName] = fieldVal
+		}
+	}
+	return nil
+}
+
+// Override default JSON handling for DeckConfigOptions to handle AdditionalProperties
+func (a DeckConfigOptions) MarshalJSON() ([]byte, error) {
+	var err error
+	object := make(map[string]json.RawMessage)
+
+	object["desired_retention"], err = json.Marshal(a.DesiredRetention)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling 'desired_retention': %w", err)
+	}
+
+	object["easy_interval"], err = json.Marshal(a.EasyInterval)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling 'easy_interval': %w", err)
+	}
+
+	if a.FsrsWeights != nil {
+		object["fsrs_weights"], err = json.Marshal(a.FsrsWeights)
+		if err != nil {
+			return nil, fmt.Errorf("error marshaling 'fsrs_weights': %w", err)
+		}
+	}
+
+	object["graduating_interval"], err = json.Marshal(a.GraduatingInterval)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling 'graduating_interval': %w", err)
+	}
+
+	if a.LearnSteps != nil {
+		object["learn_steps"], err = json.Marshal(a.LearnSteps)
+		if err != nil {
+			return nil, fmt.Errorf("error marshaling 'learn_steps': %w", err)
+		}
+	}
+
+	object["leech_threshold"], err = json.Marshal(a.LeechThreshold)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling 'leech_threshold': %w", err)
+	}
+
+	object["maximum_interval"], err = json.Marshal(a.MaximumInterval)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling 'maximum_interval': %w", err)
+	}
+
+	object["new_per_day"], err = json.Marshal(a.NewPerDay)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling 'new_per_day': %w", err)
+	}
+
+	if a.RelearnSteps != nil {
+		object["relearn_steps"], err = json.Marshal(a.RelearnSteps)
+		if err != nil {
+			return nil, fmt.Errorf("error marshaling 'relearn_steps': %w", err)
+		}
+	}
+
+	object["reviews_per_day"], err = json.Marshal(a.ReviewsPerDay)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling 'reviews_per_day': %w", err)
+	}
+
+	object["starting_ease"], err = json.Marshal(a.StartingEase)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling 'starting_ease': %w", err)
+	}
+
+	for fieldName, field := range a.AdditionalProperties {
+		object[fieldName], err = json.Marshal(field)
+		if err != nil {
+			return nil, fmt.Errorf("error marshaling '%s': %w", fieldName, err)
+		}
+	}
+	return json.Marshal(object)
+}
+
+// Getter for additional properties for SyncNoteTypeField. Returns the specified
+// element and whether it was found
+func (a SyncNoteTypeField) Get(fieldName string) (value interface{}, found bool) {
+	if a.AdditionalProperties != nil {
+		value, found = a.AdditionalProperties[fieldName]
+	}
+	return
+}
+
+// Setter for additional properties for SyncNoteTypeField
+func (a *SyncNoteTypeField) Set(fieldName string, value interface{}) {
+	if a.AdditionalProperties == nil {
+		a.AdditionalProperties = make(map[string]interface{})
+	}
+	a.AdditionalProperties[fieldName] = value
+}
+
+// Override default JSON handling for SyncNoteTypeField to handle AdditionalProperties
+func (a *SyncNoteTypeField) UnmarshalJSON(b []byte) error {
+	object := make(map[string]json.RawMessage)
+	err := json.Unmarshal(b, &object)
+	if err != nil {
+		return err
+	}
+
+	if raw, found := object["name"]; found {
+		err = json.Unmarshal(raw, &a.Name)
+		if err != nil {
+			return fmt.Errorf("error reading 'name': %w", err)
+		}
+		delete(object, "name")
+	}
+
+	if raw, found := object["ord"]; found {
+		err = json.Unmarshal(raw, &a.Ord)
+		if err != nil {
+			return fmt.Errorf("error reading 'ord': %w", err)
+		}
+		delete(object, "ord")
+	}
+
+	if len(object) != 0 {
+		a.AdditionalProperties = make(map[string]interface{})
+		for fieldName, fieldBuf := range object {
+			var fieldVal interface{}
+			err := json.Unmarshal(fieldBuf, &fieldVal)
+			if err != nil {
+				return fmt.Errorf("error unmarshaling field %s: %w", fieldName, err)
+			}
+			a.AdditionalProperties[fieldName] = fieldVal
+		}
+	}
+	return nil
+}
+
+// Override default JSON handling for SyncNoteTypeField to handle AdditionalProperties
+func (a SyncNoteTypeField) MarshalJSON() ([]byte, error) {
+	var err error
+	object := make(map[string]json.RawMessage)
+
+	object["name"], err = json.Marshal(a.Name)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling 'name': %w", err)
+	}
+
+	object["ord"], err = json.Marshal(a.Ord)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling 'ord': %w", err)
+	}
+
+	for fieldName, field := range a.AdditionalProperties {
+		object[fieldName], err = json.Marshal(field)
+		if err != nil {
+			return nil, fmt.Errorf("error marshaling '%s': %w", fieldName, err)
+		}
+	}
+	return json.Marshal(object)
+}
+
+// Getter for additional properties for SyncNoteTypeTemplate. Returns the specified
+// element and whether it was found
+func (a SyncNoteTypeTemplate) Get(fieldName string) (value interface{}, found bool) {
+	if a.AdditionalProperties != nil {
+		value, found = a.AdditionalProperties[fieldName]
+	}
+	return
+}
+
+// Setter for additional properties for SyncNoteTypeTemplate
+func (a *SyncNoteTypeTemplate) Set(fieldName string, value interface{}) {
+	if a.AdditionalProperties == nil {
+		a.AdditionalProperties = make(map[string]interface{})
+	}
+	a.AdditionalProperties[fieldName] = value
+}
+
+// Override default JSON handling for SyncNoteTypeTemplate to handle AdditionalProperties
+func (a *SyncNoteTypeTemplate) UnmarshalJSON(b []byte) error {
+	object := make(map[string]json.RawMessage)
+	err := json.Unmarshal(b, &object)
+	if err != nil {
+		return err
+	}
+
+	if raw, found := object["afmt"]; found {
+		err = json.Unmarshal(raw, &a.Afmt)
+		if err != nil {
+			return fmt.Errorf("error reading 'afmt': %w", err)
+		}
+		delete(object, "afmt")
+	}
+
+	if raw, found := object["name"]; found {
+		err = json.Unmarshal(raw, &a.Name)
+		if err != nil {
+			return fmt.Errorf("error reading 'name': %w", err)
+		}
+		delete(object, "name")
+	}
+
+	if raw, found := object["ord"]; found {
+		err = json.Unmarshal(raw, &a.Ord)
+		if err != nil {
+			return fmt.Errorf("error reading 'ord': %w", err)
+		}
+		delete(object, "ord")
+	}
+
+	if raw, found := object["qfmt"]; found {
+		err = json.Unmarshal(raw, &a.Qfmt)
+		if err != nil {
+			return fmt.Errorf("error reading 'qfmt': %w", err)
+		}
+		delete(object, "qfmt")
+	}
+
+	if len(object) != 0 {
+		a.AdditionalProperties = make(map[string]interface{})
+		for fieldName, fieldBuf := range object {
+			var fieldVal interface{}
+			err := json.Unmarshal(fieldBuf, &fieldVal)
+			if err != nil {
+				return fmt.Errorf("error unmarshaling field %s: %w", fieldName, err)
+			}
+			a.AdditionalProperties[fieldName] = fieldVal
+		}
+	}
+	return nil
+}
+
+// Override default JSON handling for SyncNoteTypeTemplate to handle AdditionalProperties
+func (a SyncNoteTypeTemplate) MarshalJSON() ([]byte, error) {
+	var err error
+	object := make(map[string]json.RawMessage)
+
+	object["afmt"], err = json.Marshal(a.Afmt)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling 'afmt': %w", err)
+	}
+
+	object["name"], err = json.Marshal(a.Name)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling 'name': %w", err)
+	}
+
+	object["ord"], err = json.Marshal(a.Ord)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling 'ord': %w", err)
+	}
+
+	object["qfmt"], err = json.Marshal(a.Qfmt)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling 'qfmt': %w", err)
+	}
+
+	for fieldName, field := range a.AdditionalProperties {
+		object[fieldName], err = json.Marshal(field)
+		if err != nil {
+			return nil, fmt.Errorf("error marshaling '%s': %w", fieldName, err)
+		}
+	}
+	return json.Marshal(object)
+}
+
 // ServerInterface represents all server handlers.
 type ServerInterface interface {
+	// Export the collection as an Anki package
+	// (GET /sync/export)
+	ExportCollection(w http.ResponseWriter, r *http.Request, params ExportCollectionParams)
 	// Full sync (reset and push)
 	// (POST /sync/full)
 	FullSync(w http.ResponseWriter, r *http.Request)
+	// Start a chunked full upload
+	// (POST /sync/full/begin)
+	BeginFullUpload(w http.ResponseWriter, r *http.Request)
+	// Abort a full upload
+	// (DELETE /sync/full/{upload_id})
+	AbortFullUpload(w http.ResponseWriter, r *http.Request, uploadId string)
+	// Upload one chunk of a full upload
+	// (PUT /sync/full/{upload_id}/chunks/{seq})
+	UploadFullChunk(w http.ResponseWriter, r *http.Request, uploadId string, seq int)
+	// Commit a full upload
+	// (POST /sync/full/{upload_id}/commit)
+	CommitFullUpload(w http.ResponseWriter, r *http.Request, uploadId string)
+	// List media changes
+	// (GET /sync/media/changes)
+	GetMediaChanges(w http.ResponseWriter, r *http.Request, params GetMediaChangesParams)
 	// List user media files
 	// (GET /sync/media/list)
 	ListMedia(w http.ResponseWriter, r *http.Request)
 	// Upload media file
 	// (POST /sync/media/upload)
 	UploadMedia(w http.ResponseWriter, r *http.Request)
+	// Delete media file
+	// (DELETE /sync/media/{hash})
+	DeleteMedia(w http.ResponseWriter, r *http.Request, hash string)
 	// Download media file
 	// (GET /sync/media/{hash})
 	DownloadMedia(w http.ResponseWriter, r *http.Request, hash string)
+	// Confirm a reserved media upload
+	// (POST /sync/media/{hash}/confirm)
+	ConfirmMedia(w http.ResponseWriter, r *http.Request, hash string)
 	// Get sync metadata
 	// (GET /sync/meta)
 	GetSyncMeta(w http.ResponseWriter, r *http.Request)
@@ -180,12 +832,48 @@ type ServerInterface interface {
 
 type Unimplemented struct{}
 
+// Export the collection as an Anki package
+// (GET /sync/export)
+func (_ Unimplemented) ExportCollection(w http.ResponseWriter, r *http.Request, params ExportCollectionParams) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
 // Full sync (reset and push)
 // (POST /sync/full)
 func (_ Unimplemented) FullSync(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNotImplemented)
 }
 
+// Start a chunked full upload
+// (POST /sync/full/begin)
+func (_ Unimplemented) BeginFullUpload(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
+// Abort a full upload
+// (DELETE /sync/full/{upload_id})
+func (_ Unimplemented) AbortFullUpload(w http.ResponseWriter, r *http.Request, uploadId string) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
+// Upload one chunk of a full upload
+// (PUT /sync/full/{upload_id}/chunks/{seq})
+func (_ Unimplemented) UploadFullChunk(w http.ResponseWriter, r *http.Request, uploadId string, seq int) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
+// Commit a full upload
+// (POST /sync/full/{upload_id}/commit)
+func (_ Unimplemented) CommitFullUpload(w http.ResponseWriter, r *http.Request, uploadId string) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
+// List media changes
+// (GET /sync/media/changes)
+func (_ Unimplemented) GetMediaChanges(w http.ResponseWriter, r *http.Request, params GetMediaChangesParams) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
 // List user media files
 // (GET /sync/media/list)
 func (_ Unimplemented) ListMedia(w http.ResponseWriter, r *http.Request) {
@@ -198,12 +886,24 @@ func (_ Unimplemented) UploadMedia(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNotImplemented)
 }
 
+// Delete media file
+// (DELETE /sync/media/{hash})
+func (_ Unimplemented) DeleteMedia(w http.ResponseWriter, r *http.Request, hash string) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
 // Download media file
 // (GET /sync/media/{hash})
 func (_ Unimplemented) DownloadMedia(w http.ResponseWriter, r *http.Request, hash string) {
 	w.WriteHeader(http.StatusNotImplemented)
 }
 
+// Confirm a reserved media upload
+// (POST /sync/media/{hash}/confirm)
+func (_ Unimplemented) ConfirmMedia(w http.ResponseWriter, r *http.Request, hash string) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
 // Get sync metadata
 // (GET /sync/meta)
 func (_ Unimplemented) GetSyncMeta(w http.ResponseWriter, r *http.Request) {
@@ -231,6 +931,55 @@ type ServerInterfaceWrapper struct {
 
 type MiddlewareFunc func(http.Handler) http.Handler
 
+// ExportCollection operation middleware
+func (siw *ServerInterfaceWrapper) ExportCollection(w http.ResponseWriter, r *http.Request) {
+
+	var err error
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, BearerAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params ExportCollectionParams
+
+	// ------------- Optional query parameter "deck_id" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "deck_id", r.URL.Query(), &params.DeckId)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "deck_id", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "scheduling" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "scheduling", r.URL.Query(), &params.Scheduling)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "scheduling", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "delivery" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "delivery", r.URL.Query(), &params.Delivery)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "delivery", Err: err})
+		return
+	}
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.ExportCollection(w, r, params)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
 // FullSync operation middleware
 func (siw *ServerInterfaceWrapper) FullSync(w http.ResponseWriter, r *http.Request) {
 
@@ -251,6 +1000,177 @@ func (siw *ServerInterfaceWrapper) FullSync(w http.ResponseWriter, r *http.Reque
 	handler.ServeHTTP(w, r)
 }
 
+// BeginFullUpload operation middleware
+func (siw *ServerInterfaceWrapper) BeginFullUpload(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, BearerAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.BeginFullUpload(w, r)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// AbortFullUpload operation middleware
+func (siw *ServerInterfaceWrapper) AbortFullUpload(w http.ResponseWriter, r *http.Request) {
+
+	var err error
+
+	// ------------- Path parameter "upload_id" -------------
+	var uploadId string
+
+	err = runtime.BindStyledParameterWithOptions("simple", "upload_id", chi.URLParam(r, "upload_id"), &uploadId, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "upload_id", Err: err})
+		return
+	}
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, BearerAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.AbortFullUpload(w, r, uploadId)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// UploadFullChunk operation middleware
+func (siw *ServerInterfaceWrapper) UploadFullChunk(w http.ResponseWriter, r *http.Request) {
+
+	var err error
+
+	// ------------- Path parameter "upload_id" -------------
+	var uploadId string
+
+	err = runtime.BindStyledParameterWithOptions("simple", "upload_id", chi.URLParam(r, "upload_id"), &uploadId, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "upload_id", Err: err})
+		return
+	}
+
+	// ------------- Path parameter "seq" -------------
+	var seq int
+
+	err = runtime.BindStyledParameterWithOptions("simple", "seq", chi.URLParam(r, "seq"), &seq, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "seq", Err: err})
+		return
+	}
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, BearerAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.UploadFullChunk(w, r, uploadId, seq)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// CommitFullUpload operation middleware
+func (siw *ServerInterfaceWrapper) CommitFullUpload(w http.ResponseWriter, r *http.Request) {
+
+	var err error
+
+	// ------------- Path parameter "upload_id" -------------
+	var uploadId string
+
+	err = runtime.BindStyledParameterWithOptions("simple", "upload_id", chi.URLParam(r, "upload_id"), &uploadId, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "upload_id", Err: err})
+		return
+	}
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, BearerAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.CommitFullUpload(w, r, uploadId)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// GetMediaChanges operation middleware
+func (siw *ServerInterfaceWrapper) GetMediaChanges(w http.ResponseWriter, r *http.Request) {
+
+	var err error
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, BearerAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params GetMediaChangesParams
+
+	// ------------- Optional query parameter "since" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "since", r.URL.Query(), &params.Since)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "since", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "limit" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "limit", r.URL.Query(), &params.Limit)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "limit", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "cursor" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "cursor", r.URL.Query(), &params.Cursor)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "cursor", Err: err})
+		return
+	}
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.GetMediaChanges(w, r, params)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
 // ListMedia operation middleware
 func (siw *ServerInterfaceWrapper) ListMedia(w http.ResponseWriter, r *http.Request) {
 
@@ -291,6 +1211,37 @@ func (siw *ServerInterfaceWrapper) UploadMedia(w http.ResponseWriter, r *http.Re
 	handler.ServeHTTP(w, r)
 }
 
+// DeleteMedia operation middleware
+func (siw *ServerInterfaceWrapper) DeleteMedia(w http.ResponseWriter, r *http.Request) {
+
+	var err error
+
+	// ------------- Path parameter "hash" -------------
+	var hash string
+
+	err = runtime.BindStyledParameterWithOptions("simple", "hash", chi.URLParam(r, "hash"), &hash, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "hash", Err: err})
+		return
+	}
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, BearerAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.DeleteMedia(w, r, hash)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
 // DownloadMedia operation middleware
 func (siw *ServerInterfaceWrapper) DownloadMedia(w http.ResponseWriter, r *http.Request) {
 
@@ -322,6 +1273,37 @@ func (siw *ServerInterfaceWrapper) DownloadMedia(w http.ResponseWriter, r *http.
 	handler.ServeHTTP(w, r)
 }
 
+// ConfirmMedia operation middleware
+func (siw *ServerInterfaceWrapper) ConfirmMedia(w http.ResponseWriter, r *http.Request) {
+
+	var err error
+
+	// ------------- Path parameter "hash" -------------
+	var hash string
+
+	err = runtime.BindStyledParameterWithOptions("simple", "hash", chi.URLParam(r, "hash"), &hash, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "hash", Err: err})
+		return
+	}
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, BearerAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.ConfirmMedia(w, r, hash)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
 // GetSyncMeta operation middleware
 func (siw *ServerInterfaceWrapper) GetSyncMeta(w http.ResponseWriter, r *http.Request) {
 
@@ -364,6 +1346,22 @@ func (siw *ServerInterfaceWrapper) PullSync(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	// ------------- Optional query parameter "limit" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "limit", r.URL.Query(), &params.Limit)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "limit", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "cursor" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "cursor", r.URL.Query(), &params.Cursor)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "cursor", Err: err})
+		return
+	}
+
 	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		siw.Handler.PullSync(w, r, params)
 	}))
@@ -508,18 +1506,42 @@ func HandlerWithOptions(si ServerInterface, options ChiServerOptions) http.Handl
 		ErrorHandlerFunc:   options.ErrorHandlerFunc,
 	}
 
+	r.Group(func(r chi.Router) {
+		r.Get(options.BaseURL+"/sync/export", wrapper.ExportCollection)
+	})
 	r.Group(func(r chi.Router) {
 		r.Post(options.BaseURL+"/sync/full", wrapper.FullSync)
 	})
+	r.Group(func(r chi.Router) {
+		r.Post(options.BaseURL+"/sync/full/begin", wrapper.BeginFullUpload)
+	})
+	r.Group(func(r chi.Router) {
+		r.Delete(options.BaseURL+"/sync/full/{upload_id}", wrapper.AbortFullUpload)
+	})
+	r.Group(func(r chi.Router) {
+		r.Put(options.BaseURL+"/sync/full/{upload_id}/chunks/{seq}", wrapper.UploadFullChunk)
+	})
+	r.Group(func(r chi.Router) {
+		r.Post(options.BaseURL+"/sync/full/{upload_id}/commit", wrapper.CommitFullUpload)
+	})
+	r.Group(func(r chi.Router) {
+		r.Get(options.BaseURL+"/sync/media/changes", wrapper.GetMediaChanges)
+	})
 	r.Group(func(r chi.Router) {
 		r.Get(options.BaseURL+"/sync/media/list", wrapper.ListMedia)
 	})
 	r.Group(func(r chi.Router) {
 		r.Post(options.BaseURL+"/sync/media/upload", wrapper.UploadMedia)
 	})
+	r.Group(func(r chi.Router) {
+		r.Delete(options.BaseURL+"/sync/media/{hash}", wrapper.DeleteMedia)
+	})
 	r.Group(func(r chi.Router) {
 		r.Get(options.BaseURL+"/sync/media/{hash}", wrapper.DownloadMedia)
 	})
+	r.Group(func(r chi.Router) {
+		r.Post(options.BaseURL+"/sync/media/{hash}/confirm", wrapper.ConfirmMedia)
+	})
 	r.Group(func(r chi.Router) {
 		r.Get(options.BaseURL+"/sync/meta", wrapper.GetSyncMeta)
 	})
@@ -536,28 +1558,89 @@ func HandlerWithOptions(si ServerInterface, options ChiServerOptions) http.Handl
 // Base64 encoded, gzipped, json marshaled Swagger object
 var swaggerSpec = []string{
 
-	"H4sIAAAAAAAC/+xYUW/bNhD+KwS3hw1Qa6fN9iAgD+mKBhmWLKgX7CEIDEY6WWwoUiGPKbzA/30gaVuS",
-	"RWVSsPRh2Jstnu6O3/fd8agnmqmqVhIkGpo+UZOVUDH/8wJyzs4RKven1qoGjRz8UsEFSFaB+43rGmhK",
-	"DWouV3ST0JKZMrKwSXZP1N0XyNCZ+hC/cYOfwdRKGuiHqpyJ+8ERKv/kew0FTel3sybz2TbtWZNzE45p",
-	"zdbPxL+uhWL5cAYD+0moQYbWjNzqYi2zX5jO+/5zhizqP4fsfsn9G4XSFUOaUi7x52O6988lwgq0t7Yw",
-	"0hKYgWXBMlS6FbdlUAi2MvGl0em4n/qRibgbwWoDAyEEFLjMlJUYX69UzgsO+ZLhyFykQhgPpNI5l0OJ",
-	"K81XbnU5jZzmtdEsPViwEE9CQz2AnZPkwEvWyNiCd/dguYacpjeO3wavRoINKl38g9td3F3SQYwtDXQ1",
-	"t93AXgUdyg+wiiC+02cSKud2oNg+QnbfL7ZMyYKvtsT1Qco0MJwirRxMpnmNXMloDY9WyAtUPdSApzDt",
-	"fCQtVDoQxLgegvtMs8dI81SjAQhPnrqI0vlJxnSekKMTJ8qEvDtxMog4ONid8nvxRkMZX0Dou92EBTO4",
-	"NGuZddLOGcIb5B6sCN6gBxU1TEY0qUuFERQzY6uxghw6TZ5p6oXITfSdle1s6yWynlIAIy1NIeJZYXeH",
-	"L6oIv+WQd8hp1+Iw9ByP1TaHJBAzsiFdWSGGpwwnczN6ztlPE70xJ/TsaZ58q4x4WrmanuYqtIGIL1e+",
-	"01z5Uoh4MqAfQS8nFtaVNeVneLBg8DXBzwQHiUPJ/dfJiYF/vbgcVv1ULj39mdUc1wuXTfByB0yDPrVY",
-	"Nv8+7frIr3/+4erVW9N0u9r0lRKxppuNn1gL1T+ATq/OSaE0cUdCqZXkf3G5IqfynpNCMFM66RBf9wlF",
-	"jsL5/L0G6S0cUuT06pwm9BG0CR6P3s7fzv1QWINkNacpfe8fJbRmWPodzVy4WWGFH0NrFUTrkGMur/Oc",
-	"pvSTFcIFoKGLgcEPKl9vhxyEMD6zuhY88y/NvpgwpAQex7DcLppNt12ituAfBGp91u/m838tfFs2PnSX",
-	"loXNMjCmsMIz4+A8nr/v07ewd/u/ZJ/9JqE/hVwPrL0cCWitgvyMrSqm11u0fSjygwYDSJjMSW1N+aM3",
-	"DIT5y+pM8EDXCiKsuduuv3bSVwSvf6+OQOjWiSqIT5q4K715CTDejRuBuo4OMLH+kj0s5nAJb4AZ0nNl",
-	"BfKaaZy5QeHNbtppYOl/qOhMFXdcurSTKZ8t2jOCd7g17h/037ZEYl8vIjwHC2L2FROKJcLyB5YTvSv3",
-	"6UrYBmpk0FPBk8NtM1gbH9VX2ZZBzTSrAEEbmt48Ue7iuxa5u7ikgYdDzJMWfods3k7iQ2UI+MagBlZ1",
-	"eflHQfV5uGjh4gg47oN7ATkjUiEplJX5Abw7dIYADpUQBfYMcH/jeUVB7mM837D1XqzTNXYGGLqw26+v",
-	"/gaCentcRiG4ak7LA1kddEVmkNxL9VWS68Wl/4hBU/pgwZO8lZ3hMgMa0Vkzuty+Ms6d28TzeHtcXv2A",
-	"dAmRrGRyBYYUWlUkDHcdgkKLjR8Bbtj4f54Rfqj4FnSZck8Xqoas1ojty6M9XN/cOl0H01A8Vgua0hmr",
-	"+ezxiG5uN38HAAD///x6dDZRGAAA",
+	"H4sIAAAAAAAC/+x8a28buZL2Xyn0+wJJBm3ZTjyzexzMhyRzOTmYJN54ggF2FBhUd0nNYzbZIdlWlCD/",
+	"fVEk+6ZmW/Il2bOY88mWmk0Wq54q1o36nGSqrJREaU1y+jkxWYElc//+hNnlCyWXfPWmslxJ9yXLc04f",
+	"mDjTqkJtOZrk1Ooa0yRHk2nuxianyRtboIZL3BhgGuESKwtM5qDR1lpiDsyAQWmTNKl6U32mabjG/EKj",
+	"Rekn+5wslS6ZTU6TXNULgUma2E2FyWki63KBOvmSJsjM5oJLi/qKiTBRj56f2MZ0r9G4lX9vabS5WCNf",
+	"FZ4F3GJp9lwzfMG0Zhv6vNIsr5nlcnUbSgQyLS+MxcqM3zqnr4FLKLmsLdIMd6FUIGbFhS00mkKJnGYZ",
+	"E1Syj7ysy9vsReL6okJ9kbNNfG6N33K7Gq84rs31JBnLtJMdMoNjks5Ql1wITAFnqxk8/v7oKLJ1t9iH",
+	"miCcnP454MOYjKHMt5kSx9M20iNi2t7KWNxpRM3et5tRi39iZoklP3+slLbv3v72Fk2lpGfLlr6qtRSK",
+	"5Re1jgDkTKPhK9L3d29/A7UEWyAYy1aYw4xVl6uOhcZqLle0Kn6suEZzwexQ3MzigeUljt/5EqH9l1qI",
+	"dxWR9kKVJbdv8UONxo53kBW1vIxg8LWDExHtR0DtZsMcHhr8AEdgVXhycPyI5MAlySE5Pd4Ji7Dk+2vJ",
+	"Pkdjgv0bEuzpuOB9te1xor9QNzS21ivMOXtRMLmKCRYFWszHfPm9QFhygbBmBsKop1AwUwA3TsCZkhal",
+	"BW5BMGOhYHkns4VSApl0tpcLlKzEyD7ShCaMPjD8U0Q9fyGK6BFZjcXGoknhCJZKexLdCZZ2YOLS/nAS",
+	"NV21kTH7sMXYlvRAqH8vbbm2g91mWqEyP2C8w/Am7e/d+WtQOkc9g2deFqyqkGkDzEKpjAUlM0xhzW0B",
+	"3BoQzKKxpHkWZ31j+v81LpPT5P8ddo7AYfACDvvwiBjVgpmLUumILF4pjRD2ARpLxuVT0F7/CCClJ0zi",
+	"R3uR1dooHYVH//mYG0paLsk6KglWXaJ0wib40XtQsRWmoKTYgEEL6wIlNBQ7nOoaY8bHoL5CfRFQMFyT",
+	"2E4LCG7IutI0C1VLMgmLzQzeGSTIM9NRYbjM0AljsPiSCYOzbvk+yuKweWmxHGPlNgo0ucRv3NhpWJY0",
+	"ZOAg7YSOo3kEnMn1vdWbNNN77XUor7/jRzj/+7ODY1Da/fP4+x+aQ8hpTTBUSbqvlTkfGpiswOySnNkV",
+	"49JjG4xVmq0QPtTKshm8DUYDainQeGjUBjUwoZHlG8JF32g+9SOao8bzCMraWCiZzUidAT+yzIrNbB+D",
+	"tmW3grFqmfl+lzSm8DBtni2zdcR8qUuvB8QfzFOoUOakROvCnyXcqRTpsAs7dOnIiImmrvZ1OKyCs3e/",
+	"d/K2KgW2MCiDQYgKg4XTjUyULbiZRkkMyucbmb1gOo8cqMyyKMdyzC7Dab7H+ZTz5ZJntbCbyCF4/vYc",
+	"SiyV3kA3bgZvSm4toUlCVZsCLhErjzpv7h4YuGKixgGipt3rvMY9iUVm8GLJMutN+HjAUrCViT/amyP9",
+	"+GT8VLDK4MQSApf2IlO1tPHnpcr5kmO+7YpO0yKVxf1lqXTO5RThSvMVPb24GT661/aW0ocaa5yK0yoz",
+	"GS4tuOC7YNgOu3cUOmcmTtt+PhzPk05gnRp2YhkCoHHx/LoN17w29EA4BH3gYAvDAea2hBUReaMgqbce",
+	"76cMjpJLwbNrju8sjNh5cvenMzQ/aq101G4NXaUdzPbTDF5Ke1Tt2lnkQHnjBsLLn0zwNR2yOjgBW1r0",
+	"HmEmOEr7wPhwxCA6F3qUe8qYzieSQNPKs+0WO9FlLnN2L3PdeRJC+L1MQt/dcaKpE5OyjXHQ8tUw0O1N",
+	"nWlk9ibGeYCfCKD3trG3OBemPNebmCofb3ZcGbAgZqzeX8Nun9yN5PQwuwTlPsFKq7pKQeMSNcrMxTnQ",
+	"TDDrExIT3C5TM04x31AG3573tGhzDoRNTvH4V82uIoZY7b1B/822eI5+JCuVwvGPpJIpPP6RjEQKT35s",
+	"VDSFE/cdBAJ3xgSKt+mZqc28QsvGeyFremE2Mts3T0esQz2p0NPyiBL1WtnYSWfqcl97MOWRX+OVLkVu",
+	"ou+s6mhC7iaI/grYN0sRp8oOd3grpXBb9nQPVcN6n8XxKtCQesHs6dCQaH+P4p+eAL0DD5/JSw6lylE8",
+	"itkoGjkrY9bJxHc+DQeOYssx2OVCNRv4hV6NHajf0s4Zpe2F20Qc0xbLymUIb7XF38PbsV1O2TBjmcyD",
+	"HcuE+oR3SMX27bMbOsRib/OtJPtbTh0e9sTjLw0Tv0EldFKcSud7sCVwhAbv2lUrwW+zMbYs7RgUz6RZ",
+	"o4aA88jRcVN+pMmH6EL/VaOhfyeXmmZkmDL1W5hi61ktxDWR2CjI2BmOMZ3fKNLYNWHP/dsr6Nhnvol6",
+	"+NUNjYr3mv4y1YZxbLaP1d0/QNvXhk8UzYVa3Wi6t/6VyGT7lFWqWohvVFPxamqK6aLwvWmpzz3Ed/6b",
+	"T0e4bAVxwWUmFptezmIGP0u2EOiy0C4TAjlazBwQQyJtNhHs/oWsw19Ej6ag/LZdYoivt67hBIRaAUqr",
+	"NzN4VlUo8wOyVCkwCTxvax++MEOqJJEAqa5QrzW3FuVs7EPv7b823TTjJ9fVBXg+uRvLS1eDw0plBVBP",
+	"DjeYKZnvWeDnV5OVAmMvJp/SspNeS0PTFjX7B/Wu8Ye8Yd8jRHF96Aai0H7JhUVXNTv5sWSydmnmO/nL",
+	"Ge885NAkRDvvMaGVT9j6NRmCd+evp/2d67PEaWIueVVhvo9a9PLSY02gqTCrNbebc3rHr75AplE/q23R",
+	"ffqlwcg//vg9SX3To3MG3NOOs4W1VfLli6swLVVE9mcvnRdAGZBCK8k/UQXTRaVLwUxBZwhQWDGby7mk",
+	"tplDGnpINjtt/xfCec3+45I+oswrxaU18JCeuO98zTP0Gz2aS4krZTmzSGcF17DmGoNDe9o6PwuVczRQ",
+	"sg0s0PniwMxcsqoSPHO+zGFpVhXLLh0JxHWNxmDuPaYXvu558LPMlCvVrj7xikrpn4zNyXrkc6mD3A0U",
+	"Sqpaw7Msw8qewtQi/nk75wxeoTFshWcsu5zLXGV1SXKH2qDP5bMSwYVtQI64aVyBf5y/eQ0BHDP/KXQf",
+	"5bhktbBzSctx4wOTUH33q8OKX6HprwwMCr6ieOZDqEHBc888pnEuBS+5xZzqyT+cwCv+HB4+eez+Vqi9",
+	"UB7BQtmiiXncVp2LkmPH1qdzKZhekW2VfmrQSOhtOH5y/GTmuAtLzcogOuLEmstcrQ01L9QVWDWX/0nL",
+	"z+Yu28KtQArIKpQOfaQr8OzsZZImV6h9E1lyPDuaHblwqULJKp6cJk/cV2lSMVs4bfEgRNf1R59XGImf",
+	"ntdc5IYOD9fB13b1bVzyJVNCePckJaioJe0VXD7SycOauTT1gr4waVNkR3DtJa7wbmgMuAOdtj6DP7gt",
+	"VG2dsPNaEBBtgXPpXDTHRE+wjzglriE8kbmbWneHIDdA5TdQtfWsI0PlMPoyT05Du+OLdgeONSQIi9ok",
+	"p39uc8KP926/6xTobxOaXbriYHJK9UK9aVIVp71yo0fxXsfpl3SbhpcyE3WOfe5w6echF9EH5I4BBacT",
+	"fjNBTvf+gKKgTMmpc6/HcdOYIGM1sjJkAbxGkvK7AKnWwnd/kpDpvGyaZbq8gQEGTU8pCC4vJ9kn+JX/",
+	"JkJtoCJJE5SUDv6z+4LaRt6PY/73adLaMprq8dFRqGRY9O0BfYv2T+NLSN3S151d4zZaOlT686nMoj0I",
+	"NA7mbSGx4JK57W5T/iWNNGi2LFcamOMjGS/ujuuTo5OJso9UFpYUfdGw74+OxsPOfbDiq7m0tKnLkuhq",
+	"tcH3MjUqRCrJpD8UA03ute64ozUqZWzM36sEy7DrmXpgejPTyUE2I1j4tNV3CqRca8FcujI7qURdwVKr",
+	"MtgDN23fVD0wMXNArbhkShPvP6Gxz1W+uTdUbMeg25gIp+ZdJhx4flbX+OUrorzvBd55L8PJRhA/r7MM",
+	"jVnWwp07HtVPInCtF+1HaFlxC3ATFtxS8FCjQX/AkyP3aAvOhwtccTkNajqkXY9Zz6szvtN7Br7pbluF",
+	"3CHpHxEZL8jdSOfSFijB97Z3TeOg+0oTUguZqjZkbRkYLlcCwWomDfOn9FwyAw3UIVdoZvCGTjQ6twNl",
+	"zs1xjXLBl1QVylNorhkAI4d07d5YMi5MaOpjUrkcMTWKL3BV+61w36ThO8NVrYMnmXPjz20lclpNLrnk",
+	"pnBdHRjVz+fE6W7ryVfE9rgrPwbKwKxQlL85KE+O/haLMD0T+4Dhrgu80mql0Zjb4PmcRAfM+644CDK2",
+	"Af25vUTwpbsXQP8NpfFsobQdSGPLd3KnOLma3SHezpxsG6r+qb77qD6J7d0LI8CqYXBk5LuBEt7tDHRM",
+	"GGr3New89OHc4WeDHxxvq9rG+v5pkHN0w80ZOtQEhWslt9RhfGBCLy0DupnSMwHMegnP5vJZu0O6AtTd",
+	"FDg+enzS3HAho3YMv/Ln5LeHxUIAO9K+LYP0FeU9cjH/G7U6WDCDISAGLnP8mKSxJQ1+uHaxcHsqOT0+",
+	"evykd4nnKOJ8v/9WjsAe5/bJBFCC2DzaI+B9Ka+Y4IFxt9KJk+Mn8UtBXhahLBJefWD6OHIhulLgouDb",
+	"6FegS8l2teUNtM0pTP9oHiJ6+yz92ibs/pE0deftf9UTHJ2TXYDd+uI74Rq45eKZYKycW+QtXndHT2lw",
+	"BaE16u4Wxe1gfvS3CRXztrjkhrypp82Z7CqNIY1WcuvvhNwG4y/C+xOwdpmSw94tsWiShi7zmEFWheXu",
+	"PoluruyF9lhXTUvBqFBwMpAx6TzdufSvr0P+xV0o8MkXT5rgxs7gjOJ3W2hVrwqv2M39KC+ikD1zrOnV",
+	"WEHwS4Sz4HfGDphf0fZvzO1Kxrha2qVUaxm27Tt8o7kO2nMS0c9r8iyv/DkBsncr1NHlfGOq//oGdwqz",
+	"NWbIrxCYEO0o5u/kzSZIcjwakHT9ddJ9CtQU8DKoKP2jauNofGD6MpjBHxRDGLRpqKpyA3wllcZ8itC2",
+	"gn4T/+z+TEv0DmXExrhxDfN3WhePUDItbnfUPuBim8Ftv0YPWBh1G90mtYRySNuWZtNyPbUe6gS939yL",
+	"+ro8HtwGjDDY7UQt+ybm1gzxkWV/oi2eBCM4GVSfo8yB+SrA+C6hV0kXC/cu16XbV8NcRN11L3W3zJrM",
+	"koSMNLp/PW0G7W1oyi1zYzH3hYeQDne5YW9pM/8e88mrUFwZ3FfoF2LNXD6k4hKTrgKgHwFvbLjmq8IC",
+	"W7NNSKD7K3egfO5Zqh7t5PgLi1oyy69QbLoN86ZSYaCsheUV4Z2yjd5Tc3sgQvK55KUTg0WxmY4EOlje",
+	"v18zlqmDSEv3IdF90PRvdnOOb5DulVK97u7s9iXw5gL4uC76bX2u2KXNiNo2Hk+bP5u0jc9Z63Vd6/X7",
+	"y5KqFjngxwwxH9+CbUpEIYdbCSbv4Px3hmJkJz6TKLayFNs55VJdDVPKfSdpdOlzBr7NMccrniGpDHo7",
+	"0fyqAHAJW55KTEd+cvQ0OrI7rAj3dO+SFPlm/rw/a4NbOelr+1F3yq54Jg4AkMbd37eYc42ZpXCzZ/K6",
+	"ghOV7V21xQC3wQ/qTPFcugxppnIM+Egp0iiZEKiBl2yFh+yKL9Pw/xoXrjDO6pyrQ7VazeXDN1VtHsEV",
+	"05xJ662tvsIcuDQWKYfndSJUpAtkOepQ4uZE96bCuVS6/XD4XRPxfKgZXagkl1Igc02OzDY61lwhfArf",
+	"HX43l0woSY7omm0MrNCawaAoUkPx7V8Gq/daG3vVYidtMhWNiCru7vI7t9lLheD15Og/puE1QJfSnbRb",
+	"oF2nDgNtGAK9KYDutHWHwauY9o1e0G8UhFygk72/Ntn+wkDYQjW4OI8fXfzoEvPuZweCyQxuVO5/aYV8",
+	"BKIi9d4Ry3PTd6h6RjbEkvRzJSU3bkZyqQMtvPc7MmEFN3eIrF0kPZvL4HjRi0y23lJgAOZ+WXeyYR41",
+	"wls/LPB/zArvecJ7hHvXbRJ9r1UnysBl38/MjZPoZAKkPfEL5po0YIEoOzBtcA9nwbsJZi8/of9zGD2c",
+	"uEUeP44v0lLjVssVekod6iIoVrrd8s1TNQ5QwLopvcZGsjbeN41Gdb+ibS/ZfUUAtWtcX1DVLbhuzpBf",
+	"0foqKe3X+eMdC6pQ7I+y4Kyrte+b5fna+R1vnP6d3/mmRm50f+aeuiIGM16HfofSO+WLaIZosujrdiic",
+	"1T00Omx4X3aggaboOwrbKmiKf7e7/Ku3u7gLH/BOUrQgmLEH1KKP+mDNpUlbm7UulEHqhUQ9aAJZs6Zb",
+	"yp32zPpCSVMSGPx+RurKHD6n5Txa36g9u3Vfw73BaPRzJxGWvQmciP80iLeG3dUc6uqh8VApwbPNoxm8",
+	"Vta5qMQxR23Y+c3V0hStWlrVKWWvX92dc/1O9T/fk5H1Q/0p6H7sKjlkFT+8Ok6+vP/yPwMAzOUvB7tX",
+	"AAA=",
 }
 
 // GetSwagger returns the content of the embedded swagger specification file
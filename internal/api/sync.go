@@ -1,13 +1,15 @@
 package api
 
 import (
+	"bytes"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"io"
 	"log"
+	"mime"
 	"net/http"
 	"os"
-	"path/filepath"
-	"strconv"
     "time"
 
 	"github.com/go-chi/chi/v5"
@@ -18,17 +20,32 @@ import (
 
 type SyncHandler struct{
     Repo *database.Repository
-    S3   *media.S3Service
+    Store media.BlobStore
+    // Transcoder makes variants of confirmed uploads, nil when disabled
+    Transcoder *media.Transcoder
+    // ConflictPolicy controls how pushes from stale clients are handled
+    ConflictPolicy database.ConflictPolicy
 }
 
+// maxPullLimit caps the page size a client may request from PullSync
+const maxPullLimit = 5000
+
 // Ensure SyncHandler implements ServerInterface
 var _ ServerInterface = (*SyncHandler)(nil)
 
-func RegisterSyncRoutes(r chi.Router, repo *database.Repository, s3Service *media.S3Service) {
-	handler := &SyncHandler{Repo: repo, S3: s3Service}
+func RegisterSyncRoutes(r chi.Router, repo *database.Repository, store media.BlobStore, transcoder *media.Transcoder) {
+	handler := &SyncHandler{
+        Repo:           repo,
+        Store:          store,
+        Transcoder:     transcoder,
+        ConflictPolicy: database.ParseConflictPolicy(os.Getenv("SYNC_CONFLICT_POLICY")),
+    }
     
     r.Group(func(r chi.Router) {
         r.Use(auth.Middleware)
+        r.Use(rateLimit("sync"))
+        r.Use(forRoute(http.MethodPost, "/sync/media/upload", rateLimit("media")))
+        r.Use(requireVerifiedEmail("sync"))
         HandlerFromMux(handler, r)
     })
 }
@@ -67,89 +84,36 @@ func (h *SyncHandler) PushSync(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	var req SyncPushRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	if err := decodeSyncBody(w, r, &req, maxSyncBodyBytes); err != nil {
+		writeDecodeError(w, err)
 		return
 	}
 
-    payload := &database.SyncPayload{}
-
-    if req.Decks != nil {
-        for _, d := range *req.Decks {
-            payload.Decks = append(payload.Decks, database.SyncDeck{
-                ID:          d.Id,
-                Name:        d.Name,
-                Description: safeString(d.Description),
-                ConfigID:    d.ConfigId,
-                CreatedAt:   d.CreatedAt,
-                ModifiedAt:  d.ModifiedAt,
-                USN:         d.Usn,
-            })
-        }
-    }
-    
-    if req.Notes != nil {
-        for _, n := range *req.Notes {
-            payload.Notes = append(payload.Notes, database.SyncNote{
-                ID:    n.Id,
-                GUID:  n.Guid,
-                MID:   n.Mid,
-                Mod:   n.Mod,
-                USN:   n.Usn,
-                Tags:  n.Tags,
-                Flds:  n.Flds,
-                Sfld:  n.Sfld,
-                Csum:  n.Csum,
-                Flags: n.Flags,
-                Data:  n.Data,
-            })
-        }
-    }
-    
-    if req.Cards != nil {
-        for _, c := range *req.Cards {
-            payload.Cards = append(payload.Cards, database.SyncCard{
-                ID:             c.Id,
-                NoteID:         c.NoteId,
-                DeckID:         c.DeckId,
-                Ordinal:        c.Ordinal,
-                ModifiedAt:     c.ModifiedAt,
-                USN:            c.Usn,
-                State:          c.State,
-                Queue:          c.Queue,
-                Due:            c.Due,
-                Interval:       c.Interval,
-                EaseFactor:     c.EaseFactor,
-                Reps:           c.Reps,
-                Lapses:         c.Lapses,
-                LeftCount:      c.LeftCount,
-                OriginalDue:    c.OriginalDue,
-                OriginalDeckID: c.OriginalDeckId,
-                Flags:          c.Flags,
-                Data:           c.Data,
-            })
-        }
-    }
-    
-    if req.Graves != nil {
-        for _, g := range *req.Graves {
-            payload.Graves = append(payload.Graves, database.SyncGrave{
-                OID:  g.Oid,
-                Type: g.Type,
-            })
-        }
-    }
+    payload := toSyncPayload(&req)
 
 	// Execute transactional sync
-	newUSN, err := h.Repo.PushSyncSafe(userID, payload)
+	result, err := h.Repo.PushSyncSafe(userID, payload, h.ConflictPolicy)
+	var conflictErr *database.ConflictError
+	if errors.As(err, &conflictErr) {
+		writeSyncResponse(w, r, http.StatusConflict, SyncConflictResponse{
+			Error:     "Server has changes newer than client_usn; pull and retry",
+			ServerUsn: conflictErr.ServerUSN,
+			Conflicts: toAPIConflicts(conflictErr.Conflicts),
+		})
+		return
+	}
 	if err != nil {
 		http.Error(w, "Sync failed: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	// Return new USN
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(USNResponse{ServerUsn: &newUSN})
+	resp := USNResponse{ServerUsn: &result.USN}
+	if !result.Skipped.Empty() {
+		skipped := toAPIConflicts(result.Skipped)
+		resp.Skipped = &skipped
+	}
+	writeSyncResponse(w, r, http.StatusOK, resp)
 }
 
 // PullSync returns changes since client's last USN
@@ -170,21 +134,32 @@ func (h *SyncHandler) PullSync(w http.ResponseWriter, r *http.Request, params Pu
     if params.Since != nil {
         since = *params.Since
     }
+    limit := 0
+    if params.Limit != nil {
+        if *params.Limit < 1 {
+            http.Error(w, "limit must be positive", http.StatusBadRequest)
+            return
+        }
+        limit = min(*params.Limit, maxPullLimit)
+    }
+    cursor := ""
+    if params.Cursor != nil {
+        cursor = *params.Cursor
+    }
 
-	meta, err := h.Repo.GetSyncMeta(userID)
-	if err != nil {
-		http.Error(w, "Database error", http.StatusInternalServerError)
+	page, err := h.Repo.PullChanges(userID, since, cursor, limit)
+	if errors.Is(err, database.ErrInvalidCursor) {
+		http.Error(w, "Invalid or expired cursor", http.StatusBadRequest)
 		return
 	}
-
-	dbDecks, err := h.Repo.GetDecksSince(userID, since)
 	if err != nil {
-		http.Error(w, "Failed to get decks", http.StatusInternalServerError)
+		log.Printf("❌ Error PullSync PullChanges: %v", err)
+		http.Error(w, "Failed to get changes", http.StatusInternalServerError)
 		return
 	}
     
-    decks := make([]SyncDeck, len(dbDecks))
-    for i, d := range dbDecks {
+    decks := make([]SyncDeck, len(page.Decks))
+    for i, d := range page.Decks {
         decks[i] = SyncDeck{
             Id:          d.ID,
             Name:        d.Name,
@@ -196,13 +171,8 @@ func (h *SyncHandler) PullSync(w http.ResponseWriter, r *http.Request, params Pu
         }
     }
 
-	dbNotes, err := h.Repo.GetNotesSince(userID, since)
-	if err != nil {
-		http.Error(w, "Failed to get notes", http.StatusInternalServerError)
-		return
-	}
-    notes := make([]SyncNote, len(dbNotes))
-    for i, n := range dbNotes {
+    notes := make([]SyncNote, len(page.Notes))
+    for i, n := range page.Notes {
         notes[i] = SyncNote{
             Id:    n.ID,
             Guid:  n.GUID,
@@ -218,14 +188,8 @@ func (h *SyncHandler) PullSync(w http.ResponseWriter, r *http.Request, params Pu
         }
     }
 
-	dbCards, err := h.Repo.GetCardsSince(userID, since)
-	if err != nil {
-        log.Printf("❌ Error PullSync GetCardsSince: %v", err)
-		http.Error(w, "Failed to get cards", http.StatusInternalServerError)
-		return
-	}
-    cards := make([]SyncCard, len(dbCards))
-    for i, c := range dbCards {
+    cards := make([]SyncCard, len(page.Cards))
+    for i, c := range page.Cards {
         cards[i] = SyncCard{
             Id:             c.ID,
             NoteId:         c.NoteID,
@@ -245,104 +209,78 @@ func (h *SyncHandler) PullSync(w http.ResponseWriter, r *http.Request, params Pu
             OriginalDeckId: c.OriginalDeckID,
             Flags:          c.Flags,
             Data:           c.Data,
+            Stability:      c.Stability,
+            Difficulty:     c.Difficulty,
         }
     }
 
-
-	dbGraves, err := h.Repo.GetGravesSince(userID, since)
-	if err != nil {
-		http.Error(w, "Failed to get graves", http.StatusInternalServerError)
-		return
-	}
-    graves := make([]SyncGrave, len(dbGraves))
-    for i, g := range dbGraves {
+    graves := make([]SyncGrave, len(page.Graves))
+    for i, g := range page.Graves {
         graves[i] = SyncGrave{
             Oid:  g.OID,
             Type: g.Type,
         }
     }
 
+    revlog := make([]SyncRevlog, len(page.Revlog))
+    for i, e := range page.Revlog {
+        revlog[i] = SyncRevlog{
+            Id:      e.ID,
+            Cid:     e.CID,
+            Usn:     e.USN,
+            Ease:    e.Ease,
+            Ivl:     e.Ivl,
+            LastIvl: e.LastIvl,
+            Factor:  e.Factor,
+            Time:    e.Time,
+            Type:    e.Type,
+        }
+    }
+
+	noteTypes := toAPINoteTypes(page.NoteTypes)
+	deckConfigs := toAPIDeckConfigs(page.DeckConfigs)
+
 	resp := SyncPullResponse{
-		ServerUsn: &meta.USN,
-		Decks:     &decks,
-		Notes:     &notes,
-		Cards:     &cards,
-		Graves:    &graves,
+		ServerUsn:   &page.ServerUSN,
+		HasMore:     &page.HasMore,
+		Decks:       &decks,
+		Notes:       &notes,
+		Cards:       &cards,
+		Graves:      &graves,
+		Revlog:      &revlog,
+		Notetypes:   &noteTypes,
+		DeckConfigs: &deckConfigs,
+	}
+	if page.HasMore {
+		resp.NextCursor = &page.Cursor
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
+	writeSyncResponse(w, r, http.StatusOK, resp)
 }
 
-// FullSync handles initial sync or reset - client sends all data
+// FullSync handles initial sync or reset - client sends all data.
+// Large collections should use the chunked BeginFullUpload flow instead.
 func (h *SyncHandler) FullSync(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value("user_id").(int)
-
-	user, err := database.GetUserByID(userID)
-	if err != nil {
-		http.Error(w, "Failed to get user", http.StatusInternalServerError)
-		return
-	}
-	if user.SubscriptionStatus == "free" {
-		http.Error(w, "Sync requires a subscription", http.StatusForbidden)
+	if !h.requireSubscription(w, userID) {
 		return
 	}
 
 	var req SyncPushRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	if err := decodeSyncBody(w, r, &req, maxSyncBodyBytes); err != nil {
+		writeDecodeError(w, err)
 		return
 	}
 
-	// This is NOT using generated safe push because FullSync logic is slightly different (Delete data first).
-    // But we can just use PushSyncSafe after delete!
-    
-	// Clear all existing data for this user
-	if err := h.Repo.DeleteUserData(userID); err != nil {
-		http.Error(w, "Failed to reset user data", http.StatusInternalServerError)
-		return
-	}
-
-    // Convert and push
-    payload := &database.SyncPayload{}
-    if req.Decks != nil {
-        for _, d := range *req.Decks {
-            payload.Decks = append(payload.Decks, database.SyncDeck{
-                ID:          d.Id,
-                Name:        d.Name,
-                Description: safeString(d.Description),
-                ConfigID:    d.ConfigId,
-                CreatedAt:   d.CreatedAt,
-                ModifiedAt:  d.ModifiedAt,
-                USN:         d.Usn,
-            })
-        }
-    }
-    // ... (Mapping others omitted for brevity, but needed in real code)
-    // Actually we need to map all of them. reusing mapping logic would be smart.
-    if req.Notes != nil {
-        for _, n := range *req.Notes {
-            payload.Notes = append(payload.Notes, database.SyncNote{
-                ID: n.Id, GUID: n.Guid, MID: n.Mid, Mod: n.Mod, USN: n.Usn, Tags: n.Tags, Flds: n.Flds, Sfld: n.Sfld, Csum: n.Csum, Flags: n.Flags, Data: n.Data,
-            })
-        }
-    }
-    if req.Cards != nil {
-        for _, c := range *req.Cards {
-            payload.Cards = append(payload.Cards, database.SyncCard{
-                ID: c.Id, NoteID: c.NoteId, DeckID: c.DeckId, Ordinal: c.Ordinal, ModifiedAt: c.ModifiedAt, USN: c.Usn, State: c.State, Queue: c.Queue, Due: c.Due, Interval: c.Interval, EaseFactor: c.EaseFactor, Reps: c.Reps, Lapses: c.Lapses, LeftCount: c.LeftCount, OriginalDue: c.OriginalDue, OriginalDeckID: c.OriginalDeckId, Flags: c.Flags, Data: c.Data,
-            })
-        }
-    }
-    
-	newUSN, err := h.Repo.PushSyncSafe(userID, payload)
+	// Replaces the collection in one transaction, the old data survives a failure
+	usn, err := h.Repo.ReplaceCollection(userID, toSyncPayload(&req))
 	if err != nil {
+		log.Printf("❌ Error FullSync ReplaceCollection: %v", err)
 		http.Error(w, "Failed to push data", http.StatusInternalServerError)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(USNResponse{ServerUsn: &newUSN})
+	writeSyncResponse(w, r, http.StatusOK, USNResponse{ServerUsn: &usn})
 }
 
 // ListMedia returns all media hashes for a user
@@ -371,74 +309,110 @@ func (h *SyncHandler) ListMedia(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(MediaListResponse{Media: &media})
 }
 
-// UploadMedia handles media file uploads - returns Presigned URL for R2/S3
+// UploadMedia handles media file uploads. A JSON request reserves the upload
+// and returns a presigned URL, unless the user already has the content;
+// multipart form data is stored directly.
 func (h *SyncHandler) UploadMedia(w http.ResponseWriter, r *http.Request) {
     userID := r.Context().Value("user_id").(int)
-    
-    // Check if using S3/R2
-    if h.S3 != nil && h.S3.IsConfigured {
-        // Parse JSON request: { "hash": "...", "filename": "...", "size": ... }
-        var req struct {
-            Hash     string `json:"hash"`
-            Filename string `json:"filename"`
-            Size     int64  `json:"size"`
+
+    mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+    if mediaType == "multipart/form-data" {
+        h.uploadMediaForm(w, r, userID)
+        return
+    }
+
+    // Parse JSON request: { "hash": "...", "filename": "...", "size": ... }
+    var req struct {
+        Hash     string `json:"hash"`
+        Filename string `json:"filename"`
+        Size     int64  `json:"size"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "Invalid JSON", http.StatusBadRequest)
+        return
+    }
+
+    if req.Hash == "" {
+         http.Error(w, "Hash required", http.StatusBadRequest)
+         return
+    }
+    if !isContentHash(req.Hash) {
+         http.Error(w, "Hash must be a hex SHA-1 or SHA-256 digest", http.StatusBadRequest)
+         return
+    }
+    if req.Size < 0 {
+         http.Error(w, "Invalid size", http.StatusBadRequest)
+         return
+    }
+
+    // Content the user already has is added under the new name without
+    // another upload. Content anyone else stored is only shared once the
+    // upload is hashed on confirmation: a client knowing a hash has not
+    // shown it has the content.
+    known, err := h.Repo.GetMediaFileByHash(userID, req.Hash)
+    if err == nil {
+        file := database.MediaFile{
+            Filename: req.Filename,
+            Hash:     req.Hash,
+            Size:     known.Size,
+            Blob:     known.Blob,
         }
-        
-        // Try to decode JSON, if fails, might be old Multipart form (fallback?)
-        // The user specifically wants presigned flow. I will prioritize that.
-        // But for "UploadMedia" name, maybe I should check Content-Type or implement a new route?
-        // Let's assume the frontend will send JSON to this endpoint now.
-        
-        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-            // Fallback to old behavior if JSON decode fails? 
-            // Or maybe check if it's multipart?
-            // "Don't proxy... kills performance." -> I should enforce presigned.
-            http.Error(w, "Invalid JSON or Multipart not supported (use R2)", http.StatusBadRequest)
+        _, err = h.Repo.PutMedia(userID, file)
+        if err == nil {
+            status := "ok"
+            w.Header().Set("Content-Type", "application/json")
+            json.NewEncoder(w).Encode(MediaUploadResponse{Status: &status, Hash: &req.Hash})
             return
         }
-        
-        if req.Hash == "" {
-             http.Error(w, "Hash required", http.StatusBadRequest)
-             return
-        }
+    }
+    if err != nil && !errors.Is(err, database.ErrMediaNotFound) && !errors.Is(err, database.ErrMediaBlobMissing) {
+        log.Printf("❌ Error storing known media: %v", err)
+        http.Error(w, "Failed to store media", http.StatusInternalServerError)
+        return
+    }
 
-        // Generate Presigned PUT URL
-        // Key format: userID/hash (or just hash if globally unique? typically hash is content addressable)
-        // But user might want folders. `data/media/userID/hash` matches old structure.
-        key := strconv.Itoa(userID) + "/" + req.Hash
-        
-        // Determine content type (optional, S3 puts default to octet-stream)
-        // We can just use generic binary.
-        url, err := h.S3.GeneratePresignedPutURL(key, "application/octet-stream", 15*time.Minute)
-        if err != nil {
-            http.Error(w, "Failed to generate upload URL", http.StatusInternalServerError)
-            return
-        }
-        
-        // Optimistically record in DB or wait for confirmation?
-        // User flow: "App requests signed URL -> Backend generates it -> App uploads"
-        // Missing step: "App tells Backend it finished".
-        // If I record it now, and upload fails, I have a broken link.
-        // But if I don't record it, I don't know it exists.
-        // I will return the URL. The client should probably call a "Confirm" endpoint.
-        // OR I can just Insert user_media now.
-        
-        usn, _ := h.Repo.IncrementUSN(userID)
-        h.Repo.DB.Exec(`
-            INSERT OR REPLACE INTO user_media (user_id, filename, hash, size, usn)
-            VALUES (?, ?, ?, ?, ?)
-        `, userID, req.Filename, req.Hash, req.Size, usn)
-        
-        w.Header().Set("Content-Type", "application/json")
-        json.NewEncoder(w).Encode(map[string]string{
-            "upload_url": url,
-            "hash": req.Hash,
-        })
+    // A presigned upload is checked against the reserved size before it is
+    // read on confirmation, so the size must be known up front
+    if req.Size == 0 {
+         http.Error(w, "Size required", http.StatusBadRequest)
+         return
+    }
+
+    // The claimed size is checked again against the object on confirmation
+    if !checkQuota(w, h.Repo, userID, req.Size) {
+         return
+    }
+
+    // Generate Presigned PUT URL
+    url, err := h.Store.PresignPut(r.Context(), media.MediaKey(userID, req.Hash), "application/octet-stream", 15*time.Minute)
+    if errors.Is(err, media.ErrPresignUnsupported) {
+        http.Error(w, "Direct uploads are not available; send the file as multipart form data", http.StatusNotImplemented)
+        return
+    }
+    if err != nil {
+        http.Error(w, "Failed to generate upload URL", http.StatusInternalServerError)
+        return
+    }
+
+    // Reserve only: the file is recorded in user_media (and synced to
+    // other devices) once ConfirmMedia has checked the uploaded object.
+    if err := h.Repo.ReserveMedia(userID, req.Filename, req.Hash, req.Size); err != nil {
+        log.Printf("❌ Error ReserveMedia: %v", err)
+        http.Error(w, "Failed to reserve upload", http.StatusInternalServerError)
         return
     }
 
-    // Legacy Local Filesystem Fallback
-    log.Println("⚠️ Falling back to legacy upload (S3 not configured or skipped)")
+    status := "pending"
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(MediaUploadResponse{
+        Status:    &status,
+        Hash:      &req.Hash,
+        UploadUrl: &url,
+    })
+}
+
+// uploadMediaForm stores a media file sent as multipart form data
+func (h *SyncHandler) uploadMediaForm(w http.ResponseWriter, r *http.Request, userID int) {
 	err := r.ParseMultipartForm(50 << 20) // 50MB limit
 	if err != nil {
 		http.Error(w, "File too large", http.StatusBadRequest)
@@ -457,74 +431,319 @@ func (h *SyncHandler) UploadMedia(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Hash required", http.StatusBadRequest)
 		return
 	}
+	if !isContentHash(hash) {
+		http.Error(w, "Hash must be a hex SHA-1 or SHA-256 digest", http.StatusBadRequest)
+		return
+	}
+	if !checkQuota(w, h.Repo, userID, handler.Size) {
+		return
+	}
 
-	// Create user media directory
-	mediaDir := filepath.Join("./data/media", strconv.Itoa(userID))
-	os.MkdirAll(mediaDir, 0755)
-
-	// Save file with hash as filename
-	filePath := filepath.Join(mediaDir, hash)
-	dst, err := os.Create(filePath)
+	data, err := io.ReadAll(file)
 	if err != nil {
+		http.Error(w, "Failed to read file", http.StatusBadRequest)
+		return
+	}
+	if want, _ := hex.DecodeString(hash); !bytes.Equal(contentHash(data, len(want)), want) {
+		http.Error(w, "File does not match its hash", http.StatusUnprocessableEntity)
+		return
+	}
+	blob, err := media.StoreContent(r.Context(), h.Repo, h.Store, data)
+	if err != nil {
+		log.Printf("❌ Error storing media: %v", err)
 		http.Error(w, "Failed to save file", http.StatusInternalServerError)
 		return
 	}
-	defer dst.Close()
-	io.Copy(dst, file)
 
 	// Record in database
-	usn, _ := h.Repo.IncrementUSN(userID)
-	h.Repo.DB.Exec(`
-		INSERT OR REPLACE INTO user_media (user_id, filename, hash, size, usn)
-		VALUES (?, ?, ?, ?, ?)
-	`, userID, handler.Filename, hash, handler.Size, usn)
+	stored := database.MediaFile{
+		Filename: handler.Filename,
+		Hash:     hash,
+		Size:     int64(len(data)),
+		Blob:     blob,
+	}
+	if _, err := h.Repo.PutMedia(userID, stored); err != nil {
+		log.Printf("❌ Error PutMedia: %v", err)
+		http.Error(w, "Failed to store media", http.StatusInternalServerError)
+		return
+	}
+	h.Transcoder.Enqueue(userID, stored)
 
     status := "ok"
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(MediaUploadResponse{Status: &status, Hash: &hash})
 }
 
-// DownloadMedia serves a media file by hash
+// DownloadMedia serves a media file by hash, redirecting to the store when it
+// can presign URLs. A smaller variant (WebP, AVIF, Opus) is served instead
+// when the Accept header asks for its type.
 func (h *SyncHandler) DownloadMedia(w http.ResponseWriter, r *http.Request, hash string) {
 	userID := r.Context().Value("user_id").(int)
-    
-    // Check if using S3/R2
-    if h.S3 != nil && h.S3.IsConfigured {
-        key := strconv.Itoa(userID) + "/" + hash
-        url, err := h.S3.GeneratePresignedGetURL(key, 60*time.Minute)
-        if err != nil {
-             http.Error(w, "Failed to get download URL", http.StatusInternalServerError)
-             return
-        }
-        
-        // Redirect to R2
+
+	file, err := h.Repo.GetMediaFileByHash(userID, hash)
+	if errors.Is(err, database.ErrMediaNotFound) {
+		http.Error(w, "Media not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	key := media.FileKey(userID, file)
+	filename, contentType := file.Filename, "application/octet-stream"
+
+	w.Header().Set("Vary", "Accept")
+	if accept := r.Header.Get("Accept"); accept != "" {
+		variants, err := h.Repo.ListMediaVariants(userID, file.Hash)
+		if err != nil {
+			log.Printf("❌ Error ListMediaVariants: %v", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if v := negotiateVariant(accept, file.Filename, variants); v != nil {
+			key = media.BlobKey(v.Blob)
+			filename, contentType = media.VariantFilename(file.Filename, v.ContentType), v.ContentType
+		}
+	}
+
+    url, err := h.Store.PresignGet(r.Context(), key, 60*time.Minute)
+    if err == nil {
         http.Redirect(w, r, url, http.StatusTemporaryRedirect)
         return
     }
+    if !errors.Is(err, media.ErrPresignUnsupported) {
+        http.Error(w, "Failed to get download URL", http.StatusInternalServerError)
+        return
+    }
 
-	// Legacy Local Filesystem Fallback
-	filePath := filepath.Join("./data/media", strconv.Itoa(userID), hash)
-
-	file, err := os.Open(filePath)
+	data, err := h.Store.Get(r.Context(), key)
 	if err != nil {
 		http.Error(w, "Media not found", http.StatusNotFound)
 		return
 	}
-	defer file.Close()
 
-	// Get filename from database
-	var filename string
-	err = h.Repo.DB.QueryRow(
-		"SELECT filename FROM user_media WHERE user_id = ? AND hash = ?",
-		userID, hash,
-	).Scan(&filename)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	w.Header().Set("Content-Type", contentType)
+	w.Write(data)
+}
+
+// requireSubscription writes a 403 and returns false for users without a paid plan
+func (h *SyncHandler) requireSubscription(w http.ResponseWriter, userID int) bool {
+	user, err := database.GetUserByID(userID)
 	if err != nil {
-		filename = hash // fallback
+		http.Error(w, "Failed to get user", http.StatusInternalServerError)
+		return false
 	}
+	if user.SubscriptionStatus == "free" {
+		http.Error(w, "Sync requires a subscription", http.StatusForbidden)
+		return false
+	}
+	return true
+}
 
-	w.Header().Set("Content-Disposition", "attachment; filename=\""+filename+"\"")
-	w.Header().Set("Content-Type", "application/octet-stream")
-	io.Copy(w, file)
+// toSyncPayload converts a push request to the database representation
+func toSyncPayload(req *SyncPushRequest) *database.SyncPayload {
+    payload := &database.SyncPayload{ClientUSN: req.ClientUsn}
+
+    if req.Decks != nil {
+        for _, d := range *req.Decks {
+            payload.Decks = append(payload.Decks, database.SyncDeck{
+                ID:          d.Id,
+                Name:        d.Name,
+                Description: safeString(d.Description),
+                ConfigID:    d.ConfigId,
+                CreatedAt:   d.CreatedAt,
+                ModifiedAt:  d.ModifiedAt,
+                USN:         d.Usn,
+            })
+        }
+    }
+    
+    if req.Notes != nil {
+        for _, n := range *req.Notes {
+            payload.Notes = append(payload.Notes, database.SyncNote{
+                ID:    n.Id,
+                GUID:  n.Guid,
+                MID:   n.Mid,
+                Mod:   n.Mod,
+                USN:   n.Usn,
+                Tags:  n.Tags,
+                Flds:  n.Flds,
+                Sfld:  n.Sfld,
+                Csum:  n.Csum,
+                Flags: n.Flags,
+                Data:  n.Data,
+            })
+        }
+    }
+    
+    if req.Cards != nil {
+        for _, c := range *req.Cards {
+            payload.Cards = append(payload.Cards, database.SyncCard{
+                ID:             c.Id,
+                NoteID:         c.NoteId,
+                DeckID:         c.DeckId,
+                Ordinal:        c.Ordinal,
+                ModifiedAt:     c.ModifiedAt,
+                USN:            c.Usn,
+                State:          c.State,
+                Queue:          c.Queue,
+                Due:            c.Due,
+                Interval:       c.Interval,
+                EaseFactor:     c.EaseFactor,
+                Reps:           c.Reps,
+                Lapses:         c.Lapses,
+                LeftCount:      c.LeftCount,
+                OriginalDue:    c.OriginalDue,
+                OriginalDeckID: c.OriginalDeckId,
+                Flags:          c.Flags,
+                Data:           c.Data,
+                Stability:      c.Stability,
+                Difficulty:     c.Difficulty,
+            })
+        }
+    }
+    
+    if req.Graves != nil {
+        for _, g := range *req.Graves {
+            payload.Graves = append(payload.Graves, database.SyncGrave{
+                OID:  g.Oid,
+                Type: g.Type,
+            })
+        }
+    }
+
+    if req.Revlog != nil {
+        for _, e := range *req.Revlog {
+            payload.Revlog = append(payload.Revlog, database.SyncRevlog{
+                ID:      e.Id,
+                CID:     e.Cid,
+                USN:     e.Usn,
+                Ease:    e.Ease,
+                Ivl:     e.Ivl,
+                LastIvl: e.LastIvl,
+                Factor:  e.Factor,
+                Time:    e.Time,
+                Type:    e.Type,
+            })
+        }
+    }
+
+    if req.Notetypes != nil {
+        payload.NoteTypes = fromAPINoteTypes(*req.Notetypes)
+    }
+    if req.DeckConfigs != nil {
+        payload.DeckConfigs = fromAPIDeckConfigs(*req.DeckConfigs)
+    }
+
+    return payload
+}
+
+func toAPIConflicts(c database.SyncConflicts) SyncConflicts {
+    res := SyncConflicts{}
+    if len(c.Decks) > 0 {
+        res.Decks = &c.Decks
+    }
+    if len(c.Notes) > 0 {
+        res.Notes = &c.Notes
+    }
+    if len(c.Cards) > 0 {
+        res.Cards = &c.Cards
+    }
+    if len(c.NoteTypes) > 0 {
+        res.Notetypes = &c.NoteTypes
+    }
+    if len(c.DeckConfigs) > 0 {
+        res.DeckConfigs = &c.DeckConfigs
+    }
+    return res
+}
+
+func fromAPINoteTypes(in []SyncNoteType) []database.SyncNoteType {
+    out := make([]database.SyncNoteType, len(in))
+    for i, nt := range in {
+        var fields []database.NoteTypeField
+        var templates []database.NoteTypeTemplate
+        convertJSON(nt.Fields, &fields)
+        convertJSON(nt.Templates, &templates)
+        out[i] = database.SyncNoteType{
+            ID:        nt.Id,
+            Name:      nt.Name,
+            Type:      nt.Type,
+            Mod:       nt.Mod,
+            USN:       nt.Usn,
+            SortField: nt.SortField,
+            Fields:    fields,
+            Templates: templates,
+            CSS:       nt.Css,
+            Data:      nt.Data,
+        }
+    }
+    return out
+}
+
+func toAPINoteTypes(in []database.SyncNoteType) []SyncNoteType {
+    out := make([]SyncNoteType, len(in))
+    for i, nt := range in {
+        fields := []SyncNoteTypeField{}
+        templates := []SyncNoteTypeTemplate{}
+        convertJSON(nt.Fields, &fields)
+        convertJSON(nt.Templates, &templates)
+        out[i] = SyncNoteType{
+            Id:        nt.ID,
+            Name:      nt.Name,
+            Type:      nt.Type,
+            Mod:       nt.Mod,
+            Usn:       nt.USN,
+            SortField: nt.SortField,
+            Fields:    fields,
+            Templates: templates,
+            Css:       nt.CSS,
+            Data:      nt.Data,
+        }
+    }
+    return out
+}
+
+func fromAPIDeckConfigs(in []SyncDeckConfig) []database.SyncDeckConfig {
+    out := make([]database.SyncDeckConfig, len(in))
+    for i, dc := range in {
+        out[i] = database.SyncDeckConfig{
+            ID:   dc.Id,
+            Name: dc.Name,
+            Mod:  dc.Mod,
+            USN:  dc.Usn,
+        }
+        convertJSON(dc.Config, &out[i].Config)
+    }
+    return out
+}
+
+func toAPIDeckConfigs(in []database.SyncDeckConfig) []SyncDeckConfig {
+    out := make([]SyncDeckConfig, len(in))
+    for i, dc := range in {
+        out[i] = SyncDeckConfig{
+            Id:   dc.ID,
+            Name: dc.Name,
+            Mod:  dc.Mod,
+            Usn:  dc.USN,
+        }
+        convertJSON(dc.Config, &out[i].Config)
+    }
+    return out
+}
+
+// convertJSON copies note type fields, templates and deck options between the
+// API and database types through their shared JSON encoding, which carries the
+// keys neither models
+func convertJSON(in, out any) {
+    b, err := json.Marshal(in)
+    if err == nil {
+        err = json.Unmarshal(b, out)
+    }
+    if err != nil {
+        log.Printf("⚠️ Failed to convert %T: %v", in, err)
+    }
 }
 
 func safeString(s *string) string {
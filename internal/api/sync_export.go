@@ -0,0 +1,170 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/magnusohle/openanki-backend/internal/anki"
+	"github.com/magnusohle/openanki-backend/internal/database"
+	"github.com/magnusohle/openanki-backend/internal/media"
+)
+
+// exportURLExpiry is how long the link to a staged export stays valid. Staged
+// files are kept under exports/; expire them with a bucket lifecycle rule.
+const exportURLExpiry = time.Hour
+
+// ExportCollection builds an .apkg of the user's collection or of one deck
+func (h *SyncHandler) ExportCollection(w http.ResponseWriter, r *http.Request, params ExportCollectionParams) {
+	userID := r.Context().Value("user_id").(int)
+
+	col, err := h.exportCollection(userID)
+	if err != nil {
+		log.Printf("❌ Error ExportCollection: %v", err)
+		http.Error(w, "Failed to read collection", http.StatusInternalServerError)
+		return
+	}
+
+	filename := "collection.apkg"
+	if params.DeckId != nil {
+		col, err = col.DeckSubset(*params.DeckId)
+		if errors.Is(err, anki.ErrDeckNotFound) {
+			http.Error(w, "Deck not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to read collection", http.StatusInternalServerError)
+			return
+		}
+		for _, d := range col.Decks {
+			if d.ID == *params.DeckId {
+				filename = d.Name + ".apkg"
+			}
+		}
+	}
+	if params.Scheduling == nil || !*params.Scheduling {
+		col.ResetScheduling()
+	}
+
+	// Whole collections carry all media, decks the files their notes use
+	files, err := h.Repo.ListMedia(userID)
+	if err != nil {
+		log.Printf("❌ Error ListMedia (export): %v", err)
+		http.Error(w, "Failed to read collection", http.StatusInternalServerError)
+		return
+	}
+	byName := make(map[string]*database.MediaFile, len(files))
+	for i := range files {
+		byName[files[i].Filename] = &files[i]
+	}
+	var mediaNames []string
+	if params.DeckId == nil {
+		for _, f := range files {
+			mediaNames = append(mediaNames, f.Filename)
+		}
+	} else {
+		for _, name := range col.MediaReferences() {
+			if _, ok := byName[name]; ok {
+				mediaNames = append(mediaNames, name)
+			}
+		}
+	}
+	readMedia := func(name string) ([]byte, error) {
+		data, err := h.Store.Get(context.Background(), media.FileKey(userID, byName[name]))
+		if err != nil {
+			log.Printf("⚠️ Media %q of user %d is missing from storage: %v", name, userID, err)
+			return nil, anki.ErrSkipMedia
+		}
+		return data, nil
+	}
+
+	if params.Delivery != nil && *params.Delivery == Url {
+		h.stageExport(w, userID, col, mediaNames, readMedia)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	if err := anki.WritePackage(w, col, mediaNames, readMedia); err != nil {
+		// Usually the download is already underway and gets cut short
+		log.Printf("❌ Error writing export: %v", err)
+		http.Error(w, "Failed to build package", http.StatusInternalServerError)
+	}
+}
+
+// exportCollection reads the user's whole collection
+func (h *SyncHandler) exportCollection(userID int) (*anki.Collection, error) {
+	state, err := h.Repo.GetCollectionState(userID)
+	if err != nil {
+		return nil, err
+	}
+	page, err := h.Repo.PullChanges(userID, -1, "", 0)
+	if err != nil {
+		return nil, err
+	}
+	return &anki.Collection{
+		Crt:         state.Crt,
+		Mod:         state.Mod,
+		Scm:         state.Scm,
+		NoteTypes:   page.NoteTypes,
+		DeckConfigs: page.DeckConfigs,
+		Decks:       page.Decks,
+		Notes:       page.Notes,
+		Cards:       page.Cards,
+		Revlog:      page.Revlog,
+	}, nil
+}
+
+// stageExport uploads the package to storage and returns a presigned link
+func (h *SyncHandler) stageExport(w http.ResponseWriter, userID int, col *anki.Collection, mediaNames []string,
+	readMedia func(string) ([]byte, error)) {
+	key := "exports/" + strconv.Itoa(userID) + "/" + database.GenerateRandomString(12) + ".apkg"
+	url, err := h.Store.PresignGet(context.Background(), key, exportURLExpiry)
+	if errors.Is(err, media.ErrPresignUnsupported) {
+		http.Error(w, "Download links are not available; use delivery=stream", http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to generate download URL", http.StatusInternalServerError)
+		return
+	}
+
+	f, err := os.CreateTemp("", "anki-export-*.apkg")
+	if err != nil {
+		http.Error(w, "Failed to build package", http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if err := anki.WritePackage(f, col, mediaNames, readMedia); err != nil {
+		log.Printf("❌ Error writing export: %v", err)
+		http.Error(w, "Failed to build package", http.StatusInternalServerError)
+		return
+	}
+	// Stream the package to storage rather than reading it back whole
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		http.Error(w, "Failed to build package", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.Store.PutReader(context.Background(), key, f, size); err != nil {
+		log.Printf("❌ Error staging export: %v", err)
+		http.Error(w, "Failed to store package", http.StatusInternalServerError)
+		return
+	}
+
+	expiresAt := time.Now().Add(exportURLExpiry)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ExportURLResponse{DownloadUrl: &url, ExpiresAt: &expiresAt})
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/magnusohle/openanki-backend/internal/anki"
+	"github.com/magnusohle/openanki-backend/internal/media"
+	"github.com/magnusohle/openanki-backend/internal/testutil"
+)
+
+func exportRequest(h *SyncHandler, userID int, params ExportCollectionParams) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/sync/export", nil)
+	w := httptest.NewRecorder()
+	h.ExportCollection(w, asUser(req, userID), params)
+	return w
+}
+
+func readExport(t *testing.T, data []byte) *anki.Package {
+	t.Helper()
+	pkg, err := anki.ReadPackage(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkg
+}
+
+func TestExportCollection(t *testing.T) {
+	h := newTestSyncHandler(t)
+	userID := testutil.CreateUser(t).ID
+	files := map[string]string{"cat.jpg": "meow", "unused.png": "x"}
+	if _, err := importTestPackage(h, userID, testPackage(t, files)); err != nil {
+		t.Fatal(err)
+	}
+
+	spanish := int64(1700000000100)
+	unknown := int64(42)
+	stream, staged := Stream, Url
+	tests := []struct {
+		name      string
+		params    ExportCollectionParams
+		wantNotes int
+		wantMedia int
+	}{
+		{"collection", ExportCollectionParams{Delivery: &stream}, 3, 2},
+		{"deck", ExportCollectionParams{DeckId: &spanish}, 2, 1},
+		{"staged", ExportCollectionParams{Delivery: &staged}, 3, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := exportRequest(h, userID, tt.params)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", w.Code, w.Body)
+			}
+			data := w.Body.Bytes()
+			if tt.params.Delivery != nil && *tt.params.Delivery == Url {
+				var resp ExportURLResponse
+				if err := json.Unmarshal(data, &resp); err != nil || resp.DownloadUrl == nil {
+					t.Fatalf("response %s: %v", data, err)
+				}
+				data = fetchSigned(t, h.Store.(*media.LocalStore), *resp.DownloadUrl)
+			}
+
+			pkg := readExport(t, data)
+			if len(pkg.Collection.Notes) != tt.wantNotes || len(pkg.Media) != tt.wantMedia {
+				t.Errorf("package has %d notes and %d media files, want %d and %d",
+					len(pkg.Collection.Notes), len(pkg.Media), tt.wantNotes, tt.wantMedia)
+			}
+			// Scheduling is left out by default
+			if len(pkg.Collection.Revlog) != 0 {
+				t.Errorf("package has %d reviews", len(pkg.Collection.Revlog))
+			}
+		})
+	}
+
+	if w := exportRequest(h, userID, ExportCollectionParams{DeckId: &unknown}); w.Code != http.StatusNotFound {
+		t.Errorf("unknown deck: status %d, want 404", w.Code)
+	}
+}
+
+// fetchSigned downloads a signed URL of the local store
+func fetchSigned(t *testing.T, store *media.LocalStore, rawURL string) []byte {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, rawURL, nil)
+	req.URL.Path = strings.TrimPrefix(u.Path, "/blobs")
+	w := httptest.NewRecorder()
+	store.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("download: status %d: %s", w.Code, w.Body)
+	}
+	return w.Body.Bytes()
+}
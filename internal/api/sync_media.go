@@ -0,0 +1,256 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/magnusohle/openanki-backend/internal/database"
+	"github.com/magnusohle/openanki-backend/internal/media"
+)
+
+// GetMediaChanges lists media files added or deleted since a USN
+func (h *SyncHandler) GetMediaChanges(w http.ResponseWriter, r *http.Request, params GetMediaChangesParams) {
+	userID := r.Context().Value("user_id").(int)
+
+	since := 0
+	if params.Since != nil {
+		since = *params.Since
+	}
+	limit := 0
+	if params.Limit != nil {
+		if *params.Limit < 1 {
+			http.Error(w, "limit must be positive", http.StatusBadRequest)
+			return
+		}
+		limit = min(*params.Limit, maxPullLimit)
+	}
+	cursor := ""
+	if params.Cursor != nil {
+		cursor = *params.Cursor
+	}
+
+	page, err := h.Repo.GetMediaChanges(userID, since, cursor, limit)
+	if errors.Is(err, database.ErrInvalidCursor) {
+		http.Error(w, "Invalid or expired cursor", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("❌ Error GetMediaChanges: %v", err)
+		http.Error(w, "Failed to get changes", http.StatusInternalServerError)
+		return
+	}
+
+	changes := make([]MediaChange, len(page.Changes))
+	for i, c := range page.Changes {
+		changes[i] = MediaChange{
+			Filename: c.Filename,
+			Hash:     c.Hash,
+			Size:     &page.Changes[i].Size,
+			Usn:      c.USN,
+			Deleted:  c.Deleted,
+		}
+	}
+	resp := MediaChangesResponse{
+		ServerUsn: &page.ServerUSN,
+		HasMore:   &page.HasMore,
+		Changes:   &changes,
+	}
+	if page.HasMore {
+		resp.NextCursor = &page.Cursor
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// DeleteMedia removes the user's media files with the given hash. The stored
+// object is left for orphan cleanup, as other files may share it.
+func (h *SyncHandler) DeleteMedia(w http.ResponseWriter, r *http.Request, hash string) {
+	userID := r.Context().Value("user_id").(int)
+
+	usn, err := h.Repo.DeleteMediaByHash(userID, hash)
+	if errors.Is(err, database.ErrMediaNotFound) {
+		http.Error(w, "Media not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("❌ Error DeleteMediaByHash: %v", err)
+		http.Error(w, "Failed to delete media", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(USNResponse{ServerUsn: &usn})
+}
+
+// ConfirmMedia completes a media upload reserved by UploadMedia once the
+// uploaded object has been checked. The object is moved from its per-user
+// staging key to the shared blob of its content.
+func (h *SyncHandler) ConfirmMedia(w http.ResponseWriter, r *http.Request, hash string) {
+	userID := r.Context().Value("user_id").(int)
+
+	pending, err := h.Repo.GetPendingMedia(userID, hash)
+	if errors.Is(err, database.ErrMediaNotFound) {
+		// A retried confirmation after success is fine
+		if ok, err := h.Repo.HasMedia(userID, hash); err == nil && ok {
+			writeMediaStatus(w, hash)
+			return
+		}
+		http.Error(w, "No pending upload for this hash", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("❌ Error GetPendingMedia: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	ctx := context.Background()
+	key := media.MediaKey(userID, hash)
+	info, err := h.Store.Head(ctx, key)
+	if errors.Is(err, media.ErrObjectNotFound) {
+		http.Error(w, "File has not been uploaded", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("❌ Error HeadObject %s: %v", key, err)
+		http.Error(w, "Failed to check upload", http.StatusInternalServerError)
+		return
+	}
+
+	// The object is only read once its size matches the reservation, and
+	// never past it: a presigned PUT does not cap what the client sends.
+	ok := pending.Size > 0 && info.Size == pending.Size
+	var spooled *media.Spooled
+	if ok {
+		body, err := h.Store.Open(ctx, key)
+		if err == nil {
+			spooled, err = media.Spool(body, pending.Size)
+			body.Close()
+		}
+		if err != nil {
+			log.Printf("❌ Error reading upload %s: %v", key, err)
+			http.Error(w, "Failed to check upload", http.StatusInternalServerError)
+			return
+		}
+		defer spooled.Close()
+		want, _ := hex.DecodeString(hash)
+		got := spooled.SHA256
+		if len(want) == sha1.Size {
+			got = spooled.SHA1
+		}
+		ok = spooled.Size == pending.Size && bytes.Equal(got, want)
+	}
+	if !ok {
+		// Drop the bad object so it cannot be mistaken for the real file
+		if err := h.Store.Delete(ctx, key); err != nil {
+			log.Printf("⚠️ Failed to delete mismatching upload %s: %v", key, err)
+		}
+		http.Error(w, "Uploaded file does not match its size or hash", http.StatusUnprocessableEntity)
+		return
+	}
+
+	// Count the stored bytes, not the size claimed on reservation. Content the
+	// user already has takes no extra space.
+	if stored, err := h.Repo.HasMedia(userID, hash); err != nil {
+		log.Printf("❌ Error HasMedia: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	} else if !stored && !checkQuota(w, h.Repo, userID, spooled.Size) {
+		if err := h.Store.Delete(ctx, key); err != nil {
+			log.Printf("⚠️ Failed to delete upload over quota %s: %v", key, err)
+		}
+		if err := h.Repo.DiscardPendingMedia(userID, hash); err != nil {
+			log.Printf("⚠️ Failed to discard reservation %s: %v", key, err)
+		}
+		return
+	}
+
+	blob, err := media.StoreSpooled(ctx, h.Repo, h.Store, spooled)
+	if err != nil {
+		log.Printf("❌ Error storing media %s: %v", key, err)
+		http.Error(w, "Failed to store media", http.StatusInternalServerError)
+		return
+	}
+	if _, err := h.Repo.ConfirmMedia(userID, hash, spooled.Size, blob); err != nil {
+		log.Printf("❌ Error ConfirmMedia: %v", err)
+		http.Error(w, "Failed to store media", http.StatusInternalServerError)
+		return
+	}
+	if err := h.Store.Delete(ctx, key); err != nil {
+		// The media GC collects it later
+		log.Printf("⚠️ Failed to delete staged upload %s: %v", key, err)
+	}
+	h.Transcoder.Enqueue(userID, database.MediaFile{
+		Filename: pending.Filename,
+		Hash:     hash,
+		Size:     spooled.Size,
+		Blob:     blob,
+	})
+	writeMediaStatus(w, hash)
+}
+
+// isContentHash reports whether hash is a hex SHA-1 or SHA-256 digest
+func isContentHash(hash string) bool {
+	b, err := hex.DecodeString(hash)
+	return err == nil && (len(b) == sha1.Size || len(b) == sha256.Size)
+}
+
+// contentHash returns the SHA-1 or SHA-256 of data, by digest size
+func contentHash(data []byte, size int) []byte {
+	if size == sha1.Size {
+		sum := sha1.Sum(data)
+		return sum[:]
+	}
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+func writeMediaStatus(w http.ResponseWriter, hash string) {
+	status := "ok"
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(MediaUploadResponse{Status: &status, Hash: &hash})
+}
+
+// negotiateVariant picks the variant the Accept header prefers over the
+// original file, the smallest among equally preferred ones. Variants must be
+// asked for by their type or type/*; a bare */* keeps the original.
+func negotiateVariant(accept, filename string, variants []database.MediaVariant) *database.MediaVariant {
+	original := acceptQuality(accept, mime.TypeByExtension(filepath.Ext(filename)))
+	var best *database.MediaVariant
+	bestQ := 0.0
+	for i := range variants {
+		v := &variants[i]
+		q := acceptQuality(accept, v.ContentType)
+		if q <= 0 || q < original {
+			continue
+		}
+		if best == nil || q > bestQ || (q == bestQ && v.Size < best.Size) {
+			best, bestQ = v, q
+		}
+	}
+	return best
+}
+
+// acceptQuality is the q value accept gives contentType by an exact entry or,
+// failing that, a type/* entry. It is 0 when neither is present.
+func acceptQuality(accept, contentType string) float64 {
+	if contentType == "" {
+		return 0
+	}
+	contentType, _, _ = strings.Cut(contentType, ";")
+	major, _, _ := strings.Cut(contentType, "/")
+	values := acceptValues(accept)
+	if q, ok := values[contentType]; ok {
+		return q
+	}
+	return values[major+"/*"]
+}
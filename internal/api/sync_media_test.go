@@ -0,0 +1,327 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/magnusohle/openanki-backend/internal/database"
+	"github.com/magnusohle/openanki-backend/internal/media"
+	"github.com/magnusohle/openanki-backend/internal/testutil"
+)
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// reserveMedia asks UploadMedia for a presigned upload
+func reserveMedia(t *testing.T, h *SyncHandler, userID int, hash string, size int64) *httptest.ResponseRecorder {
+	t.Helper()
+	body, _ := json.Marshal(map[string]any{"hash": hash, "filename": "a.png", "size": size})
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/sync/media", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.UploadMedia(w, asUser(req, userID))
+	return w
+}
+
+func confirmMedia(h *SyncHandler, userID int, hash string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/sync/media/"+hash+"/confirm", nil)
+	w := httptest.NewRecorder()
+	h.ConfirmMedia(w, asUser(req, userID), hash)
+	return w
+}
+
+func TestUploadMediaRequiresSize(t *testing.T) {
+	h := newTestSyncHandler(t)
+	userID := testutil.CreateUser(t).ID
+
+	if w := reserveMedia(t, h, userID, sha256Hex([]byte("x")), 0); w.Code != http.StatusBadRequest {
+		t.Errorf("reserving size 0: status %d, want 400", w.Code)
+	}
+	if w := reserveMedia(t, h, userID, sha256Hex([]byte("x")), -1); w.Code != http.StatusBadRequest {
+		t.Errorf("reserving size -1: status %d, want 400", w.Code)
+	}
+}
+
+func TestConfirmMedia(t *testing.T) {
+	ctx := context.Background()
+	data := []byte("the real file")
+	hash := sha256Hex(data)
+
+	tests := []struct {
+		name     string
+		uploaded []byte
+		want     int
+	}{
+		{"matching", data, http.StatusOK},
+		{"larger than reserved", append(append([]byte{}, data...), make([]byte, 1<<20)...), http.StatusUnprocessableEntity},
+		{"same size other content", []byte("the fake file"), http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestSyncHandler(t)
+			userID := testutil.CreateUser(t).ID
+			if w := reserveMedia(t, h, userID, hash, int64(len(data))); w.Code != http.StatusOK {
+				t.Fatalf("reserve: status %d: %s", w.Code, w.Body)
+			}
+			if w := confirmMedia(h, userID, hash); w.Code != http.StatusConflict {
+				t.Errorf("confirm before upload: status %d, want 409", w.Code)
+			}
+
+			key := media.MediaKey(userID, hash)
+			if err := h.Store.Put(ctx, key, tt.uploaded); err != nil {
+				t.Fatal(err)
+			}
+			w := confirmMedia(h, userID, hash)
+			if w.Code != tt.want {
+				t.Fatalf("confirm: status %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+
+			// The staged object is gone either way
+			if _, err := h.Store.Head(ctx, key); !errors.Is(err, media.ErrObjectNotFound) {
+				t.Errorf("staged upload left behind: %v", err)
+			}
+			has, err := testutil.Repo.HasMedia(userID, hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if has != (tt.want == http.StatusOK) {
+				t.Errorf("HasMedia = %v after status %d", has, w.Code)
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+			stored, err := h.Store.Get(ctx, media.BlobKey(hash))
+			if err != nil || !bytes.Equal(stored, data) {
+				t.Errorf("shared blob = %q, %v", stored, err)
+			}
+			// A retry after success still succeeds
+			if w := confirmMedia(h, userID, hash); w.Code != http.StatusOK {
+				t.Errorf("repeated confirm: status %d", w.Code)
+			}
+		})
+	}
+}
+
+func TestAcceptQuality(t *testing.T) {
+	tests := []struct {
+		accept, contentType string
+		want                float64
+	}{
+		{"image/webp", "image/webp", 1},
+		{"image/webp;q=0.5", "image/webp", 0.5},
+		{"image/*;q=0.3, image/webp;q=0.8", "image/webp", 0.8},
+		{"image/*;q=0.3", "image/avif", 0.3},
+		{"image/webp;q=0", "image/webp", 0},
+		{"*/*", "image/webp", 0},
+		{"image/webp;q=2", "image/webp", 0},
+		{"image/webp;q=abc, image/*;q=0.4", "image/webp", 0.4},
+		{"image/png", "image/png; charset=binary", 1},
+		{"image/webp", "", 0},
+	}
+	for _, tt := range tests {
+		if got := acceptQuality(tt.accept, tt.contentType); got != tt.want {
+			t.Errorf("acceptQuality(%q, %q) = %v, want %v", tt.accept, tt.contentType, got, tt.want)
+		}
+	}
+}
+
+func TestNegotiateVariant(t *testing.T) {
+	variants := []database.MediaVariant{
+		{ContentType: "image/avif", Blob: "avif", Size: 50},
+		{ContentType: "image/webp", Blob: "webp", Size: 80},
+	}
+	tests := []struct {
+		name, accept string
+		want         string // blob of the chosen variant, "" for the original
+	}{
+		{"preferred variant", "image/webp", "webp"},
+		{"smallest of equal preference", "image/avif, image/webp", "avif"},
+		{"higher q wins over size", "image/avif;q=0.5, image/webp", "webp"},
+		{"type wildcard", "image/*", "avif"},
+		{"original preferred", "image/png, image/webp;q=0.5", ""},
+		{"original as preferred as variant", "image/png, image/webp", "webp"},
+		{"any type keeps the original", "*/*", ""},
+		{"variants refused", "image/webp;q=0, image/avif;q=0", ""},
+		{"unrelated type", "text/html", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := negotiateVariant(tt.accept, "cat.png", variants)
+			blob := ""
+			if got != nil {
+				blob = got.Blob
+			}
+			if blob != tt.want {
+				t.Errorf("negotiateVariant(%q) = %q, want %q", tt.accept, blob, tt.want)
+			}
+		})
+	}
+	if got := negotiateVariant("image/webp", "cat.png", nil); got != nil {
+		t.Errorf("without variants got %+v", got)
+	}
+}
+
+func TestDownloadMediaVariant(t *testing.T) {
+	ctx := context.Background()
+	h := newTestSyncHandler(t)
+	userID := testutil.CreateUser(t).ID
+	data := []byte("original png of user " + strconv.Itoa(userID))
+	blob, err := media.StoreContent(ctx, h.Repo, h.Store, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := sha256Hex(data)
+	if _, err := h.Repo.PutMedia(userID, database.MediaFile{Filename: "cat.png", Hash: hash, Size: int64(len(data)), Blob: blob}); err != nil {
+		t.Fatal(err)
+	}
+	webp := []byte("small webp of user " + strconv.Itoa(userID))
+	webpBlob, err := media.StoreContent(ctx, h.Repo, h.Store, webp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Repo.AddMediaVariant(userID, hash, blob, database.MediaVariant{ContentType: "image/webp", Blob: webpBlob, Size: int64(len(webp))}); err != nil {
+		t.Fatal(err)
+	}
+
+	for accept, want := range map[string][]byte{
+		"":               data,
+		"*/*":            data,
+		"image/webp":     webp,
+		"image/avif":     data,
+		"image/webp;q=0": data,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/sync/media/"+hash, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		h.DownloadMedia(w, asUser(req, userID), hash)
+		if w.Code != http.StatusTemporaryRedirect {
+			t.Fatalf("Accept %q: status %d, want a redirect", accept, w.Code)
+		}
+		if got := fetchSigned(t, h.Store.(*media.LocalStore), w.Header().Get("Location")); !bytes.Equal(got, want) {
+			t.Errorf("Accept %q: downloaded %q, want %q", accept, got, want)
+		}
+		if vary := w.Header().Get("Vary"); vary != "Accept" {
+			t.Errorf("Vary = %q", vary)
+		}
+	}
+}
+
+func getMediaChanges(t *testing.T, h *SyncHandler, userID int, params GetMediaChangesParams) (int, MediaChangesResponse) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/sync/media/changes", nil)
+	w := httptest.NewRecorder()
+	h.GetMediaChanges(w, asUser(req, userID), params)
+	var resp MediaChangesResponse
+	if w.Code == http.StatusOK {
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w.Code, resp
+}
+
+// mediaChangeNames lists changes as "name" for additions and "-name" for
+// deletions
+func mediaChangeNames(changes *[]MediaChange) []string {
+	var names []string
+	if changes != nil {
+		for _, c := range *changes {
+			if c.Deleted {
+				names = append(names, "-"+c.Filename)
+			} else {
+				names = append(names, c.Filename)
+			}
+		}
+	}
+	return names
+}
+
+func TestGetMediaChanges(t *testing.T) {
+	h := newTestSyncHandler(t)
+	userID := testutil.CreateUser(t).ID
+	hashes := map[string]string{}
+	for _, name := range []string{"a.png", "b.png", "c.png"} {
+		hashes[name] = sha256Hex([]byte(name + strconv.Itoa(userID)))
+		if _, err := h.Repo.PutMedia(userID, database.MediaFile{Filename: name, Hash: hashes[name], Size: 10}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	code, resp := getMediaChanges(t, h, userID, GetMediaChangesParams{})
+	if code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	if got := mediaChangeNames(resp.Changes); !slices.Equal(got, []string{"a.png", "b.png", "c.png"}) {
+		t.Errorf("changes since 0 = %v", got)
+	}
+	since := *resp.ServerUsn
+
+	// A deletion is listed with the content the file last had
+	req := httptest.NewRequest(http.MethodDelete, "/api/v1/sync/media/"+hashes["b.png"], nil)
+	w := httptest.NewRecorder()
+	h.DeleteMedia(w, asUser(req, userID), hashes["b.png"])
+	if w.Code != http.StatusOK {
+		t.Fatalf("delete: status %d", w.Code)
+	}
+	_, resp = getMediaChanges(t, h, userID, GetMediaChangesParams{Since: &since})
+	if got := mediaChangeNames(resp.Changes); !slices.Equal(got, []string{"-b.png"}) {
+		t.Errorf("changes after delete = %v", got)
+	} else if c := (*resp.Changes)[0]; c.Hash != hashes["b.png"] || c.Usn <= since {
+		t.Errorf("grave = %+v", c)
+	}
+
+	// Adding the name again replaces its grave
+	if _, err := h.Repo.PutMedia(userID, database.MediaFile{Filename: "b.png", Hash: hashes["b.png"], Size: 10}); err != nil {
+		t.Fatal(err)
+	}
+	_, resp = getMediaChanges(t, h, userID, GetMediaChangesParams{Since: &since})
+	if got := mediaChangeNames(resp.Changes); !slices.Equal(got, []string{"b.png"}) {
+		t.Errorf("changes after re-adding = %v", got)
+	}
+
+	// Pages of one change walk the same list
+	var paged []string
+	limit := 1
+	params := GetMediaChangesParams{Limit: &limit}
+	for range 10 {
+		code, resp := getMediaChanges(t, h, userID, params)
+		if code != http.StatusOK {
+			t.Fatalf("page: status %d", code)
+		}
+		paged = append(paged, mediaChangeNames(resp.Changes)...)
+		if !*resp.HasMore {
+			break
+		}
+		params.Cursor = resp.NextCursor
+	}
+	if !slices.Equal(paged, []string{"a.png", "c.png", "b.png"}) {
+		t.Errorf("paged changes = %v", paged)
+	}
+
+	zero, bad := 0, "not-a-cursor"
+	if code, _ := getMediaChanges(t, h, userID, GetMediaChangesParams{Limit: &zero}); code != http.StatusBadRequest {
+		t.Errorf("limit 0: status %d, want 400", code)
+	}
+	if code, _ := getMediaChanges(t, h, userID, GetMediaChangesParams{Cursor: &bad}); code != http.StatusBadRequest {
+		t.Errorf("bad cursor: status %d, want 400", code)
+	}
+
+	// Other users see none of it
+	_, resp = getMediaChanges(t, h, testutil.CreateUser(t).ID, GetMediaChangesParams{})
+	if got := mediaChangeNames(resp.Changes); len(got) != 0 {
+		t.Errorf("other user's changes = %v", got)
+	}
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/magnusohle/openanki-backend/internal/database"
+	"github.com/magnusohle/openanki-backend/internal/testutil"
+)
+
+// createSyncUser adds a user whose subscription allows sync
+func createSyncUser(t *testing.T) int {
+	t.Helper()
+	userID := testutil.CreateUser(t).ID
+	if err := database.UpdateUserSubscription(userID, "active"); err != nil {
+		t.Fatal(err)
+	}
+	return userID
+}
+
+func pushSync(t *testing.T, h *SyncHandler, userID int, req SyncPushRequest) {
+	t.Helper()
+	body, _ := json.Marshal(req)
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/sync/push", bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.PushSync(w, asUser(r, userID))
+	if w.Code != http.StatusOK {
+		t.Fatalf("push: status %d: %s", w.Code, w.Body)
+	}
+}
+
+func pullSync(t *testing.T, h *SyncHandler, userID int) SyncPullResponse {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/sync/pull", nil)
+	w := httptest.NewRecorder()
+	h.PullSync(w, asUser(r, userID), PullSyncParams{})
+	if w.Code != http.StatusOK {
+		t.Fatalf("pull: status %d: %s", w.Code, w.Body)
+	}
+	var resp SyncPullResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestSyncRevlogIsAppendOnly(t *testing.T) {
+	h := newTestSyncHandler(t)
+	userID := createSyncUser(t)
+	other := createSyncUser(t)
+
+	first := SyncRevlog{Id: 1700000000000, Cid: 7, Ease: 3, Ivl: 4, LastIvl: 1, Factor: 2500, Time: 6000, Type: 1}
+	second := SyncRevlog{Id: 1700000060000, Cid: 7, Ease: 1, Ivl: -600, LastIvl: 4, Factor: 2300, Time: 9000, Type: 2}
+	pushSync(t, h, userID, SyncPushRequest{Revlog: &[]SyncRevlog{first, second}})
+
+	// Resending an entry with other values must not rewrite history
+	changed := first
+	changed.Ease, changed.Ivl = 4, 30
+	pushSync(t, h, userID, SyncPushRequest{Revlog: &[]SyncRevlog{changed}})
+
+	// Review ids are only unique per user
+	theirs := SyncRevlog{Id: first.Id, Cid: 99, Ease: 2, Ivl: 2, Factor: 2500, Time: 1000, Type: 1}
+	pushSync(t, h, other, SyncPushRequest{Revlog: &[]SyncRevlog{theirs}})
+
+	resp := pullSync(t, h, userID)
+	if resp.Revlog == nil || len(*resp.Revlog) != 2 {
+		t.Fatalf("revlog = %+v, want 2 entries", resp.Revlog)
+	}
+	for i, want := range []SyncRevlog{first, second} {
+		got := (*resp.Revlog)[i]
+		if got.Usn <= 0 || got.Usn > *resp.ServerUsn {
+			t.Errorf("entry %d: usn %d, server usn %d", got.Id, got.Usn, *resp.ServerUsn)
+		}
+		got.Usn = 0
+		if got != want {
+			t.Errorf("entry %d = %+v, want %+v", i, got, want)
+		}
+	}
+
+	resp = pullSync(t, h, other)
+	if resp.Revlog == nil || len(*resp.Revlog) != 1 || (*resp.Revlog)[0].Cid != theirs.Cid {
+		t.Errorf("other user's revlog = %+v, want only their entry", resp.Revlog)
+	}
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/magnusohle/openanki-backend/internal/database"
+)
+
+// maxChunkBytes caps the body of a single full upload chunk
+const maxChunkBytes = 32 << 20
+
+// BeginFullUpload opens a chunked full upload session
+func (h *SyncHandler) BeginFullUpload(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+	if !h.requireSubscription(w, userID) {
+		return
+	}
+
+	uploadID, err := h.Repo.BeginFullUpload(userID)
+	if errors.Is(err, database.ErrUploadInProgress) {
+		http.Error(w, "Another full upload is in progress; abort it or retry later", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("❌ Error BeginFullUpload: %v", err)
+		http.Error(w, "Failed to start upload", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(FullUploadSession{UploadId: uploadID})
+}
+
+// UploadFullChunk stages one chunk of a full upload
+func (h *SyncHandler) UploadFullChunk(w http.ResponseWriter, r *http.Request, uploadId string, seq int) {
+	userID := r.Context().Value("user_id").(int)
+	if seq < 0 || seq >= database.MaxUploadChunks {
+		http.Error(w, fmt.Sprintf("seq must be between 0 and %d", database.MaxUploadChunks-1), http.StatusBadRequest)
+		return
+	}
+
+	var req SyncPushRequest
+	if err := decodeSyncBody(w, r, &req, maxChunkBytes); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	err := h.Repo.PutFullUploadChunk(userID, uploadId, seq, toSyncPayload(&req))
+	if errors.Is(err, database.ErrUploadNotFound) {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, database.ErrUploadTooLarge) {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		log.Printf("❌ Error UploadFullChunk: %v", err)
+		http.Error(w, "Failed to store chunk", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CommitFullUpload swaps the staged chunks in as the user's collection
+func (h *SyncHandler) CommitFullUpload(w http.ResponseWriter, r *http.Request, uploadId string) {
+	userID := r.Context().Value("user_id").(int)
+	if !h.requireSubscription(w, userID) {
+		return
+	}
+
+	var req FullUploadCommitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.Chunks < 1 {
+		http.Error(w, "chunks must be at least 1", http.StatusBadRequest)
+		return
+	}
+
+	usn, err := h.Repo.CommitFullUpload(userID, uploadId, req.Chunks)
+	switch {
+	case errors.Is(err, database.ErrUploadNotFound):
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	case errors.Is(err, database.ErrUploadIncomplete):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, database.ErrUploadChunkCount):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		log.Printf("❌ Error CommitFullUpload: %v", err)
+		http.Error(w, "Failed to commit upload", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(USNResponse{ServerUsn: &usn})
+}
+
+// AbortFullUpload discards a full upload session
+func (h *SyncHandler) AbortFullUpload(w http.ResponseWriter, r *http.Request, uploadId string) {
+	userID := r.Context().Value("user_id").(int)
+
+	err := h.Repo.AbortFullUpload(userID, uploadId)
+	if errors.Is(err, database.ErrUploadNotFound) {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to abort upload", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/magnusohle/openanki-backend/internal/auth"
+	"github.com/magnusohle/openanki-backend/internal/database"
+)
+
+// attemptLimit throttles an action guessable by brute force, both per
+// account and per client IP. IPs get more free attempts since many users
+// can share one.
+type attemptLimit struct {
+	account *auth.Throttle
+	ip      *auth.Throttle
+}
+
+var (
+	loginLimit = attemptLimit{
+		account: &auth.Throttle{Name: "login:account", Free: 5, BaseDelay: 30 * time.Second, MaxDelay: time.Hour},
+		ip:      &auth.Throttle{Name: "login:ip", Free: 20, BaseDelay: 30 * time.Second, MaxDelay: time.Hour},
+	}
+	resetLimit = attemptLimit{
+		account: &auth.Throttle{Name: "reset:account", Free: 5, BaseDelay: 30 * time.Second, MaxDelay: time.Hour},
+		ip:      &auth.Throttle{Name: "reset:ip", Free: 20, BaseDelay: 30 * time.Second, MaxDelay: time.Hour},
+	}
+	// Reset emails count against their account, so one mailbox cannot be
+	// flooded; only requests for unknown addresses count against the IP
+	forgotLimit = attemptLimit{
+		account: &auth.Throttle{Name: "forgot:account", Free: 3, BaseDelay: time.Minute, MaxDelay: time.Hour},
+		ip:      &auth.Throttle{Name: "forgot:ip", Free: 10, BaseDelay: time.Minute, MaxDelay: time.Hour},
+	}
+)
+
+// attempt is one counted attempt at an account
+type attempt struct {
+	limit       attemptLimit
+	email, ip   string
+	accountLock time.Duration
+	ipLock      time.Duration
+}
+
+// begin counts an attempt at the account with the email. It writes a 429 and
+// returns nil while the account or the client's IP is locked out.
+func (l attemptLimit) begin(w http.ResponseWriter, r *http.Request, email string) *attempt {
+	a := &attempt{limit: l, email: strings.ToLower(strings.TrimSpace(email)), ip: clientIP(r)}
+
+	wait, locked, err := l.ip.Attempt(a.ip)
+	if err == nil && wait == 0 {
+		a.ipLock = locked
+		wait, locked, err = l.account.Attempt(a.email)
+		if err == nil && wait > 0 {
+			// Refused, so the IP's attempt never happened
+			err = l.ip.Forgive(a.ip)
+		}
+		a.accountLock = locked
+	}
+	if err != nil {
+		log.Printf("❌ Error counting attempt by %s at %s: %v", a.ip, a.email, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return nil
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+		http.Error(w, "Too many attempts; try again later", http.StatusTooManyRequests)
+		return nil
+	}
+	return a
+}
+
+// succeeded forgets the account's failed attempts and takes back the IP's
+// attempt
+func (a *attempt) succeeded() {
+	if err := a.limit.account.Reset(a.email); err != nil {
+		log.Printf("⚠️ Failed to reset attempts at %s: %v", a.email, err)
+	}
+	if err := a.limit.ip.Forgive(a.ip); err != nil {
+		log.Printf("⚠️ Failed to forgive attempt by %s: %v", a.ip, err)
+	}
+}
+
+// sent keeps the attempt counted against the account but takes back the
+// IP's, for requests that act on a real account without guessing at it
+func (a *attempt) sent() {
+	if err := a.limit.ip.Forgive(a.ip); err != nil {
+		log.Printf("⚠️ Failed to forgive attempt by %s: %v", a.ip, err)
+	}
+}
+
+// abandoned takes back the attempt, for requests that failed on our side
+func (a *attempt) abandoned() {
+	if err := a.limit.account.Forgive(a.email); err != nil {
+		log.Printf("⚠️ Failed to forgive attempt at %s: %v", a.email, err)
+	}
+	a.sent()
+}
+
+// failed keeps the attempt counted and records the lockouts it started in
+// the audit log
+func (a *attempt) failed() {
+	a.recordLockout(a.limit.account, a.accountLock)
+	a.recordLockout(a.limit.ip, a.ipLock)
+}
+
+func (a *attempt) recordLockout(t *auth.Throttle, locked time.Duration) {
+	if locked == 0 {
+		return
+	}
+	detail := fmt.Sprintf("%s locked for %s", t.Name, locked)
+	log.Printf("⚠️ Lockout of %s from %s: %s", a.email, a.ip, detail)
+	if err := database.RecordAuthEvent("lockout", a.email, a.ip, detail); err != nil {
+		log.Printf("❌ Error recording lockout: %v", err)
+	}
+}
+
+// trustProxy is set when the server runs behind a proxy (see nginx.conf)
+// that passes the client's address in X-Real-IP
+var trustProxy = os.Getenv("TRUST_PROXY") == "true"
+
+// clientIP is the address the request comes from
+func clientIP(r *http.Request) string {
+	if trustProxy {
+		if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,202 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/magnusohle/openanki-backend/internal/database"
+	"github.com/magnusohle/openanki-backend/internal/mailer"
+	"github.com/magnusohle/openanki-backend/internal/oidc"
+)
+
+const (
+	// verificationCodeTTL is how long an emailed verification code works
+	verificationCodeTTL = 24 * time.Hour
+	// resendInterval spaces out verification emails to the same user
+	resendInterval = time.Minute
+)
+
+// verifiedFeatures are the features EMAIL_VERIFICATION_REQUIRED can reserve
+// for users with a verified email
+var verifiedFeatures = []string{"sync", "groups", "iap"}
+
+// verificationRequired is the set of features only users with a verified
+// email may use, from the comma separated EMAIL_VERIFICATION_REQUIRED ("all"
+// for every feature). By default none requires it.
+var verificationRequired = sync.OnceValue(func() map[string]bool {
+	required := map[string]bool{}
+	for _, feature := range strings.Split(os.Getenv("EMAIL_VERIFICATION_REQUIRED"), ",") {
+		feature = strings.ToLower(strings.TrimSpace(feature))
+		switch {
+		case feature == "":
+		case feature == "all":
+			for _, f := range verifiedFeatures {
+				required[f] = true
+			}
+		case contains(verifiedFeatures, feature):
+			required[feature] = true
+		default:
+			log.Printf("⚠️ Ignoring unknown feature %q in EMAIL_VERIFICATION_REQUIRED", feature)
+		}
+	}
+	return required
+})
+
+func contains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// requireVerifiedEmail is middleware refusing users whose email is not
+// verified when the policy reserves feature for verified users. It must run
+// after auth.Middleware.
+func requireVerifiedEmail(feature string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID := r.Context().Value("user_id").(int)
+			if !checkEmailVerified(w, userID, feature) {
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// checkEmailVerified writes a 403 and returns false when feature requires a
+// verified email and the user has none
+func checkEmailVerified(w http.ResponseWriter, userID int, feature string) bool {
+	if !verificationRequired()[feature] {
+		return true
+	}
+	user, err := database.GetUserByID(userID)
+	if err != nil || user == nil {
+		http.Error(w, "Failed to get user", http.StatusInternalServerError)
+		return false
+	}
+	if !user.EmailVerified {
+		http.Error(w, "Verify your email address to use "+feature, http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+const charset = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789" // Exclude confusing chars 0,O,1,I
+
+// generateCode returns a random code of charset characters for reset and
+// verification emails
+func generateCode() (string, error) {
+	b := make([]byte, 6)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(charset))))
+		if err != nil {
+			return "", err
+		}
+		b[i] = charset[n.Int64()]
+	}
+	return string(b), nil
+}
+
+// sendVerificationCode emails the user a new code verifying their address,
+// replacing any earlier one
+func sendVerificationCode(user *database.User) error {
+	code, err := generateCode()
+	if err != nil {
+		return err
+	}
+	if err := database.SaveVerificationCode(user.ID, code, time.Now().Add(verificationCodeTTL)); err != nil {
+		return err
+	}
+	return mailer.SendVerificationEmail(user.Email, code)
+}
+
+// trustIdentityEmail marks the user's email verified when a provider vouches
+// for the same address
+func trustIdentityEmail(user *database.User, identity *oidc.Identity) {
+	if user.EmailVerified || !identity.EmailVerified || !strings.EqualFold(user.Email, identity.Email) {
+		return
+	}
+	if err := database.MarkEmailVerified(user.ID); err != nil {
+		log.Printf("⚠️ Failed to mark email of user %d verified: %v", user.ID, err)
+		return
+	}
+	user.EmailVerified = true
+}
+
+// VerifyEmail confirms the user's address with the code emailed to it. It
+// needs no sign-in, so the code can be entered on any device, and answers
+// with nothing about the account.
+func (h *AuthHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email string `json:"email"`
+		Code  string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.Email == "" || req.Code == "" {
+		http.Error(w, "Missing fields", http.StatusBadRequest)
+		return
+	}
+
+	_, err := database.VerifyEmailCode(req.Email, strings.ToUpper(strings.TrimSpace(req.Code)))
+	if errors.Is(err, database.ErrInvalidVerificationCode) {
+		http.Error(w, "Invalid or expired code", http.StatusBadRequest)
+		return
+	}
+	if err != nil && !errors.Is(err, database.ErrEmailAlreadyVerified) {
+		log.Printf("❌ Error VerifyEmailCode: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Email verified"})
+}
+
+// ResendVerification emails the signed-in user a new verification code
+func (h *AuthHandler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+	user, err := database.GetUserByID(userID)
+	if err != nil || user == nil {
+		http.Error(w, "Failed to get user", http.StatusInternalServerError)
+		return
+	}
+	if user.EmailVerified {
+		http.Error(w, "Email already verified", http.StatusConflict)
+		return
+	}
+
+	sentAt, err := database.VerificationCodeSentAt(userID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if wait := resendInterval - time.Since(sentAt); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait/time.Second)+1))
+		http.Error(w, "A code was sent recently; try again shortly", http.StatusTooManyRequests)
+		return
+	}
+
+	if err := sendVerificationCode(user); err != nil {
+		log.Printf("❌ Error sending verification email to user %d: %v", userID, err)
+		http.Error(w, "Failed to send email", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Email sent"})
+}
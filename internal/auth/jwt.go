@@ -2,36 +2,53 @@ package auth
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/magnusohle/openanki-backend/internal/database"
 )
 
-var SecretKey = []byte("REPLACE_WITH_ENV_VAR") // In prod, read from os.Getenv
+// AccessTokenTTL is how long an access token is valid; clients renew it with
+// their session's refresh token
+const AccessTokenTTL = 15 * time.Minute
 
 type Claims struct {
 	UserID int    `json:"user_id"`
 	Email  string `json:"email"`
+	// SessionID is the session the token was issued to, empty for tokens
+	// issued before sessions existed
+	SessionID string `json:"sid,omitempty"`
+	// Version is the user's token version at issue time
+	Version int `json:"ver"`
 	jwt.RegisteredClaims
 }
 
-// GenerateToken creates a JWT token for a user
-func GenerateToken(userID int, email string) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour * 7) // 7 days
+// GenerateToken creates an access token for a user's session
+func GenerateToken(userID int, email, sessionID string, version int) (string, error) {
+	expirationTime := time.Now().Add(AccessTokenTTL)
 	claims := &Claims{
-		UserID: userID,
-		Email:  email,
+		UserID:    userID,
+		Email:     email,
+		SessionID: sessionID,
+		Version:   version,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(SecretKey)
+	keys, err := currentKeys()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(keys.Signing.Method, claims)
+	token.Header["kid"] = keys.Signing.ID
+	return token.SignedString(keys.Signing.Private)
 }
 
 // Middleware verifies the JWT token
@@ -52,20 +69,58 @@ func Middleware(next http.Handler) http.Handler {
 		tokenStr := bearerToken[1]
 		claims := &Claims{}
 
-		token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method")
-			}
-			return SecretKey, nil
-		})
+		token, err := jwt.ParseWithClaims(tokenStr, claims, verificationKey)
 
 		if err != nil || !token.Valid {
 			http.Error(w, "Invalid token", http.StatusUnauthorized)
 			return
 		}
 
-		// Add user ID to context
+		live, err := tokenLive(claims)
+		if err != nil {
+			log.Printf("❌ Error checking session: %v", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if !live {
+			http.Error(w, "Token revoked", http.StatusUnauthorized)
+			return
+		}
+
+		// Add user ID and session to context
 		ctx := context.WithValue(r.Context(), "user_id", claims.UserID)
+		ctx = context.WithValue(ctx, "session_id", claims.SessionID)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// tokenLive reports whether a valid token has not been revoked, by signing
+// out its session or bumping the user's token version
+func tokenLive(claims *Claims) (bool, error) {
+	if claims.SessionID != "" {
+		return database.CheckSession(claims.SessionID, claims.UserID, claims.Version)
+	}
+	version, err := database.GetTokenVersion(claims.UserID)
+	if errors.Is(err, database.ErrUserNotFound) {
+		return false, nil
+	}
+	return err == nil && version == claims.Version, err
+}
+
+// verificationKey finds the key a token was signed with by its kid header.
+// Tokens must use the algorithm of that key.
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	keys, err := currentKeys()
+	if err != nil {
+		return nil, err
+	}
+	kid, _ := token.Header["kid"].(string)
+	key, ok := keys.Lookup(kid)
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method")
+	}
+	return key.Public, nil
+}
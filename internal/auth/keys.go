@@ -0,0 +1,264 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Key is a JWT signing key, named by the kid header of the tokens it signs
+type Key struct {
+	ID     string
+	Method jwt.SigningMethod
+	// Private signs tokens; nil for keys kept only to verify tokens issued
+	// before a rotation
+	Private any
+	// Public verifies tokens (the secret itself for HS256)
+	Public any
+}
+
+// KeySet holds the key new tokens are signed with and every key tokens are
+// still accepted from
+type KeySet struct {
+	Signing *Key
+	keys    map[string]*Key
+	order   []string
+}
+
+// Lookup returns the key with the given kid
+func (ks *KeySet) Lookup(kid string) (*Key, bool) {
+	k, ok := ks.keys[kid]
+	return k, ok
+}
+
+// Keys returns every key in configuration order
+func (ks *KeySet) Keys() []*Key {
+	keys := make([]*Key, 0, len(ks.order))
+	for _, id := range ks.order {
+		keys = append(keys, ks.keys[id])
+	}
+	return keys
+}
+
+var errNoKeys = errors.New("neither JWT_KEYS nor JWT_SECRET is set (JWT_DEV_RANDOM_KEY=true signs with a random key)")
+
+var (
+	keysMu  sync.Mutex
+	current *KeySet
+)
+
+// InitKeys loads the keys configured by JWT_KEYS (see ParseKeys) and uses
+// them from then on. JWT_SIGNING_KEY names the key that signs new tokens,
+// the first one by default. Without JWT_KEYS a JWT_SECRET is used as the
+// HS256 key "default". Without either it fails, unless JWT_DEV_RANDOM_KEY
+// is true for development: then a random key that dies with the process is
+// used (clients renew their access tokens with refresh tokens).
+func InitKeys() (*KeySet, error) {
+	ks, err := keysFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	SetKeys(ks)
+	return ks, nil
+}
+
+// SetKeys replaces the keys tokens are signed and verified with
+func SetKeys(ks *KeySet) {
+	keysMu.Lock()
+	defer keysMu.Unlock()
+	current = ks
+}
+
+// currentKeys returns the keys in use, loading them on first use
+func currentKeys() (*KeySet, error) {
+	keysMu.Lock()
+	defer keysMu.Unlock()
+	if current == nil {
+		ks, err := keysFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		current = ks
+	}
+	return current, nil
+}
+
+func keysFromEnv() (*KeySet, error) {
+	spec := os.Getenv("JWT_KEYS")
+	if spec == "" {
+		secret := os.Getenv("JWT_SECRET")
+		if secret == "" {
+			if dev, _ := strconv.ParseBool(os.Getenv("JWT_DEV_RANDOM_KEY")); !dev {
+				return nil, errNoKeys
+			}
+			log.Println("⚠️ JWT_KEYS and JWT_SECRET not set, access tokens will not survive a restart")
+			random := make([]byte, 32)
+			if _, err := rand.Read(random); err != nil {
+				return nil, err
+			}
+			secret = base64.RawStdEncoding.EncodeToString(random)
+		}
+		spec = "default=HS256:" + secret
+	}
+	return ParseKeys(spec, os.Getenv("JWT_SIGNING_KEY"))
+}
+
+// ParseKeys reads comma separated keys of the form kid=ALG:value, where ALG
+// is HS256 with the secret as value, or ES256 or EdDSA with the path of a PEM
+// key as value. A public key verifies tokens but cannot sign them. signingID
+// names the signing key; empty picks the first key.
+func ParseKeys(spec, signingID string) (*KeySet, error) {
+	ks := &KeySet{keys: map[string]*Key{}}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, rest, ok := strings.Cut(entry, "=")
+		alg, value, ok2 := strings.Cut(rest, ":")
+		if !ok || !ok2 || id == "" || value == "" {
+			return nil, fmt.Errorf("JWT key %q is not kid=ALG:value", entry)
+		}
+		if _, dup := ks.keys[id]; dup {
+			return nil, fmt.Errorf("JWT key id %q is used twice", id)
+		}
+		key, err := parseKey(id, alg, value)
+		if err != nil {
+			return nil, fmt.Errorf("JWT key %q: %w", id, err)
+		}
+		ks.keys[id] = key
+		ks.order = append(ks.order, id)
+	}
+	if len(ks.order) == 0 {
+		return nil, errors.New("no JWT keys configured")
+	}
+
+	if signingID == "" {
+		signingID = ks.order[0]
+	}
+	signing, ok := ks.keys[signingID]
+	if !ok {
+		return nil, fmt.Errorf("signing key %q is not configured", signingID)
+	}
+	if signing.Private == nil {
+		return nil, fmt.Errorf("signing key %q has no private key", signingID)
+	}
+	ks.Signing = signing
+	return ks, nil
+}
+
+func parseKey(id, alg, value string) (*Key, error) {
+	switch alg {
+	case jwt.SigningMethodHS256.Alg():
+		if len(value) < 32 {
+			return nil, errors.New("HS256 secret must be at least 32 characters")
+		}
+		return &Key{ID: id, Method: jwt.SigningMethodHS256, Private: []byte(value), Public: []byte(value)}, nil
+	case jwt.SigningMethodES256.Alg(), jwt.SigningMethodEdDSA.Alg():
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q (HS256, ES256 or EdDSA)", alg)
+	}
+
+	data, err := os.ReadFile(value)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	var private, public any
+	switch block.Type {
+	case "PRIVATE KEY":
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		private, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		public, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := private.(type) {
+	case *ecdsa.PrivateKey:
+		public = &k.PublicKey
+	case ed25519.PrivateKey:
+		public = k.Public()
+	}
+	key := &Key{ID: id, Private: private, Public: public}
+	switch p := public.(type) {
+	case *ecdsa.PublicKey:
+		if p.Curve != elliptic.P256() {
+			return nil, errors.New("ES256 needs a P-256 key")
+		}
+		key.Method = jwt.SigningMethodES256
+	case ed25519.PublicKey:
+		key.Method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", public)
+	}
+	if key.Method.Alg() != alg {
+		return nil, fmt.Errorf("key is for %s, not %s", key.Method.Alg(), alg)
+	}
+	return key, nil
+}
+
+// JWK is the public part of a key as published in a JWKS
+type JWK struct {
+	KeyType string `json:"kty"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y,omitempty"`
+	ID      string `json:"kid"`
+	Alg     string `json:"alg"`
+	Use     string `json:"use"`
+}
+
+// JWKS returns the public keys tokens can be verified with. HS256 keys are
+// secret and left out.
+func (ks *KeySet) JWKS() []JWK {
+	jwks := []JWK{}
+	for _, k := range ks.Keys() {
+		jwk := JWK{ID: k.ID, Alg: k.Method.Alg(), Use: "sig"}
+		switch p := k.Public.(type) {
+		case *ecdsa.PublicKey:
+			jwk.KeyType, jwk.Curve = "EC", "P-256"
+			// Coordinates are padded to the curve size
+			x, y := make([]byte, 32), make([]byte, 32)
+			jwk.X = base64.RawURLEncoding.EncodeToString(p.X.FillBytes(x))
+			jwk.Y = base64.RawURLEncoding.EncodeToString(p.Y.FillBytes(y))
+		case ed25519.PublicKey:
+			jwk.KeyType, jwk.Curve = "OKP", "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(p)
+		default:
+			continue
+		}
+		jwks = append(jwks, jwk)
+	}
+	return jwks
+}
+
+// PublicJWKS returns the JWKS of the keys in use
+func PublicJWKS() ([]JWK, error) {
+	ks, err := currentKeys()
+	if err != nil {
+		return nil, err
+	}
+	return ks.JWKS(), nil
+}
@@ -0,0 +1,288 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var testSecret = strings.Repeat("s", 32)
+
+// writePEM writes a PEM block of the given type to a file in dir
+func writePEM(t *testing.T, dir, name, typ string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// testKeyFiles writes an ES256 private key, its public key and an EdDSA
+// private key, returning their paths and the two public keys
+func testKeyFiles(t *testing.T) (es, esPub, ed string, ecPub *ecdsa.PublicKey, edPub ed25519.PublicKey) {
+	t.Helper()
+	dir := t.TempDir()
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	es = writePEM(t, dir, "es.pem", "EC PRIVATE KEY", der)
+	der, err = x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	esPub = writePEM(t, dir, "es.pub.pem", "PUBLIC KEY", der)
+
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err = x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ed = writePEM(t, dir, "ed.pem", "PRIVATE KEY", der)
+	return es, esPub, ed, &ecKey.PublicKey, edPub
+}
+
+// useKeys makes ks the keys in use for the rest of the test
+func useKeys(t *testing.T, ks *KeySet) {
+	t.Helper()
+	before, err := currentKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetKeys(ks)
+	t.Cleanup(func() { SetKeys(before) })
+}
+
+func TestParseKeysSigningKey(t *testing.T) {
+	es, esPub, ed, _, _ := testKeyFiles(t)
+	spec := "old=HS256:" + testSecret + ", es=ES256:" + es + ",ed=EdDSA:" + ed + ",pub=ES256:" + esPub
+	tests := []struct {
+		signing string
+		want    string
+		wantErr bool
+	}{
+		{"", "old", false},
+		{"es", "es", false},
+		{"ed", "ed", false},
+		{"pub", "", true},
+		{"missing", "", true},
+	}
+	for _, tt := range tests {
+		ks, err := ParseKeys(spec, tt.signing)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseKeys(signing %q): err = %v, want error %v", tt.signing, err, tt.wantErr)
+			continue
+		}
+		if err == nil && ks.Signing.ID != tt.want {
+			t.Errorf("ParseKeys(signing %q) signs with %q, want %q", tt.signing, ks.Signing.ID, tt.want)
+		}
+	}
+
+	ks, err := ParseKeys(spec, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, k := range ks.Keys() {
+		ids = append(ids, k.ID+":"+k.Method.Alg())
+	}
+	if got := strings.Join(ids, ","); got != "old:HS256,es:ES256,ed:EdDSA,pub:ES256" {
+		t.Errorf("keys = %s", got)
+	}
+}
+
+func TestParseKeysInvalid(t *testing.T) {
+	es, _, ed, _, _ := testKeyFiles(t)
+	for _, spec := range []string{
+		"",
+		" , ",
+		"nokid",
+		"=HS256:" + testSecret,
+		"a=HS256:",
+		"a=HS256:short",
+		"a=HS256:" + testSecret + ",a=HS256:" + testSecret,
+		"a=RS256:" + es,
+		"a=EdDSA:" + es,
+		"a=ES256:" + ed,
+		"a=ES256:" + filepath.Join(t.TempDir(), "missing.pem"),
+	} {
+		if _, err := ParseKeys(spec, ""); err == nil {
+			t.Errorf("ParseKeys(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestTokensVerifyByKid(t *testing.T) {
+	es, _, ed, _, _ := testKeyFiles(t)
+	spec := "old=HS256:" + testSecret + ",es=ES256:" + es + ",ed=EdDSA:" + ed
+	old, err := ParseKeys(spec, "old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	useKeys(t, old)
+	oldToken, err := GenerateToken(1, "a@example.com", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotate to each asymmetric key in turn; tokens of every configured key
+	// keep verifying
+	for _, id := range []string{"es", "ed"} {
+		ks, err := ParseKeys(spec, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		SetKeys(ks)
+		token, err := GenerateToken(1, "a@example.com", "", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := jwt.ParseWithClaims(token, &Claims{}, verificationKey)
+		if err != nil {
+			t.Fatalf("%s token: %v", id, err)
+		}
+		if kid := parsed.Header["kid"]; kid != id {
+			t.Errorf("kid = %v, want %s", kid, id)
+		}
+		if _, err := jwt.ParseWithClaims(oldToken, &Claims{}, verificationKey); err != nil {
+			t.Errorf("old token after rotating to %s: %v", id, err)
+		}
+	}
+
+	// Once the old key is dropped its tokens stop verifying
+	ks, err := ParseKeys("es=ES256:"+es, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetKeys(ks)
+	if _, err := jwt.ParseWithClaims(oldToken, &Claims{}, verificationKey); err == nil {
+		t.Error("token of a dropped key verified")
+	}
+}
+
+func TestVerificationKeyRejects(t *testing.T) {
+	ks, err := ParseKeys("a=HS256:"+testSecret+",b=HS256:"+strings.Repeat("t", 32), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	useKeys(t, ks)
+	claims := &Claims{UserID: 1}
+	sign := func(method jwt.SigningMethod, kid any, key any) string {
+		token := jwt.NewWithClaims(method, claims)
+		if kid != nil {
+			token.Header["kid"] = kid
+		}
+		s, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"no kid", sign(jwt.SigningMethodHS256, nil, []byte(testSecret))},
+		{"unknown kid", sign(jwt.SigningMethodHS256, "c", []byte(testSecret))},
+		{"kid of another key", sign(jwt.SigningMethodHS256, "b", []byte(testSecret))},
+		{"algorithm of another kind", sign(jwt.SigningMethodEdDSA, "a", edKey)},
+		{"none", sign(jwt.SigningMethodNone, "a", jwt.UnsafeAllowNoneSignatureType)},
+	}
+	for _, tt := range tests {
+		if _, err := jwt.ParseWithClaims(tt.token, &Claims{}, verificationKey); err == nil {
+			t.Errorf("%s: token verified", tt.name)
+		}
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	es, esPub, ed, ecPub, edPub := testKeyFiles(t)
+	ks, err := ParseKeys("hs=HS256:"+testSecret+",es=ES256:"+es+",ed=EdDSA:"+ed+",pub=ES256:"+esPub, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := ks.JWKS()
+	if len(jwks) != 3 {
+		t.Fatalf("got %d keys, want 3 without the HS256 secret: %+v", len(jwks), jwks)
+	}
+
+	b64 := base64.RawURLEncoding.EncodeToString
+	pad := func(b []byte) []byte { return append(make([]byte, 32-len(b)), b...) }
+	wantEC := JWK{KeyType: "EC", Curve: "P-256", X: b64(pad(ecPub.X.Bytes())), Y: b64(pad(ecPub.Y.Bytes())), Alg: "ES256", Use: "sig"}
+	want := []JWK{wantEC, {KeyType: "OKP", Curve: "Ed25519", X: b64(edPub), ID: "ed", Alg: "EdDSA", Use: "sig"}, wantEC}
+	want[0].ID, want[2].ID = "es", "pub"
+	for i := range want {
+		if jwks[i] != want[i] {
+			t.Errorf("jwks[%d] = %+v, want %+v", i, jwks[i], want[i])
+		}
+		if len(jwks[i].X) != 43 || (jwks[i].Y != "" && len(jwks[i].Y) != 43) {
+			t.Errorf("jwks[%d] coordinates are not 32 bytes: %+v", i, jwks[i])
+		}
+	}
+
+	hsOnly, err := ParseKeys("hs=HS256:"+testSecret, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jwks := hsOnly.JWKS(); jwks == nil || len(jwks) != 0 {
+		t.Errorf("HS256 only jwks = %#v, want empty", jwks)
+	}
+}
+
+func TestKeysFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr error
+		wantID  string
+	}{
+		{name: "nothing set", wantErr: errNoKeys},
+		{name: "dev flag off", env: map[string]string{"JWT_DEV_RANDOM_KEY": "false"}, wantErr: errNoKeys},
+		{name: "dev flag", env: map[string]string{"JWT_DEV_RANDOM_KEY": "true"}, wantID: "default"},
+		{name: "secret", env: map[string]string{"JWT_SECRET": testSecret}, wantID: "default"},
+		{name: "keys", env: map[string]string{"JWT_KEYS": "a=HS256:" + testSecret + ",b=HS256:" + testSecret, "JWT_SIGNING_KEY": "b"}, wantID: "b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"JWT_KEYS", "JWT_SECRET", "JWT_SIGNING_KEY", "JWT_DEV_RANDOM_KEY"} {
+				t.Setenv(name, tt.env[name])
+			}
+			ks, err := keysFromEnv()
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ks.Signing.ID != tt.wantID {
+				t.Errorf("signing key = %q, want %q", ks.Signing.ID, tt.wantID)
+			}
+		})
+	}
+}
@@ -0,0 +1,19 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/magnusohle/openanki-backend/internal/database"
+	"github.com/magnusohle/openanki-backend/internal/testutil"
+)
+
+func TestMain(m *testing.M) {
+	testutil.Main(m, func(*database.Repository) {
+		ks, err := ParseKeys("test=HS256:"+strings.Repeat("k", 32), "")
+		if err != nil {
+			panic(err)
+		}
+		SetKeys(ks)
+	})
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// argon2id parameters of new hashes (OWASP's minimum recommendation).
+// Changing them upgrades stored hashes on the next login.
+const (
+	argonMemory  = 19 * 1024 // KiB
+	argonTime    = 2
+	argonThreads = 1
+	argonSaltLen = 16
+	argonKeyLen  = 32
+)
+
+// argonPrefix starts every argon2id hash in users.password_hash. Hashes
+// without a known prefix are legacy unsalted hex SHA-256.
+const argonPrefix = "$argon2id$"
+
+var errBadHash = errors.New("malformed password hash")
+
+// HashPassword hashes password with argon2id and a random salt, encoded as
+// $argon2id$v=19$m=...,t=...,p=...$salt$key
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, argonSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, argonTime, argonMemory, argonThreads, argonKeyLen)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argonPrefix, argon2.Version,
+		argonMemory, argonTime, argonThreads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// VerifyPassword reports whether password matches the stored hash, and
+// whether the hash should be replaced by HashPassword's because it uses the
+// legacy scheme or outdated parameters.
+func VerifyPassword(password, hash string) (ok, rehash bool) {
+	if !strings.HasPrefix(hash, argonPrefix) {
+		sum := sha256.Sum256([]byte(password))
+		legacy := hex.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(legacy), []byte(hash)) == 1, true
+	}
+
+	var version int
+	var memory, time uint32
+	var threads uint8
+	parts := strings.Split(strings.TrimPrefix(hash, argonPrefix), "$")
+	if len(parts) != 4 {
+		return false, false
+	}
+	if _, err := fmt.Sscanf(parts[0], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false
+	}
+	if _, err := fmt.Sscanf(parts[1], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil || time == 0 || threads == 0 {
+		return false, false
+	}
+	salt, key, err := decodeArgonParts(parts[2], parts[3])
+	if err != nil {
+		return false, false
+	}
+
+	got := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(got, key) != 1 {
+		return false, false
+	}
+	outdated := memory != argonMemory || time != argonTime || threads != argonThreads ||
+		len(salt) != argonSaltLen || len(key) != argonKeyLen
+	return true, outdated
+}
+
+func decodeArgonParts(salt, key string) ([]byte, []byte, error) {
+	s, err := base64.RawStdEncoding.DecodeString(salt)
+	if err != nil {
+		return nil, nil, errBadHash
+	}
+	k, err := base64.RawStdEncoding.DecodeString(key)
+	if err != nil || len(k) == 0 {
+		return nil, nil, errBadHash
+	}
+	return s, k, nil
+}
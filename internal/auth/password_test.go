@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+)
+
+func TestHashPassword(t *testing.T) {
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=19456,t=2,p=1$") {
+		t.Errorf("hash = %q", hash)
+	}
+	other, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == hash {
+		t.Error("two hashes of the same password are equal, salt is not random")
+	}
+
+	if ok, rehash := VerifyPassword("correct horse", hash); !ok || rehash {
+		t.Errorf("VerifyPassword(right) = %v, %v, want true, false", ok, rehash)
+	}
+	if ok, _ := VerifyPassword("correct horse!", hash); ok {
+		t.Error("VerifyPassword(wrong) = true")
+	}
+}
+
+func TestVerifyLegacyPassword(t *testing.T) {
+	sum := sha256.Sum256([]byte("hunter2"))
+	legacy := hex.EncodeToString(sum[:])
+
+	if ok, rehash := VerifyPassword("hunter2", legacy); !ok || !rehash {
+		t.Errorf("VerifyPassword(right) = %v, %v, want true, true", ok, rehash)
+	}
+	if ok, _ := VerifyPassword("hunter3", legacy); ok {
+		t.Error("VerifyPassword(wrong) = true")
+	}
+	if ok, _ := VerifyPassword("", ""); ok {
+		t.Error("empty password matches an empty hash")
+	}
+}
+
+// argonHash encodes a hash of password with the given parameters
+func argonHash(password string, memory, time uint32, threads uint8, saltLen int) string {
+	salt := make([]byte, saltLen)
+	key := argon2.IDKey([]byte(password), salt, time, memory, threads, argonKeyLen)
+	return fmt.Sprintf("$argon2id$v=19$m=%d,t=%d,p=%d$%s$%s", memory, time, threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+func TestVerifyPasswordRehash(t *testing.T) {
+	tests := []struct {
+		name       string
+		hash       string
+		wantRehash bool
+	}{
+		{"current parameters", argonHash("pw", argonMemory, argonTime, argonThreads, argonSaltLen), false},
+		{"less memory", argonHash("pw", 8*1024, argonTime, argonThreads, argonSaltLen), true},
+		{"fewer passes", argonHash("pw", argonMemory, 1, argonThreads, argonSaltLen), true},
+		{"more threads", argonHash("pw", argonMemory, argonTime, 2, argonSaltLen), true},
+		{"short salt", argonHash("pw", argonMemory, argonTime, argonThreads, 8), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, rehash := VerifyPassword("pw", tt.hash)
+			if !ok || rehash != tt.wantRehash {
+				t.Errorf("VerifyPassword = %v, %v, want true, %v", ok, rehash, tt.wantRehash)
+			}
+		})
+	}
+}
+
+func TestVerifyPasswordMalformed(t *testing.T) {
+	valid := argonHash("pw", argonMemory, argonTime, argonThreads, argonSaltLen)
+	parts := strings.Split(valid, "$")
+	salt, key := parts[4], parts[5]
+	for _, hash := range []string{
+		"$argon2id$",
+		"$argon2id$v=19$m=19456,t=2,p=1$" + salt,
+		"$argon2id$v=18$m=19456,t=2,p=1$" + salt + "$" + key,
+		"$argon2id$v=19$m=19456,t=2$" + salt + "$" + key,
+		"$argon2id$v=19$m=19456,t=0,p=1$" + salt + "$" + key,
+		"$argon2id$v=19$m=19456,t=2,p=0$" + salt + "$" + key,
+		"$argon2id$v=19$m=19456,t=2,p=1$!!$" + key,
+		"$argon2id$v=19$m=19456,t=2,p=1$" + salt + "$",
+		"$argon2id$v=19$m=19456,t=2,p=1$" + salt + "$" + key + "$extra",
+	} {
+		if ok, rehash := VerifyPassword("pw", hash); ok || rehash {
+			t.Errorf("VerifyPassword(%q) = %v, %v, want false, false", hash, ok, rehash)
+		}
+	}
+}
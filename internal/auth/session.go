@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/magnusohle/openanki-backend/internal/database"
+)
+
+// RefreshTokenTTL is how long a session lasts without being refreshed. Every
+// refresh extends it.
+const RefreshTokenTTL = 30 * 24 * time.Hour
+
+// ErrInvalidRefreshToken is returned for refresh tokens that are malformed,
+// expired, revoked or already used
+var ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+// Tokens are what signing in or refreshing a session returns to the client
+type Tokens struct {
+	// AccessToken authenticates API requests until it expires
+	AccessToken string `json:"token"`
+	// RefreshToken gets new tokens once; each refresh returns a new one
+	RefreshToken string `json:"refresh_token"`
+	// ExpiresIn is the access token's lifetime in seconds
+	ExpiresIn int `json:"expires_in"`
+}
+
+// StartSession signs the user in on a device, creating a session
+func StartSession(user *database.User, deviceName string) (*Tokens, error) {
+	secret, hash, err := newRefreshSecret()
+	if err != nil {
+		return nil, err
+	}
+	sessionID, err := database.CreateSession(user.ID, deviceName, hash, time.Now().Add(RefreshTokenTTL))
+	if err != nil {
+		return nil, err
+	}
+	return sessionTokens(user, sessionID, secret)
+}
+
+// RefreshSession exchanges a refresh token for new tokens of its session.
+// The presented token stops working; presenting it again revokes the session.
+func RefreshSession(refreshToken string) (*Tokens, error) {
+	sessionID, secret, ok := strings.Cut(refreshToken, ".")
+	if !ok || sessionID == "" || secret == "" {
+		return nil, ErrInvalidRefreshToken
+	}
+	newSecret, newHash, err := newRefreshSecret()
+	if err != nil {
+		return nil, err
+	}
+	userID, err := database.RotateSession(sessionID, hashRefreshSecret(secret), newHash, time.Now().Add(RefreshTokenTTL))
+	if errors.Is(err, database.ErrSessionNotFound) || errors.Is(err, database.ErrRefreshTokenReused) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := database.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	return sessionTokens(user, sessionID, newSecret)
+}
+
+func sessionTokens(user *database.User, sessionID, secret string) (*Tokens, error) {
+	access, err := GenerateToken(user.ID, user.Email, sessionID, user.TokenVersion)
+	if err != nil {
+		return nil, err
+	}
+	return &Tokens{
+		AccessToken:  access,
+		RefreshToken: sessionID + "." + secret,
+		ExpiresIn:    int(AccessTokenTTL / time.Second),
+	}, nil
+}
+
+// newRefreshSecret returns a random refresh secret and the hash it is
+// stored as
+func newRefreshSecret() (string, string, error) {
+	secret := database.GenerateRandomString(32)
+	if secret == "" {
+		return "", "", errors.New("failed to generate refresh token")
+	}
+	return secret, hashRefreshSecret(secret), nil
+}
+
+func hashRefreshSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
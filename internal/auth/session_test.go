@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/magnusohle/openanki-backend/internal/database"
+	"github.com/magnusohle/openanki-backend/internal/testutil"
+)
+
+// accessClaims parses an access token the way Middleware does
+func accessClaims(t *testing.T, token string) *Claims {
+	t.Helper()
+	claims := &Claims{}
+	if _, err := jwt.ParseWithClaims(token, claims, verificationKey); err != nil {
+		t.Fatal(err)
+	}
+	return claims
+}
+
+func TestRefreshSessionRotates(t *testing.T) {
+	user := testutil.CreateUser(t)
+	first, err := StartSession(user, "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessionID, _, _ := strings.Cut(first.RefreshToken, ".")
+	if claims := accessClaims(t, first.AccessToken); claims.UserID != user.ID || claims.SessionID != sessionID {
+		t.Errorf("claims = user %d session %q, want user %d session %q", claims.UserID, claims.SessionID, user.ID, sessionID)
+	}
+
+	second, err := RefreshSession(first.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.RefreshToken == first.RefreshToken {
+		t.Error("refresh returned the same refresh token")
+	}
+	if !strings.HasPrefix(second.RefreshToken, sessionID+".") {
+		t.Errorf("refresh token %q is not of session %q", second.RefreshToken, sessionID)
+	}
+	if claims := accessClaims(t, second.AccessToken); claims.SessionID != sessionID {
+		t.Errorf("refreshed token session = %q, want %q", claims.SessionID, sessionID)
+	}
+
+	third, err := RefreshSession(second.RefreshToken)
+	if err != nil {
+		t.Fatalf("refreshing with the new token: %v", err)
+	}
+	if live, err := tokenLive(accessClaims(t, third.AccessToken)); err != nil || !live {
+		t.Errorf("tokenLive = %v, %v, want true", live, err)
+	}
+}
+
+func TestRefreshSessionReuseRevokes(t *testing.T) {
+	user := testutil.CreateUser(t)
+	first, err := StartSession(user, "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := RefreshSession(first.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The used token is presented again, say by whoever stole it
+	if _, err := RefreshSession(first.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("reused token: err = %v, want ErrInvalidRefreshToken", err)
+	}
+	if _, err := RefreshSession(second.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("token of the revoked session: err = %v, want ErrInvalidRefreshToken", err)
+	}
+	if live, err := tokenLive(accessClaims(t, second.AccessToken)); err != nil || live {
+		t.Errorf("tokenLive after reuse = %v, %v, want false", live, err)
+	}
+}
+
+func TestRefreshSessionKeepsOtherSessions(t *testing.T) {
+	user := testutil.CreateUser(t)
+	phone, err := StartSession(user, "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	laptop, err := StartSession(user, "laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RefreshSession(phone.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RefreshSession(phone.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("reused token: err = %v, want ErrInvalidRefreshToken", err)
+	}
+	if _, err := RefreshSession(laptop.RefreshToken); err != nil {
+		t.Errorf("other session after reuse: %v", err)
+	}
+}
+
+func TestRefreshSessionInvalid(t *testing.T) {
+	user := testutil.CreateUser(t)
+	tokens, err := StartSession(user, "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessionID, _, _ := strings.Cut(tokens.RefreshToken, ".")
+	for _, token := range []string{"", "nodot", ".secret", sessionID + ".", "unknown.secret"} {
+		if _, err := RefreshSession(token); !errors.Is(err, ErrInvalidRefreshToken) {
+			t.Errorf("RefreshSession(%q): err = %v, want ErrInvalidRefreshToken", token, err)
+		}
+	}
+	// None of them counted as reuse of the live session's token
+	if _, err := RefreshSession(tokens.RefreshToken); err != nil {
+		t.Errorf("session after invalid tokens: %v", err)
+	}
+}
+
+func TestRefreshSessionSignedOut(t *testing.T) {
+	user := testutil.CreateUser(t)
+	tokens, err := StartSession(user, "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := database.RevokeUserSessions(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RefreshSession(tokens.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("after signing out everywhere: err = %v, want ErrInvalidRefreshToken", err)
+	}
+	if live, err := tokenLive(accessClaims(t, tokens.AccessToken)); err != nil || live {
+		t.Errorf("tokenLive after signing out = %v, %v, want false", live, err)
+	}
+}
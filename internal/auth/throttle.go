@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/magnusohle/openanki-backend/internal/database"
+)
+
+// ThrottleMemory is how long attempts are remembered without a new one
+const ThrottleMemory = 24 * time.Hour
+
+// Throttle slows down guessing at secrets. Once a subject (an account, an
+// IP) has used its free attempts, every further attempt locks it out for
+// twice as long as the one before, from BaseDelay up to MaxDelay. Counters
+// live in the database, so restarts do not reset them.
+type Throttle struct {
+	// Name prefixes the subjects' keys, e.g. "login:account"
+	Name      string
+	Free      int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+func (t *Throttle) key(subject string) string {
+	return t.Name + ":" + subject
+}
+
+// lockFor is how long the subject is locked out after attempts attempts
+func (t *Throttle) lockFor(attempts int) time.Duration {
+	if attempts <= t.Free {
+		return 0
+	}
+	delay := t.BaseDelay
+	for i := t.Free + 1; i < attempts && delay < t.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, t.MaxDelay)
+}
+
+// Attempt counts an attempt by subject. While the subject is locked out it
+// returns how long it has to wait and counts nothing; otherwise it returns
+// the lockout this attempt started, if any. Counting before checking the
+// secret keeps concurrent guesses from slipping past the lock.
+func (t *Throttle) Attempt(subject string) (wait, locked time.Duration, err error) {
+	until, locked, err := database.ThrottleAttempt(t.key(subject), time.Now().Add(-ThrottleMemory), t.lockFor)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !until.IsZero() {
+		return time.Until(until), 0, nil
+	}
+	return 0, locked, nil
+}
+
+// Forgive takes back an attempt that turned out legitimate
+func (t *Throttle) Forgive(subject string) error {
+	return database.ForgiveThrottleAttempt(t.key(subject), t.Free)
+}
+
+// Reset forgets the subject's attempts
+func (t *Throttle) Reset(subject string) error {
+	return database.ClearThrottle(t.key(subject))
+}
@@ -0,0 +1,183 @@
+package auth
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/magnusohle/openanki-backend/internal/database"
+)
+
+func TestThrottleLockFor(t *testing.T) {
+	th := &Throttle{Free: 3, BaseDelay: 30 * time.Second, MaxDelay: 5 * time.Minute}
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 0},
+		{3, 0},
+		{4, 30 * time.Second},
+		{5, time.Minute},
+		{6, 2 * time.Minute},
+		{7, 4 * time.Minute},
+		{8, 5 * time.Minute},
+		{100, 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := th.lockFor(tt.attempts); got != tt.want {
+			t.Errorf("lockFor(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+var testThrottles int
+
+// testThrottle returns a throttle of its own for a test
+func testThrottle(t *testing.T) *Throttle {
+	testThrottles++
+	return &Throttle{Name: fmt.Sprintf("test%d", testThrottles), Free: 2, BaseDelay: time.Minute, MaxDelay: time.Hour}
+}
+
+// expireLock ends the subject's lockout and backdates its last attempt by ago
+func expireLock(t *testing.T, th *Throttle, subject string, ago time.Duration) {
+	t.Helper()
+	past := time.Now().UTC().Add(-ago)
+	if _, err := database.DB.Exec("UPDATE auth_throttles SET locked_until = ?, last_attempt_at = ? WHERE key = ?",
+		past, past, th.key(subject)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func attempt(t *testing.T, th *Throttle, subject string) (wait, locked time.Duration) {
+	t.Helper()
+	wait, locked, err := th.Attempt(subject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wait, locked
+}
+
+func TestThrottleAttempt(t *testing.T) {
+	th := testThrottle(t)
+	for i := 1; i <= th.Free; i++ {
+		if wait, locked := attempt(t, th, "a"); wait != 0 || locked != 0 {
+			t.Fatalf("free attempt %d: wait %v, locked %v", i, wait, locked)
+		}
+	}
+	if wait, locked := attempt(t, th, "a"); wait != 0 || locked != time.Minute {
+		t.Fatalf("first attempt past the free ones: wait %v, locked %v, want a minute's lock", wait, locked)
+	}
+
+	// Attempts while locked out wait and do not count
+	for i := 0; i < 3; i++ {
+		wait, locked := attempt(t, th, "a")
+		if wait <= 0 || wait > time.Minute || locked != 0 {
+			t.Fatalf("attempt while locked: wait %v, locked %v", wait, locked)
+		}
+	}
+
+	// Other subjects are not affected
+	if wait, _ := attempt(t, th, "b"); wait != 0 {
+		t.Errorf("other subject waits %v", wait)
+	}
+
+	// Each attempt after a lockout doubles it
+	expireLock(t, th, "a", time.Second)
+	if wait, locked := attempt(t, th, "a"); wait != 0 || locked != 2*time.Minute {
+		t.Errorf("attempt after the lockout: wait %v, locked %v, want 2m lock", wait, locked)
+	}
+}
+
+func TestThrottleForgets(t *testing.T) {
+	th := testThrottle(t)
+	for i := 0; i <= th.Free; i++ {
+		attempt(t, th, "a")
+	}
+	expireLock(t, th, "a", ThrottleMemory+time.Minute)
+	for i := 1; i <= th.Free; i++ {
+		if wait, locked := attempt(t, th, "a"); wait != 0 || locked != 0 {
+			t.Fatalf("attempt %d after a day: wait %v, locked %v, want attempts forgotten", i, wait, locked)
+		}
+	}
+}
+
+func TestThrottleForgive(t *testing.T) {
+	th := testThrottle(t)
+	for i := 0; i <= th.Free; i++ {
+		attempt(t, th, "a")
+	}
+	if wait, _ := attempt(t, th, "a"); wait == 0 {
+		t.Fatal("not locked out")
+	}
+
+	// Taking back the attempt that started the lock lifts it
+	if err := th.Forgive("a"); err != nil {
+		t.Fatal(err)
+	}
+	if wait, locked := attempt(t, th, "a"); wait != 0 || locked != time.Minute {
+		t.Errorf("after forgiving: wait %v, locked %v, want a new minute's lock", wait, locked)
+	}
+
+	// Forgiving more than was counted stops at zero
+	other := "b"
+	for i := 0; i < 3; i++ {
+		if err := th.Forgive(other); err != nil {
+			t.Fatal(err)
+		}
+	}
+	attempt(t, th, other)
+	for i := 0; i < 3; i++ {
+		if err := th.Forgive(other); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 1; i <= th.Free; i++ {
+		if wait, locked := attempt(t, th, other); wait != 0 || locked != 0 {
+			t.Errorf("attempt %d after over-forgiving: wait %v, locked %v", i, wait, locked)
+		}
+	}
+}
+
+func TestThrottleReset(t *testing.T) {
+	th := testThrottle(t)
+	for i := 0; i <= th.Free; i++ {
+		attempt(t, th, "a")
+	}
+	if err := th.Reset("a"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= th.Free; i++ {
+		if wait, locked := attempt(t, th, "a"); wait != 0 || locked != 0 {
+			t.Errorf("attempt %d after reset: wait %v, locked %v", i, wait, locked)
+		}
+	}
+}
+
+func TestThrottleConcurrentAttempts(t *testing.T) {
+	th := testThrottle(t)
+	type result struct {
+		wait, locked time.Duration
+		err          error
+	}
+	results := make(chan result, 10)
+	for i := 0; i < cap(results); i++ {
+		go func() {
+			wait, locked, err := th.Attempt("a")
+			results <- result{wait, locked, err}
+		}()
+	}
+	passed := 0
+	for i := 0; i < cap(results); i++ {
+		r := <-results
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+		if r.wait == 0 {
+			passed++
+		}
+	}
+	// The free attempts and the one that starts the lock get through
+	if passed != th.Free+1 {
+		t.Errorf("%d concurrent attempts got through, want %d", passed, th.Free+1)
+	}
+}
@@ -0,0 +1,177 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// ErrHostKeyNotFound is returned for unknown Anki host keys
+var ErrHostKeyNotFound = errors.New("host key not found")
+
+// CollectionState is the collection metadata reported to Anki desktop clients
+type CollectionState struct {
+	USN   int
+	Mod   int64 // Last change, epoch milliseconds
+	Scm   int64 // Last schema change, epoch milliseconds
+	Crt   int64 // Creation, epoch seconds
+	Empty bool  // No notes or cards
+}
+
+// HostKeyIdleTTL is how long an Anki host key stays valid without being used
+const HostKeyIdleTTL = 90 * 24 * time.Hour
+
+// CreateHostKey issues a new Anki host key for the user. Only its hash is
+// stored. The user's keys unused for HostKeyIdleTTL are dropped.
+func (r *Repository) CreateHostKey(userID int) (string, error) {
+	ctx := context.Background()
+	now := time.Now()
+	key := GenerateRandomString(16)
+	if key == "" {
+		return "", errors.New("failed to generate host key")
+	}
+	err := r.Q.DeleteStaleHostKeys(ctx, DeleteStaleHostKeysParams{
+		UserID: int64(userID),
+		Cutoff: now.Add(-HostKeyIdleTTL).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	err = r.Q.CreateHostKey(ctx, CreateHostKeyParams{Key: hashHostKey(key), UserID: int64(userID), LastUsedAt: now.Unix()})
+	return key, err
+}
+
+// GetHostKeyUser resolves an Anki host key to its user and marks it used.
+// Keys unused for HostKeyIdleTTL are not found.
+func (r *Repository) GetHostKeyUser(key string) (int, error) {
+	now := time.Now()
+	userID, err := r.Q.UseHostKey(context.Background(), UseHostKeyParams{
+		LastUsedAt: now.Unix(),
+		Key:        hashHostKey(key),
+		Cutoff:     now.Add(-HostKeyIdleTTL).Unix(),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrHostKeyNotFound
+	}
+	return int(userID), err
+}
+
+func hashHostKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// migrateHostKeys hashes host keys stored in plain text before keys were
+// hashed, counting them as used now
+func (r *Repository) migrateHostKeys() error {
+	r.DB.Exec(`ALTER TABLE anki_host_keys ADD COLUMN last_used_at INTEGER NOT NULL DEFAULT 0`)
+	rows, err := r.DB.Query(`SELECT key FROM anki_host_keys WHERE length(key) != 64`)
+	if err != nil {
+		return err
+	}
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return err
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	for _, key := range keys {
+		_, err := r.DB.Exec(`UPDATE anki_host_keys SET key = ?, last_used_at = ? WHERE key = ?`, hashHostKey(key), now, key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetCollectionState returns the user's collection metadata. Collections that
+// were never seen by Anki get their schema and creation stamps set to now.
+func (r *Repository) GetCollectionState(userID int) (*CollectionState, error) {
+	ctx := context.Background()
+	uid := int64(userID)
+
+	if err := r.Q.CreateSyncMeta(ctx, uid); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	err := r.Q.InitCollectionStamps(ctx, InitCollectionStampsParams{
+		Scm:    now.UnixMilli(),
+		Crt:    now.Truncate(24 * time.Hour).Unix(),
+		UserID: uid,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	row, err := r.Q.GetCollectionState(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	cards, err := r.Q.CountUserCards(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	return &CollectionState{
+		USN:   int(row.Usn),
+		Mod:   row.Mod,
+		Scm:   row.Scm,
+		Crt:   row.Crt,
+		Empty: cards == 0,
+	}, nil
+}
+
+// TouchCollection sets the collection modification time to now and returns it
+func (r *Repository) TouchCollection(userID int) (int64, error) {
+	mod := time.Now().UnixMilli()
+	err := r.Q.SetCollectionMod(context.Background(), SetCollectionModParams{Mod: mod, UserID: int64(userID)})
+	return mod, err
+}
+
+// CountCollection returns the number of cards and notes of the user
+func (r *Repository) CountCollection(userID int) (cards, notes int, err error) {
+	ctx := context.Background()
+	c, err := r.Q.CountUserCards(ctx, int64(userID))
+	if err != nil {
+		return 0, 0, err
+	}
+	n, err := r.Q.CountUserNotes(ctx, int64(userID))
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(c), int(n), nil
+}
+
+// ApplyChanges writes payload stamped with usn, a USN already allocated with
+// IncrementUSN. Protocols that spread one sync over several requests apply
+// each of them this way. Objects changed after payload.ClientUSN are handled
+// per policy as in PushSyncSafe; changes stamped with usn by earlier requests
+// of the same sync are not conflicts.
+func (r *Repository) ApplyChanges(userID int, payload *SyncPayload, usn int, policy ConflictPolicy) (*PushResult, error) {
+	ctx := context.Background()
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := applyPayload(ctx, r.Q.WithTx(tx), int64(userID), payload, policy, int64(usn), int64(usn))
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	result.USN = usn
+	return result, nil
+}
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHostKeys(t *testing.T) {
+	userID := createTestUser(t)
+	key, err := testRepo.CreateHostKey(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := testRepo.GetHostKeyUser(key)
+	if err != nil || got != userID {
+		t.Fatalf("GetHostKeyUser = %d, %v, want %d", got, err, userID)
+	}
+
+	var stored string
+	if err := testRepo.DB.QueryRow(`SELECT key FROM anki_host_keys WHERE user_id = ?`, userID).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored != hashHostKey(key) {
+		t.Errorf("stored key = %q, want its hash", stored)
+	}
+	if _, err := testRepo.GetHostKeyUser(stored); !errors.Is(err, ErrHostKeyNotFound) {
+		t.Errorf("lookup by stored hash: err = %v, want ErrHostKeyNotFound", err)
+	}
+
+	// Keys idle for longer than the TTL expire and are pruned on the next login
+	idle := time.Now().Add(-HostKeyIdleTTL - time.Hour).Unix()
+	if _, err := testRepo.DB.Exec(`UPDATE anki_host_keys SET last_used_at = ? WHERE user_id = ?`, idle, userID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testRepo.GetHostKeyUser(key); !errors.Is(err, ErrHostKeyNotFound) {
+		t.Errorf("idle key: err = %v, want ErrHostKeyNotFound", err)
+	}
+	if _, err := testRepo.CreateHostKey(userID); err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	if err := testRepo.DB.QueryRow(`SELECT COUNT(*) FROM anki_host_keys WHERE user_id = ?`, userID).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("got %d host keys, want the idle one pruned", count)
+	}
+}
+
+func TestMigrateHostKeys(t *testing.T) {
+	userID := createTestUser(t)
+	const plain = "0123456789abcdef0123456789abcdef"
+	if _, err := testRepo.DB.Exec(`INSERT INTO anki_host_keys (key, user_id) VALUES (?, ?)`, plain, userID); err != nil {
+		t.Fatal(err)
+	}
+	if err := testRepo.migrateHostKeys(); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := testRepo.GetHostKeyUser(plain); err != nil || got != userID {
+		t.Errorf("migrated key resolves to %d, %v, want %d", got, err, userID)
+	}
+	// Running it again leaves hashed keys alone
+	if err := testRepo.migrateHostKeys(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testRepo.GetHostKeyUser(plain); err != nil {
+		t.Errorf("key after second migration: %v", err)
+	}
+}
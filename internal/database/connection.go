@@ -66,13 +66,35 @@ func InitDB(dataSourceName string) (*Repository, error) {
         }
     }
 
+    // Auto-Migrate: group deck sizes, measured once the upload exists
+    DB.Exec(`ALTER TABLE group_decks ADD COLUMN size INTEGER`)
+
+    // Auto-Migrate: group decks are published once their upload is measured.
+    // Decks shared before that stay visible.
+    if _, err := DB.Exec(`ALTER TABLE group_decks ADD COLUMN published_at DATETIME`); err == nil {
+        DB.Exec(`UPDATE group_decks SET published_at = created_at`)
+    }
+
+    // Auto-Migrate: token versions, bumped to sign a user out everywhere
+    DB.Exec(`ALTER TABLE users ADD COLUMN token_version INTEGER NOT NULL DEFAULT 0`)
+
+    // Auto-Migrate: email verification. Accounts from before it existed
+    // count as verified, so requiring it does not lock their owners out.
+    if _, err := DB.Exec(`ALTER TABLE users ADD COLUMN email_verified_at DATETIME`); err == nil {
+        DB.Exec(`UPDATE users SET email_verified_at = CURRENT_TIMESTAMP`)
+    }
+
     // Create password_resets table if not exists
     DB.Exec(`CREATE TABLE IF NOT EXISTS password_resets (
         email TEXT NOT NULL PRIMARY KEY,
         code TEXT NOT NULL,
+        attempts INTEGER NOT NULL DEFAULT 0,
         expires_at DATETIME NOT NULL,
         created_at DATETIME DEFAULT CURRENT_TIMESTAMP
     )`)
+
+    // Auto-Migrate: wrong guesses per reset code
+    DB.Exec(`ALTER TABLE password_resets ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0`)
     
 	return &Repository{
         DB: DB,
@@ -0,0 +1,96 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// maxVerificationAttempts is how many wrong codes void a verification code
+const maxVerificationAttempts = 5
+
+var (
+	// ErrInvalidVerificationCode is returned for wrong, expired or used up
+	// verification codes
+	ErrInvalidVerificationCode = errors.New("invalid verification code")
+	// ErrEmailAlreadyVerified is returned when verifying a verified address
+	ErrEmailAlreadyVerified = errors.New("email already verified")
+)
+
+// SaveVerificationCode replaces the user's email verification code
+func SaveVerificationCode(userID int, code string, expiresAt time.Time) error {
+	_, err := DB.Exec(`INSERT INTO email_verifications (user_id, code, expires_at, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET code = excluded.code, attempts = 0,
+			expires_at = excluded.expires_at, created_at = excluded.created_at`,
+		userID, code, expiresAt.UTC(), time.Now().UTC())
+	return err
+}
+
+// VerificationCodeSentAt returns when the user's current verification code
+// was created, the zero time without one
+func VerificationCodeSentAt(userID int) (time.Time, error) {
+	var sentAt time.Time
+	err := DB.QueryRow("SELECT created_at FROM email_verifications WHERE user_id = ?", userID).Scan(&sentAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	return sentAt, err
+}
+
+// VerifyEmailCode marks the address of the user with the email verified if
+// code is the user's verification code. Each wrong guess counts against the
+// code, which stops working after a few.
+func VerifyEmailCode(email, code string) (*User, error) {
+	user, err := GetUserByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrInvalidVerificationCode
+	}
+	if user.EmailVerified {
+		return user, ErrEmailAlreadyVerified
+	}
+
+	// Counting the guess and reading the code is one statement, so
+	// concurrent guesses cannot get past the limit
+	var storedCode string
+	var expiresAt time.Time
+	err = DB.QueryRow(`UPDATE email_verifications SET attempts = attempts + 1
+		WHERE user_id = ? AND attempts < ? RETURNING code, expires_at`,
+		user.ID, maxVerificationAttempts).Scan(&storedCode, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidVerificationCode
+	}
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(expiresAt) || storedCode != code {
+		return nil, ErrInvalidVerificationCode
+	}
+
+	if err := MarkEmailVerified(user.ID); err != nil {
+		return nil, err
+	}
+	user.EmailVerified = true
+	return user, nil
+}
+
+// MarkEmailVerified records that the user proved owning their address, e.g.
+// with a verification code or an identity provider vouching for it
+func MarkEmailVerified(userID int) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE users SET email_verified_at = ? WHERE id = ? AND email_verified_at IS NULL",
+		time.Now().UTC(), userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM email_verifications WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,86 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestVerifyEmailCode(t *testing.T) {
+	tests := []struct {
+		name    string
+		expires time.Duration
+		guesses []string
+		wantErr error
+	}{
+		{name: "right code", expires: time.Hour, guesses: []string{"ABC123"}},
+		{name: "after wrong guesses", expires: time.Hour, guesses: []string{"x", "y", "z", "w", "ABC123"}},
+		{name: "wrong code", expires: time.Hour, guesses: []string{"ABC124"}, wantErr: ErrInvalidVerificationCode},
+		{name: "too many wrong guesses", expires: time.Hour, guesses: []string{"x", "y", "z", "w", "v", "ABC123"}, wantErr: ErrInvalidVerificationCode},
+		{name: "expired", expires: -time.Minute, guesses: []string{"ABC123"}, wantErr: ErrInvalidVerificationCode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID := createTestUser(t)
+			user, err := GetUserByID(userID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.EmailVerified {
+				t.Fatal("new user is verified")
+			}
+			if err := SaveVerificationCode(userID, "ABC123", time.Now().Add(tt.expires)); err != nil {
+				t.Fatal(err)
+			}
+			var got *User
+			for _, code := range tt.guesses {
+				got, err = VerifyEmailCode(user.Email, code)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if got == nil || got.ID != userID || !got.EmailVerified {
+				t.Errorf("user = %+v, want verified user %d", got, userID)
+			}
+			if _, err := VerifyEmailCode(user.Email, "ABC123"); !errors.Is(err, ErrEmailAlreadyVerified) {
+				t.Errorf("second verification: err = %v, want ErrEmailAlreadyVerified", err)
+			}
+		})
+	}
+}
+
+func TestVerifyEmailCodeConcurrentGuesses(t *testing.T) {
+	userID := createTestUser(t)
+	user, err := GetUserByID(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveVerificationCode(userID, "ABC123", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 20)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			_, err := VerifyEmailCode(user.Email, "wrong")
+			errs <- err
+		}()
+	}
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; !errors.Is(err, ErrInvalidVerificationCode) {
+			t.Errorf("err = %v, want ErrInvalidVerificationCode", err)
+		}
+	}
+	var attempts int
+	if err := DB.QueryRow("SELECT attempts FROM email_verifications WHERE user_id = ?", userID).Scan(&attempts); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != maxVerificationAttempts {
+		t.Errorf("attempts = %d, want %d", attempts, maxVerificationAttempts)
+	}
+	if _, err := VerifyEmailCode(user.Email, "ABC123"); !errors.Is(err, ErrInvalidVerificationCode) {
+		t.Errorf("right code after the limit: err = %v, want ErrInvalidVerificationCode", err)
+	}
+}
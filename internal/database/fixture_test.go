@@ -0,0 +1,21 @@
+package database
+
+import "testing"
+
+// Set up by TestMain in main_test.go
+var (
+	testRepo *Repository
+	// createTestUser adds a user of its own for a test to sync into
+	createTestUser func(t *testing.T) int
+)
+
+// UseTestFixture hands the package's tests their database and a way to add
+// users to it
+func UseTestFixture(repo *Repository, newUser func(t *testing.T) int) {
+	testRepo = repo
+	createTestUser = newUser
+}
+
+func intPtr(v int) *int {
+	return &v
+}
@@ -1,6 +1,9 @@
 package database
 
-import "time"
+import (
+    "database/sql"
+    "time"
+)
 
 // GroupDeck represents a deck shared within a group
 type GroupDeck struct {
@@ -13,7 +16,8 @@ type GroupDeck struct {
     CreatedAt time.Time `json:"created_at"`
 }
 
-// CreateGroupDeck adds a deck to a group (now just metadata + R2 path)
+// CreateGroupDeck adds a deck to a group (now just metadata + R2 path). The
+// deck stays hidden until PublishGroupDeck.
 func CreateGroupDeck(groupID, uploaderID int, name string, cardCount int, r2Key string) (*GroupDeck, error) {
     query := `INSERT INTO group_decks (group_id, uploader_id, name, card_count, r2_key) VALUES (?, ?, ?, ?, ?)`
     result, err := DB.Exec(query, groupID, uploaderID, name, cardCount, r2Key)
@@ -33,9 +37,9 @@ func CreateGroupDeck(groupID, uploaderID int, name string, cardCount int, r2Key
     }, nil
 }
 
-// ListGroupDecks returns all decks shared in a group
+// ListGroupDecks returns all published decks shared in a group
 func ListGroupDecks(groupID int) ([]GroupDeck, error) {
-    query := `SELECT id, group_id, uploader_id, name, card_count, created_at FROM group_decks WHERE group_id = ? ORDER BY created_at DESC`
+    query := `SELECT id, group_id, uploader_id, name, card_count, created_at FROM group_decks WHERE group_id = ? AND published_at IS NOT NULL ORDER BY created_at DESC`
     
     rows, err := DB.Query(query, groupID)
     if err != nil {
@@ -55,9 +59,9 @@ func ListGroupDecks(groupID int) ([]GroupDeck, error) {
     return decks, nil
 }
 
-// GetGroupDeck returns a specific deck metadata (including R2 key for download link generation)
+// GetGroupDeck returns a specific published deck's metadata (including R2 key for download link generation)
 func GetGroupDeck(deckID int) (*GroupDeck, error) {
-    query := `SELECT id, group_id, uploader_id, name, card_count, r2_key, created_at FROM group_decks WHERE id = ?`
+    query := `SELECT id, group_id, uploader_id, name, card_count, r2_key, created_at FROM group_decks WHERE id = ? AND published_at IS NOT NULL`
     
     var d GroupDeck
     err := DB.QueryRow(query, deckID).Scan(&d.ID, &d.GroupID, &d.UploaderID, &d.Name, &d.CardCount, &d.R2Key, &d.CreatedAt)
@@ -67,3 +71,70 @@ func GetGroupDeck(deckID int) (*GroupDeck, error) {
     
     return &d, nil
 }
+
+// GetPendingGroupDeck returns a deck the uploader has not published yet
+func GetPendingGroupDeck(deckID, uploaderID int) (*GroupDeck, error) {
+    query := `SELECT id, group_id, uploader_id, name, card_count, r2_key, created_at FROM group_decks
+        WHERE id = ? AND uploader_id = ? AND published_at IS NULL`
+
+    var d GroupDeck
+    var r2Key sql.NullString
+    err := DB.QueryRow(query, deckID, uploaderID).Scan(&d.ID, &d.GroupID, &d.UploaderID, &d.Name, &d.CardCount, &r2Key, &d.CreatedAt)
+    if err != nil {
+        return nil, err
+    }
+    d.R2Key = r2Key.String
+    return &d, nil
+}
+
+// ListStaleGroupDecks returns the uploader's decks created before cutoff
+// that were never published
+func ListStaleGroupDecks(uploaderID int, cutoff time.Time) ([]GroupDeck, error) {
+    query := `SELECT id, group_id, uploader_id, name, card_count, r2_key, created_at FROM group_decks
+        WHERE uploader_id = ? AND published_at IS NULL AND created_at < datetime(?, 'unixepoch')`
+
+    rows, err := DB.Query(query, uploaderID, cutoff.Unix())
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var decks []GroupDeck
+    for rows.Next() {
+        var d GroupDeck
+        var r2Key sql.NullString
+        if err := rows.Scan(&d.ID, &d.GroupID, &d.UploaderID, &d.Name, &d.CardCount, &r2Key, &d.CreatedAt); err != nil {
+            return nil, err
+        }
+        d.R2Key = r2Key.String
+        decks = append(decks, d)
+    }
+    return decks, rows.Err()
+}
+
+// PublishGroupDeck makes a pending deck visible to the group, stored at
+// r2Key with the measured size. It returns sql.ErrNoRows when the deck is
+// not pending.
+func PublishGroupDeck(deckID int, r2Key string, size int64) error {
+    result, err := DB.Exec(`UPDATE group_decks SET r2_key = ?, size = ?, published_at = CURRENT_TIMESTAMP
+        WHERE id = ? AND published_at IS NULL`, r2Key, size, deckID)
+    if err != nil {
+        return err
+    }
+    if n, _ := result.RowsAffected(); n == 0 {
+        return sql.ErrNoRows
+    }
+    return nil
+}
+
+// DeleteGroupDeck removes a deck record
+func DeleteGroupDeck(deckID int) error {
+    _, err := DB.Exec(`DELETE FROM group_decks WHERE id = ?`, deckID)
+    return err
+}
+
+// GetUploadedDeckBytes returns the measured size of all decks a user uploaded
+func GetUploadedDeckBytes(uploaderID int) (bytes int64, decks int, err error) {
+    err = DB.QueryRow(`SELECT COALESCE(SUM(size), 0), COUNT(*) FROM group_decks WHERE uploader_id = ?`, uploaderID).Scan(&bytes, &decks)
+    return bytes, decks, err
+}
@@ -0,0 +1,55 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGroupDeckPublishing(t *testing.T) {
+	userID := createTestUser(t)
+	group, err := CreateGroup("Group", "", "", "", userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deck, err := CreateGroupDeck(group.ID, userID, "Deck", 10, "groups/1/uploads/a.apkg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Pending decks are hidden from the group
+	if decks, err := ListGroupDecks(group.ID); err != nil || len(decks) != 0 {
+		t.Errorf("ListGroupDecks = %v, %v, want none", decks, err)
+	}
+	if _, err := GetGroupDeck(deck.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetGroupDeck of a pending deck: err = %v", err)
+	}
+	if _, err := GetPendingGroupDeck(deck.ID, userID+1); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetPendingGroupDeck by another user: err = %v", err)
+	}
+	stale, err := ListStaleGroupDecks(userID, time.Now().Add(time.Minute))
+	if err != nil || len(stale) != 1 {
+		t.Errorf("ListStaleGroupDecks = %v, %v, want the pending deck", stale, err)
+	}
+
+	if err := PublishGroupDeck(deck.ID, "groups/1/decks/a.apkg", 1234); err != nil {
+		t.Fatal(err)
+	}
+	got, err := GetGroupDeck(deck.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.R2Key != "groups/1/decks/a.apkg" {
+		t.Errorf("published key = %q", got.R2Key)
+	}
+	if bytes, _, err := GetUploadedDeckBytes(userID); err != nil || bytes != 1234 {
+		t.Errorf("uploaded deck bytes = %d, %v, want 1234", bytes, err)
+	}
+	if err := PublishGroupDeck(deck.ID, "other", 1); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("publishing twice: err = %v, want sql.ErrNoRows", err)
+	}
+	if stale, err := ListStaleGroupDecks(userID, time.Now().Add(time.Minute)); err != nil || len(stale) != 0 {
+		t.Errorf("ListStaleGroupDecks after publishing = %v, %v", stale, err)
+	}
+}
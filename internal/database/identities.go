@@ -0,0 +1,168 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrIdentityNotFound is returned for provider accounts linked to no user
+	ErrIdentityNotFound = errors.New("identity not found")
+	// ErrIdentityTaken is returned when linking a provider account that is
+	// linked to another user
+	ErrIdentityTaken = errors.New("identity linked to another user")
+	// ErrProviderLinked is returned when linking a second account of a
+	// provider the user already has one of
+	ErrProviderLinked = errors.New("provider already linked")
+	// ErrLastSignInMethod is returned when unlinking the only way a user
+	// without a password can sign in
+	ErrLastSignInMethod = errors.New("last sign-in method")
+)
+
+// Identity is a provider account (Sign in with Apple, Google) linked to a user
+type Identity struct {
+	Provider  string    `json:"provider"`
+	Email     string    `json:"email,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// GetIdentityUser returns the user the provider account is linked to
+func GetIdentityUser(provider, subject string) (*User, error) {
+	var userID int
+	err := DB.QueryRow("SELECT user_id FROM user_identities WHERE provider = ? AND subject = ?",
+		provider, subject).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrIdentityNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	user, err := GetUserByID(userID)
+	if err == nil && user == nil {
+		return nil, ErrIdentityNotFound
+	}
+	return user, err
+}
+
+// LinkIdentity links a provider account to the user. Linking an account
+// already linked to the same user succeeds.
+func LinkIdentity(userID int, provider, subject, email string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := linkIdentity(tx, userID, provider, subject, email); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func linkIdentity(tx *sql.Tx, userID int, provider, subject, email string) error {
+	var owner int
+	err := tx.QueryRow("SELECT user_id FROM user_identities WHERE provider = ? AND subject = ?",
+		provider, subject).Scan(&owner)
+	if err == nil {
+		if owner != userID {
+			return ErrIdentityTaken
+		}
+		return nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	var linked int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM user_identities WHERE user_id = ? AND provider = ?",
+		userID, provider).Scan(&linked); err != nil {
+		return err
+	}
+	if linked > 0 {
+		return ErrProviderLinked
+	}
+	_, err = tx.Exec("INSERT INTO user_identities (provider, subject, user_id, email) VALUES (?, ?, ?, ?)",
+		provider, subject, userID, email)
+	return err
+}
+
+// CreateIdentityUser creates a user without a password who signs in with the
+// given provider account
+func CreateIdentityUser(email, username, provider, subject string) (*User, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`INSERT INTO users (email, password_hash, username) VALUES (?, '', ?)`, email, username)
+	if err != nil {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	if err := linkIdentity(tx, int(id), provider, subject, email); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return GetUserByID(int(id))
+}
+
+// ListIdentities returns the provider accounts linked to the user
+func ListIdentities(userID int) ([]Identity, error) {
+	rows, err := DB.Query("SELECT provider, email, created_at FROM user_identities WHERE user_id = ? ORDER BY provider", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []Identity{}
+	for rows.Next() {
+		var i Identity
+		if err := rows.Scan(&i.Provider, &i.Email, &i.CreatedAt); err != nil {
+			return nil, err
+		}
+		identities = append(identities, i)
+	}
+	return identities, rows.Err()
+}
+
+// UnlinkIdentity removes the user's account of the provider, unless the user
+// has no password and no other provider account to sign in with
+func UnlinkIdentity(userID int, provider string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var passwordHash string
+	var identities int
+	err = tx.QueryRow(`SELECT u.password_hash, (SELECT COUNT(*) FROM user_identities i WHERE i.user_id = u.id)
+		FROM users u WHERE u.id = ?`, userID).Scan(&passwordHash, &identities)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.Exec("DELETE FROM user_identities WHERE user_id = ? AND provider = ?", userID, provider)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrIdentityNotFound
+	}
+	if passwordHash == "" && identities <= 1 {
+		return ErrLastSignInMethod
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,18 @@
+package database_test
+
+import (
+	"testing"
+
+	"github.com/magnusohle/openanki-backend/internal/database"
+	"github.com/magnusohle/openanki-backend/internal/testutil"
+)
+
+// TestMain lives in the external test package, as internal/testutil imports
+// this one; the package's own tests get the database through UseTestFixture
+func TestMain(m *testing.M) {
+	testutil.Main(m, func(repo *database.Repository) {
+		database.UseTestFixture(repo, func(t *testing.T) int {
+			return testutil.CreateUser(t).ID
+		})
+	})
+}
@@ -0,0 +1,411 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"time"
+)
+
+// ErrMediaNotFound is returned for media files the user does not have
+var ErrMediaNotFound = errors.New("media not found")
+
+// ErrMediaBlobMissing is returned when a file refers to shared content that
+// is not (or no longer) stored
+var ErrMediaBlobMissing = errors.New("media blob not stored")
+
+// MediaChange is a media file added, changed or deleted at USN
+type MediaChange struct {
+	Filename string
+	USN      int
+	Hash     string
+	Size     int64
+	Deleted  bool
+	id       int64 // Row id, for paging
+}
+
+// MediaChangesPage is one chunk of a paginated media change listing
+type MediaChangesPage struct {
+	// ServerUSN bounds the whole listing, like PullPage.ServerUSN
+	ServerUSN int
+	Changes   []MediaChange
+	HasMore   bool
+	// Cursor resumes the listing after this page, empty when HasMore is false
+	Cursor string
+}
+
+// MediaFile is a stored media file
+type MediaFile struct {
+	Filename string
+	Hash     string
+	Size     int64
+	// Blob is the SHA-256 of the content, stored once for all users (see
+	// RegisterMediaBlob). Empty for files stored per user before that.
+	Blob string
+	// StoredAt is when the file was last stored; set by the repository
+	StoredAt time.Time
+}
+
+// GetMediaUSN returns the USN of the user's latest media change
+func (r *Repository) GetMediaUSN(userID int) (int, error) {
+	usn, err := r.Q.GetMediaUSN(context.Background(), int64(userID))
+	return int(usn), err
+}
+
+// CountMedia returns the number of media files of the user
+func (r *Repository) CountMedia(userID int) (int, error) {
+	n, err := r.Q.CountUserMedia(context.Background(), int64(userID))
+	return int(n), err
+}
+
+// ListMedia returns all media files of the user, sorted by name
+func (r *Repository) ListMedia(userID int) ([]MediaFile, error) {
+	rows, err := r.Q.ListUserMedia(context.Background(), int64(userID))
+	if err != nil {
+		return nil, err
+	}
+	files := make([]MediaFile, 0, len(rows))
+	for _, m := range rows {
+		files = append(files, MediaFile{
+			Filename: m.Filename,
+			Hash:     m.Hash,
+			Size:     m.Size,
+			Blob:     m.Blob,
+			StoredAt: time.Unix(m.Mtime, 0),
+		})
+	}
+	return files, nil
+}
+
+// GetMediaSince returns about limit media changes after sinceUSN, oldest
+// first. Changes sharing the last USN are never split across calls, as
+// clients resume from the highest USN they have seen.
+func (r *Repository) GetMediaSince(userID, sinceUSN, limit int) ([]MediaChange, error) {
+	ctx := context.Background()
+	uid := int64(userID)
+	b := sinceBounds(sinceUSN)
+	b.limit = int64(limit)
+	changes, err := mediaChanges(ctx, r.Q, uid, b, 1)
+	if err != nil || len(changes) < limit {
+		return changes, err
+	}
+
+	// Finish the last USN, e.g. the media of a large deck import
+	last := changes[len(changes)-1]
+	rest, err := mediaChanges(ctx, r.Q, uid, pageBounds{
+		afterUSN: int64(last.USN),
+		afterID:  last.id,
+		untilUSN: int64(last.USN),
+		limit:    -1,
+	}, last.kind())
+	return append(changes, rest...), err
+}
+
+// GetMediaChanges returns up to limit media additions and deletions after
+// since, continuing from cursor when it is non-empty. A limit <= 0 returns
+// everything in one page. Pages are bounded like those of PullChanges.
+func (r *Repository) GetMediaChanges(userID int, since int, cursor string, limit int) (*MediaChangesPage, error) {
+	ctx := context.Background()
+	uid := int64(userID)
+
+	serverUSN, err := r.Q.GetUSN(ctx, uid)
+	if errors.Is(err, sql.ErrNoRows) {
+		serverUSN = 0
+	} else if err != nil {
+		return nil, err
+	}
+
+	// The cursor's Stage holds the kind of its row, see MediaChange.kind
+	var cur pullCursor
+	if cursor != "" {
+		if cur, err = decodePullCursor(cursor); err != nil {
+			return nil, err
+		}
+		if int64(cur.Until) > serverUSN || cur.Stage > 1 {
+			return nil, ErrInvalidCursor
+		}
+	} else {
+		cur = pullCursor{Since: since, Until: int(serverUSN), Stage: 1, USN: int64(since), ID: math.MaxInt64}
+	}
+
+	b := pageBounds{afterUSN: cur.USN, afterID: cur.ID, untilUSN: int64(cur.Until), limit: -1}
+	if limit > 0 {
+		// One extra row tells whether there is another page
+		b.limit = int64(limit) + 1
+	}
+	changes, err := mediaChanges(ctx, r.Q, uid, b, int64(cur.Stage))
+	if err != nil {
+		return nil, err
+	}
+
+	page := &MediaChangesPage{ServerUSN: cur.Until, Changes: changes}
+	if limit > 0 && len(changes) > limit {
+		page.Changes = changes[:limit]
+		last := page.Changes[limit-1]
+		cur.Stage = int(last.kind())
+		cur.USN = int64(last.USN)
+		cur.ID = last.id
+		page.HasMore = true
+		page.Cursor = cur.encode()
+	}
+	return page, nil
+}
+
+// kind orders additions (0) before deletions (1) within a USN
+func (c MediaChange) kind() int64 {
+	if c.Deleted {
+		return 1
+	}
+	return 0
+}
+
+// mediaChanges reads media files and graves within b in (usn, kind, id)
+// order. Rows start after (b.afterUSN, afterKind, b.afterID).
+func mediaChanges(ctx context.Context, q *Queries, uid int64, b pageBounds, afterKind int64) ([]MediaChange, error) {
+	rows, err := q.GetMediaChanges(ctx, GetMediaChangesParams{
+		UserID:       uid,
+		AfterUsn:     b.afterUSN,
+		AfterDeleted: afterKind,
+		AfterID:      b.afterID,
+		UntilUsn:     b.untilUSN,
+		MaxRows:      b.limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	changes := make([]MediaChange, 0, len(rows))
+	for _, m := range rows {
+		changes = append(changes, MediaChange{
+			Filename: m.Filename,
+			USN:      int(m.Usn),
+			Hash:     m.Hash,
+			Size:     m.Size,
+			Deleted:  m.Deleted != 0,
+			id:       m.ID,
+		})
+	}
+	return changes, nil
+}
+
+// GetMediaFile returns one of the user's media files by name
+func (r *Repository) GetMediaFile(userID int, filename string) (*MediaFile, error) {
+	row, err := r.Q.GetMediaByFilename(context.Background(), GetMediaByFilenameParams{
+		UserID:   int64(userID),
+		Filename: filename,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrMediaNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &MediaFile{Filename: filename, Hash: row.Hash, Size: row.Size, Blob: row.Blob, StoredAt: time.Unix(row.Mtime, 0)}, nil
+}
+
+// GetMediaFileByHash returns the user's media file with the given content
+func (r *Repository) GetMediaFileByHash(userID int, hash string) (*MediaFile, error) {
+	row, err := r.Q.GetMediaByHash(context.Background(), GetMediaByHashParams{
+		UserID: int64(userID),
+		Hash:   hash,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrMediaNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &MediaFile{Filename: row.Filename, Hash: hash, Size: row.Size, Blob: row.Blob}, nil
+}
+
+// PutMedia records f and returns the new USN. A previous file of the same
+// name is replaced. A non-empty f.Blob must have been registered, otherwise
+// ErrMediaBlobMissing is returned.
+func (r *Repository) PutMedia(userID int, f MediaFile) (int, error) {
+	return r.changeMedia(userID, func(ctx context.Context, q *Queries, usn int64) error {
+		return putMedia(ctx, q, int64(userID), f, usn)
+	})
+}
+
+// putMedia records f at usn, replacing the file of the same name. Files are
+// unique by content, so another name with the same hash is replaced too and
+// gets a grave. The blobs of replaced files are released.
+func putMedia(ctx context.Context, q *Queries, uid int64, f MediaFile, usn int64) error {
+	same, err := q.ListMediaByHash(ctx, ListMediaByHashParams{UserID: uid, Hash: f.Hash})
+	if err != nil {
+		return err
+	}
+	var replaced []string
+	for _, m := range same {
+		replaced = append(replaced, m.Blob)
+		if m.Filename == f.Filename {
+			continue
+		}
+		err := q.InsertMediaGrave(ctx, InsertMediaGraveParams{UserID: uid, Filename: m.Filename, Hash: f.Hash, Usn: usn})
+		if err != nil {
+			return err
+		}
+	}
+	old, err := q.GetMediaByFilename(ctx, GetMediaByFilenameParams{UserID: uid, Filename: f.Filename})
+	if err == nil && old.Hash != f.Hash {
+		replaced = append(replaced, old.Blob)
+		if err := dropMediaVariants(ctx, q, uid, old.Hash); err != nil {
+			return err
+		}
+	} else if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	if err := q.DeleteMediaByFilename(ctx, DeleteMediaByFilenameParams{UserID: uid, Filename: f.Filename}); err != nil {
+		return err
+	}
+	if err := q.DeleteMediaGrave(ctx, DeleteMediaGraveParams{UserID: uid, Filename: f.Filename}); err != nil {
+		return err
+	}
+	for _, blob := range replaced {
+		if err := unrefMediaBlob(ctx, q, blob); err != nil {
+			return err
+		}
+	}
+	if err := refMediaBlob(ctx, q, f.Blob); err != nil {
+		return err
+	}
+	return q.UpsertMedia(ctx, UpsertMediaParams{
+		UserID:   uid,
+		Filename: f.Filename,
+		Hash:     f.Hash,
+		Size:     f.Size,
+		Usn:      usn,
+		Blob:     f.Blob,
+		Mtime:    time.Now().Unix(),
+	})
+}
+
+// ReserveMedia records a media upload the client is about to make. The file
+// becomes part of the user's media once ConfirmMedia is called.
+func (r *Repository) ReserveMedia(userID int, filename, hash string, size int64) error {
+	return r.Q.ReserveMedia(context.Background(), ReserveMediaParams{
+		UserID:   int64(userID),
+		Hash:     hash,
+		Filename: filename,
+		Size:     size,
+	})
+}
+
+// GetPendingMedia returns a reserved upload, or ErrMediaNotFound
+func (r *Repository) GetPendingMedia(userID int, hash string) (*MediaFile, error) {
+	row, err := r.Q.GetPendingMedia(context.Background(), GetPendingMediaParams{UserID: int64(userID), Hash: hash})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrMediaNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &MediaFile{Filename: row.Filename, Hash: hash, Size: row.Size}, nil
+}
+
+// ConfirmMedia moves a reserved upload of size bytes, stored as blob, to the
+// user's media and returns the new USN
+func (r *Repository) ConfirmMedia(userID int, hash string, size int64, blob string) (int, error) {
+	return r.changeMedia(userID, func(ctx context.Context, q *Queries, usn int64) error {
+		uid := int64(userID)
+		pending, err := q.GetPendingMedia(ctx, GetPendingMediaParams{UserID: uid, Hash: hash})
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrMediaNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if err := q.DeletePendingMedia(ctx, DeletePendingMediaParams{UserID: uid, Hash: hash}); err != nil {
+			return err
+		}
+		return putMedia(ctx, q, uid, MediaFile{Filename: pending.Filename, Hash: hash, Size: size, Blob: blob}, usn)
+	})
+}
+
+// DiscardPendingMedia drops a reserved upload
+func (r *Repository) DiscardPendingMedia(userID int, hash string) error {
+	return r.Q.DeletePendingMedia(context.Background(), DeletePendingMediaParams{UserID: int64(userID), Hash: hash})
+}
+
+// HasMedia reports whether the user has a media file with the given content
+func (r *Repository) HasMedia(userID int, hash string) (bool, error) {
+	n, err := r.Q.CountMediaByHash(context.Background(), CountMediaByHashParams{UserID: int64(userID), Hash: hash})
+	return n > 0, err
+}
+
+// DeleteMedia removes filename from the user's media, leaving a grave, and
+// returns the new USN
+func (r *Repository) DeleteMedia(userID int, filename string) (int, error) {
+	return r.changeMedia(userID, func(ctx context.Context, q *Queries, usn int64) error {
+		return deleteMedia(ctx, q, int64(userID), filename, usn)
+	})
+}
+
+// DeleteMediaByHash removes the user's media files with the given content and
+// returns the new USN, or ErrMediaNotFound
+func (r *Repository) DeleteMediaByHash(userID int, hash string) (int, error) {
+	return r.changeMedia(userID, func(ctx context.Context, q *Queries, usn int64) error {
+		uid := int64(userID)
+		files, err := q.ListMediaByHash(ctx, ListMediaByHashParams{UserID: uid, Hash: hash})
+		if err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			return ErrMediaNotFound
+		}
+		for _, f := range files {
+			if err := deleteMedia(ctx, q, uid, f.Filename, usn); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func deleteMedia(ctx context.Context, q *Queries, uid int64, filename string, usn int64) error {
+	old, err := q.GetMediaByFilename(ctx, GetMediaByFilenameParams{UserID: uid, Filename: filename})
+	if errors.Is(err, sql.ErrNoRows) {
+		// Nothing to delete; other devices never saw the file
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := q.DeleteMediaByFilename(ctx, DeleteMediaByFilenameParams{UserID: uid, Filename: filename}); err != nil {
+		return err
+	}
+	if err := unrefMediaBlob(ctx, q, old.Blob); err != nil {
+		return err
+	}
+	if err := dropMediaVariants(ctx, q, uid, old.Hash); err != nil {
+		return err
+	}
+	return q.InsertMediaGrave(ctx, InsertMediaGraveParams{UserID: uid, Filename: filename, Hash: old.Hash, Usn: usn})
+}
+
+// changeMedia runs change with a freshly allocated USN in one transaction
+func (r *Repository) changeMedia(userID int, change func(ctx context.Context, q *Queries, usn int64) error) (int, error) {
+	ctx := context.Background()
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	qtx := r.Q.WithTx(tx)
+
+	if err := qtx.CreateSyncMeta(ctx, int64(userID)); err != nil {
+		return 0, err
+	}
+	usn, err := qtx.UpdateUSN(ctx, int64(userID))
+	if err != nil {
+		return 0, err
+	}
+	if err := change(ctx, qtx, usn); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return int(usn), nil
+}
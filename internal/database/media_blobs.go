@@ -0,0 +1,111 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Media content is stored once per SHA-256 and shared by every user with a
+// file of that content. media_blobs counts the files using each blob; the
+// media GC deletes blobs nobody has used for its grace period.
+
+// SharedMedia is media content stored once for all users
+type SharedMedia struct {
+	SHA256 string
+	Size   int64
+}
+
+// FindMediaBlob returns the stored blob of the given SHA-256, or
+// ErrMediaBlobMissing. A blob without files is kept from the GC for another
+// grace period, so it can be used right away. Only look up hashes the server
+// computed itself: blobs are shared by all users.
+func (r *Repository) FindMediaBlob(sha256 string) (*SharedMedia, error) {
+	row, err := r.Q.FindMediaBlob(context.Background(), sha256)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrMediaBlobMissing
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &SharedMedia{SHA256: row.Sha256, Size: row.Size}, nil
+}
+
+// RegisterMediaBlob records content just written to storage. It stays
+// unreferenced until a media file uses it.
+func (r *Repository) RegisterMediaBlob(sha256, sha1 string, size int64) error {
+	return r.Q.InsertMediaBlob(context.Background(), InsertMediaBlobParams{Sha256: sha256, Sha1: sha1, Size: size})
+}
+
+// AttachMediaBlob points the user's file with the given hash, stored before
+// deduplication, at blob
+func (r *Repository) AttachMediaBlob(userID int, hash, blob string) error {
+	ctx := context.Background()
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	qtx := r.Q.WithTx(tx)
+
+	_, err = qtx.SetMediaBlob(ctx, SetMediaBlobParams{Blob: blob, UserID: int64(userID), Hash: hash})
+	if errors.Is(err, sql.ErrNoRows) {
+		// Deleted or already attached meanwhile
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := refMediaBlob(ctx, qtx, blob); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListReleasedMediaBlobs returns the blobs no file has used since cutoff
+func (r *Repository) ListReleasedMediaBlobs(cutoff time.Time) ([]SharedMedia, error) {
+	rows, err := r.Q.ListReleasedMediaBlobs(context.Background(), cutoff.Unix())
+	if err != nil {
+		return nil, err
+	}
+	blobs := make([]SharedMedia, 0, len(rows))
+	for _, b := range rows {
+		blobs = append(blobs, SharedMedia{SHA256: b.Sha256, Size: b.Size})
+	}
+	return blobs, nil
+}
+
+// DeleteReleasedMediaBlob forgets a blob unless a file started using it
+// since cutoff, and reports whether it did. The stored object must be deleted
+// afterwards.
+func (r *Repository) DeleteReleasedMediaBlob(sha256 string, cutoff time.Time) (bool, error) {
+	_, err := r.Q.DeleteReleasedMediaBlob(context.Background(), DeleteReleasedMediaBlobParams{
+		Sha256: sha256,
+		Cutoff: cutoff.Unix(),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// refMediaBlob counts a new file using blob
+func refMediaBlob(ctx context.Context, q *Queries, blob string) error {
+	if blob == "" {
+		return nil
+	}
+	_, err := q.AcquireMediaBlob(ctx, blob)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrMediaBlobMissing
+	}
+	return err
+}
+
+// unrefMediaBlob uncounts a file that stopped using blob
+func unrefMediaBlob(ctx context.Context, q *Queries, blob string) error {
+	if blob == "" {
+		return nil
+	}
+	return q.ReleaseMediaBlob(ctx, blob)
+}
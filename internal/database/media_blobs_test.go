@@ -0,0 +1,20 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFindMediaBlobBySHA256Only(t *testing.T) {
+	const sha256, sha1 = "5ab9ee3a0f8e8f0b4e5c3f7e86cbb6ba1f35a0b1a2c3d4e5f60718293a4b5c6d", "da39a3ee5e6b4b0d3255bfef95601890afd80709"
+	if err := testRepo.RegisterMediaBlob(sha256, sha1, 42); err != nil {
+		t.Fatal(err)
+	}
+	blob, err := testRepo.FindMediaBlob(sha256)
+	if err != nil || blob.Size != 42 {
+		t.Fatalf("FindMediaBlob(sha256) = %+v, %v", blob, err)
+	}
+	if _, err := testRepo.FindMediaBlob(sha1); !errors.Is(err, ErrMediaBlobMissing) {
+		t.Errorf("FindMediaBlob(sha1): err = %v, want ErrMediaBlobMissing", err)
+	}
+}
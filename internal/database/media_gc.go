@@ -0,0 +1,83 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ListMediaUsers returns the ids of users that have media files
+func (r *Repository) ListMediaUsers() ([]int, error) {
+	ids, err := r.Q.ListMediaUsers(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	users := make([]int, len(ids))
+	for i, id := range ids {
+		users[i] = int(id)
+	}
+	return users, nil
+}
+
+// ListNoteFields returns the flds column of each of the user's notes
+func (r *Repository) ListNoteFields(userID int) ([]string, error) {
+	return r.Q.ListUserNoteFields(context.Background(), int64(userID))
+}
+
+// ListPendingMedia returns the hashes of uploads the user reserved after since
+func (r *Repository) ListPendingMedia(userID int, since time.Time) ([]string, error) {
+	return r.Q.ListUserPendingMedia(context.Background(), ListUserPendingMediaParams{
+		UserID: int64(userID),
+		Cutoff: since.Unix(),
+	})
+}
+
+// ExpirePendingMedia drops upload reservations made before cutoff that were
+// never confirmed
+func (r *Repository) ExpirePendingMedia(cutoff time.Time) error {
+	return r.Q.DeleteExpiredPendingMedia(context.Background(), cutoff.Unix())
+}
+
+// DeleteUnusedMedia removes the user's files among filenames that were last
+// stored before cutoff and that no note uses, leaving graves so devices
+// delete them too, and returns the files removed. used maps the flds of the
+// user's notes to the filenames they reference; the notes are read again in
+// the same transaction, so a note synced since the caller's scan keeps its
+// files. Nothing is removed while the user has no notes, e.g. before their
+// collection is uploaded.
+func (r *Repository) DeleteUnusedMedia(userID int, filenames []string, cutoff time.Time, used func(fields []string) map[string]bool) ([]MediaFile, error) {
+	var removed []MediaFile
+	_, err := r.changeMedia(userID, func(ctx context.Context, q *Queries, usn int64) error {
+		uid := int64(userID)
+		fields, err := q.ListUserNoteFields(ctx, uid)
+		if err != nil || len(fields) == 0 {
+			return err
+		}
+		refs := used(fields)
+		for _, name := range filenames {
+			if refs[name] {
+				continue
+			}
+			f, err := q.GetMediaByFilename(ctx, GetMediaByFilenameParams{UserID: uid, Filename: name})
+			if errors.Is(err, sql.ErrNoRows) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if f.Mtime >= cutoff.Unix() {
+				continue
+			}
+			if err := deleteMedia(ctx, q, uid, name, usn); err != nil {
+				return err
+			}
+			removed = append(removed, MediaFile{Filename: name, Hash: f.Hash, Size: f.Size, Blob: f.Blob, StoredAt: time.Unix(f.Mtime, 0)})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
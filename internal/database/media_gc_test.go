@@ -0,0 +1,80 @@
+package database
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// refsIn is a crude stand-in for anki.MediaReferences
+func refsIn(fields []string) map[string]bool {
+	refs := map[string]bool{}
+	for _, flds := range fields {
+		for _, name := range []string{"used.png", "late.png"} {
+			if strings.Contains(flds, name) {
+				refs[name] = true
+			}
+		}
+	}
+	return refs
+}
+
+func TestDeleteUnusedMedia(t *testing.T) {
+	userID := createTestUser(t)
+	for _, name := range []string{"used.png", "unused.png", "late.png", "recent.png"} {
+		if _, err := testRepo.PutMedia(userID, MediaFile{Filename: name, Hash: "h-" + name, Size: 10}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-48 * time.Hour).Unix()
+	if _, err := testRepo.DB.Exec(`UPDATE user_media SET mtime = ? WHERE user_id = ? AND filename != 'recent.png'`, old, userID); err != nil {
+		t.Fatal(err)
+	}
+	cutoff := time.Now().Add(-24 * time.Hour)
+	candidates := []string{"unused.png", "late.png", "recent.png", "gone.png"}
+
+	// Without notes nothing is removed
+	removed, err := testRepo.DeleteUnusedMedia(userID, candidates, cutoff, refsIn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 0 {
+		t.Errorf("removed %v from a user without notes", removed)
+	}
+
+	// late.png is referenced by a note synced after the caller's scan
+	payload := &SyncPayload{Notes: []SyncNote{{ID: 1, GUID: "a", Flds: `<img src="used.png">`}, {ID: 2, GUID: "b", Flds: `<img src="late.png">`}}}
+	if _, err := testRepo.PushSyncSafe(userID, payload, ConflictLastWriteWins); err != nil {
+		t.Fatal(err)
+	}
+	usn, err := testRepo.GetMediaUSN(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed, err = testRepo.DeleteUnusedMedia(userID, candidates, cutoff, refsIn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0].Filename != "unused.png" {
+		t.Fatalf("removed %+v, want unused.png only", removed)
+	}
+
+	files, err := testRepo.ListMedia(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Filename)
+	}
+	if strings.Join(names, ",") != "late.png,recent.png,used.png" {
+		t.Errorf("files left = %v", names)
+	}
+	changes, err := testRepo.GetMediaSince(userID, usn, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Filename != "unused.png" || !changes[0].Deleted {
+		t.Errorf("media changes = %+v, want the deletion of unused.png", changes)
+	}
+}
@@ -0,0 +1,103 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// MediaVariant is a smaller encoding of a media file, e.g. a WebP version of
+// a PNG, stored as a shared blob
+type MediaVariant struct {
+	ContentType string
+	Blob        string
+	Size        int64
+}
+
+// ListMediaVariants returns the variants of the user's file with the given
+// content, smallest first
+func (r *Repository) ListMediaVariants(userID int, hash string) ([]MediaVariant, error) {
+	rows, err := r.Q.ListMediaVariants(context.Background(), ListMediaVariantsParams{UserID: int64(userID), Hash: hash})
+	if err != nil {
+		return nil, err
+	}
+	variants := make([]MediaVariant, 0, len(rows))
+	for _, v := range rows {
+		variants = append(variants, MediaVariant{ContentType: v.ContentType, Blob: v.Blob, Size: v.Size})
+	}
+	return variants, nil
+}
+
+// FindVariantsBySource returns variants made of the blob for any user
+func (r *Repository) FindVariantsBySource(blob string) ([]MediaVariant, error) {
+	rows, err := r.Q.ListVariantsBySource(context.Background(), blob)
+	if err != nil {
+		return nil, err
+	}
+	variants := make([]MediaVariant, 0, len(rows))
+	for _, v := range rows {
+		variants = append(variants, MediaVariant{ContentType: v.ContentType, Blob: v.Blob, Size: v.Size})
+	}
+	return variants, nil
+}
+
+// AddMediaVariant records v, made of source, for the user's file with the
+// given content. It returns ErrMediaNotFound when the file went away and
+// ignores content types the file already has a variant of.
+func (r *Repository) AddMediaVariant(userID int, hash, source string, v MediaVariant) error {
+	ctx := context.Background()
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	qtx := r.Q.WithTx(tx)
+	uid := int64(userID)
+
+	_, err = qtx.GetMediaByHash(ctx, GetMediaByHashParams{UserID: uid, Hash: hash})
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrMediaNotFound
+	}
+	if err != nil {
+		return err
+	}
+	existing, err := qtx.ListMediaVariants(ctx, ListMediaVariantsParams{UserID: uid, Hash: hash})
+	if err != nil {
+		return err
+	}
+	for _, e := range existing {
+		if e.ContentType == v.ContentType {
+			return nil
+		}
+	}
+
+	if err := refMediaBlob(ctx, qtx, v.Blob); err != nil {
+		return err
+	}
+	err = qtx.InsertMediaVariant(ctx, InsertMediaVariantParams{
+		UserID:      uid,
+		Hash:        hash,
+		ContentType: v.ContentType,
+		Blob:        v.Blob,
+		Size:        v.Size,
+		Source:      source,
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// dropMediaVariants removes the variants of content the user no longer has
+func dropMediaVariants(ctx context.Context, q *Queries, uid int64, hash string) error {
+	variants, err := q.ListMediaVariants(ctx, ListMediaVariantsParams{UserID: uid, Hash: hash})
+	if err != nil {
+		return err
+	}
+	for _, v := range variants {
+		if err := unrefMediaBlob(ctx, q, v.Blob); err != nil {
+			return err
+		}
+	}
+	return q.DeleteMediaVariants(ctx, DeleteMediaVariantsParams{UserID: uid, Hash: hash})
+}
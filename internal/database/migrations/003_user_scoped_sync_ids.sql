@@ -0,0 +1,102 @@
+-- Namespace synced ids per user: user_decks, user_notes and user_cards are
+-- keyed by (user_id, id) instead of the client-supplied id alone.
+-- Applied automatically by Repository.InitSyncSchema (migrateUserScopedIDs),
+-- which rebuilds each table still keyed by id alone, one transaction per table.
+-- To run it by hand, user_cards must already have the stability and
+-- difficulty columns, which InitSyncSchema adds before migrating:
+--   ALTER TABLE user_cards ADD COLUMN stability REAL DEFAULT 0;
+--   ALTER TABLE user_cards ADD COLUMN difficulty REAL DEFAULT 0;
+
+-- user_decks
+ALTER TABLE user_decks RENAME TO user_decks_legacy;
+
+CREATE TABLE user_decks (
+    id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    description TEXT,
+    config_id INTEGER DEFAULT 1,
+    created_at INTEGER NOT NULL,
+    modified_at INTEGER NOT NULL,
+    usn INTEGER NOT NULL,
+    PRIMARY KEY (user_id, id),
+    FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+INSERT INTO user_decks (id, user_id, name, description, config_id, created_at, modified_at, usn)
+SELECT id, user_id, name, description, config_id, created_at, modified_at, usn FROM user_decks_legacy;
+
+DROP TABLE user_decks_legacy;
+
+CREATE INDEX IF NOT EXISTS idx_user_decks_user ON user_decks(user_id);
+CREATE INDEX IF NOT EXISTS idx_user_decks_usn ON user_decks(user_id, usn);
+
+-- user_notes
+ALTER TABLE user_notes RENAME TO user_notes_legacy;
+
+CREATE TABLE user_notes (
+    id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    guid TEXT NOT NULL,
+    mid INTEGER NOT NULL,
+    mod INTEGER NOT NULL,
+    usn INTEGER NOT NULL,
+    tags TEXT NOT NULL DEFAULT '',
+    flds TEXT NOT NULL,
+    sfld TEXT NOT NULL DEFAULT '',
+    csum INTEGER NOT NULL DEFAULT 0,
+    flags INTEGER NOT NULL DEFAULT 0,
+    data TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (user_id, id),
+    FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+INSERT INTO user_notes (id, user_id, guid, mid, mod, usn, tags, flds, sfld, csum, flags, data)
+SELECT id, user_id, guid, mid, mod, usn, tags, flds, sfld, csum, flags, data FROM user_notes_legacy;
+
+DROP TABLE user_notes_legacy;
+
+CREATE INDEX IF NOT EXISTS idx_user_notes_user ON user_notes(user_id);
+CREATE INDEX IF NOT EXISTS idx_user_notes_usn ON user_notes(user_id, usn);
+
+-- user_cards
+ALTER TABLE user_cards RENAME TO user_cards_legacy;
+
+CREATE TABLE user_cards (
+    id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    note_id INTEGER NOT NULL,
+    deck_id INTEGER NOT NULL,
+    ordinal INTEGER NOT NULL DEFAULT 0,
+    modified_at INTEGER NOT NULL,
+    usn INTEGER NOT NULL,
+    state INTEGER NOT NULL DEFAULT 0,
+    queue INTEGER NOT NULL DEFAULT 0,
+    due INTEGER NOT NULL DEFAULT 0,
+    interval INTEGER NOT NULL DEFAULT 0,
+    ease_factor INTEGER NOT NULL DEFAULT 2500,
+    reps INTEGER NOT NULL DEFAULT 0,
+    lapses INTEGER NOT NULL DEFAULT 0,
+    left_count INTEGER NOT NULL DEFAULT 0,
+    original_due INTEGER NOT NULL DEFAULT 0,
+    original_deck_id INTEGER NOT NULL DEFAULT 0,
+    flags INTEGER NOT NULL DEFAULT 0,
+    data TEXT NOT NULL DEFAULT '',
+    stability REAL DEFAULT 0,
+    difficulty REAL DEFAULT 0,
+    PRIMARY KEY (user_id, id),
+    FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+INSERT INTO user_cards (id, user_id, note_id, deck_id, ordinal, modified_at, usn, state, queue, due,
+    interval, ease_factor, reps, lapses, left_count, original_due, original_deck_id, flags, data,
+    stability, difficulty)
+SELECT id, user_id, note_id, deck_id, ordinal, modified_at, usn, state, queue, due,
+    interval, ease_factor, reps, lapses, left_count, original_due, original_deck_id, flags, data,
+    stability, difficulty
+FROM user_cards_legacy;
+
+DROP TABLE user_cards_legacy;
+
+CREATE INDEX IF NOT EXISTS idx_user_cards_user ON user_cards(user_id);
+CREATE INDEX IF NOT EXISTS idx_user_cards_usn ON user_cards(user_id, usn);
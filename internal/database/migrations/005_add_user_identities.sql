@@ -0,0 +1,13 @@
+-- Sign in with Apple / Google: provider accounts linked to users, at most
+-- one per provider and user. Applied automatically by InitDB (schema.sql).
+CREATE TABLE IF NOT EXISTS user_identities (
+    provider TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    user_id INTEGER NOT NULL,
+    email TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (provider, subject),
+    FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_identities_user ON user_identities(user_id, provider);
@@ -0,0 +1,21 @@
+-- Brute-force protection: attempt counters with lockouts, an audit log of
+-- lockouts, and wrong guesses per reset code. Applied automatically by InitDB.
+ALTER TABLE password_resets ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS auth_throttles (
+    key TEXT PRIMARY KEY,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_attempt_at DATETIME NOT NULL,
+    locked_until DATETIME
+);
+CREATE INDEX IF NOT EXISTS idx_auth_throttles_last_attempt ON auth_throttles(last_attempt_at);
+
+CREATE TABLE IF NOT EXISTS auth_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    event TEXT NOT NULL,
+    email TEXT,
+    ip TEXT,
+    detail TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_auth_events_created ON auth_events(created_at);
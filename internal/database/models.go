@@ -6,8 +6,37 @@ package database
 
 import (
 	"database/sql"
+	"time"
 )
 
+type AnkiHostKey struct {
+	Key        string    `json:"key"`
+	UserID     int64     `json:"user_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt int64     `json:"last_used_at"`
+}
+
+type MediaBlob struct {
+	Sha256     string       `json:"sha256"`
+	Sha1       string       `json:"sha1"`
+	Size       int64        `json:"size"`
+	Refs       int64        `json:"refs"`
+	CreatedAt  time.Time    `json:"created_at"`
+	ReleasedAt sql.NullTime `json:"released_at"`
+}
+
+type SyncUpload struct {
+	ID        string    `json:"id"`
+	UserID    int64     `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type SyncUploadChunk struct {
+	UploadID string `json:"upload_id"`
+	Seq      int64  `json:"seq"`
+	Payload  []byte `json:"payload"`
+}
+
 type UserCard struct {
 	ID             int64  `json:"id"`
 	UserID         int64  `json:"user_id"`
@@ -36,6 +65,10 @@ type UserCollection struct {
 	UserID   int64        `json:"user_id"`
 	Usn      int64        `json:"usn"`
 	LastSync sql.NullTime `json:"last_sync"`
+	Mod      int64        `json:"mod"`
+	Scm      int64        `json:"scm"`
+	Crt      int64        `json:"crt"`
+	Resets   int64        `json:"resets"`
 }
 
 type UserDeck struct {
@@ -49,6 +82,15 @@ type UserDeck struct {
 	Usn         int64          `json:"usn"`
 }
 
+type UserDeckConfig struct {
+	ID     int64  `json:"id"`
+	UserID int64  `json:"user_id"`
+	Name   string `json:"name"`
+	Mod    int64  `json:"mod"`
+	Usn    int64  `json:"usn"`
+	Config string `json:"config"`
+}
+
 type UserGrafe struct {
 	ID     int64 `json:"id"`
 	UserID int64 `json:"user_id"`
@@ -64,6 +106,33 @@ type UserMedium struct {
 	Hash     string `json:"hash"`
 	Size     int64  `json:"size"`
 	Usn      int64  `json:"usn"`
+	Blob     string `json:"blob"`
+	Mtime    int64  `json:"mtime"`
+}
+
+type UserMediaGrafe struct {
+	ID       int64  `json:"id"`
+	UserID   int64  `json:"user_id"`
+	Filename string `json:"filename"`
+	Hash     string `json:"hash"`
+	Usn      int64  `json:"usn"`
+}
+
+type UserMediaPending struct {
+	UserID    int64     `json:"user_id"`
+	Hash      string    `json:"hash"`
+	Filename  string    `json:"filename"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type UserMediaVariant struct {
+	UserID      int64  `json:"user_id"`
+	Hash        string `json:"hash"`
+	ContentType string `json:"content_type"`
+	Blob        string `json:"blob"`
+	Size        int64  `json:"size"`
+	Source      string `json:"source"`
 }
 
 type UserNote struct {
@@ -80,3 +149,30 @@ type UserNote struct {
 	Flags  int64  `json:"flags"`
 	Data   string `json:"data"`
 }
+
+type UserNotetype struct {
+	ID        int64  `json:"id"`
+	UserID    int64  `json:"user_id"`
+	Name      string `json:"name"`
+	Type      int64  `json:"type"`
+	Mod       int64  `json:"mod"`
+	Usn       int64  `json:"usn"`
+	SortField int64  `json:"sort_field"`
+	Fields    string `json:"fields"`
+	Templates string `json:"templates"`
+	Css       string `json:"css"`
+	Data      string `json:"data"`
+}
+
+type UserRevlog struct {
+	ID      int64 `json:"id"`
+	UserID  int64 `json:"user_id"`
+	Cid     int64 `json:"cid"`
+	Usn     int64 `json:"usn"`
+	Ease    int64 `json:"ease"`
+	Ivl     int64 `json:"ivl"`
+	LastIvl int64 `json:"last_ivl"`
+	Factor  int64 `json:"factor"`
+	Time    int64 `json:"time"`
+	Type    int64 `json:"type"`
+}
@@ -12,35 +12,41 @@ func SaveResetCode(email, code string) error {
 		return err
 	}
 
-	expiresAt := time.Now().Add(10 * time.Minute)
+	expiresAt := time.Now().UTC().Add(10 * time.Minute)
 	_, err = DB.Exec("INSERT INTO password_resets (email, code, expires_at) VALUES (?, ?, ?)", email, code, expiresAt)
 	return err
 }
 
-// VerifyAndConsumeResetCode checks if the code is valid and not expired. 
-// If valid, it deletes the code (consumes it) and returns true.
-func VerifyAndConsumeResetCode(email, code string) bool {
-	var expiresAt time.Time
-	var storedCode string
+// maxResetAttempts is how many wrong guesses invalidate a reset code
+const maxResetAttempts = 5
 
-	err := DB.QueryRow("SELECT code, expires_at FROM password_resets WHERE email = ?", email).Scan(&storedCode, &expiresAt)
+// VerifyAndConsumeResetCode checks if the code is valid and not expired.
+// If valid, it deletes the code (consumes it) and returns true.
+// Too many wrong guesses delete the code too. Each check is a single
+// conditional statement, so concurrent guesses cannot get past the limit and
+// a code is consumed only once.
+func VerifyAndConsumeResetCode(email, code string) (bool, error) {
+	now := time.Now().UTC()
+	res, err := DB.Exec(`DELETE FROM password_resets
+		WHERE email = ? AND code = ? AND attempts < ? AND expires_at > ?`,
+		email, code, maxResetAttempts, now)
 	if err != nil {
-		return false // Not found
+		return false, err
 	}
-
-	if time.Now().After(expiresAt) {
-		// Clean up expired
-		DB.Exec("DELETE FROM password_resets WHERE email = ?", email)
-		return false
+	if n, err := res.RowsAffected(); err != nil || n == 1 {
+		return n == 1, err
 	}
 
-	if storedCode != code {
-		return false // Wrong code
+	// Wrong, expired or used up: count the guess, then drop the code once it
+	// cannot be used any more
+	if _, err := DB.Exec(`UPDATE password_resets SET attempts = attempts + 1
+		WHERE email = ? AND attempts < ? AND expires_at > ?`,
+		email, maxResetAttempts, now); err != nil {
+		return false, err
 	}
-
-	// Consume the code so it cannot be reused
-	DB.Exec("DELETE FROM password_resets WHERE email = ?", email)
-	return true
+	_, err = DB.Exec(`DELETE FROM password_resets WHERE email = ? AND (attempts >= ? OR expires_at <= ?)`,
+		email, maxResetAttempts, now)
+	return false, err
 }
 
 // UpdateUserPassword updates the password hash for a user by email
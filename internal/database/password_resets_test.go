@@ -0,0 +1,99 @@
+package database
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+var testResets int
+
+// saveTestResetCode stores code for an address of its own
+func saveTestResetCode(t *testing.T, code string) string {
+	t.Helper()
+	testResets++
+	email := fmt.Sprintf("reset%d@example.com", testResets)
+	if err := SaveResetCode(email, code); err != nil {
+		t.Fatal(err)
+	}
+	return email
+}
+
+func TestVerifyAndConsumeResetCode(t *testing.T) {
+	tests := []struct {
+		name    string
+		expired bool
+		guesses []string
+		want    bool
+	}{
+		{name: "right code", guesses: []string{"ABC123"}, want: true},
+		{name: "after wrong guesses", guesses: []string{"x", "y", "z", "w", "ABC123"}, want: true},
+		{name: "wrong code", guesses: []string{"ABC124"}},
+		{name: "too many wrong guesses", guesses: []string{"x", "y", "z", "w", "v", "ABC123"}},
+		{name: "used twice", guesses: []string{"ABC123", "ABC123"}},
+		{name: "expired", expired: true, guesses: []string{"ABC123"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email := saveTestResetCode(t, "ABC123")
+			if tt.expired {
+				if _, err := DB.Exec("UPDATE password_resets SET expires_at = ? WHERE email = ?", time.Now().UTC().Add(-time.Minute), email); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var got bool
+			for _, code := range tt.guesses {
+				var err error
+				if got, err = VerifyAndConsumeResetCode(email, code); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got != tt.want {
+				t.Errorf("valid = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyAndConsumeResetCodeConcurrent(t *testing.T) {
+	tests := []struct {
+		name      string
+		code      string
+		wantValid int
+	}{
+		{"wrong guesses", "wrong", 0},
+		{"right code", "ABC123", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email := saveTestResetCode(t, "ABC123")
+			results := make(chan bool, 20)
+			for i := 0; i < cap(results); i++ {
+				go func() {
+					valid, err := VerifyAndConsumeResetCode(email, tt.code)
+					if err != nil {
+						t.Error(err)
+					}
+					results <- valid
+				}()
+			}
+			valid := 0
+			for i := 0; i < cap(results); i++ {
+				if <-results {
+					valid++
+				}
+			}
+			if valid != tt.wantValid {
+				t.Errorf("%d guesses succeeded, want %d", valid, tt.wantValid)
+			}
+			// Used up either way
+			var left int
+			if err := DB.QueryRow("SELECT COUNT(*) FROM password_resets WHERE email = ?", email).Scan(&left); err != nil {
+				t.Fatal(err)
+			}
+			if left != 0 {
+				t.Errorf("%d reset codes left", left)
+			}
+		})
+	}
+}
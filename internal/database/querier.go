@@ -9,27 +9,105 @@ import (
 )
 
 type Querier interface {
+	AcquireMediaBlob(ctx context.Context, sha256 string) (int64, error)
+	BumpCollectionSchema(ctx context.Context, userID int64) error
+	CountMediaByHash(ctx context.Context, arg CountMediaByHashParams) (int64, error)
+	CountOpenUploads(ctx context.Context, userID int64) (int64, error)
+	CountUploadChunksFrom(ctx context.Context, arg CountUploadChunksFromParams) (int64, error)
+	CountUserCards(ctx context.Context, userID int64) (int64, error)
+	CountUserMedia(ctx context.Context, userID int64) (int64, error)
+	CountUserNotes(ctx context.Context, userID int64) (int64, error)
+	CreateHostKey(ctx context.Context, arg CreateHostKeyParams) error
 	CreateSyncMeta(ctx context.Context, userID int64) error
+	CreateUpload(ctx context.Context, arg CreateUploadParams) error
+	DeleteExpiredPendingMedia(ctx context.Context, cutoff int64) error
+	DeleteMediaByFilename(ctx context.Context, arg DeleteMediaByFilenameParams) error
+	DeleteMediaGrave(ctx context.Context, arg DeleteMediaGraveParams) error
+	DeleteMediaVariants(ctx context.Context, arg DeleteMediaVariantsParams) error
+	DeletePendingMedia(ctx context.Context, arg DeletePendingMediaParams) error
+	DeleteReleasedMediaBlob(ctx context.Context, arg DeleteReleasedMediaBlobParams) (string, error)
 	DeleteSpecificCard(ctx context.Context, arg DeleteSpecificCardParams) error
 	DeleteSpecificDeck(ctx context.Context, arg DeleteSpecificDeckParams) error
+	DeleteSpecificDeckConfig(ctx context.Context, arg DeleteSpecificDeckConfigParams) error
 	DeleteSpecificNote(ctx context.Context, arg DeleteSpecificNoteParams) error
+	DeleteSpecificNoteType(ctx context.Context, arg DeleteSpecificNoteTypeParams) error
+	DeleteStaleHostKeys(ctx context.Context, arg DeleteStaleHostKeysParams) error
+	DeleteStaleUploadChunks(ctx context.Context, userID int64) error
+	DeleteStaleUploads(ctx context.Context, userID int64) error
+	DeleteUpload(ctx context.Context, id string) error
+	DeleteUploadChunks(ctx context.Context, uploadID string) error
 	DeleteUserCards(ctx context.Context, userID int64) error
+	DeleteUserDeckConfigs(ctx context.Context, userID int64) error
 	DeleteUserDecks(ctx context.Context, userID int64) error
 	DeleteUserGraves(ctx context.Context, userID int64) error
+	DeleteUserHostKeys(ctx context.Context, userID int64) error
 	DeleteUserMedia(ctx context.Context, userID int64) error
+	DeleteUserMediaGraves(ctx context.Context, userID int64) error
+	DeleteUserMediaVariants(ctx context.Context, userID int64) error
 	DeleteUserNotes(ctx context.Context, userID int64) error
+	DeleteUserNoteTypes(ctx context.Context, userID int64) error
+	DeleteUserPendingMedia(ctx context.Context, userID int64) error
+	DeleteUserRevlog(ctx context.Context, userID int64) error
+	FindMediaBlob(ctx context.Context, sha256 string) (FindMediaBlobRow, error)
 	GetCardsSince(ctx context.Context, arg GetCardsSinceParams) ([]GetCardsSinceRow, error)
+	GetCardState(ctx context.Context, arg GetCardStateParams) (GetCardStateRow, error)
+	GetCollectionState(ctx context.Context, userID int64) (GetCollectionStateRow, error)
+	GetDeckConfigsSince(ctx context.Context, arg GetDeckConfigsSinceParams) ([]GetDeckConfigsSinceRow, error)
+	GetDeckConfigState(ctx context.Context, arg GetDeckConfigStateParams) (GetDeckConfigStateRow, error)
 	GetDecksSince(ctx context.Context, arg GetDecksSinceParams) ([]GetDecksSinceRow, error)
+	GetDeckState(ctx context.Context, arg GetDeckStateParams) (GetDeckStateRow, error)
 	GetGravesSince(ctx context.Context, arg GetGravesSinceParams) ([]GetGravesSinceRow, error)
+	GetGraveUsn(ctx context.Context, arg GetGraveUsnParams) (int64, error)
+	GetMaxNewCardDue(ctx context.Context, userID int64) (int64, error)
+	GetMediaByFilename(ctx context.Context, arg GetMediaByFilenameParams) (GetMediaByFilenameRow, error)
+	GetMediaByHash(ctx context.Context, arg GetMediaByHashParams) (GetMediaByHashRow, error)
+	GetMediaBytes(ctx context.Context, userID int64) (int64, error)
+	GetMediaChanges(ctx context.Context, arg GetMediaChangesParams) ([]GetMediaChangesRow, error)
+	GetMediaUSN(ctx context.Context, userID int64) (int64, error)
 	GetNotesSince(ctx context.Context, arg GetNotesSinceParams) ([]GetNotesSinceRow, error)
+	GetNoteState(ctx context.Context, arg GetNoteStateParams) (GetNoteStateRow, error)
+	GetNoteTypesSince(ctx context.Context, arg GetNoteTypesSinceParams) ([]GetNoteTypesSinceRow, error)
+	GetNoteTypeState(ctx context.Context, arg GetNoteTypeStateParams) (GetNoteTypeStateRow, error)
+	GetPendingMedia(ctx context.Context, arg GetPendingMediaParams) (GetPendingMediaRow, error)
+	GetPullState(ctx context.Context, userID int64) (GetPullStateRow, error)
+	GetRevlogSince(ctx context.Context, arg GetRevlogSinceParams) ([]GetRevlogSinceRow, error)
 	GetSyncMeta(ctx context.Context, userID int64) (GetSyncMetaRow, error)
+	GetUploadChunk(ctx context.Context, arg GetUploadChunkParams) ([]byte, error)
+	GetUploadOwner(ctx context.Context, id string) (int64, error)
 	GetUSN(ctx context.Context, userID int64) (int64, error)
+	InitCollectionStamps(ctx context.Context, arg InitCollectionStampsParams) error
+	InsertMediaBlob(ctx context.Context, arg InsertMediaBlobParams) error
+	InsertMediaGrave(ctx context.Context, arg InsertMediaGraveParams) error
+	InsertMediaVariant(ctx context.Context, arg InsertMediaVariantParams) error
+	InsertRevlog(ctx context.Context, arg InsertRevlogParams) error
+	ListMediaByHash(ctx context.Context, arg ListMediaByHashParams) ([]ListMediaByHashRow, error)
+	ListMediaUsers(ctx context.Context) ([]int64, error)
+	ListMediaVariants(ctx context.Context, arg ListMediaVariantsParams) ([]ListMediaVariantsRow, error)
+	ListReleasedMediaBlobs(ctx context.Context, cutoff int64) ([]ListReleasedMediaBlobsRow, error)
+	ListUserMedia(ctx context.Context, userID int64) ([]ListUserMediaRow, error)
+	ListUserNoteFields(ctx context.Context, userID int64) ([]string, error)
+	ListUserPendingMedia(ctx context.Context, arg ListUserPendingMediaParams) ([]string, error)
+	ListVariantsBySource(ctx context.Context, source string) ([]ListVariantsBySourceRow, error)
+	// Stores the chunk only while the upload's staged bytes, counting the chunk
+	// and not the one it replaces, stay within max_bytes
+	PutUploadChunk(ctx context.Context, arg PutUploadChunkParams) (int64, error)
 	RecordGrave(ctx context.Context, arg RecordGraveParams) error
+	ReleaseMediaBlob(ctx context.Context, sha256 string) error
+	ReleaseUserMediaBlobs(ctx context.Context, userID int64) error
+	ReleaseUserMediaVariantBlobs(ctx context.Context, userID int64) error
+	ReserveMedia(ctx context.Context, arg ReserveMediaParams) error
 	ResetUserUSN(ctx context.Context, userID int64) error
+	SetCollectionMod(ctx context.Context, arg SetCollectionModParams) error
+	SetCollectionSchema(ctx context.Context, arg SetCollectionSchemaParams) error
+	SetMediaBlob(ctx context.Context, arg SetMediaBlobParams) (int64, error)
 	UpdateUSN(ctx context.Context, userID int64) (int64, error)
 	UpsertCard(ctx context.Context, arg UpsertCardParams) error
 	UpsertDeck(ctx context.Context, arg UpsertDeckParams) error
+	UpsertDeckConfig(ctx context.Context, arg UpsertDeckConfigParams) error
+	UpsertMedia(ctx context.Context, arg UpsertMediaParams) error
 	UpsertNote(ctx context.Context, arg UpsertNoteParams) error
+	UpsertNoteType(ctx context.Context, arg UpsertNoteTypeParams) error
+	UseHostKey(ctx context.Context, arg UseHostKeyParams) (int64, error)
 }
 
 var _ Querier = (*Queries)(nil)
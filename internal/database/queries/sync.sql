@@ -8,17 +8,21 @@ VALUES (?, 0, NULL);
 
 -- name: UpdateUSN :one
 UPDATE user_collections 
-SET usn = usn + 1, last_sync = CURRENT_TIMESTAMP 
+SET usn = usn + 1, last_sync = CURRENT_TIMESTAMP,
+    mod = CAST(unixepoch('subsec') * 1000 AS INTEGER)
 WHERE user_id = ? 
 RETURNING usn;
 
 -- name: GetUSN :one
 SELECT usn FROM user_collections WHERE user_id = ?;
 
+-- name: GetPullState :one
+SELECT usn, resets FROM user_collections WHERE user_id = ?;
+
 -- name: UpsertDeck :exec
 INSERT INTO user_decks (id, user_id, name, description, config_id, created_at, modified_at, usn)
 VALUES (?, ?, ?, ?, ?, ?, ?, ?)
-ON CONFLICT(id) DO UPDATE SET
+ON CONFLICT(user_id, id) DO UPDATE SET
     name = excluded.name,
     description = excluded.description,
     config_id = excluded.config_id,
@@ -28,7 +32,7 @@ ON CONFLICT(id) DO UPDATE SET
 -- name: UpsertNote :exec
 INSERT INTO user_notes (id, user_id, guid, mid, mod, usn, tags, flds, sfld, csum, flags, data)
 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-ON CONFLICT(id) DO UPDATE SET
+ON CONFLICT(user_id, id) DO UPDATE SET
     guid = excluded.guid,
     mid = excluded.mid,
     mod = excluded.mod,
@@ -44,8 +48,8 @@ ON CONFLICT(id) DO UPDATE SET
 INSERT INTO user_cards (id, user_id, note_id, deck_id, ordinal, modified_at, usn, 
     state, queue, due, interval, ease_factor, reps, lapses, left_count,
     original_due, original_deck_id, flags, data, stability, difficulty)
-VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-ON CONFLICT(id) DO UPDATE SET
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, COALESCE(?20, 0), COALESCE(?21, 0))
+ON CONFLICT(user_id, id) DO UPDATE SET
     note_id = excluded.note_id,
     deck_id = excluded.deck_id,
     ordinal = excluded.ordinal,
@@ -63,37 +67,54 @@ ON CONFLICT(id) DO UPDATE SET
     original_deck_id = excluded.original_deck_id,
     flags = excluded.flags,
     data = excluded.data,
-    stability = excluded.stability,
-    difficulty = excluded.difficulty;
+    stability = COALESCE(?20, user_cards.stability),
+    difficulty = COALESCE(?21, user_cards.difficulty);
 
 -- name: RecordGrave :exec
 INSERT INTO user_graves (user_id, usn, oid, type) 
 VALUES (?, ?, ?, ?);
 
+-- name: InsertRevlog :exec
+INSERT INTO user_revlog (id, user_id, cid, usn, ease, ivl, last_ivl, factor, time, type)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(user_id, id) DO NOTHING;
+
 -- name: GetDecksSince :many
 SELECT id, name, description, config_id, created_at, modified_at, usn
 FROM user_decks
-WHERE user_id = ? AND usn > ?
-ORDER BY usn;
+WHERE user_id = ? AND (usn, id) > (sqlc.arg(after_usn), sqlc.arg(after_id)) AND usn <= sqlc.arg(until_usn)
+ORDER BY usn, id
+LIMIT sqlc.arg(max_rows);
 
 -- name: GetNotesSince :many
 SELECT id, guid, mid, mod, usn, tags, flds, sfld, csum, flags, data
 FROM user_notes
-WHERE user_id = ? AND usn > ?
-ORDER BY usn;
+WHERE user_id = ? AND (usn, id) > (sqlc.arg(after_usn), sqlc.arg(after_id)) AND usn <= sqlc.arg(until_usn)
+ORDER BY usn, id
+LIMIT sqlc.arg(max_rows);
 
 -- name: GetCardsSince :many
 SELECT id, note_id, deck_id, ordinal, modified_at, usn, state, queue, due, 
     interval, ease_factor, reps, lapses, left_count, original_due, original_deck_id, flags, data,
     stability, difficulty
 FROM user_cards
-WHERE user_id = ? AND usn > ?
-ORDER BY usn;
+WHERE user_id = ? AND (usn, id) > (sqlc.arg(after_usn), sqlc.arg(after_id)) AND usn <= sqlc.arg(until_usn)
+ORDER BY usn, id
+LIMIT sqlc.arg(max_rows);
 
 -- name: GetGravesSince :many
-SELECT oid, type FROM user_graves
-WHERE user_id = ? AND usn > ?
-ORDER BY usn;
+SELECT id, usn, oid, type
+FROM user_graves
+WHERE user_id = ? AND (usn, id) > (sqlc.arg(after_usn), sqlc.arg(after_id)) AND usn <= sqlc.arg(until_usn)
+ORDER BY usn, id
+LIMIT sqlc.arg(max_rows);
+
+-- name: GetRevlogSince :many
+SELECT id, cid, usn, ease, ivl, last_ivl, factor, time, type
+FROM user_revlog
+WHERE user_id = ? AND (usn, id) > (sqlc.arg(after_usn), sqlc.arg(after_id)) AND usn <= sqlc.arg(until_usn)
+ORDER BY usn, id
+LIMIT sqlc.arg(max_rows);
 
 -- name: DeleteUserCards :exec
 DELETE FROM user_cards WHERE user_id = ?;
@@ -110,8 +131,11 @@ DELETE FROM user_graves WHERE user_id = ?;
 -- name: DeleteUserMedia :exec
 DELETE FROM user_media WHERE user_id = ?;
 
+-- name: DeleteUserRevlog :exec
+DELETE FROM user_revlog WHERE user_id = ?;
+
 -- name: ResetUserUSN :exec
-UPDATE user_collections SET usn = 0, last_sync = NULL WHERE user_id = ?;
+UPDATE user_collections SET usn = 0, last_sync = NULL, resets = resets + 1 WHERE user_id = ?;
 
 -- name: DeleteSpecificCard :exec
 DELETE FROM user_cards WHERE id = ? AND user_id = ?;
@@ -121,3 +145,304 @@ DELETE FROM user_notes WHERE id = ? AND user_id = ?;
 
 -- name: DeleteSpecificDeck :exec
 DELETE FROM user_decks WHERE id = ? AND user_id = ?;
+
+-- name: GetDeckState :one
+SELECT usn, modified_at FROM user_decks WHERE user_id = ? AND id = ?;
+
+-- name: GetNoteState :one
+SELECT usn, mod FROM user_notes WHERE user_id = ? AND id = ?;
+
+-- name: GetCardState :one
+SELECT usn, modified_at FROM user_cards WHERE user_id = ? AND id = ?;
+
+-- name: GetGraveUsn :one
+SELECT CAST(COALESCE(MAX(usn), 0) AS INTEGER) FROM user_graves
+WHERE user_id = ? AND type = ? AND oid = ?;
+
+-- name: UpsertNoteType :exec
+INSERT INTO user_notetypes (id, user_id, name, type, mod, usn, sort_field, fields, templates, css, data)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(user_id, id) DO UPDATE SET
+    name = excluded.name,
+    type = excluded.type,
+    mod = excluded.mod,
+    usn = excluded.usn,
+    sort_field = excluded.sort_field,
+    fields = excluded.fields,
+    templates = excluded.templates,
+    css = excluded.css,
+    data = excluded.data;
+
+-- name: UpsertDeckConfig :exec
+INSERT INTO user_deck_configs (id, user_id, name, mod, usn, config)
+VALUES (?, ?, ?, ?, ?, ?)
+ON CONFLICT(user_id, id) DO UPDATE SET
+    name = excluded.name,
+    mod = excluded.mod,
+    usn = excluded.usn,
+    config = excluded.config;
+
+-- name: GetNoteTypesSince :many
+SELECT id, name, type, mod, usn, sort_field, fields, templates, css, data
+FROM user_notetypes
+WHERE user_id = ? AND (usn, id) > (sqlc.arg(after_usn), sqlc.arg(after_id)) AND usn <= sqlc.arg(until_usn)
+ORDER BY usn, id
+LIMIT sqlc.arg(max_rows);
+
+-- name: GetDeckConfigsSince :many
+SELECT id, name, mod, usn, config
+FROM user_deck_configs
+WHERE user_id = ? AND (usn, id) > (sqlc.arg(after_usn), sqlc.arg(after_id)) AND usn <= sqlc.arg(until_usn)
+ORDER BY usn, id
+LIMIT sqlc.arg(max_rows);
+
+-- name: GetNoteTypeState :one
+SELECT usn, mod FROM user_notetypes WHERE user_id = ? AND id = ?;
+
+-- name: GetDeckConfigState :one
+SELECT usn, mod FROM user_deck_configs WHERE user_id = ? AND id = ?;
+
+-- name: DeleteSpecificNoteType :exec
+DELETE FROM user_notetypes WHERE id = ? AND user_id = ?;
+
+-- name: DeleteSpecificDeckConfig :exec
+DELETE FROM user_deck_configs WHERE id = ? AND user_id = ?;
+
+-- name: DeleteUserNoteTypes :exec
+DELETE FROM user_notetypes WHERE user_id = ?;
+
+-- name: DeleteUserDeckConfigs :exec
+DELETE FROM user_deck_configs WHERE user_id = ?;
+
+-- name: CreateUpload :exec
+INSERT INTO sync_uploads (id, user_id)
+VALUES (?, ?);
+
+-- name: GetUploadOwner :one
+SELECT user_id FROM sync_uploads WHERE id = ?;
+
+-- name: CountOpenUploads :one
+SELECT COUNT(*) FROM sync_uploads
+WHERE user_id = ? AND created_at >= datetime('now', '-1 hour');
+
+-- Stores the chunk only while the upload's staged bytes, counting the chunk
+-- and not the one it replaces, stay within max_bytes
+-- name: PutUploadChunk :execrows
+INSERT OR REPLACE INTO sync_upload_chunks (upload_id, seq, payload)
+SELECT sqlc.arg(upload_id), sqlc.arg(seq), sqlc.arg(payload)
+WHERE (
+    SELECT COALESCE(SUM(LENGTH(payload)), 0) FROM sync_upload_chunks
+    WHERE upload_id = sqlc.arg(upload_id) AND seq != sqlc.arg(seq)
+) + LENGTH(sqlc.arg(payload)) <= sqlc.arg(max_bytes);
+
+-- name: GetUploadChunk :one
+SELECT payload FROM sync_upload_chunks WHERE upload_id = ? AND seq = ?;
+
+-- name: CountUploadChunksFrom :one
+SELECT COUNT(*) FROM sync_upload_chunks WHERE upload_id = ? AND seq >= ?;
+
+-- name: DeleteUploadChunks :exec
+DELETE FROM sync_upload_chunks WHERE upload_id = ?;
+
+-- name: DeleteUpload :exec
+DELETE FROM sync_uploads WHERE id = ?;
+
+-- name: DeleteStaleUploadChunks :exec
+DELETE FROM sync_upload_chunks
+WHERE upload_id IN (
+    SELECT id FROM sync_uploads
+    WHERE user_id = ? OR created_at < datetime('now', '-1 day')
+);
+
+-- name: DeleteStaleUploads :exec
+DELETE FROM sync_uploads
+WHERE user_id = ? OR created_at < datetime('now', '-1 day');
+
+-- name: GetCollectionState :one
+SELECT usn, mod, scm, crt FROM user_collections WHERE user_id = ?;
+
+-- name: SetCollectionMod :exec
+UPDATE user_collections SET mod = ? WHERE user_id = ?;
+
+-- name: SetCollectionSchema :exec
+UPDATE user_collections SET scm = ?, crt = ? WHERE user_id = ?;
+
+-- name: InitCollectionStamps :exec
+UPDATE user_collections
+SET scm = CASE WHEN scm = 0 THEN ? ELSE scm END,
+    crt = CASE WHEN crt = 0 THEN ? ELSE crt END
+WHERE user_id = ?;
+
+-- name: BumpCollectionSchema :exec
+UPDATE user_collections SET scm = CAST(unixepoch('subsec') * 1000 AS INTEGER), resets = resets + 1
+WHERE user_id = ?;
+
+-- name: CountUserCards :one
+SELECT COUNT(*) FROM user_cards WHERE user_id = ?;
+
+-- name: CountUserNotes :one
+SELECT COUNT(*) FROM user_notes WHERE user_id = ?;
+
+-- name: GetMaxNewCardDue :one
+SELECT CAST(COALESCE(MAX(due), 0) AS INTEGER) FROM user_cards WHERE user_id = ? AND queue = 0;
+
+-- name: CreateHostKey :exec
+INSERT INTO anki_host_keys (key, user_id, last_used_at)
+VALUES (?, ?, ?);
+
+-- name: UseHostKey :one
+UPDATE anki_host_keys SET last_used_at = ?
+WHERE key = ? AND last_used_at >= ?
+RETURNING user_id;
+
+-- name: DeleteStaleHostKeys :exec
+DELETE FROM anki_host_keys WHERE user_id = ? AND last_used_at < ?;
+
+-- name: DeleteUserHostKeys :exec
+DELETE FROM anki_host_keys WHERE user_id = ?;
+
+-- name: GetMediaUSN :one
+SELECT CAST(COALESCE(MAX(usn), 0) AS INTEGER) FROM (
+    SELECT user_id, usn FROM user_media
+    UNION ALL
+    SELECT user_id, usn FROM user_media_graves
+)
+WHERE user_id = ?;
+
+-- name: CountUserMedia :one
+SELECT COUNT(*) FROM user_media WHERE user_id = ?;
+
+-- name: GetMediaBytes :one
+SELECT CAST(COALESCE(SUM(size), 0) AS INTEGER) FROM user_media WHERE user_id = ?;
+
+-- name: ListUserMedia :many
+SELECT filename, hash, size, blob, mtime FROM user_media WHERE user_id = ? ORDER BY filename;
+
+-- name: GetMediaChanges :many
+SELECT filename, hash, size, usn, deleted, id FROM (
+    SELECT user_id, filename, hash, size, usn, 0 AS deleted, id FROM user_media
+    UNION ALL
+    SELECT user_id, filename, hash, 0 AS size, usn, 1 AS deleted, id FROM user_media_graves
+)
+WHERE user_id = ? AND (usn, deleted, id) > (sqlc.arg(after_usn), sqlc.arg(after_deleted), sqlc.arg(after_id))
+    AND usn <= sqlc.arg(until_usn)
+ORDER BY usn, deleted, id
+LIMIT sqlc.arg(max_rows);
+
+-- name: ListMediaByHash :many
+SELECT filename, blob FROM user_media WHERE user_id = ? AND hash = ?;
+
+-- name: GetMediaByFilename :one
+SELECT hash, size, blob, mtime FROM user_media WHERE user_id = ? AND filename = ? LIMIT 1;
+
+-- name: GetMediaByHash :one
+SELECT filename, size, blob FROM user_media WHERE user_id = ? AND hash = ? LIMIT 1;
+
+-- name: DeleteMediaByFilename :exec
+DELETE FROM user_media WHERE user_id = ? AND filename = ?;
+
+-- name: InsertMediaGrave :exec
+INSERT OR REPLACE INTO user_media_graves (user_id, filename, hash, usn)
+VALUES (?, ?, ?, ?);
+
+-- name: DeleteMediaGrave :exec
+DELETE FROM user_media_graves WHERE user_id = ? AND filename = ?;
+
+-- name: DeleteUserMediaGraves :exec
+DELETE FROM user_media_graves WHERE user_id = ?;
+
+-- name: UpsertMedia :exec
+INSERT OR REPLACE INTO user_media (user_id, filename, hash, size, usn, blob, mtime)
+VALUES (?, ?, ?, ?, ?, ?, ?);
+
+-- name: SetMediaBlob :one
+UPDATE user_media SET blob = ? WHERE user_id = ? AND hash = ? AND blob = ''
+RETURNING id;
+
+-- name: ReserveMedia :exec
+INSERT OR REPLACE INTO user_media_pending (user_id, hash, filename, size)
+VALUES (?, ?, ?, ?);
+
+-- name: GetPendingMedia :one
+SELECT filename, size FROM user_media_pending WHERE user_id = ? AND hash = ?;
+
+-- name: DeletePendingMedia :exec
+DELETE FROM user_media_pending WHERE user_id = ? AND hash = ?;
+
+-- name: DeleteUserPendingMedia :exec
+DELETE FROM user_media_pending WHERE user_id = ?;
+
+-- name: ListUserPendingMedia :many
+SELECT hash FROM user_media_pending
+WHERE user_id = ? AND created_at > datetime(sqlc.arg(cutoff), 'unixepoch');
+
+-- name: DeleteExpiredPendingMedia :exec
+DELETE FROM user_media_pending WHERE created_at <= datetime(sqlc.arg(cutoff), 'unixepoch');
+
+-- name: ListMediaUsers :many
+SELECT DISTINCT user_id FROM user_media ORDER BY user_id;
+
+-- name: ListUserNoteFields :many
+SELECT flds FROM user_notes WHERE user_id = ?;
+
+-- name: CountMediaByHash :one
+SELECT COUNT(*) FROM user_media WHERE user_id = ? AND hash = ?;
+
+-- name: FindMediaBlob :one
+UPDATE media_blobs
+SET released_at = CASE WHEN refs <= 0 THEN CURRENT_TIMESTAMP ELSE released_at END
+WHERE sha256 = ?
+RETURNING sha256, size;
+
+-- name: InsertMediaBlob :exec
+INSERT INTO media_blobs (sha256, sha1, size, refs, released_at)
+VALUES (?, ?, ?, 0, CURRENT_TIMESTAMP)
+ON CONFLICT(sha256) DO UPDATE
+SET released_at = CASE WHEN refs <= 0 THEN CURRENT_TIMESTAMP ELSE released_at END;
+
+-- name: AcquireMediaBlob :one
+UPDATE media_blobs SET refs = refs + 1, released_at = NULL WHERE sha256 = ?
+RETURNING refs;
+
+-- name: ReleaseMediaBlob :exec
+UPDATE media_blobs SET refs = refs - 1, released_at = CURRENT_TIMESTAMP WHERE sha256 = ?;
+
+-- name: ReleaseUserMediaBlobs :exec
+UPDATE media_blobs
+SET refs = refs - (SELECT COUNT(*) FROM user_media m WHERE m.user_id = sqlc.arg(user_id) AND m.blob = media_blobs.sha256),
+    released_at = CURRENT_TIMESTAMP
+WHERE sha256 IN (SELECT blob FROM user_media WHERE user_id = sqlc.arg(user_id));
+
+-- name: ListReleasedMediaBlobs :many
+SELECT sha256, size FROM media_blobs
+WHERE refs <= 0 AND released_at <= datetime(sqlc.arg(cutoff), 'unixepoch')
+ORDER BY sha256;
+
+-- name: DeleteReleasedMediaBlob :one
+DELETE FROM media_blobs
+WHERE sha256 = ? AND refs <= 0 AND released_at <= datetime(sqlc.arg(cutoff), 'unixepoch')
+RETURNING sha256;
+
+-- name: ListMediaVariants :many
+SELECT content_type, blob, size FROM user_media_variants
+WHERE user_id = ? AND hash = ?
+ORDER BY size;
+
+-- name: ListVariantsBySource :many
+SELECT DISTINCT content_type, blob, size FROM user_media_variants WHERE source = ?;
+
+-- name: InsertMediaVariant :exec
+INSERT INTO user_media_variants (user_id, hash, content_type, blob, size, source)
+VALUES (?, ?, ?, ?, ?, ?);
+
+-- name: DeleteMediaVariants :exec
+DELETE FROM user_media_variants WHERE user_id = ? AND hash = ?;
+
+-- name: ReleaseUserMediaVariantBlobs :exec
+UPDATE media_blobs
+SET refs = refs - (SELECT COUNT(*) FROM user_media_variants v WHERE v.user_id = sqlc.arg(user_id) AND v.blob = media_blobs.sha256),
+    released_at = CURRENT_TIMESTAMP
+WHERE sha256 IN (SELECT blob FROM user_media_variants WHERE user_id = sqlc.arg(user_id));
+
+-- name: DeleteUserMediaVariants :exec
+DELETE FROM user_media_variants WHERE user_id = ?;
@@ -12,6 +12,8 @@ CREATE TABLE IF NOT EXISTS users (
     unlocked_achievements TEXT DEFAULT '[]',
     subscription_status TEXT DEFAULT 'free', -- free, pro, group_host
     subscription_expiry DATETIME,
+    token_version INTEGER NOT NULL DEFAULT 0, -- bumped to revoke all access tokens
+    email_verified_at DATETIME,
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
     updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 );
@@ -58,7 +60,9 @@ CREATE TABLE IF NOT EXISTS group_decks (
     name TEXT NOT NULL,
     card_count INTEGER DEFAULT 0,
     r2_key TEXT, -- Path to .apkg file in R2
+    size INTEGER, -- Bytes stored at r2_key, NULL until measured
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    published_at DATETIME, -- NULL until the upload is confirmed
     FOREIGN KEY(group_id) REFERENCES groups(id),
     FOREIGN KEY(uploader_id) REFERENCES users(id)
 );
@@ -75,6 +79,7 @@ CREATE TABLE IF NOT EXISTS deck_access (
 );
 
 CREATE INDEX IF NOT EXISTS idx_users_email ON users(email);
+CREATE INDEX IF NOT EXISTS idx_users_email_nocase ON users(email COLLATE NOCASE);
 CREATE INDEX IF NOT EXISTS idx_shared_decks_group ON shared_decks(group_id);
 CREATE INDEX IF NOT EXISTS idx_group_decks_group ON group_decks(group_id);
 
@@ -92,3 +97,60 @@ CREATE TABLE IF NOT EXISTS subscriptions (
 
 CREATE INDEX IF NOT EXISTS idx_subscriptions_user ON subscriptions(user_id);
 CREATE INDEX IF NOT EXISTS idx_subscriptions_transaction ON subscriptions(transaction_id);
+
+-- Signed-in devices, each holding one rotating refresh token (stored hashed)
+CREATE TABLE IF NOT EXISTS sessions (
+    id TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    device_name TEXT NOT NULL DEFAULT '',
+    refresh_hash TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    last_seen_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    expires_at DATETIME NOT NULL,
+    FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id);
+
+-- Sign in with Apple / Google accounts linked to users
+CREATE TABLE IF NOT EXISTS user_identities (
+    provider TEXT NOT NULL, -- apple, google
+    subject TEXT NOT NULL,  -- the provider's stable user id (sub)
+    user_id INTEGER NOT NULL,
+    email TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (provider, subject),
+    FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_identities_user ON user_identities(user_id, provider);
+
+-- Pending email verification codes, one per user
+CREATE TABLE IF NOT EXISTS email_verifications (
+    user_id INTEGER PRIMARY KEY,
+    code TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    expires_at DATETIME NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+-- Failed sign-in and reset attempts, per account or client IP
+CREATE TABLE IF NOT EXISTS auth_throttles (
+    key TEXT PRIMARY KEY, -- e.g. login:account:<email>, login:ip:<ip>
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_attempt_at DATETIME NOT NULL,
+    locked_until DATETIME
+);
+CREATE INDEX IF NOT EXISTS idx_auth_throttles_last_attempt ON auth_throttles(last_attempt_at);
+
+-- Security relevant authentication events, such as lockouts
+CREATE TABLE IF NOT EXISTS auth_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    event TEXT NOT NULL,
+    email TEXT,
+    ip TEXT,
+    detail TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_auth_events_created ON auth_events(created_at);
@@ -0,0 +1,177 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrSessionNotFound is returned for unknown, expired or revoked sessions
+var ErrSessionNotFound = errors.New("session not found")
+
+// ErrRefreshTokenReused is returned when a session's previous refresh token
+// is presented again. The session is revoked, as the token has likely leaked.
+var ErrRefreshTokenReused = errors.New("refresh token reused")
+
+// Session is a signed-in device
+type Session struct {
+	ID         string    `json:"id"`
+	DeviceName string    `json:"device_name"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	// Current marks the session of the request listing the sessions
+	Current bool `json:"current"`
+}
+
+// CreateSession records a new session of the user whose refresh token hashes
+// to refreshHash, dropping the user's expired sessions
+func CreateSession(userID int, deviceName, refreshHash string, expiresAt time.Time) (string, error) {
+	id := GenerateRandomString(16)
+	if id == "" {
+		return "", errors.New("failed to generate session id")
+	}
+	if _, err := DB.Exec("DELETE FROM sessions WHERE user_id = ? AND expires_at <= ?", userID, time.Now().UTC()); err != nil {
+		return "", err
+	}
+	_, err := DB.Exec(`INSERT INTO sessions (id, user_id, device_name, refresh_hash, expires_at) VALUES (?, ?, ?, ?, ?)`,
+		id, userID, deviceName, refreshHash, expiresAt.UTC())
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// RotateSession replaces the session's refresh token hash oldHash by newHash
+// and extends it to expiresAt. It returns the session's user.
+func RotateSession(id, oldHash, newHash string, expiresAt time.Time) (int, error) {
+	// Times are stored in UTC so they compare as text
+	now := time.Now().UTC()
+	res, err := DB.Exec(`UPDATE sessions SET refresh_hash = ?, expires_at = ?, last_seen_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND refresh_hash = ? AND expires_at > ?`, newHash, expiresAt.UTC(), id, oldHash, now)
+	if err != nil {
+		return 0, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return 0, err
+	} else if n == 1 {
+		var userID int
+		err := DB.QueryRow("SELECT user_id FROM sessions WHERE id = ?", id).Scan(&userID)
+		return userID, err
+	}
+
+	// Not rotated: unknown, expired, or a stale token of a live session
+	var expires time.Time
+	err = DB.QueryRow("SELECT expires_at FROM sessions WHERE id = ?", id).Scan(&expires)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrSessionNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	if _, err := DB.Exec("DELETE FROM sessions WHERE id = ?", id); err != nil {
+		return 0, err
+	}
+	if !expires.After(now) {
+		return 0, ErrSessionNotFound
+	}
+	return 0, ErrRefreshTokenReused
+}
+
+// CheckSession reports whether the user's session is live and version is
+// the user's current token version. Last-seen times are kept to the minute.
+func CheckSession(id string, userID, version int) (bool, error) {
+	now := time.Now().UTC()
+	var current int
+	err := DB.QueryRow(`SELECT u.token_version FROM sessions s JOIN users u ON u.id = s.user_id
+		WHERE s.id = ? AND s.user_id = ? AND s.expires_at > ?`, id, userID, now).Scan(&current)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if current != version {
+		return false, nil
+	}
+	_, err = DB.Exec("UPDATE sessions SET last_seen_at = CURRENT_TIMESTAMP WHERE id = ? AND last_seen_at <= ?",
+		id, now.Add(-time.Minute).Format("2006-01-02 15:04:05"))
+	return true, err
+}
+
+// GetTokenVersion returns the user's token version, ErrUserNotFound for
+// deleted users
+func GetTokenVersion(userID int) (int, error) {
+	var version int
+	err := DB.QueryRow("SELECT token_version FROM users WHERE id = ?", userID).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrUserNotFound
+	}
+	return version, err
+}
+
+// ListSessions returns the user's live sessions, most recently seen first
+func ListSessions(userID int) ([]Session, error) {
+	rows, err := DB.Query(`SELECT id, device_name, created_at, last_seen_at, expires_at FROM sessions
+		WHERE user_id = ? AND expires_at > ? ORDER BY last_seen_at DESC`, userID, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var s Session
+		if err := rows.Scan(&s.ID, &s.DeviceName, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// DeleteSession revokes one of the user's sessions
+func DeleteSession(userID int, id string) error {
+	res, err := DB.Exec("DELETE FROM sessions WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// DeleteOtherSessions revokes every session of the user but keepID and
+// returns how many were revoked
+func DeleteOtherSessions(userID int, keepID string) (int64, error) {
+	res, err := DB.Exec("DELETE FROM sessions WHERE user_id = ? AND id != ?", userID, keepID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// RevokeUserSessions signs the user out everywhere: it revokes all sessions,
+// bumps the token version so outstanding access tokens stop working, and
+// drops the user's Anki host keys.
+func RevokeUserSessions(userID int) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE users SET token_version = token_version + 1 WHERE id = ?", userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM sessions WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM anki_host_keys WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
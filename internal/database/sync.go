@@ -7,6 +7,7 @@ import (
 	"fmt"
     "log"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -45,40 +46,140 @@ type SyncNote struct {
 
 // SyncPayload represents a collection of data to be synced
 type SyncPayload struct {
-    Decks  []SyncDeck
-    Notes  []SyncNote
-    Cards  []SyncCard
-    Graves []SyncGrave
+    // ClientUSN is the last server USN the client saw. Nil skips conflict
+    // detection (full sync, clients that predate it).
+    ClientUSN   *int
+    Decks       []SyncDeck
+    Notes       []SyncNote
+    Cards       []SyncCard
+    Graves      []SyncGrave
+    Revlog      []SyncRevlog
+    NoteTypes   []SyncNoteType
+    DeckConfigs []SyncDeckConfig
 }
 
-// PushSyncSafe performs a transactional sync push
-func (r *Repository) PushSyncSafe(userID int, payload *SyncPayload) (int, error) {
+// PushSyncSafe performs a transactional sync push.
+// Objects changed on the server after payload.ClientUSN are handled per policy:
+// ConflictReject returns a *ConflictError and applies nothing, ConflictLastWriteWins
+// keeps the copy with the newer modification time and reports skipped objects.
+func (r *Repository) PushSyncSafe(userID int, payload *SyncPayload, policy ConflictPolicy) (*PushResult, error) {
     ctx := context.Background()
     tx, err := r.DB.BeginTx(ctx, nil)
     if err != nil {
-        return 0, err
+        return nil, err
     }
     defer tx.Rollback()
     
     qtx := r.Q.WithTx(tx)
+    uid := int64(userID)
     
     // Ensure meta exists
-    if err := qtx.CreateSyncMeta(ctx, int64(userID)); err != nil {
-        return 0, err
+    if err := qtx.CreateSyncMeta(ctx, uid); err != nil {
+        return nil, err
     }
-    
+
+    serverUSN, err := qtx.GetUSN(ctx, uid)
+    if err != nil {
+        return nil, err
+    }
+
     // Increment USN
-    usnInt64, err := qtx.UpdateUSN(ctx, int64(userID))
+    usnInt64, err := qtx.UpdateUSN(ctx, uid)
     if err != nil {
-        return 0, err
+        return nil, err
     }
     usn := int64(usnInt64)
     
+    result, err := applyPayload(ctx, qtx, uid, payload, policy, serverUSN, usn)
+    if err != nil {
+        return nil, err
+    }
+    
+    if err := tx.Commit(); err != nil {
+        return nil, err
+    }
+    
+    result.USN = int(usn)
+    return result, nil
+}
+
+// applyPayload writes payload inside qtx, stamping changed objects with usn.
+// serverUSN is the collection USN before this push, for conflict detection.
+func applyPayload(ctx context.Context, qtx *Queries, uid int64, payload *SyncPayload, policy ConflictPolicy, serverUSN, usn int64) (*PushResult, error) {
+    checkConflicts := payload.ClientUSN != nil && int64(*payload.ClientUSN) < serverUSN
+
+    result := &PushResult{}
+    var conflicts SyncConflicts
+
+    // changedOnServer reports whether the object was modified or deleted on
+    // the server after the client's last sync, returning its modification
+    // time and whether it is deleted. Objects already stamped with usn were
+    // written by this sync.
+    changedOnServer := func(objType int, id int64) (changed bool, mod int64, deleted bool, err error) {
+        if !checkConflicts {
+            return false, 0, false, nil
+        }
+        objUSN, mod, found, err := serverState(ctx, qtx, uid, objType, id)
+        if err != nil {
+            return false, 0, false, err
+        }
+        return objUSN > int64(*payload.ClientUSN) && objUSN != usn, mod, !found, nil
+    }
+
+    // accept decides whether an incoming object overwrites the server copy
+    accept := func(objType int, id, mod int64) (bool, error) {
+        changed, serverMod, deleted, err := changedOnServer(objType, id)
+        if err != nil || !changed {
+            return err == nil, err
+        }
+        if policy == ConflictReject {
+            conflicts.add(objType, id)
+            return false, nil
+        }
+        // A deletion the client has not seen beats its edit, as edits
+        // lose to graves on push
+        if !deleted && mod > serverMod {
+            return true, nil
+        }
+        result.Skipped.add(objType, id)
+        return false, nil
+    }
+    
+    // Apply Note Types and Deck Configs first, notes and decks reference them
+    for i := range payload.NoteTypes {
+        nt := &payload.NoteTypes[i]
+        if ok, err := accept(3, nt.ID, nt.Mod); err != nil {
+            return nil, fmt.Errorf("failed to check notetype %d: %w", nt.ID, err)
+        } else if !ok {
+            continue
+        }
+        if err := putNoteType(ctx, qtx, uid, nt, usn); err != nil {
+            return nil, fmt.Errorf("failed to upsert notetype %d: %w", nt.ID, err)
+        }
+    }
+
+    for i := range payload.DeckConfigs {
+        dc := &payload.DeckConfigs[i]
+        if ok, err := accept(4, dc.ID, dc.Mod); err != nil {
+            return nil, fmt.Errorf("failed to check deck config %d: %w", dc.ID, err)
+        } else if !ok {
+            continue
+        }
+        if err := putDeckConfig(ctx, qtx, uid, dc, usn); err != nil {
+            return nil, fmt.Errorf("failed to upsert deck config %d: %w", dc.ID, err)
+        }
+    }
+    
     // Apply Decks
     for _, deck := range payload.Decks {
+        if ok, err := accept(2, deck.ID, deck.ModifiedAt); err != nil {
+            return nil, fmt.Errorf("failed to check deck %d: %w", deck.ID, err)
+        } else if !ok {
+            continue
+        }
         err := qtx.UpsertDeck(ctx, UpsertDeckParams{
             ID:          deck.ID,
-            UserID:      int64(userID),
+            UserID:      uid,
             Name:        deck.Name,
             Description: sql.NullString{String: deck.Description, Valid: deck.Description != ""},
             ConfigID:    sql.NullInt64{Int64: int64(deck.ConfigID), Valid: true},
@@ -87,15 +188,20 @@ func (r *Repository) PushSyncSafe(userID int, payload *SyncPayload) (int, error)
             Usn:         usn,
         })
         if err != nil {
-            return 0, fmt.Errorf("failed to upsert deck %d: %w", deck.ID, err)
+            return nil, fmt.Errorf("failed to upsert deck %d: %w", deck.ID, err)
         }
     }
     
     // Apply Notes
     for _, note := range payload.Notes {
+        if ok, err := accept(1, note.ID, note.Mod); err != nil {
+            return nil, fmt.Errorf("failed to check note %d: %w", note.ID, err)
+        } else if !ok {
+            continue
+        }
         err := qtx.UpsertNote(ctx, UpsertNoteParams{
             ID:     note.ID,
-            UserID: int64(userID),
+            UserID: uid,
             Guid:   note.GUID,
             Mid:    note.MID,
             Mod:    note.Mod,
@@ -108,15 +214,20 @@ func (r *Repository) PushSyncSafe(userID int, payload *SyncPayload) (int, error)
             Data:   note.Data,
         })
         if err != nil {
-             return 0, fmt.Errorf("failed to upsert note %d: %w", note.ID, err)
+             return nil, fmt.Errorf("failed to upsert note %d: %w", note.ID, err)
         }
     }
     
     // Apply Cards
     for _, card := range payload.Cards {
+        if ok, err := accept(0, card.ID, card.ModifiedAt); err != nil {
+            return nil, fmt.Errorf("failed to check card %d: %w", card.ID, err)
+        } else if !ok {
+            continue
+        }
         err := qtx.UpsertCard(ctx, UpsertCardParams{
             ID:             card.ID,
-            UserID:         int64(userID),
+            UserID:         uid,
             NoteID:         card.NoteID,
             DeckID:         card.DeckID,
             Ordinal:        int64(card.Ordinal),
@@ -134,73 +245,130 @@ func (r *Repository) PushSyncSafe(userID int, payload *SyncPayload) (int, error)
             OriginalDeckID: card.OriginalDeckID,
             Flags:          int64(card.Flags),
             Data:           card.Data,
+            Stability:      nullFloat(card.Stability),
+            Difficulty:     nullFloat(card.Difficulty),
+        })
+        if err != nil {
+            return nil, fmt.Errorf("failed to upsert card %d: %w", card.ID, err)
+        }
+    }
+    
+    // Apply Revlog. Entries are append-only: a resent id is ignored, never
+    // overwritten, so no conflict checks are needed.
+    for _, entry := range payload.Revlog {
+        err := qtx.InsertRevlog(ctx, InsertRevlogParams{
+            ID:      entry.ID,
+            UserID:  uid,
+            Cid:     entry.CID,
+            Usn:     usn,
+            Ease:    int64(entry.Ease),
+            Ivl:     int64(entry.Ivl),
+            LastIvl: int64(entry.LastIvl),
+            Factor:  int64(entry.Factor),
+            Time:    int64(entry.Time),
+            Type:    int64(entry.Type),
         })
         if err != nil {
-            return 0, fmt.Errorf("failed to upsert card %d: %w", card.ID, err)
+            return nil, fmt.Errorf("failed to insert revlog %d: %w", entry.ID, err)
         }
     }
     
     // Apply Graves
     for _, grave := range payload.Graves {
+        // Graves carry no timestamp, so under last writer wins a deletion
+        // always beats a server-side edit (as in Anki).
+        if policy == ConflictReject {
+            changed, _, deleted, err := changedOnServer(grave.Type, grave.OID)
+            if err != nil {
+                return nil, fmt.Errorf("failed to check grave %d: %w", grave.OID, err)
+            }
+            // Deleting what the server deleted too is no conflict
+            if changed && !deleted {
+                conflicts.add(grave.Type, grave.OID)
+                continue
+            }
+        }
+
         // Record
         err := qtx.RecordGrave(ctx, RecordGraveParams{
-            UserID: int64(userID),
+            UserID: uid,
             Usn:    usn,
             Oid:    grave.OID,
             Type:   int64(grave.Type),
         })
         if err != nil {
-            return 0, fmt.Errorf("failed to record grave %d: %w", grave.OID, err)
+            return nil, fmt.Errorf("failed to record grave %d: %w", grave.OID, err)
         }
         
         // Execute deletion
         switch grave.Type {
         case 0: // Card
-            err = qtx.DeleteSpecificCard(ctx, DeleteSpecificCardParams{ID: grave.OID, UserID: int64(userID)})
+            err = qtx.DeleteSpecificCard(ctx, DeleteSpecificCardParams{ID: grave.OID, UserID: uid})
         case 1: // Note
-            err = qtx.DeleteSpecificNote(ctx, DeleteSpecificNoteParams{ID: grave.OID, UserID: int64(userID)})
+            err = qtx.DeleteSpecificNote(ctx, DeleteSpecificNoteParams{ID: grave.OID, UserID: uid})
         case 2: // Deck
-            err = qtx.DeleteSpecificDeck(ctx, DeleteSpecificDeckParams{ID: grave.OID, UserID: int64(userID)})
+            err = qtx.DeleteSpecificDeck(ctx, DeleteSpecificDeckParams{ID: grave.OID, UserID: uid})
+        case 3: // Note type
+            err = qtx.DeleteSpecificNoteType(ctx, DeleteSpecificNoteTypeParams{ID: grave.OID, UserID: uid})
+        case 4: // Deck config
+            err = qtx.DeleteSpecificDeckConfig(ctx, DeleteSpecificDeckConfigParams{ID: grave.OID, UserID: uid})
         }
         if err != nil {
             // We ignore errors on delete (idempotency), but logging would be good.
             // For now, continue.
         }
     }
-    
-    if err := tx.Commit(); err != nil {
-        return 0, err
+
+    if !conflicts.Empty() {
+        return nil, &ConflictError{ServerUSN: int(serverUSN), Conflicts: conflicts}
     }
-    
-    return int(usn), nil
+
+    return result, nil
 }
 
 // SyncCard represents a synced card
 type SyncCard struct {
-	ID             int64  `json:"id"`
-	NoteID         int64  `json:"note_id"`
-	DeckID         int64  `json:"deck_id"`
-	Ordinal        int    `json:"ordinal"`
-	ModifiedAt     int64  `json:"modified_at"`
-	USN            int    `json:"usn"`
-	State          int    `json:"state"`
-	Queue          int    `json:"queue"`
-	Due            int64  `json:"due"`
-	Interval       int    `json:"interval"`
-	EaseFactor     int    `json:"ease_factor"`
-	Reps           int    `json:"reps"`
-	Lapses         int    `json:"lapses"`
-	LeftCount      int    `json:"left_count"`
-	OriginalDue    int64  `json:"original_due"`
-	OriginalDeckID int64  `json:"original_deck_id"`
-	Flags          int    `json:"flags"`
-	Data           string `json:"data"`
+	ID             int64   `json:"id"`
+	NoteID         int64   `json:"note_id"`
+	DeckID         int64   `json:"deck_id"`
+	Ordinal        int     `json:"ordinal"`
+	ModifiedAt     int64   `json:"modified_at"`
+	USN            int     `json:"usn"`
+	State          int     `json:"state"`
+	Queue          int     `json:"queue"`
+	Due            int64   `json:"due"`
+	Interval       int     `json:"interval"`
+	EaseFactor     int     `json:"ease_factor"`
+	Reps           int     `json:"reps"`
+	Lapses         int     `json:"lapses"`
+	LeftCount      int     `json:"left_count"`
+	OriginalDue    int64   `json:"original_due"`
+	OriginalDeckID int64   `json:"original_deck_id"`
+	Flags          int     `json:"flags"`
+	Data           string  `json:"data"`
+	// Stability and Difficulty are the FSRS memory state; nil on push keeps
+	// the stored values
+	Stability  *float64 `json:"stability,omitempty"`
+	Difficulty *float64 `json:"difficulty,omitempty"`
 }
 
 // SyncGrave represents a deleted item
 type SyncGrave struct {
 	OID  int64 `json:"oid"`
-	Type int   `json:"type"` // 0=card, 1=note, 2=deck
+	Type int   `json:"type"` // 0=card, 1=note, 2=deck, 3=notetype, 4=deck config
+}
+
+// SyncRevlog represents a single review log entry
+type SyncRevlog struct {
+	ID      int64 `json:"id"` // Review time in epoch milliseconds
+	CID     int64 `json:"cid"`
+	USN     int   `json:"usn"`
+	Ease    int   `json:"ease"`
+	Ivl     int   `json:"ivl"`
+	LastIvl int   `json:"last_ivl"`
+	Factor  int   `json:"factor"`
+	Time    int   `json:"time"` // Answer time in milliseconds
+	Type    int   `json:"type"` // 0=learn, 1=review, 2=relearn, 3=filtered, 4=manual
 }
 
 // InitSyncSchema applies sync-specific tables
@@ -225,7 +393,22 @@ func (r *Repository) InitSyncSchema() error {
 	if _, err := r.DB.Exec(`ALTER TABLE user_cards ADD COLUMN difficulty REAL DEFAULT 0`); err != nil {
         // log.Printf("Migration difficulty: %v", err)
     }
-    
+    // Collection timestamps used by the Anki sync protocol, and the full
+    // sync count that expires pull cursors
+    for _, col := range []string{"mod", "scm", "crt", "resets"} {
+        r.DB.Exec(`ALTER TABLE user_collections ADD COLUMN ` + col + ` INTEGER NOT NULL DEFAULT 0`)
+    }
+    // Content address of deduplicated media
+    r.DB.Exec(`ALTER TABLE user_media ADD COLUMN blob TEXT NOT NULL DEFAULT ''`)
+    r.DB.Exec(`CREATE INDEX IF NOT EXISTS idx_user_media_blob ON user_media(blob)`)
+    // Blobs are only looked up by the SHA-256 the server computed
+    r.DB.Exec(`DROP INDEX IF EXISTS idx_media_blobs_sha1`)
+    // Media files stored before their time was kept count as stored now, so
+    // the media GC gives them a full grace period
+    if _, err := r.DB.Exec(`ALTER TABLE user_media ADD COLUMN mtime INTEGER NOT NULL DEFAULT 0`); err == nil {
+        r.DB.Exec(`UPDATE user_media SET mtime = CAST(strftime('%s', 'now') AS INTEGER)`)
+    }
+
     // Explicitly verify columns exist
     rows, err := r.DB.Query("PRAGMA table_info(user_cards)")
     if err == nil {
@@ -242,9 +425,91 @@ func (r *Repository) InitSyncSchema() error {
         log.Printf("🔍 Schema Check: user_cards has stability? %v", found)
     }
 
+    if err := r.migrateHostKeys(); err != nil {
+        return fmt.Errorf("failed to migrate host keys: %w", err)
+    }
+
+    // Tables created before ids were namespaced per user still use the
+    // client-supplied id as a global primary key. Rebuild them.
+    if err := r.migrateUserScopedIDs(string(schema)); err != nil {
+        return fmt.Errorf("failed to migrate sync ids: %w", err)
+    }
+
 	return nil
 }
 
+// userScopedTables are the synced tables keyed by (user_id, id)
+var userScopedTables = []string{"user_decks", "user_notes", "user_cards"}
+
+// migrateUserScopedIDs moves rows from legacy tables (global id primary key)
+// into the composite (user_id, id) layout defined in sync_schema.sql.
+// Each table is rebuilt in its own transaction so a failure leaves it untouched.
+func (r *Repository) migrateUserScopedIDs(schema string) error {
+    for _, table := range userScopedTables {
+        pkCols, columns, err := r.tableInfo(table)
+        if err != nil {
+            return err
+        }
+        if pkCols != 1 {
+            continue // already composite
+        }
+
+        log.Printf("🔧 Migrating %s to per-user ids", table)
+        legacy := table + "_legacy"
+        cols := strings.Join(columns, ", ")
+
+        tx, err := r.DB.Begin()
+        if err != nil {
+            return err
+        }
+        // Renaming keeps the old indexes attached to the legacy table, so the
+        // schema is applied once to create the new table and again after the
+        // legacy table (and its indexes) is gone.
+        steps := []string{
+            "ALTER TABLE " + table + " RENAME TO " + legacy,
+            schema,
+            "INSERT INTO " + table + " (" + cols + ") SELECT " + cols + " FROM " + legacy,
+            "DROP TABLE " + legacy,
+            schema,
+        }
+        for _, stmt := range steps {
+            if _, err := tx.Exec(stmt); err != nil {
+                tx.Rollback()
+                return fmt.Errorf("%s: %w", table, err)
+            }
+        }
+        if err := tx.Commit(); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// tableInfo returns the number of primary key columns and the column names of a table
+func (r *Repository) tableInfo(table string) (int, []string, error) {
+    rows, err := r.DB.Query("PRAGMA table_info(" + table + ")")
+    if err != nil {
+        return 0, nil, err
+    }
+    defer rows.Close()
+
+    pkCols := 0
+    var columns []string
+    for rows.Next() {
+        var cid, notnull, pk int
+        var name, ctype string
+        var dfltValue interface{}
+        if err := rows.Scan(&cid, &name, &ctype, &notnull, &dfltValue, &pk); err != nil {
+            return 0, nil, err
+        }
+        if pk > 0 {
+            pkCols++
+        }
+        columns = append(columns, name)
+    }
+    return pkCols, columns, rows.Err()
+}
+
 // GetSyncMeta returns user's current sync status
 func (r *Repository) GetSyncMeta(userID int) (*SyncMeta, error) {
     ctx := context.Background()
@@ -339,6 +604,8 @@ func (r *Repository) UpsertCard(userID int, card *SyncCard, usn int) error {
         OriginalDeckID: card.OriginalDeckID,
         Flags:          int64(card.Flags),
         Data:           card.Data,
+        Stability:      nullFloat(card.Stability),
+        Difficulty:     nullFloat(card.Difficulty),
     })
 }
 
@@ -355,112 +622,37 @@ func (r *Repository) RecordGrave(userID int, oid int64, itemType int, usn int) e
 
 // GetDecksSince returns decks modified since the given USN
 func (r *Repository) GetDecksSince(userID, sinceUSN int) ([]SyncDeck, error) {
-    dbDecks, err := r.Q.GetDecksSince(context.Background(), GetDecksSinceParams{
-        UserID: int64(userID),
-        Usn:    int64(sinceUSN),
-    })
-    if err != nil {
-        return nil, err
-    }
-    
-    decks := make([]SyncDeck, len(dbDecks))
-    for i, d := range dbDecks {
-        decks[i] = SyncDeck{
-            ID:          d.ID,
-            Name:        d.Name,
-            Description: d.Description.String,
-            ConfigID:    int(d.ConfigID.Int64),
-            CreatedAt:   d.CreatedAt,
-            ModifiedAt:  d.ModifiedAt,
-            USN:         int(d.Usn),
-        }
-    }
-    return decks, nil
+    var page PullPage
+    _, _, _, err := pullDecks(context.Background(), r.Q, int64(userID), sinceBounds(sinceUSN), &page)
+    return page.Decks, err
 }
 
 // GetNotesSince returns notes modified since the given USN
 func (r *Repository) GetNotesSince(userID, sinceUSN int) ([]SyncNote, error) {
-    dbNotes, err := r.Q.GetNotesSince(context.Background(), GetNotesSinceParams{
-        UserID: int64(userID),
-        Usn:    int64(sinceUSN),
-    })
-    if err != nil {
-        return nil, err
-    }
-    
-    notes := make([]SyncNote, len(dbNotes))
-    for i, n := range dbNotes {
-        notes[i] = SyncNote{
-            ID:    n.ID,
-            GUID:  n.Guid,
-            MID:   n.Mid,
-            Mod:   n.Mod,
-            USN:   int(n.Usn),
-            Tags:  n.Tags,
-            Flds:  n.Flds,
-            Sfld:  n.Sfld,
-            Csum:  n.Csum,
-            Flags: int(n.Flags),
-            Data:  n.Data,
-        }
-    }
-    return notes, nil
+    var page PullPage
+    _, _, _, err := pullNotes(context.Background(), r.Q, int64(userID), sinceBounds(sinceUSN), &page)
+    return page.Notes, err
 }
 
 // GetCardsSince returns cards modified since the given USN
 func (r *Repository) GetCardsSince(userID, sinceUSN int) ([]SyncCard, error) {
-    dbCards, err := r.Q.GetCardsSince(context.Background(), GetCardsSinceParams{
-        UserID: int64(userID),
-        Usn:    int64(sinceUSN),
-    })
-    if err != nil {
-        return nil, err
-    }
-    
-    cards := make([]SyncCard, len(dbCards))
-    for i, c := range dbCards {
-        cards[i] = SyncCard{
-            ID:             c.ID,
-            NoteID:         c.NoteID,
-            DeckID:         c.DeckID,
-            Ordinal:        int(c.Ordinal),
-            ModifiedAt:     c.ModifiedAt,
-            USN:            int(c.Usn),
-            State:          int(c.State),
-            Queue:          int(c.Queue),
-            Due:            c.Due,
-            Interval:       int(c.Interval),
-            EaseFactor:     int(c.EaseFactor),
-            Reps:           int(c.Reps),
-            Lapses:         int(c.Lapses),
-            LeftCount:      int(c.LeftCount),
-            OriginalDue:    c.OriginalDue,
-            OriginalDeckID: c.OriginalDeckID,
-            Flags:          int(c.Flags),
-            Data:           c.Data,
-        }
-    }
-    return cards, nil
+    var page PullPage
+    _, _, _, err := pullCards(context.Background(), r.Q, int64(userID), sinceBounds(sinceUSN), &page)
+    return page.Cards, err
 }
 
 // GetGravesSince returns deleted items since the given USN
 func (r *Repository) GetGravesSince(userID, sinceUSN int) ([]SyncGrave, error) {
-    dbGraves, err := r.Q.GetGravesSince(context.Background(), GetGravesSinceParams{
-        UserID: int64(userID),
-        Usn:    int64(sinceUSN),
-    })
-    if err != nil {
-        return nil, err
-    }
-    
-    graves := make([]SyncGrave, len(dbGraves))
-    for i, g := range dbGraves {
-        graves[i] = SyncGrave{
-            OID:  g.Oid,
-            Type: int(g.Type),
-        }
-    }
-    return graves, nil
+    var page PullPage
+    _, _, _, err := pullGraves(context.Background(), r.Q, int64(userID), sinceBounds(sinceUSN), &page)
+    return page.Graves, err
+}
+
+// GetRevlogSince returns review log entries stored since the given USN
+func (r *Repository) GetRevlogSince(userID, sinceUSN int) ([]SyncRevlog, error) {
+    var page PullPage
+    _, _, _, err := pullRevlog(context.Background(), r.Q, int64(userID), sinceBounds(sinceUSN), &page)
+    return page.Revlog, err
 }
 
 // ApplyGrave deletes an entity based on grave record
@@ -475,6 +667,10 @@ func (r *Repository) ApplyGrave(userID int, grave SyncGrave) error {
         return r.Q.DeleteSpecificNote(ctx, DeleteSpecificNoteParams{ID: grave.OID, UserID: uid})
 	case 2: // Deck
         return r.Q.DeleteSpecificDeck(ctx, DeleteSpecificDeckParams{ID: grave.OID, UserID: uid})
+	case 3: // Note type
+        return r.Q.DeleteSpecificNoteType(ctx, DeleteSpecificNoteTypeParams{ID: grave.OID, UserID: uid})
+	case 4: // Deck config
+        return r.Q.DeleteSpecificDeckConfig(ctx, DeleteSpecificDeckConfigParams{ID: grave.OID, UserID: uid})
 	default:
 		return fmt.Errorf("unknown grave type: %d", grave.Type)
 	}
@@ -482,14 +678,29 @@ func (r *Repository) ApplyGrave(userID int, grave SyncGrave) error {
 
 // DeleteUserData clears all sync data for a user (for full sync reset)
 func (r *Repository) DeleteUserData(userID int) error {
-    ctx := context.Background()
-    uid := int64(userID)
-    
-    if err := r.Q.DeleteUserCards(ctx, uid); err != nil { return err }
-    if err := r.Q.DeleteUserNotes(ctx, uid); err != nil { return err }
-    if err := r.Q.DeleteUserDecks(ctx, uid); err != nil { return err }
-    if err := r.Q.DeleteUserGraves(ctx, uid); err != nil { return err }
-    if err := r.Q.DeleteUserMedia(ctx, uid); err != nil { return err }
+    return deleteUserData(context.Background(), r.Q, int64(userID))
+}
+
+func deleteUserData(ctx context.Context, q *Queries, uid int64) error {
+    if err := deleteCollection(ctx, q, uid); err != nil { return err }
+    if err := q.ReleaseUserMediaBlobs(ctx, uid); err != nil { return err }
+    if err := q.ReleaseUserMediaVariantBlobs(ctx, uid); err != nil { return err }
+    if err := q.DeleteUserMedia(ctx, uid); err != nil { return err }
+    if err := q.DeleteUserMediaVariants(ctx, uid); err != nil { return err }
+    if err := q.DeleteUserPendingMedia(ctx, uid); err != nil { return err }
+    if err := q.DeleteUserMediaGraves(ctx, uid); err != nil { return err }
     
-	return r.Q.ResetUserUSN(ctx, uid)
+	return q.ResetUserUSN(ctx, uid)
+}
+
+// deleteCollection clears the user's collection, keeping media and the USN
+func deleteCollection(ctx context.Context, q *Queries, uid int64) error {
+    if err := q.DeleteUserCards(ctx, uid); err != nil { return err }
+    if err := q.DeleteUserNotes(ctx, uid); err != nil { return err }
+    if err := q.DeleteUserDecks(ctx, uid); err != nil { return err }
+    if err := q.DeleteUserGraves(ctx, uid); err != nil { return err }
+    if err := q.DeleteUserRevlog(ctx, uid); err != nil { return err }
+    if err := q.DeleteUserNoteTypes(ctx, uid); err != nil { return err }
+    if err := q.DeleteUserDeckConfigs(ctx, uid); err != nil { return err }
+    return nil
 }
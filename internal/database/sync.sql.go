@@ -10,6 +10,121 @@ import (
 	"database/sql"
 )
 
+const acquireMediaBlob = `-- name: AcquireMediaBlob :one
+UPDATE media_blobs SET refs = refs + 1, released_at = NULL WHERE sha256 = ?
+RETURNING refs
+`
+
+func (q *Queries) AcquireMediaBlob(ctx context.Context, sha256 string) (int64, error) {
+	row := q.db.QueryRowContext(ctx, acquireMediaBlob, sha256)
+	var refs int64
+	err := row.Scan(&refs)
+	return refs, err
+}
+
+const bumpCollectionSchema = `-- name: BumpCollectionSchema :exec
+UPDATE user_collections SET scm = CAST(unixepoch('subsec') * 1000 AS INTEGER), resets = resets + 1
+WHERE user_id = ?
+`
+
+func (q *Queries) BumpCollectionSchema(ctx context.Context, userID int64) error {
+	_, err := q.db.ExecContext(ctx, bumpCollectionSchema, userID)
+	return err
+}
+
+const countMediaByHash = `-- name: CountMediaByHash :one
+SELECT COUNT(*) FROM user_media WHERE user_id = ? AND hash = ?
+`
+
+type CountMediaByHashParams struct {
+	UserID int64  `json:"user_id"`
+	Hash   string `json:"hash"`
+}
+
+func (q *Queries) CountMediaByHash(ctx context.Context, arg CountMediaByHashParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countMediaByHash, arg.UserID, arg.Hash)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countOpenUploads = `-- name: CountOpenUploads :one
+SELECT COUNT(*) FROM sync_uploads
+WHERE user_id = ? AND created_at >= datetime('now', '-1 hour')
+`
+
+func (q *Queries) CountOpenUploads(ctx context.Context, userID int64) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countOpenUploads, userID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countUploadChunksFrom = `-- name: CountUploadChunksFrom :one
+SELECT COUNT(*) FROM sync_upload_chunks WHERE upload_id = ? AND seq >= ?
+`
+
+type CountUploadChunksFromParams struct {
+	UploadID string `json:"upload_id"`
+	Seq      int64  `json:"seq"`
+}
+
+func (q *Queries) CountUploadChunksFrom(ctx context.Context, arg CountUploadChunksFromParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countUploadChunksFrom, arg.UploadID, arg.Seq)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countUserCards = `-- name: CountUserCards :one
+SELECT COUNT(*) FROM user_cards WHERE user_id = ?
+`
+
+func (q *Queries) CountUserCards(ctx context.Context, userID int64) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countUserCards, userID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countUserMedia = `-- name: CountUserMedia :one
+SELECT COUNT(*) FROM user_media WHERE user_id = ?
+`
+
+func (q *Queries) CountUserMedia(ctx context.Context, userID int64) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countUserMedia, userID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countUserNotes = `-- name: CountUserNotes :one
+SELECT COUNT(*) FROM user_notes WHERE user_id = ?
+`
+
+func (q *Queries) CountUserNotes(ctx context.Context, userID int64) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countUserNotes, userID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const createHostKey = `-- name: CreateHostKey :exec
+INSERT INTO anki_host_keys (key, user_id, last_used_at)
+VALUES (?, ?, ?)
+`
+
+type CreateHostKeyParams struct {
+	Key        string `json:"key"`
+	UserID     int64  `json:"user_id"`
+	LastUsedAt int64  `json:"last_used_at"`
+}
+
+func (q *Queries) CreateHostKey(ctx context.Context, arg CreateHostKeyParams) error {
+	_, err := q.db.ExecContext(ctx, createHostKey, arg.Key, arg.UserID, arg.LastUsedAt)
+	return err
+}
+
 const createSyncMeta = `-- name: CreateSyncMeta :exec
 INSERT OR IGNORE INTO user_collections (user_id, usn, last_sync) 
 VALUES (?, 0, NULL)
@@ -20,6 +135,104 @@ func (q *Queries) CreateSyncMeta(ctx context.Context, userID int64) error {
 	return err
 }
 
+const createUpload = `-- name: CreateUpload :exec
+INSERT INTO sync_uploads (id, user_id)
+VALUES (?, ?)
+`
+
+type CreateUploadParams struct {
+	ID     string `json:"id"`
+	UserID int64  `json:"user_id"`
+}
+
+func (q *Queries) CreateUpload(ctx context.Context, arg CreateUploadParams) error {
+	_, err := q.db.ExecContext(ctx, createUpload, arg.ID, arg.UserID)
+	return err
+}
+
+const deleteExpiredPendingMedia = `-- name: DeleteExpiredPendingMedia :exec
+DELETE FROM user_media_pending WHERE created_at <= datetime(?, 'unixepoch')
+`
+
+func (q *Queries) DeleteExpiredPendingMedia(ctx context.Context, cutoff int64) error {
+	_, err := q.db.ExecContext(ctx, deleteExpiredPendingMedia, cutoff)
+	return err
+}
+
+const deleteMediaByFilename = `-- name: DeleteMediaByFilename :exec
+DELETE FROM user_media WHERE user_id = ? AND filename = ?
+`
+
+type DeleteMediaByFilenameParams struct {
+	UserID   int64  `json:"user_id"`
+	Filename string `json:"filename"`
+}
+
+func (q *Queries) DeleteMediaByFilename(ctx context.Context, arg DeleteMediaByFilenameParams) error {
+	_, err := q.db.ExecContext(ctx, deleteMediaByFilename, arg.UserID, arg.Filename)
+	return err
+}
+
+const deleteMediaGrave = `-- name: DeleteMediaGrave :exec
+DELETE FROM user_media_graves WHERE user_id = ? AND filename = ?
+`
+
+type DeleteMediaGraveParams struct {
+	UserID   int64  `json:"user_id"`
+	Filename string `json:"filename"`
+}
+
+func (q *Queries) DeleteMediaGrave(ctx context.Context, arg DeleteMediaGraveParams) error {
+	_, err := q.db.ExecContext(ctx, deleteMediaGrave, arg.UserID, arg.Filename)
+	return err
+}
+
+const deleteMediaVariants = `-- name: DeleteMediaVariants :exec
+DELETE FROM user_media_variants WHERE user_id = ? AND hash = ?
+`
+
+type DeleteMediaVariantsParams struct {
+	UserID int64  `json:"user_id"`
+	Hash   string `json:"hash"`
+}
+
+func (q *Queries) DeleteMediaVariants(ctx context.Context, arg DeleteMediaVariantsParams) error {
+	_, err := q.db.ExecContext(ctx, deleteMediaVariants, arg.UserID, arg.Hash)
+	return err
+}
+
+const deletePendingMedia = `-- name: DeletePendingMedia :exec
+DELETE FROM user_media_pending WHERE user_id = ? AND hash = ?
+`
+
+type DeletePendingMediaParams struct {
+	UserID int64  `json:"user_id"`
+	Hash   string `json:"hash"`
+}
+
+func (q *Queries) DeletePendingMedia(ctx context.Context, arg DeletePendingMediaParams) error {
+	_, err := q.db.ExecContext(ctx, deletePendingMedia, arg.UserID, arg.Hash)
+	return err
+}
+
+const deleteReleasedMediaBlob = `-- name: DeleteReleasedMediaBlob :one
+DELETE FROM media_blobs
+WHERE sha256 = ? AND refs <= 0 AND released_at <= datetime(?, 'unixepoch')
+RETURNING sha256
+`
+
+type DeleteReleasedMediaBlobParams struct {
+	Sha256 string `json:"sha256"`
+	Cutoff int64  `json:"cutoff"`
+}
+
+func (q *Queries) DeleteReleasedMediaBlob(ctx context.Context, arg DeleteReleasedMediaBlobParams) (string, error) {
+	row := q.db.QueryRowContext(ctx, deleteReleasedMediaBlob, arg.Sha256, arg.Cutoff)
+	var sha256 string
+	err := row.Scan(&sha256)
+	return sha256, err
+}
+
 const deleteSpecificCard = `-- name: DeleteSpecificCard :exec
 DELETE FROM user_cards WHERE id = ? AND user_id = ?
 `
@@ -48,6 +261,20 @@ func (q *Queries) DeleteSpecificDeck(ctx context.Context, arg DeleteSpecificDeck
 	return err
 }
 
+const deleteSpecificDeckConfig = `-- name: DeleteSpecificDeckConfig :exec
+DELETE FROM user_deck_configs WHERE id = ? AND user_id = ?
+`
+
+type DeleteSpecificDeckConfigParams struct {
+	ID     int64 `json:"id"`
+	UserID int64 `json:"user_id"`
+}
+
+func (q *Queries) DeleteSpecificDeckConfig(ctx context.Context, arg DeleteSpecificDeckConfigParams) error {
+	_, err := q.db.ExecContext(ctx, deleteSpecificDeckConfig, arg.ID, arg.UserID)
+	return err
+}
+
 const deleteSpecificNote = `-- name: DeleteSpecificNote :exec
 DELETE FROM user_notes WHERE id = ? AND user_id = ?
 `
@@ -62,6 +289,75 @@ func (q *Queries) DeleteSpecificNote(ctx context.Context, arg DeleteSpecificNote
 	return err
 }
 
+const deleteSpecificNoteType = `-- name: DeleteSpecificNoteType :exec
+DELETE FROM user_notetypes WHERE id = ? AND user_id = ?
+`
+
+type DeleteSpecificNoteTypeParams struct {
+	ID     int64 `json:"id"`
+	UserID int64 `json:"user_id"`
+}
+
+func (q *Queries) DeleteSpecificNoteType(ctx context.Context, arg DeleteSpecificNoteTypeParams) error {
+	_, err := q.db.ExecContext(ctx, deleteSpecificNoteType, arg.ID, arg.UserID)
+	return err
+}
+
+const deleteStaleHostKeys = `-- name: DeleteStaleHostKeys :exec
+DELETE FROM anki_host_keys WHERE user_id = ? AND last_used_at < ?
+`
+
+type DeleteStaleHostKeysParams struct {
+	UserID int64 `json:"user_id"`
+	Cutoff int64 `json:"cutoff"`
+}
+
+func (q *Queries) DeleteStaleHostKeys(ctx context.Context, arg DeleteStaleHostKeysParams) error {
+	_, err := q.db.ExecContext(ctx, deleteStaleHostKeys, arg.UserID, arg.Cutoff)
+	return err
+}
+
+const deleteStaleUploadChunks = `-- name: DeleteStaleUploadChunks :exec
+DELETE FROM sync_upload_chunks
+WHERE upload_id IN (
+    SELECT id FROM sync_uploads
+    WHERE user_id = ? OR created_at < datetime('now', '-1 day')
+)
+`
+
+func (q *Queries) DeleteStaleUploadChunks(ctx context.Context, userID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteStaleUploadChunks, userID)
+	return err
+}
+
+const deleteStaleUploads = `-- name: DeleteStaleUploads :exec
+DELETE FROM sync_uploads
+WHERE user_id = ? OR created_at < datetime('now', '-1 day')
+`
+
+func (q *Queries) DeleteStaleUploads(ctx context.Context, userID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteStaleUploads, userID)
+	return err
+}
+
+const deleteUpload = `-- name: DeleteUpload :exec
+DELETE FROM sync_uploads WHERE id = ?
+`
+
+func (q *Queries) DeleteUpload(ctx context.Context, id string) error {
+	_, err := q.db.ExecContext(ctx, deleteUpload, id)
+	return err
+}
+
+const deleteUploadChunks = `-- name: DeleteUploadChunks :exec
+DELETE FROM sync_upload_chunks WHERE upload_id = ?
+`
+
+func (q *Queries) DeleteUploadChunks(ctx context.Context, uploadID string) error {
+	_, err := q.db.ExecContext(ctx, deleteUploadChunks, uploadID)
+	return err
+}
+
 const deleteUserCards = `-- name: DeleteUserCards :exec
 DELETE FROM user_cards WHERE user_id = ?
 `
@@ -71,6 +367,15 @@ func (q *Queries) DeleteUserCards(ctx context.Context, userID int64) error {
 	return err
 }
 
+const deleteUserDeckConfigs = `-- name: DeleteUserDeckConfigs :exec
+DELETE FROM user_deck_configs WHERE user_id = ?
+`
+
+func (q *Queries) DeleteUserDeckConfigs(ctx context.Context, userID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteUserDeckConfigs, userID)
+	return err
+}
+
 const deleteUserDecks = `-- name: DeleteUserDecks :exec
 DELETE FROM user_decks WHERE user_id = ?
 `
@@ -89,6 +394,15 @@ func (q *Queries) DeleteUserGraves(ctx context.Context, userID int64) error {
 	return err
 }
 
+const deleteUserHostKeys = `-- name: DeleteUserHostKeys :exec
+DELETE FROM anki_host_keys WHERE user_id = ?
+`
+
+func (q *Queries) DeleteUserHostKeys(ctx context.Context, userID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteUserHostKeys, userID)
+	return err
+}
+
 const deleteUserMedia = `-- name: DeleteUserMedia :exec
 DELETE FROM user_media WHERE user_id = ?
 `
@@ -98,6 +412,24 @@ func (q *Queries) DeleteUserMedia(ctx context.Context, userID int64) error {
 	return err
 }
 
+const deleteUserMediaGraves = `-- name: DeleteUserMediaGraves :exec
+DELETE FROM user_media_graves WHERE user_id = ?
+`
+
+func (q *Queries) DeleteUserMediaGraves(ctx context.Context, userID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteUserMediaGraves, userID)
+	return err
+}
+
+const deleteUserMediaVariants = `-- name: DeleteUserMediaVariants :exec
+DELETE FROM user_media_variants WHERE user_id = ?
+`
+
+func (q *Queries) DeleteUserMediaVariants(ctx context.Context, userID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteUserMediaVariants, userID)
+	return err
+}
+
 const deleteUserNotes = `-- name: DeleteUserNotes :exec
 DELETE FROM user_notes WHERE user_id = ?
 `
@@ -107,74 +439,1166 @@ func (q *Queries) DeleteUserNotes(ctx context.Context, userID int64) error {
 	return err
 }
 
+const deleteUserNoteTypes = `-- name: DeleteUserNoteTypes :exec
+DELETE FROM user_notetypes WHERE user_id = ?
+`
+
+func (q *Queries) DeleteUserNoteTypes(ctx context.Context, userID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteUserNoteTypes, userID)
+	return err
+}
+
+const deleteUserPendingMedia = `-- name: DeleteUserPendingMedia :exec
+DELETE FROM user_media_pending WHERE user_id = ?
+`
+
+func (q *Queries) DeleteUserPendingMedia(ctx context.Context, userID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteUserPendingMedia, userID)
+	return err
+}
+
+const deleteUserRevlog = `-- name: DeleteUserRevlog :exec
+DELETE FROM user_revlog WHERE user_id = ?
+`
+
+func (q *Queries) DeleteUserRevlog(ctx context.Context, userID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteUserRevlog, userID)
+	return err
+}
+
+const findMediaBlob = `-- name: FindMediaBlob :one
+UPDATE media_blobs
+SET released_at = CASE WHEN refs <= 0 THEN CURRENT_TIMESTAMP ELSE released_at END
+WHERE sha256 = ?
+RETURNING sha256, size
+`
+
+type FindMediaBlobRow struct {
+	Sha256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+func (q *Queries) FindMediaBlob(ctx context.Context, sha256 string) (FindMediaBlobRow, error) {
+	row := q.db.QueryRowContext(ctx, findMediaBlob, sha256)
+	var i FindMediaBlobRow
+	err := row.Scan(&i.Sha256, &i.Size)
+	return i, err
+}
+
 const getCardsSince = `-- name: GetCardsSince :many
 SELECT id, note_id, deck_id, ordinal, modified_at, usn, state, queue, due, 
     interval, ease_factor, reps, lapses, left_count, original_due, original_deck_id, flags, data,
     stability, difficulty
 FROM user_cards
-WHERE user_id = ? AND usn > ?
-ORDER BY usn
+WHERE user_id = ? AND (usn, id) > (?, ?) AND usn <= ?
+ORDER BY usn, id
+LIMIT ?
 `
 
 type GetCardsSinceParams struct {
+	UserID   int64 `json:"user_id"`
+	AfterUsn int64 `json:"after_usn"`
+	AfterID  int64 `json:"after_id"`
+	UntilUsn int64 `json:"until_usn"`
+	MaxRows  int64 `json:"max_rows"`
+}
+
+type GetCardsSinceRow struct {
+	ID             int64   `json:"id"`
+	NoteID         int64   `json:"note_id"`
+	DeckID         int64   `json:"deck_id"`
+	Ordinal        int64   `json:"ordinal"`
+	ModifiedAt     int64   `json:"modified_at"`
+	Usn            int64   `json:"usn"`
+	State          int64   `json:"state"`
+	Queue          int64   `json:"queue"`
+	Due            int64   `json:"due"`
+	Interval       int64   `json:"interval"`
+	EaseFactor     int64   `json:"ease_factor"`
+	Reps           int64   `json:"reps"`
+	Lapses         int64   `json:"lapses"`
+	LeftCount      int64   `json:"left_count"`
+	OriginalDue    int64   `json:"original_due"`
+	OriginalDeckID int64   `json:"original_deck_id"`
+	Flags          int64   `json:"flags"`
+	Data           string  `json:"data"`
+	Stability      float64 `json:"stability"`
+	Difficulty     float64 `json:"difficulty"`
+}
+
+func (q *Queries) GetCardsSince(ctx context.Context, arg GetCardsSinceParams) ([]GetCardsSinceRow, error) {
+	rows, err := q.db.QueryContext(ctx, getCardsSince,
+		arg.UserID,
+		arg.AfterUsn,
+		arg.AfterID,
+		arg.UntilUsn,
+		arg.MaxRows,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetCardsSinceRow
+	for rows.Next() {
+		var i GetCardsSinceRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.NoteID,
+			&i.DeckID,
+			&i.Ordinal,
+			&i.ModifiedAt,
+			&i.Usn,
+			&i.State,
+			&i.Queue,
+			&i.Due,
+			&i.Interval,
+			&i.EaseFactor,
+			&i.Reps,
+			&i.Lapses,
+			&i.LeftCount,
+			&i.OriginalDue,
+			&i.OriginalDeckID,
+			&i.Flags,
+			&i.Data,
+			&i.Stability,
+			&i.Difficulty,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getCardState = `-- name: GetCardState :one
+SELECT usn, modified_at FROM user_cards WHERE user_id = ? AND id = ?
+`
+
+type GetCardStateParams struct {
 	UserID int64 `json:"user_id"`
-	Usn    int64 `json:"usn"`
+	ID     int64 `json:"id"`
+}
+
+type GetCardStateRow struct {
+	Usn        int64 `json:"usn"`
+	ModifiedAt int64 `json:"modified_at"`
+}
+
+func (q *Queries) GetCardState(ctx context.Context, arg GetCardStateParams) (GetCardStateRow, error) {
+	row := q.db.QueryRowContext(ctx, getCardState, arg.UserID, arg.ID)
+	var i GetCardStateRow
+	err := row.Scan(&i.Usn, &i.ModifiedAt)
+	return i, err
+}
+
+const getCollectionState = `-- name: GetCollectionState :one
+SELECT usn, mod, scm, crt FROM user_collections WHERE user_id = ?
+`
+
+type GetCollectionStateRow struct {
+	Usn int64 `json:"usn"`
+	Mod int64 `json:"mod"`
+	Scm int64 `json:"scm"`
+	Crt int64 `json:"crt"`
+}
+
+func (q *Queries) GetCollectionState(ctx context.Context, userID int64) (GetCollectionStateRow, error) {
+	row := q.db.QueryRowContext(ctx, getCollectionState, userID)
+	var i GetCollectionStateRow
+	err := row.Scan(
+		&i.Usn,
+		&i.Mod,
+		&i.Scm,
+		&i.Crt,
+	)
+	return i, err
+}
+
+const getDeckConfigsSince = `-- name: GetDeckConfigsSince :many
+SELECT id, name, mod, usn, config
+FROM user_deck_configs
+WHERE user_id = ? AND (usn, id) > (?, ?) AND usn <= ?
+ORDER BY usn, id
+LIMIT ?
+`
+
+type GetDeckConfigsSinceParams struct {
+	UserID   int64 `json:"user_id"`
+	AfterUsn int64 `json:"after_usn"`
+	AfterID  int64 `json:"after_id"`
+	UntilUsn int64 `json:"until_usn"`
+	MaxRows  int64 `json:"max_rows"`
+}
+
+type GetDeckConfigsSinceRow struct {
+	ID     int64  `json:"id"`
+	Name   string `json:"name"`
+	Mod    int64  `json:"mod"`
+	Usn    int64  `json:"usn"`
+	Config string `json:"config"`
+}
+
+func (q *Queries) GetDeckConfigsSince(ctx context.Context, arg GetDeckConfigsSinceParams) ([]GetDeckConfigsSinceRow, error) {
+	rows, err := q.db.QueryContext(ctx, getDeckConfigsSince,
+		arg.UserID,
+		arg.AfterUsn,
+		arg.AfterID,
+		arg.UntilUsn,
+		arg.MaxRows,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetDeckConfigsSinceRow
+	for rows.Next() {
+		var i GetDeckConfigsSinceRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Mod,
+			&i.Usn,
+			&i.Config,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getDeckConfigState = `-- name: GetDeckConfigState :one
+SELECT usn, mod FROM user_deck_configs WHERE user_id = ? AND id = ?
+`
+
+type GetDeckConfigStateParams struct {
+	UserID int64 `json:"user_id"`
+	ID     int64 `json:"id"`
+}
+
+type GetDeckConfigStateRow struct {
+	Usn int64 `json:"usn"`
+	Mod int64 `json:"mod"`
+}
+
+func (q *Queries) GetDeckConfigState(ctx context.Context, arg GetDeckConfigStateParams) (GetDeckConfigStateRow, error) {
+	row := q.db.QueryRowContext(ctx, getDeckConfigState, arg.UserID, arg.ID)
+	var i GetDeckConfigStateRow
+	err := row.Scan(&i.Usn, &i.Mod)
+	return i, err
+}
+
+const getDecksSince = `-- name: GetDecksSince :many
+SELECT id, name, description, config_id, created_at, modified_at, usn
+FROM user_decks
+WHERE user_id = ? AND (usn, id) > (?, ?) AND usn <= ?
+ORDER BY usn, id
+LIMIT ?
+`
+
+type GetDecksSinceParams struct {
+	UserID   int64 `json:"user_id"`
+	AfterUsn int64 `json:"after_usn"`
+	AfterID  int64 `json:"after_id"`
+	UntilUsn int64 `json:"until_usn"`
+	MaxRows  int64 `json:"max_rows"`
+}
+
+type GetDecksSinceRow struct {
+	ID          int64          `json:"id"`
+	Name        string         `json:"name"`
+	Description sql.NullString `json:"description"`
+	ConfigID    sql.NullInt64  `json:"config_id"`
+	CreatedAt   int64          `json:"created_at"`
+	ModifiedAt  int64          `json:"modified_at"`
+	Usn         int64          `json:"usn"`
+}
+
+func (q *Queries) GetDecksSince(ctx context.Context, arg GetDecksSinceParams) ([]GetDecksSinceRow, error) {
+	rows, err := q.db.QueryContext(ctx, getDecksSince,
+		arg.UserID,
+		arg.AfterUsn,
+		arg.AfterID,
+		arg.UntilUsn,
+		arg.MaxRows,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetDecksSinceRow
+	for rows.Next() {
+		var i GetDecksSinceRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Description,
+			&i.ConfigID,
+			&i.CreatedAt,
+			&i.ModifiedAt,
+			&i.Usn,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getDeckState = `-- name: GetDeckState :one
+SELECT usn, modified_at FROM user_decks WHERE user_id = ? AND id = ?
+`
+
+type GetDeckStateParams struct {
+	UserID int64 `json:"user_id"`
+	ID     int64 `json:"id"`
+}
+
+type GetDeckStateRow struct {
+	Usn        int64 `json:"usn"`
+	ModifiedAt int64 `json:"modified_at"`
+}
+
+func (q *Queries) GetDeckState(ctx context.Context, arg GetDeckStateParams) (GetDeckStateRow, error) {
+	row := q.db.QueryRowContext(ctx, getDeckState, arg.UserID, arg.ID)
+	var i GetDeckStateRow
+	err := row.Scan(&i.Usn, &i.ModifiedAt)
+	return i, err
+}
+
+const getGravesSince = `-- name: GetGravesSince :many
+SELECT id, usn, oid, type
+FROM user_graves
+WHERE user_id = ? AND (usn, id) > (?, ?) AND usn <= ?
+ORDER BY usn, id
+LIMIT ?
+`
+
+type GetGravesSinceParams struct {
+	UserID   int64 `json:"user_id"`
+	AfterUsn int64 `json:"after_usn"`
+	AfterID  int64 `json:"after_id"`
+	UntilUsn int64 `json:"until_usn"`
+	MaxRows  int64 `json:"max_rows"`
+}
+
+type GetGravesSinceRow struct {
+	ID   int64 `json:"id"`
+	Usn  int64 `json:"usn"`
+	Oid  int64 `json:"oid"`
+	Type int64 `json:"type"`
+}
+
+func (q *Queries) GetGravesSince(ctx context.Context, arg GetGravesSinceParams) ([]GetGravesSinceRow, error) {
+	rows, err := q.db.QueryContext(ctx, getGravesSince,
+		arg.UserID,
+		arg.AfterUsn,
+		arg.AfterID,
+		arg.UntilUsn,
+		arg.MaxRows,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetGravesSinceRow
+	for rows.Next() {
+		var i GetGravesSinceRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Usn,
+			&i.Oid,
+			&i.Type,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getGraveUsn = `-- name: GetGraveUsn :one
+SELECT CAST(COALESCE(MAX(usn), 0) AS INTEGER) FROM user_graves
+WHERE user_id = ? AND type = ? AND oid = ?
+`
+
+type GetGraveUsnParams struct {
+	UserID int64 `json:"user_id"`
+	Type   int64 `json:"type"`
+	Oid    int64 `json:"oid"`
+}
+
+func (q *Queries) GetGraveUsn(ctx context.Context, arg GetGraveUsnParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, getGraveUsn, arg.UserID, arg.Type, arg.Oid)
+	var usn int64
+	err := row.Scan(&usn)
+	return usn, err
+}
+
+const getMaxNewCardDue = `-- name: GetMaxNewCardDue :one
+SELECT CAST(COALESCE(MAX(due), 0) AS INTEGER) FROM user_cards WHERE user_id = ? AND queue = 0
+`
+
+func (q *Queries) GetMaxNewCardDue(ctx context.Context, userID int64) (int64, error) {
+	row := q.db.QueryRowContext(ctx, getMaxNewCardDue, userID)
+	var due int64
+	err := row.Scan(&due)
+	return due, err
+}
+
+const getMediaByFilename = `-- name: GetMediaByFilename :one
+SELECT hash, size, blob, mtime FROM user_media WHERE user_id = ? AND filename = ? LIMIT 1
+`
+
+type GetMediaByFilenameParams struct {
+	UserID   int64  `json:"user_id"`
+	Filename string `json:"filename"`
+}
+
+type GetMediaByFilenameRow struct {
+	Hash  string `json:"hash"`
+	Size  int64  `json:"size"`
+	Blob  string `json:"blob"`
+	Mtime int64  `json:"mtime"`
+}
+
+func (q *Queries) GetMediaByFilename(ctx context.Context, arg GetMediaByFilenameParams) (GetMediaByFilenameRow, error) {
+	row := q.db.QueryRowContext(ctx, getMediaByFilename, arg.UserID, arg.Filename)
+	var i GetMediaByFilenameRow
+	err := row.Scan(
+		&i.Hash,
+		&i.Size,
+		&i.Blob,
+		&i.Mtime,
+	)
+	return i, err
+}
+
+const getMediaByHash = `-- name: GetMediaByHash :one
+SELECT filename, size, blob FROM user_media WHERE user_id = ? AND hash = ? LIMIT 1
+`
+
+type GetMediaByHashParams struct {
+	UserID int64  `json:"user_id"`
+	Hash   string `json:"hash"`
+}
+
+type GetMediaByHashRow struct {
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+	Blob     string `json:"blob"`
+}
+
+func (q *Queries) GetMediaByHash(ctx context.Context, arg GetMediaByHashParams) (GetMediaByHashRow, error) {
+	row := q.db.QueryRowContext(ctx, getMediaByHash, arg.UserID, arg.Hash)
+	var i GetMediaByHashRow
+	err := row.Scan(&i.Filename, &i.Size, &i.Blob)
+	return i, err
+}
+
+const getMediaBytes = `-- name: GetMediaBytes :one
+SELECT CAST(COALESCE(SUM(size), 0) AS INTEGER) FROM user_media WHERE user_id = ?
+`
+
+func (q *Queries) GetMediaBytes(ctx context.Context, userID int64) (int64, error) {
+	row := q.db.QueryRowContext(ctx, getMediaBytes, userID)
+	var size int64
+	err := row.Scan(&size)
+	return size, err
+}
+
+const getMediaChanges = `-- name: GetMediaChanges :many
+SELECT filename, hash, size, usn, deleted, id FROM (
+    SELECT user_id, filename, hash, size, usn, 0 AS deleted, id FROM user_media
+    UNION ALL
+    SELECT user_id, filename, hash, 0 AS size, usn, 1 AS deleted, id FROM user_media_graves
+)
+WHERE user_id = ? AND (usn, deleted, id) > (?, ?, ?)
+    AND usn <= ?
+ORDER BY usn, deleted, id
+LIMIT ?
+`
+
+type GetMediaChangesParams struct {
+	UserID       int64 `json:"user_id"`
+	AfterUsn     int64 `json:"after_usn"`
+	AfterDeleted int64 `json:"after_deleted"`
+	AfterID      int64 `json:"after_id"`
+	UntilUsn     int64 `json:"until_usn"`
+	MaxRows      int64 `json:"max_rows"`
+}
+
+type GetMediaChangesRow struct {
+	Filename string `json:"filename"`
+	Hash     string `json:"hash"`
+	Size     int64  `json:"size"`
+	Usn      int64  `json:"usn"`
+	Deleted  int64  `json:"deleted"`
+	ID       int64  `json:"id"`
+}
+
+func (q *Queries) GetMediaChanges(ctx context.Context, arg GetMediaChangesParams) ([]GetMediaChangesRow, error) {
+	rows, err := q.db.QueryContext(ctx, getMediaChanges,
+		arg.UserID,
+		arg.AfterUsn,
+		arg.AfterDeleted,
+		arg.AfterID,
+		arg.UntilUsn,
+		arg.MaxRows,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetMediaChangesRow
+	for rows.Next() {
+		var i GetMediaChangesRow
+		if err := rows.Scan(
+			&i.Filename,
+			&i.Hash,
+			&i.Size,
+			&i.Usn,
+			&i.Deleted,
+			&i.ID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getMediaUSN = `-- name: GetMediaUSN :one
+SELECT CAST(COALESCE(MAX(usn), 0) AS INTEGER) FROM (
+    SELECT user_id, usn FROM user_media
+    UNION ALL
+    SELECT user_id, usn FROM user_media_graves
+)
+WHERE user_id = ?
+`
+
+func (q *Queries) GetMediaUSN(ctx context.Context, userID int64) (int64, error) {
+	row := q.db.QueryRowContext(ctx, getMediaUSN, userID)
+	var usn int64
+	err := row.Scan(&usn)
+	return usn, err
+}
+
+const getNotesSince = `-- name: GetNotesSince :many
+SELECT id, guid, mid, mod, usn, tags, flds, sfld, csum, flags, data
+FROM user_notes
+WHERE user_id = ? AND (usn, id) > (?, ?) AND usn <= ?
+ORDER BY usn, id
+LIMIT ?
+`
+
+type GetNotesSinceParams struct {
+	UserID   int64 `json:"user_id"`
+	AfterUsn int64 `json:"after_usn"`
+	AfterID  int64 `json:"after_id"`
+	UntilUsn int64 `json:"until_usn"`
+	MaxRows  int64 `json:"max_rows"`
+}
+
+type GetNotesSinceRow struct {
+	ID    int64  `json:"id"`
+	Guid  string `json:"guid"`
+	Mid   int64  `json:"mid"`
+	Mod   int64  `json:"mod"`
+	Usn   int64  `json:"usn"`
+	Tags  string `json:"tags"`
+	Flds  string `json:"flds"`
+	Sfld  string `json:"sfld"`
+	Csum  int64  `json:"csum"`
+	Flags int64  `json:"flags"`
+	Data  string `json:"data"`
+}
+
+func (q *Queries) GetNotesSince(ctx context.Context, arg GetNotesSinceParams) ([]GetNotesSinceRow, error) {
+	rows, err := q.db.QueryContext(ctx, getNotesSince,
+		arg.UserID,
+		arg.AfterUsn,
+		arg.AfterID,
+		arg.UntilUsn,
+		arg.MaxRows,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetNotesSinceRow
+	for rows.Next() {
+		var i GetNotesSinceRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Guid,
+			&i.Mid,
+			&i.Mod,
+			&i.Usn,
+			&i.Tags,
+			&i.Flds,
+			&i.Sfld,
+			&i.Csum,
+			&i.Flags,
+			&i.Data,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getNoteState = `-- name: GetNoteState :one
+SELECT usn, mod FROM user_notes WHERE user_id = ? AND id = ?
+`
+
+type GetNoteStateParams struct {
+	UserID int64 `json:"user_id"`
+	ID     int64 `json:"id"`
+}
+
+type GetNoteStateRow struct {
+	Usn int64 `json:"usn"`
+	Mod int64 `json:"mod"`
+}
+
+func (q *Queries) GetNoteState(ctx context.Context, arg GetNoteStateParams) (GetNoteStateRow, error) {
+	row := q.db.QueryRowContext(ctx, getNoteState, arg.UserID, arg.ID)
+	var i GetNoteStateRow
+	err := row.Scan(&i.Usn, &i.Mod)
+	return i, err
+}
+
+const getNoteTypesSince = `-- name: GetNoteTypesSince :many
+SELECT id, name, type, mod, usn, sort_field, fields, templates, css, data
+FROM user_notetypes
+WHERE user_id = ? AND (usn, id) > (?, ?) AND usn <= ?
+ORDER BY usn, id
+LIMIT ?
+`
+
+type GetNoteTypesSinceParams struct {
+	UserID   int64 `json:"user_id"`
+	AfterUsn int64 `json:"after_usn"`
+	AfterID  int64 `json:"after_id"`
+	UntilUsn int64 `json:"until_usn"`
+	MaxRows  int64 `json:"max_rows"`
+}
+
+type GetNoteTypesSinceRow struct {
+	ID        int64  `json:"id"`
+	Name      string `json:"name"`
+	Type      int64  `json:"type"`
+	Mod       int64  `json:"mod"`
+	Usn       int64  `json:"usn"`
+	SortField int64  `json:"sort_field"`
+	Fields    string `json:"fields"`
+	Templates string `json:"templates"`
+	Css       string `json:"css"`
+	Data      string `json:"data"`
+}
+
+func (q *Queries) GetNoteTypesSince(ctx context.Context, arg GetNoteTypesSinceParams) ([]GetNoteTypesSinceRow, error) {
+	rows, err := q.db.QueryContext(ctx, getNoteTypesSince,
+		arg.UserID,
+		arg.AfterUsn,
+		arg.AfterID,
+		arg.UntilUsn,
+		arg.MaxRows,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetNoteTypesSinceRow
+	for rows.Next() {
+		var i GetNoteTypesSinceRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Type,
+			&i.Mod,
+			&i.Usn,
+			&i.SortField,
+			&i.Fields,
+			&i.Templates,
+			&i.Css,
+			&i.Data,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getNoteTypeState = `-- name: GetNoteTypeState :one
+SELECT usn, mod FROM user_notetypes WHERE user_id = ? AND id = ?
+`
+
+type GetNoteTypeStateParams struct {
+	UserID int64 `json:"user_id"`
+	ID     int64 `json:"id"`
+}
+
+type GetNoteTypeStateRow struct {
+	Usn int64 `json:"usn"`
+	Mod int64 `json:"mod"`
+}
+
+func (q *Queries) GetNoteTypeState(ctx context.Context, arg GetNoteTypeStateParams) (GetNoteTypeStateRow, error) {
+	row := q.db.QueryRowContext(ctx, getNoteTypeState, arg.UserID, arg.ID)
+	var i GetNoteTypeStateRow
+	err := row.Scan(&i.Usn, &i.Mod)
+	return i, err
+}
+
+const getPendingMedia = `-- name: GetPendingMedia :one
+SELECT filename, size FROM user_media_pending WHERE user_id = ? AND hash = ?
+`
+
+type GetPendingMediaParams struct {
+	UserID int64  `json:"user_id"`
+	Hash   string `json:"hash"`
+}
+
+type GetPendingMediaRow struct {
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+}
+
+func (q *Queries) GetPendingMedia(ctx context.Context, arg GetPendingMediaParams) (GetPendingMediaRow, error) {
+	row := q.db.QueryRowContext(ctx, getPendingMedia, arg.UserID, arg.Hash)
+	var i GetPendingMediaRow
+	err := row.Scan(&i.Filename, &i.Size)
+	return i, err
+}
+
+const getPullState = `-- name: GetPullState :one
+SELECT usn, resets FROM user_collections WHERE user_id = ?
+`
+
+type GetPullStateRow struct {
+	Usn    int64 `json:"usn"`
+	Resets int64 `json:"resets"`
+}
+
+func (q *Queries) GetPullState(ctx context.Context, userID int64) (GetPullStateRow, error) {
+	row := q.db.QueryRowContext(ctx, getPullState, userID)
+	var i GetPullStateRow
+	err := row.Scan(&i.Usn, &i.Resets)
+	return i, err
+}
+
+const getRevlogSince = `-- name: GetRevlogSince :many
+SELECT id, cid, usn, ease, ivl, last_ivl, factor, time, type
+FROM user_revlog
+WHERE user_id = ? AND (usn, id) > (?, ?) AND usn <= ?
+ORDER BY usn, id
+LIMIT ?
+`
+
+type GetRevlogSinceParams struct {
+	UserID   int64 `json:"user_id"`
+	AfterUsn int64 `json:"after_usn"`
+	AfterID  int64 `json:"after_id"`
+	UntilUsn int64 `json:"until_usn"`
+	MaxRows  int64 `json:"max_rows"`
+}
+
+type GetRevlogSinceRow struct {
+	ID      int64 `json:"id"`
+	Cid     int64 `json:"cid"`
+	Usn     int64 `json:"usn"`
+	Ease    int64 `json:"ease"`
+	Ivl     int64 `json:"ivl"`
+	LastIvl int64 `json:"last_ivl"`
+	Factor  int64 `json:"factor"`
+	Time    int64 `json:"time"`
+	Type    int64 `json:"type"`
+}
+
+func (q *Queries) GetRevlogSince(ctx context.Context, arg GetRevlogSinceParams) ([]GetRevlogSinceRow, error) {
+	rows, err := q.db.QueryContext(ctx, getRevlogSince,
+		arg.UserID,
+		arg.AfterUsn,
+		arg.AfterID,
+		arg.UntilUsn,
+		arg.MaxRows,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetRevlogSinceRow
+	for rows.Next() {
+		var i GetRevlogSinceRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Cid,
+			&i.Usn,
+			&i.Ease,
+			&i.Ivl,
+			&i.LastIvl,
+			&i.Factor,
+			&i.Time,
+			&i.Type,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getSyncMeta = `-- name: GetSyncMeta :one
+SELECT usn, last_sync FROM user_collections 
+WHERE user_id = ? LIMIT 1
+`
+
+type GetSyncMetaRow struct {
+	Usn      int64        `json:"usn"`
+	LastSync sql.NullTime `json:"last_sync"`
+}
+
+func (q *Queries) GetSyncMeta(ctx context.Context, userID int64) (GetSyncMetaRow, error) {
+	row := q.db.QueryRowContext(ctx, getSyncMeta, userID)
+	var i GetSyncMetaRow
+	err := row.Scan(&i.Usn, &i.LastSync)
+	return i, err
+}
+
+const getUploadChunk = `-- name: GetUploadChunk :one
+SELECT payload FROM sync_upload_chunks WHERE upload_id = ? AND seq = ?
+`
+
+type GetUploadChunkParams struct {
+	UploadID string `json:"upload_id"`
+	Seq      int64  `json:"seq"`
+}
+
+func (q *Queries) GetUploadChunk(ctx context.Context, arg GetUploadChunkParams) ([]byte, error) {
+	row := q.db.QueryRowContext(ctx, getUploadChunk, arg.UploadID, arg.Seq)
+	var payload []byte
+	err := row.Scan(&payload)
+	return payload, err
+}
+
+const getUploadOwner = `-- name: GetUploadOwner :one
+SELECT user_id FROM sync_uploads WHERE id = ?
+`
+
+func (q *Queries) GetUploadOwner(ctx context.Context, id string) (int64, error) {
+	row := q.db.QueryRowContext(ctx, getUploadOwner, id)
+	var userID int64
+	err := row.Scan(&userID)
+	return userID, err
+}
+
+const getUSN = `-- name: GetUSN :one
+SELECT usn FROM user_collections WHERE user_id = ?
+`
+
+func (q *Queries) GetUSN(ctx context.Context, userID int64) (int64, error) {
+	row := q.db.QueryRowContext(ctx, getUSN, userID)
+	var usn int64
+	err := row.Scan(&usn)
+	return usn, err
+}
+
+const initCollectionStamps = `-- name: InitCollectionStamps :exec
+UPDATE user_collections
+SET scm = CASE WHEN scm = 0 THEN ? ELSE scm END,
+    crt = CASE WHEN crt = 0 THEN ? ELSE crt END
+WHERE user_id = ?
+`
+
+type InitCollectionStampsParams struct {
+	Scm    int64 `json:"scm"`
+	Crt    int64 `json:"crt"`
+	UserID int64 `json:"user_id"`
+}
+
+func (q *Queries) InitCollectionStamps(ctx context.Context, arg InitCollectionStampsParams) error {
+	_, err := q.db.ExecContext(ctx, initCollectionStamps, arg.Scm, arg.Crt, arg.UserID)
+	return err
+}
+
+const insertMediaBlob = `-- name: InsertMediaBlob :exec
+INSERT INTO media_blobs (sha256, sha1, size, refs, released_at)
+VALUES (?, ?, ?, 0, CURRENT_TIMESTAMP)
+ON CONFLICT(sha256) DO UPDATE
+SET released_at = CASE WHEN refs <= 0 THEN CURRENT_TIMESTAMP ELSE released_at END
+`
+
+type InsertMediaBlobParams struct {
+	Sha256 string `json:"sha256"`
+	Sha1   string `json:"sha1"`
+	Size   int64  `json:"size"`
+}
+
+func (q *Queries) InsertMediaBlob(ctx context.Context, arg InsertMediaBlobParams) error {
+	_, err := q.db.ExecContext(ctx, insertMediaBlob, arg.Sha256, arg.Sha1, arg.Size)
+	return err
+}
+
+const insertMediaGrave = `-- name: InsertMediaGrave :exec
+INSERT OR REPLACE INTO user_media_graves (user_id, filename, hash, usn)
+VALUES (?, ?, ?, ?)
+`
+
+type InsertMediaGraveParams struct {
+	UserID   int64  `json:"user_id"`
+	Filename string `json:"filename"`
+	Hash     string `json:"hash"`
+	Usn      int64  `json:"usn"`
+}
+
+func (q *Queries) InsertMediaGrave(ctx context.Context, arg InsertMediaGraveParams) error {
+	_, err := q.db.ExecContext(ctx, insertMediaGrave,
+		arg.UserID,
+		arg.Filename,
+		arg.Hash,
+		arg.Usn,
+	)
+	return err
+}
+
+const insertMediaVariant = `-- name: InsertMediaVariant :exec
+INSERT INTO user_media_variants (user_id, hash, content_type, blob, size, source)
+VALUES (?, ?, ?, ?, ?, ?)
+`
+
+type InsertMediaVariantParams struct {
+	UserID      int64  `json:"user_id"`
+	Hash        string `json:"hash"`
+	ContentType string `json:"content_type"`
+	Blob        string `json:"blob"`
+	Size        int64  `json:"size"`
+	Source      string `json:"source"`
+}
+
+func (q *Queries) InsertMediaVariant(ctx context.Context, arg InsertMediaVariantParams) error {
+	_, err := q.db.ExecContext(ctx, insertMediaVariant,
+		arg.UserID,
+		arg.Hash,
+		arg.ContentType,
+		arg.Blob,
+		arg.Size,
+		arg.Source,
+	)
+	return err
+}
+
+const insertRevlog = `-- name: InsertRevlog :exec
+INSERT INTO user_revlog (id, user_id, cid, usn, ease, ivl, last_ivl, factor, time, type)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(user_id, id) DO NOTHING
+`
+
+type InsertRevlogParams struct {
+	ID      int64 `json:"id"`
+	UserID  int64 `json:"user_id"`
+	Cid     int64 `json:"cid"`
+	Usn     int64 `json:"usn"`
+	Ease    int64 `json:"ease"`
+	Ivl     int64 `json:"ivl"`
+	LastIvl int64 `json:"last_ivl"`
+	Factor  int64 `json:"factor"`
+	Time    int64 `json:"time"`
+	Type    int64 `json:"type"`
+}
+
+func (q *Queries) InsertRevlog(ctx context.Context, arg InsertRevlogParams) error {
+	_, err := q.db.ExecContext(ctx, insertRevlog,
+		arg.ID,
+		arg.UserID,
+		arg.Cid,
+		arg.Usn,
+		arg.Ease,
+		arg.Ivl,
+		arg.LastIvl,
+		arg.Factor,
+		arg.Time,
+		arg.Type,
+	)
+	return err
+}
+
+const listMediaByHash = `-- name: ListMediaByHash :many
+SELECT filename, blob FROM user_media WHERE user_id = ? AND hash = ?
+`
+
+type ListMediaByHashParams struct {
+	UserID int64  `json:"user_id"`
+	Hash   string `json:"hash"`
+}
+
+type ListMediaByHashRow struct {
+	Filename string `json:"filename"`
+	Blob     string `json:"blob"`
+}
+
+func (q *Queries) ListMediaByHash(ctx context.Context, arg ListMediaByHashParams) ([]ListMediaByHashRow, error) {
+	rows, err := q.db.QueryContext(ctx, listMediaByHash, arg.UserID, arg.Hash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListMediaByHashRow
+	for rows.Next() {
+		var i ListMediaByHashRow
+		if err := rows.Scan(&i.Filename, &i.Blob); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listMediaUsers = `-- name: ListMediaUsers :many
+SELECT DISTINCT user_id FROM user_media ORDER BY user_id
+`
+
+func (q *Queries) ListMediaUsers(ctx context.Context) ([]int64, error) {
+	rows, err := q.db.QueryContext(ctx, listMediaUsers)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []int64
+	for rows.Next() {
+		var userID int64
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		items = append(items, userID)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listMediaVariants = `-- name: ListMediaVariants :many
+SELECT content_type, blob, size FROM user_media_variants
+WHERE user_id = ? AND hash = ?
+ORDER BY size
+`
+
+type ListMediaVariantsParams struct {
+	UserID int64  `json:"user_id"`
+	Hash   string `json:"hash"`
+}
+
+type ListMediaVariantsRow struct {
+	ContentType string `json:"content_type"`
+	Blob        string `json:"blob"`
+	Size        int64  `json:"size"`
+}
+
+func (q *Queries) ListMediaVariants(ctx context.Context, arg ListMediaVariantsParams) ([]ListMediaVariantsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listMediaVariants, arg.UserID, arg.Hash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListMediaVariantsRow
+	for rows.Next() {
+		var i ListMediaVariantsRow
+		if err := rows.Scan(&i.ContentType, &i.Blob, &i.Size); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
-type GetCardsSinceRow struct {
-	ID             int64  `json:"id"`
-	NoteID         int64  `json:"note_id"`
-	DeckID         int64  `json:"deck_id"`
-	Ordinal        int64  `json:"ordinal"`
-	ModifiedAt     int64  `json:"modified_at"`
-	Usn            int64  `json:"usn"`
-	State          int64  `json:"state"`
-	Queue          int64  `json:"queue"`
-	Due            int64  `json:"due"`
-	Interval       int64  `json:"interval"`
-	EaseFactor     int64  `json:"ease_factor"`
-	Reps           int64  `json:"reps"`
-	Lapses         int64  `json:"lapses"`
-	LeftCount      int64  `json:"left_count"`
-	OriginalDue    int64  `json:"original_due"`
-	OriginalDeckID int64  `json:"original_deck_id"`
-	Flags          int64   `json:"flags"`
-	Data           string  `json:"data"`
-	Stability      float64 `json:"stability"`
-	Difficulty     float64 `json:"difficulty"`
+const listReleasedMediaBlobs = `-- name: ListReleasedMediaBlobs :many
+SELECT sha256, size FROM media_blobs
+WHERE refs <= 0 AND released_at <= datetime(?, 'unixepoch')
+ORDER BY sha256
+`
+
+type ListReleasedMediaBlobsRow struct {
+	Sha256 string `json:"sha256"`
+	Size   int64  `json:"size"`
 }
 
-func (q *Queries) GetCardsSince(ctx context.Context, arg GetCardsSinceParams) ([]GetCardsSinceRow, error) {
-	rows, err := q.db.QueryContext(ctx, getCardsSince, arg.UserID, arg.Usn)
+func (q *Queries) ListReleasedMediaBlobs(ctx context.Context, cutoff int64) ([]ListReleasedMediaBlobsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listReleasedMediaBlobs, cutoff)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []GetCardsSinceRow
+	var items []ListReleasedMediaBlobsRow
 	for rows.Next() {
-		var i GetCardsSinceRow
-		if err := rows.Scan(
-			&i.ID,
-			&i.NoteID,
-			&i.DeckID,
-			&i.Ordinal,
-			&i.ModifiedAt,
-			&i.Usn,
-			&i.State,
-			&i.Queue,
-			&i.Due,
-			&i.Interval,
-			&i.EaseFactor,
-			&i.Reps,
-			&i.Lapses,
-			&i.LeftCount,
-			&i.OriginalDue,
-			&i.OriginalDeckID,
-			&i.Flags,
-			&i.Data,
-			&i.Stability,
-			&i.Difficulty,
-		); err != nil {
+		var i ListReleasedMediaBlobsRow
+		if err := rows.Scan(&i.Sha256, &i.Size); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -188,45 +1612,33 @@ func (q *Queries) GetCardsSince(ctx context.Context, arg GetCardsSinceParams) ([
 	return items, nil
 }
 
-const getDecksSince = `-- name: GetDecksSince :many
-SELECT id, name, description, config_id, created_at, modified_at, usn
-FROM user_decks
-WHERE user_id = ? AND usn > ?
-ORDER BY usn
+const listUserMedia = `-- name: ListUserMedia :many
+SELECT filename, hash, size, blob, mtime FROM user_media WHERE user_id = ? ORDER BY filename
 `
 
-type GetDecksSinceParams struct {
-	UserID int64 `json:"user_id"`
-	Usn    int64 `json:"usn"`
-}
-
-type GetDecksSinceRow struct {
-	ID          int64          `json:"id"`
-	Name        string         `json:"name"`
-	Description sql.NullString `json:"description"`
-	ConfigID    sql.NullInt64  `json:"config_id"`
-	CreatedAt   int64          `json:"created_at"`
-	ModifiedAt  int64          `json:"modified_at"`
-	Usn         int64          `json:"usn"`
+type ListUserMediaRow struct {
+	Filename string `json:"filename"`
+	Hash     string `json:"hash"`
+	Size     int64  `json:"size"`
+	Blob     string `json:"blob"`
+	Mtime    int64  `json:"mtime"`
 }
 
-func (q *Queries) GetDecksSince(ctx context.Context, arg GetDecksSinceParams) ([]GetDecksSinceRow, error) {
-	rows, err := q.db.QueryContext(ctx, getDecksSince, arg.UserID, arg.Usn)
+func (q *Queries) ListUserMedia(ctx context.Context, userID int64) ([]ListUserMediaRow, error) {
+	rows, err := q.db.QueryContext(ctx, listUserMedia, userID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []GetDecksSinceRow
+	var items []ListUserMediaRow
 	for rows.Next() {
-		var i GetDecksSinceRow
+		var i ListUserMediaRow
 		if err := rows.Scan(
-			&i.ID,
-			&i.Name,
-			&i.Description,
-			&i.ConfigID,
-			&i.CreatedAt,
-			&i.ModifiedAt,
-			&i.Usn,
+			&i.Filename,
+			&i.Hash,
+			&i.Size,
+			&i.Blob,
+			&i.Mtime,
 		); err != nil {
 			return nil, err
 		}
@@ -241,35 +1653,56 @@ func (q *Queries) GetDecksSince(ctx context.Context, arg GetDecksSinceParams) ([
 	return items, nil
 }
 
-const getGravesSince = `-- name: GetGravesSince :many
-SELECT oid, type FROM user_graves
-WHERE user_id = ? AND usn > ?
-ORDER BY usn
+const listUserNoteFields = `-- name: ListUserNoteFields :many
+SELECT flds FROM user_notes WHERE user_id = ?
 `
 
-type GetGravesSinceParams struct {
-	UserID int64 `json:"user_id"`
-	Usn    int64 `json:"usn"`
+func (q *Queries) ListUserNoteFields(ctx context.Context, userID int64) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, listUserNoteFields, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var flds string
+		if err := rows.Scan(&flds); err != nil {
+			return nil, err
+		}
+		items = append(items, flds)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
-type GetGravesSinceRow struct {
-	Oid  int64 `json:"oid"`
-	Type int64 `json:"type"`
+const listUserPendingMedia = `-- name: ListUserPendingMedia :many
+SELECT hash FROM user_media_pending
+WHERE user_id = ? AND created_at > datetime(?, 'unixepoch')
+`
+
+type ListUserPendingMediaParams struct {
+	UserID    int64 `json:"user_id"`
+	Cutoff    int64 `json:"cutoff"`
 }
 
-func (q *Queries) GetGravesSince(ctx context.Context, arg GetGravesSinceParams) ([]GetGravesSinceRow, error) {
-	rows, err := q.db.QueryContext(ctx, getGravesSince, arg.UserID, arg.Usn)
+func (q *Queries) ListUserPendingMedia(ctx context.Context, arg ListUserPendingMediaParams) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, listUserPendingMedia, arg.UserID, arg.Cutoff)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []GetGravesSinceRow
+	var items []string
 	for rows.Next() {
-		var i GetGravesSinceRow
-		if err := rows.Scan(&i.Oid, &i.Type); err != nil {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
 			return nil, err
 		}
-		items = append(items, i)
+		items = append(items, hash)
 	}
 	if err := rows.Close(); err != nil {
 		return nil, err
@@ -280,54 +1713,26 @@ func (q *Queries) GetGravesSince(ctx context.Context, arg GetGravesSinceParams)
 	return items, nil
 }
 
-const getNotesSince = `-- name: GetNotesSince :many
-SELECT id, guid, mid, mod, usn, tags, flds, sfld, csum, flags, data
-FROM user_notes
-WHERE user_id = ? AND usn > ?
-ORDER BY usn
+const listVariantsBySource = `-- name: ListVariantsBySource :many
+SELECT DISTINCT content_type, blob, size FROM user_media_variants WHERE source = ?
 `
 
-type GetNotesSinceParams struct {
-	UserID int64 `json:"user_id"`
-	Usn    int64 `json:"usn"`
-}
-
-type GetNotesSinceRow struct {
-	ID    int64  `json:"id"`
-	Guid  string `json:"guid"`
-	Mid   int64  `json:"mid"`
-	Mod   int64  `json:"mod"`
-	Usn   int64  `json:"usn"`
-	Tags  string `json:"tags"`
-	Flds  string `json:"flds"`
-	Sfld  string `json:"sfld"`
-	Csum  int64  `json:"csum"`
-	Flags int64  `json:"flags"`
-	Data  string `json:"data"`
+type ListVariantsBySourceRow struct {
+	ContentType string `json:"content_type"`
+	Blob        string `json:"blob"`
+	Size        int64  `json:"size"`
 }
 
-func (q *Queries) GetNotesSince(ctx context.Context, arg GetNotesSinceParams) ([]GetNotesSinceRow, error) {
-	rows, err := q.db.QueryContext(ctx, getNotesSince, arg.UserID, arg.Usn)
+func (q *Queries) ListVariantsBySource(ctx context.Context, source string) ([]ListVariantsBySourceRow, error) {
+	rows, err := q.db.QueryContext(ctx, listVariantsBySource, source)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []GetNotesSinceRow
+	var items []ListVariantsBySourceRow
 	for rows.Next() {
-		var i GetNotesSinceRow
-		if err := rows.Scan(
-			&i.ID,
-			&i.Guid,
-			&i.Mid,
-			&i.Mod,
-			&i.Usn,
-			&i.Tags,
-			&i.Flds,
-			&i.Sfld,
-			&i.Csum,
-			&i.Flags,
-			&i.Data,
-		); err != nil {
+		var i ListVariantsBySourceRow
+		if err := rows.Scan(&i.ContentType, &i.Blob, &i.Size); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -341,32 +1746,35 @@ func (q *Queries) GetNotesSince(ctx context.Context, arg GetNotesSinceParams) ([
 	return items, nil
 }
 
-const getSyncMeta = `-- name: GetSyncMeta :one
-SELECT usn, last_sync FROM user_collections 
-WHERE user_id = ? LIMIT 1
+const putUploadChunk = `-- name: PutUploadChunk :execrows
+INSERT OR REPLACE INTO sync_upload_chunks (upload_id, seq, payload)
+SELECT ?1, ?2, ?3
+WHERE (
+    SELECT COALESCE(SUM(LENGTH(payload)), 0) FROM sync_upload_chunks
+    WHERE upload_id = ?1 AND seq != ?2
+) + LENGTH(?3) <= ?4
 `
 
-type GetSyncMetaRow struct {
-	Usn      int64        `json:"usn"`
-	LastSync sql.NullTime `json:"last_sync"`
-}
-
-func (q *Queries) GetSyncMeta(ctx context.Context, userID int64) (GetSyncMetaRow, error) {
-	row := q.db.QueryRowContext(ctx, getSyncMeta, userID)
-	var i GetSyncMetaRow
-	err := row.Scan(&i.Usn, &i.LastSync)
-	return i, err
+type PutUploadChunkParams struct {
+	UploadID string `json:"upload_id"`
+	Seq      int64  `json:"seq"`
+	Payload  []byte `json:"payload"`
+	MaxBytes int64  `json:"max_bytes"`
 }
 
-const getUSN = `-- name: GetUSN :one
-SELECT usn FROM user_collections WHERE user_id = ?
-`
-
-func (q *Queries) GetUSN(ctx context.Context, userID int64) (int64, error) {
-	row := q.db.QueryRowContext(ctx, getUSN, userID)
-	var usn int64
-	err := row.Scan(&usn)
-	return usn, err
+// Stores the chunk only while the upload's staged bytes, counting the chunk
+// and not the one it replaces, stay within max_bytes
+func (q *Queries) PutUploadChunk(ctx context.Context, arg PutUploadChunkParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, putUploadChunk,
+		arg.UploadID,
+		arg.Seq,
+		arg.Payload,
+		arg.MaxBytes,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
 
 const recordGrave = `-- name: RecordGrave :exec
@@ -391,8 +1799,63 @@ func (q *Queries) RecordGrave(ctx context.Context, arg RecordGraveParams) error
 	return err
 }
 
+const releaseMediaBlob = `-- name: ReleaseMediaBlob :exec
+UPDATE media_blobs SET refs = refs - 1, released_at = CURRENT_TIMESTAMP WHERE sha256 = ?
+`
+
+func (q *Queries) ReleaseMediaBlob(ctx context.Context, sha256 string) error {
+	_, err := q.db.ExecContext(ctx, releaseMediaBlob, sha256)
+	return err
+}
+
+const releaseUserMediaBlobs = `-- name: ReleaseUserMediaBlobs :exec
+UPDATE media_blobs
+SET refs = refs - (SELECT COUNT(*) FROM user_media m WHERE m.user_id = ?1 AND m.blob = media_blobs.sha256),
+    released_at = CURRENT_TIMESTAMP
+WHERE sha256 IN (SELECT blob FROM user_media WHERE user_id = ?1)
+`
+
+func (q *Queries) ReleaseUserMediaBlobs(ctx context.Context, userID int64) error {
+	_, err := q.db.ExecContext(ctx, releaseUserMediaBlobs, userID)
+	return err
+}
+
+const releaseUserMediaVariantBlobs = `-- name: ReleaseUserMediaVariantBlobs :exec
+UPDATE media_blobs
+SET refs = refs - (SELECT COUNT(*) FROM user_media_variants v WHERE v.user_id = ?1 AND v.blob = media_blobs.sha256),
+    released_at = CURRENT_TIMESTAMP
+WHERE sha256 IN (SELECT blob FROM user_media_variants WHERE user_id = ?1)
+`
+
+func (q *Queries) ReleaseUserMediaVariantBlobs(ctx context.Context, userID int64) error {
+	_, err := q.db.ExecContext(ctx, releaseUserMediaVariantBlobs, userID)
+	return err
+}
+
+const reserveMedia = `-- name: ReserveMedia :exec
+INSERT OR REPLACE INTO user_media_pending (user_id, hash, filename, size)
+VALUES (?, ?, ?, ?)
+`
+
+type ReserveMediaParams struct {
+	UserID   int64  `json:"user_id"`
+	Hash     string `json:"hash"`
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+}
+
+func (q *Queries) ReserveMedia(ctx context.Context, arg ReserveMediaParams) error {
+	_, err := q.db.ExecContext(ctx, reserveMedia,
+		arg.UserID,
+		arg.Hash,
+		arg.Filename,
+		arg.Size,
+	)
+	return err
+}
+
 const resetUserUSN = `-- name: ResetUserUSN :exec
-UPDATE user_collections SET usn = 0, last_sync = NULL WHERE user_id = ?
+UPDATE user_collections SET usn = 0, last_sync = NULL, resets = resets + 1 WHERE user_id = ?
 `
 
 func (q *Queries) ResetUserUSN(ctx context.Context, userID int64) error {
@@ -400,9 +1863,57 @@ func (q *Queries) ResetUserUSN(ctx context.Context, userID int64) error {
 	return err
 }
 
+const setCollectionMod = `-- name: SetCollectionMod :exec
+UPDATE user_collections SET mod = ? WHERE user_id = ?
+`
+
+type SetCollectionModParams struct {
+	Mod    int64 `json:"mod"`
+	UserID int64 `json:"user_id"`
+}
+
+func (q *Queries) SetCollectionMod(ctx context.Context, arg SetCollectionModParams) error {
+	_, err := q.db.ExecContext(ctx, setCollectionMod, arg.Mod, arg.UserID)
+	return err
+}
+
+const setCollectionSchema = `-- name: SetCollectionSchema :exec
+UPDATE user_collections SET scm = ?, crt = ? WHERE user_id = ?
+`
+
+type SetCollectionSchemaParams struct {
+	Scm    int64 `json:"scm"`
+	Crt    int64 `json:"crt"`
+	UserID int64 `json:"user_id"`
+}
+
+func (q *Queries) SetCollectionSchema(ctx context.Context, arg SetCollectionSchemaParams) error {
+	_, err := q.db.ExecContext(ctx, setCollectionSchema, arg.Scm, arg.Crt, arg.UserID)
+	return err
+}
+
+const setMediaBlob = `-- name: SetMediaBlob :one
+UPDATE user_media SET blob = ? WHERE user_id = ? AND hash = ? AND blob = ''
+RETURNING id
+`
+
+type SetMediaBlobParams struct {
+	Blob   string `json:"blob"`
+	UserID int64  `json:"user_id"`
+	Hash   string `json:"hash"`
+}
+
+func (q *Queries) SetMediaBlob(ctx context.Context, arg SetMediaBlobParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, setMediaBlob, arg.Blob, arg.UserID, arg.Hash)
+	var iD int64
+	err := row.Scan(&iD)
+	return iD, err
+}
+
 const updateUSN = `-- name: UpdateUSN :one
 UPDATE user_collections 
-SET usn = usn + 1, last_sync = CURRENT_TIMESTAMP 
+SET usn = usn + 1, last_sync = CURRENT_TIMESTAMP,
+    mod = CAST(unixepoch('subsec') * 1000 AS INTEGER)
 WHERE user_id = ? 
 RETURNING usn
 `
@@ -417,9 +1928,9 @@ func (q *Queries) UpdateUSN(ctx context.Context, userID int64) (int64, error) {
 const upsertCard = `-- name: UpsertCard :exec
 INSERT INTO user_cards (id, user_id, note_id, deck_id, ordinal, modified_at, usn, 
     state, queue, due, interval, ease_factor, reps, lapses, left_count,
-    original_due, original_deck_id, flags, data)
-VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-ON CONFLICT(id) DO UPDATE SET
+    original_due, original_deck_id, flags, data, stability, difficulty)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, COALESCE(?20, 0), COALESCE(?21, 0))
+ON CONFLICT(user_id, id) DO UPDATE SET
     note_id = excluded.note_id,
     deck_id = excluded.deck_id,
     ordinal = excluded.ordinal,
@@ -436,31 +1947,33 @@ ON CONFLICT(id) DO UPDATE SET
     original_due = excluded.original_due,
     original_deck_id = excluded.original_deck_id,
     flags = excluded.flags,
-    data = excluded.data
+    data = excluded.data,
+    stability = COALESCE(?20, user_cards.stability),
+    difficulty = COALESCE(?21, user_cards.difficulty)
 `
 
 type UpsertCardParams struct {
-	ID             int64  `json:"id"`
-	UserID         int64  `json:"user_id"`
-	NoteID         int64  `json:"note_id"`
-	DeckID         int64  `json:"deck_id"`
-	Ordinal        int64  `json:"ordinal"`
-	ModifiedAt     int64  `json:"modified_at"`
-	Usn            int64  `json:"usn"`
-	State          int64  `json:"state"`
-	Queue          int64  `json:"queue"`
-	Due            int64  `json:"due"`
-	Interval       int64  `json:"interval"`
-	EaseFactor     int64  `json:"ease_factor"`
-	Reps           int64  `json:"reps"`
-	Lapses         int64  `json:"lapses"`
-	LeftCount      int64  `json:"left_count"`
-	OriginalDue    int64  `json:"original_due"`
-	OriginalDeckID int64  `json:"original_deck_id"`
-	Flags          int64   `json:"flags"`
-	Data           string  `json:"data"`
-	Stability      float64 `json:"stability"`
-	Difficulty     float64 `json:"difficulty"`
+	ID             int64           `json:"id"`
+	UserID         int64           `json:"user_id"`
+	NoteID         int64           `json:"note_id"`
+	DeckID         int64           `json:"deck_id"`
+	Ordinal        int64           `json:"ordinal"`
+	ModifiedAt     int64           `json:"modified_at"`
+	Usn            int64           `json:"usn"`
+	State          int64           `json:"state"`
+	Queue          int64           `json:"queue"`
+	Due            int64           `json:"due"`
+	Interval       int64           `json:"interval"`
+	EaseFactor     int64           `json:"ease_factor"`
+	Reps           int64           `json:"reps"`
+	Lapses         int64           `json:"lapses"`
+	LeftCount      int64           `json:"left_count"`
+	OriginalDue    int64           `json:"original_due"`
+	OriginalDeckID int64           `json:"original_deck_id"`
+	Flags          int64           `json:"flags"`
+	Data           string          `json:"data"`
+	Stability      sql.NullFloat64 `json:"stability"`
+	Difficulty     sql.NullFloat64 `json:"difficulty"`
 }
 
 func (q *Queries) UpsertCard(ctx context.Context, arg UpsertCardParams) error {
@@ -493,7 +2006,7 @@ func (q *Queries) UpsertCard(ctx context.Context, arg UpsertCardParams) error {
 const upsertDeck = `-- name: UpsertDeck :exec
 INSERT INTO user_decks (id, user_id, name, description, config_id, created_at, modified_at, usn)
 VALUES (?, ?, ?, ?, ?, ?, ?, ?)
-ON CONFLICT(id) DO UPDATE SET
+ON CONFLICT(user_id, id) DO UPDATE SET
     name = excluded.name,
     description = excluded.description,
     config_id = excluded.config_id,
@@ -526,10 +2039,69 @@ func (q *Queries) UpsertDeck(ctx context.Context, arg UpsertDeckParams) error {
 	return err
 }
 
+const upsertDeckConfig = `-- name: UpsertDeckConfig :exec
+INSERT INTO user_deck_configs (id, user_id, name, mod, usn, config)
+VALUES (?, ?, ?, ?, ?, ?)
+ON CONFLICT(user_id, id) DO UPDATE SET
+    name = excluded.name,
+    mod = excluded.mod,
+    usn = excluded.usn,
+    config = excluded.config
+`
+
+type UpsertDeckConfigParams struct {
+	ID     int64  `json:"id"`
+	UserID int64  `json:"user_id"`
+	Name   string `json:"name"`
+	Mod    int64  `json:"mod"`
+	Usn    int64  `json:"usn"`
+	Config string `json:"config"`
+}
+
+func (q *Queries) UpsertDeckConfig(ctx context.Context, arg UpsertDeckConfigParams) error {
+	_, err := q.db.ExecContext(ctx, upsertDeckConfig,
+		arg.ID,
+		arg.UserID,
+		arg.Name,
+		arg.Mod,
+		arg.Usn,
+		arg.Config,
+	)
+	return err
+}
+
+const upsertMedia = `-- name: UpsertMedia :exec
+INSERT OR REPLACE INTO user_media (user_id, filename, hash, size, usn, blob, mtime)
+VALUES (?, ?, ?, ?, ?, ?, ?)
+`
+
+type UpsertMediaParams struct {
+	UserID   int64  `json:"user_id"`
+	Filename string `json:"filename"`
+	Hash     string `json:"hash"`
+	Size     int64  `json:"size"`
+	Usn      int64  `json:"usn"`
+	Blob     string `json:"blob"`
+	Mtime    int64  `json:"mtime"`
+}
+
+func (q *Queries) UpsertMedia(ctx context.Context, arg UpsertMediaParams) error {
+	_, err := q.db.ExecContext(ctx, upsertMedia,
+		arg.UserID,
+		arg.Filename,
+		arg.Hash,
+		arg.Size,
+		arg.Usn,
+		arg.Blob,
+		arg.Mtime,
+	)
+	return err
+}
+
 const upsertNote = `-- name: UpsertNote :exec
 INSERT INTO user_notes (id, user_id, guid, mid, mod, usn, tags, flds, sfld, csum, flags, data)
 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-ON CONFLICT(id) DO UPDATE SET
+ON CONFLICT(user_id, id) DO UPDATE SET
     guid = excluded.guid,
     mid = excluded.mid,
     mod = excluded.mod,
@@ -574,3 +2146,68 @@ func (q *Queries) UpsertNote(ctx context.Context, arg UpsertNoteParams) error {
 	)
 	return err
 }
+
+const upsertNoteType = `-- name: UpsertNoteType :exec
+INSERT INTO user_notetypes (id, user_id, name, type, mod, usn, sort_field, fields, templates, css, data)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(user_id, id) DO UPDATE SET
+    name = excluded.name,
+    type = excluded.type,
+    mod = excluded.mod,
+    usn = excluded.usn,
+    sort_field = excluded.sort_field,
+    fields = excluded.fields,
+    templates = excluded.templates,
+    css = excluded.css,
+    data = excluded.data
+`
+
+type UpsertNoteTypeParams struct {
+	ID        int64  `json:"id"`
+	UserID    int64  `json:"user_id"`
+	Name      string `json:"name"`
+	Type      int64  `json:"type"`
+	Mod       int64  `json:"mod"`
+	Usn       int64  `json:"usn"`
+	SortField int64  `json:"sort_field"`
+	Fields    string `json:"fields"`
+	Templates string `json:"templates"`
+	Css       string `json:"css"`
+	Data      string `json:"data"`
+}
+
+func (q *Queries) UpsertNoteType(ctx context.Context, arg UpsertNoteTypeParams) error {
+	_, err := q.db.ExecContext(ctx, upsertNoteType,
+		arg.ID,
+		arg.UserID,
+		arg.Name,
+		arg.Type,
+		arg.Mod,
+		arg.Usn,
+		arg.SortField,
+		arg.Fields,
+		arg.Templates,
+		arg.Css,
+		arg.Data,
+	)
+	return err
+}
+
+const useHostKey = `-- name: UseHostKey :one
+UPDATE anki_host_keys SET last_used_at = ?
+WHERE key = ? AND last_used_at >= ?
+RETURNING user_id
+`
+
+type UseHostKeyParams struct {
+	LastUsedAt int64  `json:"last_used_at"`
+	Key        string `json:"key"`
+	Cutoff     int64  `json:"cutoff"`
+}
+
+func (q *Queries) UseHostKey(ctx context.Context, arg UseHostKeyParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, useHostKey, arg.LastUsedAt, arg.Key, arg.Cutoff)
+	var userID int64
+	err := row.Scan(&userID)
+	return userID, err
+}
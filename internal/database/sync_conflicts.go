@@ -0,0 +1,129 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ConflictPolicy decides what PushSyncSafe does with objects that were changed
+// on the server after the USN the client last saw.
+type ConflictPolicy string
+
+const (
+	// ConflictReject aborts the whole push so the client can pull and retry
+	ConflictReject ConflictPolicy = "reject"
+	// ConflictLastWriteWins keeps whichever copy has the newer mod/modified_at
+	ConflictLastWriteWins ConflictPolicy = "last_write_wins"
+)
+
+// ParseConflictPolicy maps a config value to a policy, defaulting to last writer wins
+func ParseConflictPolicy(s string) ConflictPolicy {
+	switch ConflictPolicy(strings.ToLower(strings.TrimSpace(s))) {
+	case ConflictReject:
+		return ConflictReject
+	default:
+		return ConflictLastWriteWins
+	}
+}
+
+// SyncConflicts lists object IDs, by type, that were changed on the server
+// after the client's last seen USN
+type SyncConflicts struct {
+	Decks       []int64 `json:"decks,omitempty"`
+	Notes       []int64 `json:"notes,omitempty"`
+	Cards       []int64 `json:"cards,omitempty"`
+	NoteTypes   []int64 `json:"notetypes,omitempty"`
+	DeckConfigs []int64 `json:"deck_configs,omitempty"`
+}
+
+// Empty reports whether no conflicts were recorded
+func (c *SyncConflicts) Empty() bool {
+	return len(c.Decks) == 0 && len(c.Notes) == 0 && len(c.Cards) == 0 &&
+		len(c.NoteTypes) == 0 && len(c.DeckConfigs) == 0
+}
+
+// add records an object once, using grave type numbering
+// (0=card, 1=note, 2=deck, 3=notetype, 4=deck config)
+func (c *SyncConflicts) add(objType int, id int64) {
+	var ids *[]int64
+	switch objType {
+	case 0:
+		ids = &c.Cards
+	case 1:
+		ids = &c.Notes
+	case 2:
+		ids = &c.Decks
+	case 3:
+		ids = &c.NoteTypes
+	case 4:
+		ids = &c.DeckConfigs
+	default:
+		return
+	}
+	if !slices.Contains(*ids, id) {
+		*ids = append(*ids, id)
+	}
+}
+
+// ConflictError is returned by PushSyncSafe when the reject policy finds
+// objects the client has not seen yet. Nothing from the push is applied.
+type ConflictError struct {
+	ServerUSN int
+	Conflicts SyncConflicts
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("sync conflict: %d decks, %d notes, %d cards, %d notetypes, %d deck configs changed on server (usn %d)",
+		len(e.Conflicts.Decks), len(e.Conflicts.Notes), len(e.Conflicts.Cards),
+		len(e.Conflicts.NoteTypes), len(e.Conflicts.DeckConfigs), e.ServerUSN)
+}
+
+// PushResult is the outcome of a successful PushSyncSafe
+type PushResult struct {
+	USN int
+	// Skipped holds objects whose newer server copy (or server deletion) was
+	// kept under last writer wins
+	Skipped SyncConflicts
+}
+
+// serverState returns the USN and modification time of a stored object.
+// found is false if the user has no such object; a deleted object then has
+// the USN of its grave and a zero mod.
+func serverState(ctx context.Context, q *Queries, userID int64, objType int, id int64) (usn, mod int64, found bool, err error) {
+	switch objType {
+	case 0: // Card
+		var s GetCardStateRow
+		s, err = q.GetCardState(ctx, GetCardStateParams{UserID: userID, ID: id})
+		usn, mod = s.Usn, s.ModifiedAt
+	case 1: // Note
+		var s GetNoteStateRow
+		s, err = q.GetNoteState(ctx, GetNoteStateParams{UserID: userID, ID: id})
+		usn, mod = s.Usn, s.Mod
+	case 2: // Deck
+		var s GetDeckStateRow
+		s, err = q.GetDeckState(ctx, GetDeckStateParams{UserID: userID, ID: id})
+		usn, mod = s.Usn, s.ModifiedAt
+	case 3: // Note type
+		var s GetNoteTypeStateRow
+		s, err = q.GetNoteTypeState(ctx, GetNoteTypeStateParams{UserID: userID, ID: id})
+		usn, mod = s.Usn, s.Mod
+	case 4: // Deck config
+		var s GetDeckConfigStateRow
+		s, err = q.GetDeckConfigState(ctx, GetDeckConfigStateParams{UserID: userID, ID: id})
+		usn, mod = s.Usn, s.Mod
+	default:
+		return 0, 0, false, fmt.Errorf("unknown object type: %d", objType)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		usn, err = q.GetGraveUsn(ctx, GetGraveUsnParams{UserID: userID, Type: int64(objType), Oid: id})
+		return usn, 0, false, err
+	}
+	if err != nil {
+		return 0, 0, false, err
+	}
+	return usn, mod, true, nil
+}
@@ -0,0 +1,128 @@
+package database
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestPushConflicts(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy ConflictPolicy
+		// serverChange is pushed by another device after the first sync
+		serverChange SyncPayload
+		// push is the stale device's push with client_usn of the first sync
+		push SyncPayload
+		// wantConflict lists the cards a reject must report
+		wantConflict []int64
+		wantSkipped  []int64
+		wantCard     bool
+	}{
+		{
+			name:         "edit of card deleted on server is skipped",
+			policy:       ConflictLastWriteWins,
+			serverChange: SyncPayload{Graves: []SyncGrave{{OID: 1, Type: 0}}},
+			push:         SyncPayload{Cards: []SyncCard{{ID: 1, ModifiedAt: 200}}},
+			wantSkipped:  []int64{1},
+		},
+		{
+			name:         "edit of card deleted on server is rejected",
+			policy:       ConflictReject,
+			serverChange: SyncPayload{Graves: []SyncGrave{{OID: 1, Type: 0}}},
+			push:         SyncPayload{Cards: []SyncCard{{ID: 1, ModifiedAt: 200}}},
+			wantConflict: []int64{1},
+		},
+		{
+			name:         "deleting a card deleted on server is no conflict",
+			policy:       ConflictReject,
+			serverChange: SyncPayload{Graves: []SyncGrave{{OID: 1, Type: 0}}},
+			push:         SyncPayload{Graves: []SyncGrave{{OID: 1, Type: 0}}},
+		},
+		{
+			name:         "newer client edit wins",
+			policy:       ConflictLastWriteWins,
+			serverChange: SyncPayload{Cards: []SyncCard{{ID: 1, ModifiedAt: 150}}},
+			push:         SyncPayload{Cards: []SyncCard{{ID: 1, ModifiedAt: 200}}},
+			wantCard:     true,
+		},
+		{
+			name:         "older client edit is skipped",
+			policy:       ConflictLastWriteWins,
+			serverChange: SyncPayload{Cards: []SyncCard{{ID: 1, ModifiedAt: 300}}},
+			push:         SyncPayload{Cards: []SyncCard{{ID: 1, ModifiedAt: 200}}},
+			wantSkipped:  []int64{1},
+			wantCard:     true,
+		},
+		{
+			name:         "edit of server edit is rejected",
+			policy:       ConflictReject,
+			serverChange: SyncPayload{Cards: []SyncCard{{ID: 1, ModifiedAt: 150}}},
+			push:         SyncPayload{Cards: []SyncCard{{ID: 1, ModifiedAt: 200}}},
+			wantConflict: []int64{1},
+			wantCard:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID := createTestUser(t)
+			first, err := testRepo.PushSyncSafe(userID, &SyncPayload{Cards: []SyncCard{{ID: 1, ModifiedAt: 100}}}, tt.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.serverChange.ClientUSN = intPtr(first.USN)
+			if _, err := testRepo.PushSyncSafe(userID, &tt.serverChange, tt.policy); err != nil {
+				t.Fatal(err)
+			}
+
+			tt.push.ClientUSN = intPtr(first.USN)
+			result, err := testRepo.PushSyncSafe(userID, &tt.push, tt.policy)
+			var conflict *ConflictError
+			if tt.wantConflict != nil {
+				if !errors.As(err, &conflict) {
+					t.Fatalf("push error = %v, want conflict", err)
+				}
+				if !slices.Equal(conflict.Conflicts.Cards, tt.wantConflict) {
+					t.Errorf("conflicting cards = %v, want %v", conflict.Conflicts.Cards, tt.wantConflict)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else if !slices.Equal(result.Skipped.Cards, tt.wantSkipped) {
+				t.Errorf("skipped cards = %v, want %v", result.Skipped.Cards, tt.wantSkipped)
+			}
+
+			cards, err := testRepo.GetCardsSince(userID, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(cards) == 1; got != tt.wantCard {
+				t.Errorf("card stored = %v, want %v", got, tt.wantCard)
+			}
+		})
+	}
+}
+
+func TestPushUpToDateRecreatesDeletedCard(t *testing.T) {
+	userID := createTestUser(t)
+	first, err := testRepo.PushSyncSafe(userID, &SyncPayload{Cards: []SyncCard{{ID: 1, ModifiedAt: 100}}}, ConflictReject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := testRepo.PushSyncSafe(userID, &SyncPayload{ClientUSN: intPtr(first.USN), Graves: []SyncGrave{{OID: 1}}}, ConflictReject)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A client that has seen the deletion may add the card back
+	_, err = testRepo.PushSyncSafe(userID, &SyncPayload{ClientUSN: intPtr(deleted.USN), Cards: []SyncCard{{ID: 1, ModifiedAt: 200}}}, ConflictReject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cards, err := testRepo.GetCardsSince(userID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cards) != 1 {
+		t.Errorf("got %d cards, want the recreated one", len(cards))
+	}
+}
@@ -0,0 +1,144 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ImportResult counts the objects an import added or updated
+type ImportResult struct {
+	USN         int `json:"usn"`
+	NoteTypes   int `json:"note_types"`
+	DeckConfigs int `json:"deck_configs"`
+	Decks       int `json:"decks"`
+	Notes       int `json:"notes"`
+	Cards       int `json:"cards"`
+	Media       int `json:"media"`
+}
+
+// ImportCollection merges payload, e.g. the content of a shared deck, and its
+// media files into the user's collection under a new USN. Note types, deck
+// option groups, decks, cards and media file names the user already has are
+// kept, so importing a deck again only updates notes whose imported copy is
+// newer. New cards are added unscheduled, after the user's other new cards;
+// review history is not imported. The media content must already be stored.
+func (r *Repository) ImportCollection(userID int, payload *SyncPayload, media []MediaFile) (*ImportResult, error) {
+	ctx := context.Background()
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	qtx := r.Q.WithTx(tx)
+	uid := int64(userID)
+	if err := qtx.CreateSyncMeta(ctx, uid); err != nil {
+		return nil, err
+	}
+	serverUSN, err := qtx.GetUSN(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	usn, err := qtx.UpdateUSN(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	// missing reports whether the user has no object of the type yet
+	missing := func(objType int, id int64) (bool, error) {
+		_, _, found, err := serverState(ctx, qtx, uid, objType, id)
+		return !found, err
+	}
+
+	var merged SyncPayload
+	for _, nt := range payload.NoteTypes {
+		if ok, err := missing(3, nt.ID); err != nil {
+			return nil, fmt.Errorf("failed to check notetype %d: %w", nt.ID, err)
+		} else if ok {
+			merged.NoteTypes = append(merged.NoteTypes, nt)
+		}
+	}
+	for _, dc := range payload.DeckConfigs {
+		if ok, err := missing(4, dc.ID); err != nil {
+			return nil, fmt.Errorf("failed to check deck config %d: %w", dc.ID, err)
+		} else if ok {
+			merged.DeckConfigs = append(merged.DeckConfigs, dc)
+		}
+	}
+	for _, deck := range payload.Decks {
+		if ok, err := missing(2, deck.ID); err != nil {
+			return nil, fmt.Errorf("failed to check deck %d: %w", deck.ID, err)
+		} else if ok {
+			merged.Decks = append(merged.Decks, deck)
+		}
+	}
+	for _, note := range payload.Notes {
+		_, mod, found, err := serverState(ctx, qtx, uid, 1, note.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check note %d: %w", note.ID, err)
+		}
+		if !found || note.Mod > mod {
+			merged.Notes = append(merged.Notes, note)
+		}
+	}
+
+	due, err := qtx.GetMaxNewCardDue(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	for _, card := range payload.Cards {
+		if ok, err := missing(0, card.ID); err != nil {
+			return nil, fmt.Errorf("failed to check card %d: %w", card.ID, err)
+		} else if !ok {
+			continue
+		}
+		deckID := card.DeckID
+		if card.OriginalDeckID != 0 {
+			// Cards in filtered decks go back to their home deck
+			deckID = card.OriginalDeckID
+		}
+		due++
+		merged.Cards = append(merged.Cards, SyncCard{
+			ID:         card.ID,
+			NoteID:     card.NoteID,
+			DeckID:     deckID,
+			Ordinal:    card.Ordinal,
+			ModifiedAt: card.ModifiedAt,
+			Due:        due,
+		})
+	}
+
+	if _, err := applyPayload(ctx, qtx, uid, &merged, ConflictLastWriteWins, serverUSN, usn); err != nil {
+		return nil, err
+	}
+
+	added := 0
+	for _, m := range media {
+		_, err := qtx.GetMediaByFilename(ctx, GetMediaByFilenameParams{UserID: uid, Filename: m.Filename})
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("failed to check media %q: %w", m.Filename, err)
+		}
+		if err := putMedia(ctx, qtx, uid, m, usn); err != nil {
+			return nil, fmt.Errorf("failed to add media %q: %w", m.Filename, err)
+		}
+		added++
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &ImportResult{
+		USN:         int(usn),
+		NoteTypes:   len(merged.NoteTypes),
+		DeckConfigs: len(merged.DeckConfigs),
+		Decks:       len(merged.Decks),
+		Notes:       len(merged.Notes),
+		Cards:       len(merged.Cards),
+		Media:       added,
+	}, nil
+}
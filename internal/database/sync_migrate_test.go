@@ -0,0 +1,152 @@
+package database
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+// legacySyncTables is the layout before synced ids were namespaced per user:
+// the client-supplied id alone is the primary key
+const legacySyncTables = `
+CREATE TABLE user_decks (
+    id INTEGER PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    description TEXT,
+    config_id INTEGER DEFAULT 1,
+    created_at INTEGER NOT NULL,
+    modified_at INTEGER NOT NULL,
+    usn INTEGER NOT NULL
+);
+CREATE TABLE user_notes (
+    id INTEGER PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    guid TEXT NOT NULL,
+    mid INTEGER NOT NULL,
+    mod INTEGER NOT NULL,
+    usn INTEGER NOT NULL,
+    tags TEXT NOT NULL DEFAULT '',
+    flds TEXT NOT NULL,
+    sfld TEXT NOT NULL DEFAULT '',
+    csum INTEGER NOT NULL DEFAULT 0,
+    flags INTEGER NOT NULL DEFAULT 0,
+    data TEXT NOT NULL DEFAULT ''
+);
+CREATE TABLE user_cards (
+    id INTEGER PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    note_id INTEGER NOT NULL,
+    deck_id INTEGER NOT NULL,
+    ordinal INTEGER NOT NULL DEFAULT 0,
+    modified_at INTEGER NOT NULL,
+    usn INTEGER NOT NULL,
+    state INTEGER NOT NULL DEFAULT 0,
+    queue INTEGER NOT NULL DEFAULT 0,
+    due INTEGER NOT NULL DEFAULT 0,
+    interval INTEGER NOT NULL DEFAULT 0,
+    ease_factor INTEGER NOT NULL DEFAULT 2500,
+    reps INTEGER NOT NULL DEFAULT 0,
+    lapses INTEGER NOT NULL DEFAULT 0,
+    left_count INTEGER NOT NULL DEFAULT 0,
+    original_due INTEGER NOT NULL DEFAULT 0,
+    original_deck_id INTEGER NOT NULL DEFAULT 0,
+    flags INTEGER NOT NULL DEFAULT 0,
+    data TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX idx_user_decks_user ON user_decks(user_id);
+CREATE INDEX idx_user_decks_usn ON user_decks(user_id, usn);
+CREATE INDEX idx_user_notes_user ON user_notes(user_id);
+CREATE INDEX idx_user_notes_usn ON user_notes(user_id, usn);
+CREATE INDEX idx_user_cards_user ON user_cards(user_id);
+CREATE INDEX idx_user_cards_usn ON user_cards(user_id, usn);
+
+INSERT INTO user_decks (id, user_id, name, description, config_id, created_at, modified_at, usn)
+VALUES (10, 1, 'Spanish', 'Words', 3, 100, 200, 5);
+INSERT INTO user_notes (id, user_id, guid, mid, mod, usn, tags, flds, sfld, csum)
+VALUES (20, 1, 'g', 30, 300, 6, ' verbs ', 'ser' || char(31) || 'to be', 'ser', 42);
+INSERT INTO user_cards (id, user_id, note_id, deck_id, ordinal, modified_at, usn, queue, due, interval, reps)
+VALUES (40, 1, 20, 10, 0, 400, 7, 2, 120, 9, 4);
+`
+
+func TestMigrateUserScopedIDs(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "legacy.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(legacySyncTables); err != nil {
+		t.Fatal(err)
+	}
+	repo := &Repository{DB: db, Q: New(db)}
+
+	// Boot twice: the second boot finds the new layout and leaves it alone
+	for boot := 1; boot <= 2; boot++ {
+		if err := repo.InitSyncSchema(); err != nil {
+			t.Fatalf("boot %d: %v", boot, err)
+		}
+		for _, table := range userScopedTables {
+			pkCols, _, err := repo.tableInfo(table)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pkCols != 2 {
+				t.Errorf("boot %d: %s has %d primary key columns, want 2", boot, table, pkCols)
+			}
+		}
+		var legacy int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name LIKE '%_legacy'`).Scan(&legacy); err != nil {
+			t.Fatal(err)
+		}
+		if legacy != 0 {
+			t.Errorf("boot %d: %d legacy tables left", boot, legacy)
+		}
+
+		decks, err := repo.GetDecksSince(1, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantDeck := SyncDeck{ID: 10, Name: "Spanish", Description: "Words", ConfigID: 3, CreatedAt: 100, ModifiedAt: 200, USN: 5}
+		if len(decks) != 1 || decks[0] != wantDeck {
+			t.Errorf("boot %d: decks = %+v, want %+v", boot, decks, wantDeck)
+		}
+		notes, err := repo.GetNotesSince(1, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(notes) != 1 || notes[0].ID != 20 || notes[0].Flds != "ser\x1fto be" || notes[0].Tags != " verbs " || notes[0].Csum != 42 {
+			t.Errorf("boot %d: notes = %+v", boot, notes)
+		}
+		var card struct{ noteID, deckID, due, interval, reps int64 }
+		err = db.QueryRow(`SELECT note_id, deck_id, due, interval, reps FROM user_cards WHERE user_id = 1 AND id = 40`).
+			Scan(&card.noteID, &card.deckID, &card.due, &card.interval, &card.reps)
+		if err != nil {
+			t.Fatalf("boot %d: card: %v", boot, err)
+		}
+		if card.noteID != 20 || card.deckID != 10 || card.due != 120 || card.interval != 9 || card.reps != 4 {
+			t.Errorf("boot %d: card = %+v", boot, card)
+		}
+	}
+
+	// Another user may now use the same ids
+	if _, err := db.Exec(`INSERT INTO user_decks (id, user_id, name, created_at, modified_at, usn) VALUES (10, 2, 'Other', 1, 1, 1)`); err != nil {
+		t.Fatalf("deck id 10 for a second user: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO user_notes (id, user_id, guid, mid, mod, usn, flds) VALUES (20, 2, 'h', 30, 1, 1, 'x')`); err != nil {
+		t.Fatalf("note id 20 for a second user: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO user_cards (id, user_id, note_id, deck_id, modified_at, usn) VALUES (40, 2, 20, 10, 1, 1)`); err != nil {
+		t.Fatalf("card id 40 for a second user: %v", err)
+	}
+	// but not twice for one user
+	if _, err := db.Exec(`INSERT INTO user_decks (id, user_id, name, created_at, modified_at, usn) VALUES (10, 2, 'Again', 1, 1, 1)`); err == nil {
+		t.Error("deck id 10 stored twice for one user")
+	}
+	decks, err := repo.GetDecksSince(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decks) != 1 || decks[0].Name != "Spanish" {
+		t.Errorf("first user's decks = %+v after the second user's", decks)
+	}
+}
@@ -0,0 +1,180 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// NoteTypeField is a field definition of a note type
+type NoteTypeField struct {
+	Name string `json:"name"`
+	Ord  int    `json:"ord"`
+	// Raw is the field as decoded, keeping the options (font, sticky, ...) we
+	// do not model. The fields above are merged over it when encoding.
+	Raw json.RawMessage `json:"-"`
+}
+
+// NoteTypeTemplate is a card template of a note type
+type NoteTypeTemplate struct {
+	Name string          `json:"name"`
+	Ord  int             `json:"ord"`
+	Qfmt string          `json:"qfmt"` // Question format
+	Afmt string          `json:"afmt"` // Answer format
+	Raw  json.RawMessage `json:"-"`    // As NoteTypeField.Raw
+}
+
+// SyncNoteType represents a synced note type (Anki "model"), referenced by SyncNote.MID
+type SyncNoteType struct {
+	ID        int64              `json:"id"`
+	Name      string             `json:"name"`
+	Type      int                `json:"type"` // 0=standard, 1=cloze
+	Mod       int64              `json:"mod"`
+	USN       int                `json:"usn"`
+	SortField int                `json:"sort_field"`
+	Fields    []NoteTypeField    `json:"fields"`
+	Templates []NoteTypeTemplate `json:"templates"`
+	CSS       string             `json:"css"`
+	Data      string             `json:"data"`
+}
+
+// DeckConfigOptions holds the scheduler options of a deck option group
+type DeckConfigOptions struct {
+	NewPerDay          int       `json:"new_per_day"`
+	ReviewsPerDay      int       `json:"reviews_per_day"`
+	LearnSteps         []float64 `json:"learn_steps"`         // Minutes
+	RelearnSteps       []float64 `json:"relearn_steps"`       // Minutes
+	GraduatingInterval int       `json:"graduating_interval"` // Days
+	EasyInterval       int       `json:"easy_interval"`       // Days
+	MaximumInterval    int       `json:"maximum_interval"`    // Days
+	StartingEase       int       `json:"starting_ease"`       // Permille, e.g. 2500
+	LeechThreshold     int       `json:"leech_threshold"`
+	DesiredRetention   float64   `json:"desired_retention"`
+	FSRSWeights        []float64 `json:"fsrs_weights,omitempty"`
+	// Raw is the options as decoded, keeping the ones we do not model
+	Raw json.RawMessage `json:"-"`
+}
+
+// SyncDeckConfig represents a synced deck option group, referenced by SyncDeck.ConfigID
+type SyncDeckConfig struct {
+	ID     int64             `json:"id"`
+	Name   string            `json:"name"`
+	Mod    int64             `json:"mod"`
+	USN    int               `json:"usn"`
+	Config DeckConfigOptions `json:"config"`
+}
+
+func (f *NoteTypeField) UnmarshalJSON(b []byte) error {
+	type known NoteTypeField
+	return unmarshalRaw(b, (*known)(f), &f.Raw)
+}
+
+func (f NoteTypeField) MarshalJSON() ([]byte, error) {
+	type known NoteTypeField
+	return mergeJSON(f.Raw, known(f))
+}
+
+func (t *NoteTypeTemplate) UnmarshalJSON(b []byte) error {
+	type known NoteTypeTemplate
+	return unmarshalRaw(b, (*known)(t), &t.Raw)
+}
+
+func (t NoteTypeTemplate) MarshalJSON() ([]byte, error) {
+	type known NoteTypeTemplate
+	return mergeJSON(t.Raw, known(t))
+}
+
+func (o *DeckConfigOptions) UnmarshalJSON(b []byte) error {
+	type known DeckConfigOptions
+	return unmarshalRaw(b, (*known)(o), &o.Raw)
+}
+
+func (o DeckConfigOptions) MarshalJSON() ([]byte, error) {
+	type known DeckConfigOptions
+	return mergeJSON(o.Raw, known(o))
+}
+
+// unmarshalRaw decodes b into v and keeps a copy of it in raw
+func unmarshalRaw(b []byte, v any, raw *json.RawMessage) error {
+	if err := json.Unmarshal(b, v); err != nil {
+		return err
+	}
+	*raw = append((*raw)[:0], b...)
+	return nil
+}
+
+// mergeJSON encodes the struct v over the JSON object raw. Keys of raw that v
+// has a field for are replaced, or dropped when the field is omitted as empty.
+func mergeJSON(raw json.RawMessage, v any) ([]byte, error) {
+	known, err := json.Marshal(v)
+	if err != nil || len(raw) == 0 {
+		return known, err
+	}
+	var merged map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &merged); err != nil {
+		return nil, err
+	}
+	t := reflect.TypeOf(v)
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		delete(merged, name)
+	}
+	if err := json.Unmarshal(known, &merged); err != nil {
+		return nil, err
+	}
+	return json.Marshal(merged)
+}
+
+func putNoteType(ctx context.Context, q *Queries, userID int64, nt *SyncNoteType, usn int64) error {
+	fields, err := json.Marshal(nt.Fields)
+	if err != nil {
+		return err
+	}
+	templates, err := json.Marshal(nt.Templates)
+	if err != nil {
+		return err
+	}
+	return q.UpsertNoteType(ctx, UpsertNoteTypeParams{
+		ID:        nt.ID,
+		UserID:    userID,
+		Name:      nt.Name,
+		Type:      int64(nt.Type),
+		Mod:       nt.Mod,
+		Usn:       usn,
+		SortField: int64(nt.SortField),
+		Fields:    string(fields),
+		Templates: string(templates),
+		Css:       nt.CSS,
+		Data:      nt.Data,
+	})
+}
+
+func putDeckConfig(ctx context.Context, q *Queries, userID int64, dc *SyncDeckConfig, usn int64) error {
+	config, err := json.Marshal(dc.Config)
+	if err != nil {
+		return err
+	}
+	return q.UpsertDeckConfig(ctx, UpsertDeckConfigParams{
+		ID:     dc.ID,
+		UserID: userID,
+		Name:   dc.Name,
+		Mod:    dc.Mod,
+		Usn:    usn,
+		Config: string(config),
+	})
+}
+
+// GetNoteTypesSince returns note types modified since the given USN
+func (r *Repository) GetNoteTypesSince(userID, sinceUSN int) ([]SyncNoteType, error) {
+	var page PullPage
+	_, _, _, err := pullNoteTypes(context.Background(), r.Q, int64(userID), sinceBounds(sinceUSN), &page)
+	return page.NoteTypes, err
+}
+
+// GetDeckConfigsSince returns deck option groups modified since the given USN
+func (r *Repository) GetDeckConfigsSince(userID, sinceUSN int) ([]SyncDeckConfig, error) {
+	var page PullPage
+	_, _, _, err := pullDeckConfigs(context.Background(), r.Q, int64(userID), sinceBounds(sinceUSN), &page)
+	return page.DeckConfigs, err
+}
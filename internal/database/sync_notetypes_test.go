@@ -0,0 +1,115 @@
+package database
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMergeJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		edit func(o *DeckConfigOptions)
+		want map[string]any
+	}{
+		{
+			name: "unknown keys are kept",
+			in:   `{"new_per_day": 20, "bury_new": true, "new": {"order": 0}}`,
+			want: map[string]any{"new_per_day": 20.0, "bury_new": true, "new": map[string]any{"order": 0.0}},
+		},
+		{
+			name: "known fields replace their keys",
+			in:   `{"new_per_day": 20, "bury_new": true}`,
+			edit: func(o *DeckConfigOptions) { o.NewPerDay = 5 },
+			want: map[string]any{"new_per_day": 5.0, "bury_new": true},
+		},
+		{
+			name: "cleared omitempty field drops its key",
+			in:   `{"fsrs_weights": [1, 2], "bury_new": true}`,
+			edit: func(o *DeckConfigOptions) { o.FSRSWeights = nil },
+			want: map[string]any{"bury_new": true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var o DeckConfigOptions
+			if err := json.Unmarshal([]byte(tt.in), &o); err != nil {
+				t.Fatal(err)
+			}
+			if tt.edit != nil {
+				tt.edit(&o)
+			}
+			b, err := json.Marshal(o)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got map[string]any
+			if err := json.Unmarshal(b, &got); err != nil {
+				t.Fatal(err)
+			}
+			for key, want := range tt.want {
+				if g, _ := json.Marshal(got[key]); string(g) != mustJSON(t, want) {
+					t.Errorf("%s = %s, want %s", key, g, mustJSON(t, want))
+				}
+			}
+			if _, ok := got["fsrs_weights"]; ok && tt.want["fsrs_weights"] == nil {
+				t.Errorf("fsrs_weights kept: %s", b)
+			}
+		})
+	}
+}
+
+func TestSyncKeepsUnknownNoteTypeAndDeckConfigKeys(t *testing.T) {
+	userID := createTestUser(t)
+	var payload SyncPayload
+	err := json.Unmarshal([]byte(`{
+		"notetypes": [{"id": 1, "name": "Basic", "fields": [{"name": "Front", "ord": 0, "sticky": true}],
+			"templates": [{"name": "Card 1", "ord": 0, "qfmt": "{{Front}}", "afmt": "", "bqfmt": "Q"}]}],
+		"deckconfigs": [{"id": 1, "name": "Default", "config": {"new_per_day": 20, "bury_new": true}}]
+	}`), &payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testRepo.PushSyncSafe(userID, &payload, ConflictLastWriteWins); err != nil {
+		t.Fatal(err)
+	}
+
+	noteTypes, err := testRepo.GetNoteTypesSince(userID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configs, err := testRepo.GetDeckConfigsSince(userID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(noteTypes) != 1 || len(configs) != 1 {
+		t.Fatalf("got %d note types and %d deck configs, want 1 each", len(noteTypes), len(configs))
+	}
+	checks := []struct {
+		name string
+		v    any
+		key  string
+	}{
+		{"field", noteTypes[0].Fields[0], "sticky"},
+		{"template", noteTypes[0].Templates[0], "bqfmt"},
+		{"deck config", configs[0].Config, "bury_new"},
+	}
+	for _, c := range checks {
+		var got map[string]any
+		if err := json.Unmarshal([]byte(mustJSON(t, c.v)), &got); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := got[c.key]; !ok {
+			t.Errorf("%s lost %q: %v", c.name, c.key, got)
+		}
+	}
+}
+
+func mustJSON(t *testing.T, v any) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
@@ -0,0 +1,417 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrInvalidCursor is returned by PullChanges for a malformed continuation
+// token, or one issued before the collection was reset by a full sync.
+var ErrInvalidCursor = errors.New("invalid pull cursor")
+
+// PullPage is one chunk of a paginated pull
+type PullPage struct {
+	// ServerUSN is the USN the whole pull is bounded by. Clients store it as
+	// their new "since" once HasMore is false.
+	ServerUSN   int
+	NoteTypes   []SyncNoteType
+	DeckConfigs []SyncDeckConfig
+	Decks       []SyncDeck
+	Notes       []SyncNote
+	Cards       []SyncCard
+	Revlog      []SyncRevlog
+	Graves      []SyncGrave
+	HasMore     bool
+	// Cursor resumes the pull after this page, empty when HasMore is false
+	Cursor string
+}
+
+// pullCursor is the position of a paginated pull. Rows are walked table by
+// table (see pullStages) in (usn, id) order, so a page can be re-requested
+// with the same token after an interrupted download without skipping rows.
+type pullCursor struct {
+	Since  int   `json:"s"`
+	Until  int   `json:"u"` // server USN when the pull started
+	Resets int64 `json:"r"` // full syncs when the pull started
+	Stage int   `json:"t"`
+	USN   int64 `json:"n"`
+	ID    int64 `json:"i"`
+}
+
+func (c pullCursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodePullCursor(token string) (pullCursor, error) {
+	var c pullCursor
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+	if c.Stage < 0 || c.Stage >= len(pullStages) || c.Since > c.Until {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// pageBounds selects rows with (usn, id) > (afterUSN, afterID) and usn <= untilUSN
+type pageBounds struct {
+	afterUSN, afterID, untilUSN, limit int64
+}
+
+// sinceBounds selects every row changed after since
+func sinceBounds(since int) pageBounds {
+	return pageBounds{afterUSN: int64(since), afterID: math.MaxInt64, untilUSN: math.MaxInt64, limit: -1}
+}
+
+// pullStage appends up to b.limit rows of one table to page and returns the
+// number of rows read and the (usn, id) of the last one
+type pullStage func(ctx context.Context, q *Queries, userID int64, b pageBounds, page *PullPage) (n int, usn, id int64, err error)
+
+// pullStages is the order tables are sent in. Note types and deck configs go
+// first since notes and decks reference them.
+var pullStages = []pullStage{
+	pullNoteTypes,
+	pullDeckConfigs,
+	pullDecks,
+	pullNotes,
+	pullCards,
+	pullRevlog,
+	pullGraves,
+}
+
+// PullChanges returns up to limit objects changed after since, continuing from
+// cursor when it is non-empty. A limit <= 0 returns everything in one page.
+// All pages of one pull are bounded by the server USN at its first page;
+// objects changed later are picked up by the next pull.
+func (r *Repository) PullChanges(userID int, since int, cursor string, limit int) (*PullPage, error) {
+	ctx := context.Background()
+	uid := int64(userID)
+
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	qtx := r.Q.WithTx(tx)
+
+	state, err := qtx.GetPullState(ctx, uid)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	var cur pullCursor
+	if cursor != "" {
+		if cur, err = decodePullCursor(cursor); err != nil {
+			return nil, err
+		}
+		// A full sync since the first page replaced the rows it walks, even
+		// when the USN has grown past Until again
+		if cur.Resets != state.Resets || int64(cur.Until) > state.Usn {
+			return nil, ErrInvalidCursor
+		}
+	} else {
+		cur = pullCursor{Since: since, Until: int(state.Usn), Resets: state.Resets, USN: int64(since), ID: math.MaxInt64}
+	}
+
+	page := &PullPage{ServerUSN: cur.Until}
+	if err := readStages(ctx, qtx, uid, &cur, len(pullStages), limit, page); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// Stage ranges of pullStages used by the Anki sync protocol, which sends
+// note types, deck configs and decks whole and pages only through records
+const (
+	firstRecordStage = 3 // notes
+	endRecordStage   = 6 // graves
+)
+
+// PullSchemaChanges returns the note types, deck configs, decks and graves
+// changed in (since, until], unpaginated
+func (r *Repository) PullSchemaChanges(userID, since, until int) (*PullPage, error) {
+	ctx := context.Background()
+	uid := int64(userID)
+
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	qtx := r.Q.WithTx(tx)
+
+	page := &PullPage{ServerUSN: until}
+	b := pageBounds{afterUSN: int64(since), afterID: math.MaxInt64, untilUSN: int64(until), limit: -1}
+	for _, stage := range append(pullStages[:firstRecordStage:firstRecordStage], pullStages[endRecordStage:]...) {
+		if _, _, _, err := stage(ctx, qtx, uid, b, page); err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+// PullRecords returns up to limit notes, cards and review log entries changed
+// in (since, until], continuing from cursor when it is non-empty
+func (r *Repository) PullRecords(userID, since, until int, cursor string, limit int) (*PullPage, error) {
+	ctx := context.Background()
+	uid := int64(userID)
+
+	cur := pullCursor{Since: since, Until: until, Stage: firstRecordStage, USN: int64(since), ID: math.MaxInt64}
+	if cursor != "" {
+		var err error
+		if cur, err = decodePullCursor(cursor); err != nil {
+			return nil, err
+		}
+		if cur.Stage < firstRecordStage || cur.Stage >= endRecordStage || cur.Since != since || cur.Until != until {
+			return nil, ErrInvalidCursor
+		}
+	}
+
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	page := &PullPage{ServerUSN: until}
+	if err := readStages(ctx, r.Q.WithTx(tx), uid, &cur, endRecordStage, limit, page); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// readStages appends up to limit rows from pullStages[cur.Stage:end] to page,
+// advancing cur. HasMore and Cursor are set when rows remain.
+func readStages(ctx context.Context, q *Queries, uid int64, cur *pullCursor, end, limit int, page *PullPage) error {
+	read := 0
+	for ; cur.Stage < end; cur.Stage++ {
+		b := pageBounds{afterUSN: cur.USN, afterID: cur.ID, untilUSN: int64(cur.Until), limit: -1}
+		if limit > 0 {
+			b.limit = int64(limit - read)
+		}
+		n, usn, id, err := pullStages[cur.Stage](ctx, q, uid, b, page)
+		if err != nil {
+			return err
+		}
+		read += n
+		if limit > 0 && read >= limit {
+			cur.USN, cur.ID = usn, id
+			break
+		}
+		cur.USN, cur.ID = int64(cur.Since), math.MaxInt64
+	}
+
+	if cur.Stage < end {
+		more, err := hasMoreRows(ctx, q, uid, *cur, end)
+		if err != nil {
+			return err
+		}
+		if more {
+			page.HasMore = true
+			page.Cursor = cur.encode()
+		}
+	}
+	return nil
+}
+
+// hasMoreRows reports whether any row remains after cur, so the last page of
+// a pull is not followed by an empty one
+func hasMoreRows(ctx context.Context, q *Queries, userID int64, cur pullCursor, end int) (bool, error) {
+	var probe PullPage
+	for ; cur.Stage < end; cur.Stage++ {
+		b := pageBounds{afterUSN: cur.USN, afterID: cur.ID, untilUSN: int64(cur.Until), limit: 1}
+		n, _, _, err := pullStages[cur.Stage](ctx, q, userID, b, &probe)
+		if err != nil || n > 0 {
+			return n > 0, err
+		}
+		cur.USN, cur.ID = int64(cur.Since), math.MaxInt64
+	}
+	return false, nil
+}
+
+func pullNoteTypes(ctx context.Context, q *Queries, userID int64, b pageBounds, page *PullPage) (int, int64, int64, error) {
+	rows, err := q.GetNoteTypesSince(ctx, GetNoteTypesSinceParams{
+		UserID: userID, AfterUsn: b.afterUSN, AfterID: b.afterID, UntilUsn: b.untilUSN, MaxRows: b.limit,
+	})
+	if err != nil || len(rows) == 0 {
+		return 0, 0, 0, err
+	}
+	for _, nt := range rows {
+		noteType := SyncNoteType{
+			ID:        nt.ID,
+			Name:      nt.Name,
+			Type:      int(nt.Type),
+			Mod:       nt.Mod,
+			USN:       int(nt.Usn),
+			SortField: int(nt.SortField),
+			CSS:       nt.Css,
+			Data:      nt.Data,
+		}
+		if err := json.Unmarshal([]byte(nt.Fields), &noteType.Fields); err != nil {
+			return 0, 0, 0, fmt.Errorf("note type %d has invalid fields: %w", nt.ID, err)
+		}
+		if err := json.Unmarshal([]byte(nt.Templates), &noteType.Templates); err != nil {
+			return 0, 0, 0, fmt.Errorf("note type %d has invalid templates: %w", nt.ID, err)
+		}
+		page.NoteTypes = append(page.NoteTypes, noteType)
+	}
+	last := rows[len(rows)-1]
+	return len(rows), last.Usn, last.ID, nil
+}
+
+func pullDeckConfigs(ctx context.Context, q *Queries, userID int64, b pageBounds, page *PullPage) (int, int64, int64, error) {
+	rows, err := q.GetDeckConfigsSince(ctx, GetDeckConfigsSinceParams{
+		UserID: userID, AfterUsn: b.afterUSN, AfterID: b.afterID, UntilUsn: b.untilUSN, MaxRows: b.limit,
+	})
+	if err != nil || len(rows) == 0 {
+		return 0, 0, 0, err
+	}
+	for _, dc := range rows {
+		config := SyncDeckConfig{
+			ID:   dc.ID,
+			Name: dc.Name,
+			Mod:  dc.Mod,
+			USN:  int(dc.Usn),
+		}
+		if err := json.Unmarshal([]byte(dc.Config), &config.Config); err != nil {
+			return 0, 0, 0, fmt.Errorf("deck config %d is invalid: %w", dc.ID, err)
+		}
+		page.DeckConfigs = append(page.DeckConfigs, config)
+	}
+	last := rows[len(rows)-1]
+	return len(rows), last.Usn, last.ID, nil
+}
+
+func pullDecks(ctx context.Context, q *Queries, userID int64, b pageBounds, page *PullPage) (int, int64, int64, error) {
+	rows, err := q.GetDecksSince(ctx, GetDecksSinceParams{
+		UserID: userID, AfterUsn: b.afterUSN, AfterID: b.afterID, UntilUsn: b.untilUSN, MaxRows: b.limit,
+	})
+	if err != nil || len(rows) == 0 {
+		return 0, 0, 0, err
+	}
+	for _, d := range rows {
+		page.Decks = append(page.Decks, SyncDeck{
+			ID:          d.ID,
+			Name:        d.Name,
+			Description: d.Description.String,
+			ConfigID:    int(d.ConfigID.Int64),
+			CreatedAt:   d.CreatedAt,
+			ModifiedAt:  d.ModifiedAt,
+			USN:         int(d.Usn),
+		})
+	}
+	last := rows[len(rows)-1]
+	return len(rows), last.Usn, last.ID, nil
+}
+
+func pullNotes(ctx context.Context, q *Queries, userID int64, b pageBounds, page *PullPage) (int, int64, int64, error) {
+	rows, err := q.GetNotesSince(ctx, GetNotesSinceParams{
+		UserID: userID, AfterUsn: b.afterUSN, AfterID: b.afterID, UntilUsn: b.untilUSN, MaxRows: b.limit,
+	})
+	if err != nil || len(rows) == 0 {
+		return 0, 0, 0, err
+	}
+	for _, n := range rows {
+		page.Notes = append(page.Notes, SyncNote{
+			ID:    n.ID,
+			GUID:  n.Guid,
+			MID:   n.Mid,
+			Mod:   n.Mod,
+			USN:   int(n.Usn),
+			Tags:  n.Tags,
+			Flds:  n.Flds,
+			Sfld:  n.Sfld,
+			Csum:  n.Csum,
+			Flags: int(n.Flags),
+			Data:  n.Data,
+		})
+	}
+	last := rows[len(rows)-1]
+	return len(rows), last.Usn, last.ID, nil
+}
+
+func pullCards(ctx context.Context, q *Queries, userID int64, b pageBounds, page *PullPage) (int, int64, int64, error) {
+	rows, err := q.GetCardsSince(ctx, GetCardsSinceParams{
+		UserID: userID, AfterUsn: b.afterUSN, AfterID: b.afterID, UntilUsn: b.untilUSN, MaxRows: b.limit,
+	})
+	if err != nil || len(rows) == 0 {
+		return 0, 0, 0, err
+	}
+	for _, c := range rows {
+		page.Cards = append(page.Cards, SyncCard{
+			ID:             c.ID,
+			NoteID:         c.NoteID,
+			DeckID:         c.DeckID,
+			Ordinal:        int(c.Ordinal),
+			ModifiedAt:     c.ModifiedAt,
+			USN:            int(c.Usn),
+			State:          int(c.State),
+			Queue:          int(c.Queue),
+			Due:            c.Due,
+			Interval:       int(c.Interval),
+			EaseFactor:     int(c.EaseFactor),
+			Reps:           int(c.Reps),
+			Lapses:         int(c.Lapses),
+			LeftCount:      int(c.LeftCount),
+			OriginalDue:    c.OriginalDue,
+			OriginalDeckID: c.OriginalDeckID,
+			Flags:          int(c.Flags),
+			Data:           c.Data,
+			Stability:      &c.Stability,
+			Difficulty:     &c.Difficulty,
+		})
+	}
+	last := rows[len(rows)-1]
+	return len(rows), last.Usn, last.ID, nil
+}
+
+func pullRevlog(ctx context.Context, q *Queries, userID int64, b pageBounds, page *PullPage) (int, int64, int64, error) {
+	rows, err := q.GetRevlogSince(ctx, GetRevlogSinceParams{
+		UserID: userID, AfterUsn: b.afterUSN, AfterID: b.afterID, UntilUsn: b.untilUSN, MaxRows: b.limit,
+	})
+	if err != nil || len(rows) == 0 {
+		return 0, 0, 0, err
+	}
+	for _, e := range rows {
+		page.Revlog = append(page.Revlog, SyncRevlog{
+			ID:      e.ID,
+			CID:     e.Cid,
+			USN:     int(e.Usn),
+			Ease:    int(e.Ease),
+			Ivl:     int(e.Ivl),
+			LastIvl: int(e.LastIvl),
+			Factor:  int(e.Factor),
+			Time:    int(e.Time),
+			Type:    int(e.Type),
+		})
+	}
+	last := rows[len(rows)-1]
+	return len(rows), last.Usn, last.ID, nil
+}
+
+func pullGraves(ctx context.Context, q *Queries, userID int64, b pageBounds, page *PullPage) (int, int64, int64, error) {
+	rows, err := q.GetGravesSince(ctx, GetGravesSinceParams{
+		UserID: userID, AfterUsn: b.afterUSN, AfterID: b.afterID, UntilUsn: b.untilUSN, MaxRows: b.limit,
+	})
+	if err != nil || len(rows) == 0 {
+		return 0, 0, 0, err
+	}
+	for _, g := range rows {
+		page.Graves = append(page.Graves, SyncGrave{
+			OID:  g.Oid,
+			Type: int(g.Type),
+		})
+	}
+	last := rows[len(rows)-1]
+	return len(rows), last.Usn, last.ID, nil
+}
@@ -0,0 +1,195 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+// pullAll follows a pull's cursors with the given page size and returns the
+// ids of every card, note and deck in the order they were sent
+func pullAll(t *testing.T, userID, since, limit int) (ids []int64, pages int) {
+	t.Helper()
+	cursor := ""
+	for {
+		page, err := testRepo.PullChanges(userID, since, cursor, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages++
+		for _, d := range page.Decks {
+			ids = append(ids, d.ID)
+		}
+		for _, n := range page.Notes {
+			ids = append(ids, n.ID)
+		}
+		for _, c := range page.Cards {
+			ids = append(ids, c.ID)
+		}
+		if !page.HasMore {
+			return ids, pages
+		}
+		if limit > 0 && len(page.Decks)+len(page.Notes)+len(page.Cards) > limit {
+			t.Fatalf("page of limit %d has %d objects", limit, len(page.Decks)+len(page.Notes)+len(page.Cards))
+		}
+		cursor = page.Cursor
+	}
+}
+
+func TestPullChangesPaging(t *testing.T) {
+	userID := createTestUser(t)
+	payload := &SyncPayload{
+		Decks: []SyncDeck{{ID: 10, Name: "A"}, {ID: 11, Name: "B"}},
+		Notes: []SyncNote{{ID: 20, GUID: "a", Flds: "x"}, {ID: 21, GUID: "b", Flds: "y"}, {ID: 22, GUID: "c", Flds: "z"}},
+		Cards: []SyncCard{{ID: 30, NoteID: 20, DeckID: 10}, {ID: 31, NoteID: 21, DeckID: 11}},
+	}
+	if _, err := testRepo.PushSyncSafe(userID, payload, ConflictLastWriteWins); err != nil {
+		t.Fatal(err)
+	}
+	want := []int64{10, 11, 20, 21, 22, 30, 31}
+
+	tests := []struct {
+		name      string
+		limit     int
+		wantPages int
+	}{
+		{"unlimited", 0, 1},
+		{"one per page", 1, 7},
+		// Pages end at 2 and 4 rows, inside the deck and note stages,
+		// and the last page starts in the note stage and ends with cards
+		{"across stage boundaries", 3, 3},
+		{"exact fit", 7, 1},
+		{"larger than the pull", 100, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ids, pages := pullAll(t, userID, 0, tt.limit)
+			if len(ids) != len(want) {
+				t.Fatalf("pulled %v, want %v", ids, want)
+			}
+			for i := range want {
+				if ids[i] != want[i] {
+					t.Fatalf("pulled %v, want %v", ids, want)
+				}
+			}
+			if pages != tt.wantPages {
+				t.Errorf("took %d pages, want %d", pages, tt.wantPages)
+			}
+		})
+	}
+}
+
+func TestPullChangesCursorBoundsPull(t *testing.T) {
+	userID := createTestUser(t)
+	first, err := testRepo.PushSyncSafe(userID, &SyncPayload{Decks: []SyncDeck{{ID: 1, Name: "A"}, {ID: 2, Name: "B"}}}, ConflictLastWriteWins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := testRepo.PullChanges(userID, 0, "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Changes made during the pull wait for the next one
+	if _, err := testRepo.PushSyncSafe(userID, &SyncPayload{Decks: []SyncDeck{{ID: 3, Name: "C"}}}, ConflictLastWriteWins); err != nil {
+		t.Fatal(err)
+	}
+	page, err = testRepo.PullChanges(userID, 0, page.Cursor, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.HasMore || len(page.Decks) != 1 || page.Decks[0].ID != 2 {
+		t.Errorf("second page = %+v, want deck 2 only", page.Decks)
+	}
+	if page.ServerUSN != first.USN {
+		t.Errorf("server usn = %d, want %d from the first page", page.ServerUSN, first.USN)
+	}
+}
+
+func TestPullChangesStaleCursor(t *testing.T) {
+	decks := func(ids ...int64) *SyncPayload {
+		p := &SyncPayload{}
+		for _, id := range ids {
+			p.Decks = append(p.Decks, SyncDeck{ID: id, Name: "deck"})
+		}
+		return p
+	}
+	tests := []struct {
+		name string
+		// change runs between the first and second page
+		change func(t *testing.T, userID int)
+	}{
+		{
+			name: "full upload",
+			change: func(t *testing.T, userID int) {
+				if _, err := testRepo.ReplaceCollection(userID, decks(7)); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "full upload then pushes past the cursor's usn",
+			change: func(t *testing.T, userID int) {
+				if _, err := testRepo.ReplaceCollection(userID, decks(7)); err != nil {
+					t.Fatal(err)
+				}
+				for i := int64(0); i < 5; i++ {
+					if _, err := testRepo.PushSyncSafe(userID, decks(100+i), ConflictLastWriteWins); err != nil {
+						t.Fatal(err)
+					}
+				}
+			},
+		},
+		{
+			name: "reset then pushes past the cursor's usn",
+			change: func(t *testing.T, userID int) {
+				if err := testRepo.DeleteUserData(userID); err != nil {
+					t.Fatal(err)
+				}
+				for i := int64(0); i < 5; i++ {
+					if _, err := testRepo.PushSyncSafe(userID, decks(100+i), ConflictLastWriteWins); err != nil {
+						t.Fatal(err)
+					}
+				}
+			},
+		},
+		{
+			name: "anki full upload",
+			change: func(t *testing.T, userID int) {
+				if _, err := testRepo.ReplaceAnkiCollection(userID, decks(7), 1, 1); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID := createTestUser(t)
+			for i := int64(1); i <= 3; i++ {
+				if _, err := testRepo.PushSyncSafe(userID, decks(i), ConflictLastWriteWins); err != nil {
+					t.Fatal(err)
+				}
+			}
+			page, err := testRepo.PullChanges(userID, 0, "", 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !page.HasMore {
+				t.Fatal("first page is the whole pull")
+			}
+
+			tt.change(t, userID)
+			if _, err := testRepo.PullChanges(userID, 0, page.Cursor, 1); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("pull with stale cursor: err = %v, want ErrInvalidCursor", err)
+			}
+		})
+	}
+}
+
+func TestPullChangesMalformedCursor(t *testing.T) {
+	userID := createTestUser(t)
+	for _, cursor := range []string{"!", "WzFd", pullCursor{Since: 5, Until: 1}.encode(), pullCursor{Stage: len(pullStages)}.encode()} {
+		if _, err := testRepo.PullChanges(userID, 0, cursor, 1); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("cursor %q: err = %v, want ErrInvalidCursor", cursor, err)
+		}
+	}
+}
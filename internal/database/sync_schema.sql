@@ -3,12 +3,16 @@ CREATE TABLE IF NOT EXISTS user_collections (
     user_id INTEGER PRIMARY KEY,
     usn INTEGER NOT NULL DEFAULT 0,
     last_sync DATETIME,
+    mod INTEGER NOT NULL DEFAULT 0, -- last change, epoch milliseconds
+    scm INTEGER NOT NULL DEFAULT 0, -- last schema change (full sync), epoch milliseconds
+    crt INTEGER NOT NULL DEFAULT 0, -- collection creation, epoch seconds (Anki day cutoff)
+    resets INTEGER NOT NULL DEFAULT 0, -- full syncs so far; pull cursors from before one are stale
     FOREIGN KEY(user_id) REFERENCES users(id)
 );
 
--- User's synced decks
+-- User's synced decks (ids are client-generated, so they are only unique per user)
 CREATE TABLE IF NOT EXISTS user_decks (
-    id INTEGER PRIMARY KEY,
+    id INTEGER NOT NULL,
     user_id INTEGER NOT NULL,
     name TEXT NOT NULL,
     description TEXT,
@@ -16,12 +20,13 @@ CREATE TABLE IF NOT EXISTS user_decks (
     created_at INTEGER NOT NULL,
     modified_at INTEGER NOT NULL,
     usn INTEGER NOT NULL,
+    PRIMARY KEY (user_id, id),
     FOREIGN KEY(user_id) REFERENCES users(id)
 );
 
 -- User's synced notes (card content)
 CREATE TABLE IF NOT EXISTS user_notes (
-    id INTEGER PRIMARY KEY,
+    id INTEGER NOT NULL,
     user_id INTEGER NOT NULL,
     guid TEXT NOT NULL,
     mid INTEGER NOT NULL,
@@ -33,12 +38,13 @@ CREATE TABLE IF NOT EXISTS user_notes (
     csum INTEGER NOT NULL DEFAULT 0,
     flags INTEGER NOT NULL DEFAULT 0,
     data TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (user_id, id),
     FOREIGN KEY(user_id) REFERENCES users(id)
 );
 
 -- User's synced cards (scheduling data)
 CREATE TABLE IF NOT EXISTS user_cards (
-    id INTEGER PRIMARY KEY,
+    id INTEGER NOT NULL,
     user_id INTEGER NOT NULL,
     note_id INTEGER NOT NULL,
     deck_id INTEGER NOT NULL,
@@ -59,6 +65,36 @@ CREATE TABLE IF NOT EXISTS user_cards (
     data TEXT NOT NULL DEFAULT '',
     stability REAL DEFAULT 0,
     difficulty REAL DEFAULT 0,
+    PRIMARY KEY (user_id, id),
+    FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+-- User's note types (models): fields and templates are JSON arrays
+CREATE TABLE IF NOT EXISTS user_notetypes (
+    id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    type INTEGER NOT NULL DEFAULT 0, -- 0=standard, 1=cloze
+    mod INTEGER NOT NULL,
+    usn INTEGER NOT NULL,
+    sort_field INTEGER NOT NULL DEFAULT 0,
+    fields TEXT NOT NULL DEFAULT '[]',
+    templates TEXT NOT NULL DEFAULT '[]',
+    css TEXT NOT NULL DEFAULT '',
+    data TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (user_id, id),
+    FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+-- User's deck option groups (referenced by user_decks.config_id)
+CREATE TABLE IF NOT EXISTS user_deck_configs (
+    id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    mod INTEGER NOT NULL,
+    usn INTEGER NOT NULL,
+    config TEXT NOT NULL DEFAULT '{}', -- JSON scheduler options
+    PRIMARY KEY (user_id, id),
     FOREIGN KEY(user_id) REFERENCES users(id)
 );
 
@@ -68,7 +104,23 @@ CREATE TABLE IF NOT EXISTS user_graves (
     user_id INTEGER NOT NULL,
     usn INTEGER NOT NULL,
     oid INTEGER NOT NULL,
+    type INTEGER NOT NULL, -- 0=card, 1=note, 2=deck, 3=notetype, 4=deck config
+    FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+-- Review history (append-only: entries are never updated once stored)
+CREATE TABLE IF NOT EXISTS user_revlog (
+    id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    cid INTEGER NOT NULL,
+    usn INTEGER NOT NULL,
+    ease INTEGER NOT NULL,
+    ivl INTEGER NOT NULL,
+    last_ivl INTEGER NOT NULL,
+    factor INTEGER NOT NULL,
+    time INTEGER NOT NULL,
     type INTEGER NOT NULL,
+    PRIMARY KEY (user_id, id),
     FOREIGN KEY(user_id) REFERENCES users(id)
 );
 
@@ -80,10 +132,90 @@ CREATE TABLE IF NOT EXISTS user_media (
     hash TEXT NOT NULL,
     size INTEGER NOT NULL,
     usn INTEGER NOT NULL,
+    -- SHA-256 of the content, naming its media_blobs object. Empty for files
+    -- stored per user under {user_id}/{hash} before deduplication.
+    blob TEXT NOT NULL DEFAULT '',
+    mtime INTEGER NOT NULL DEFAULT 0, -- epoch seconds the file was stored
     FOREIGN KEY(user_id) REFERENCES users(id),
     UNIQUE(user_id, hash)
 );
 
+-- Media content shared by all users, stored once under media/sha256/. refs
+-- counts the user_media rows using it; blobs released (refs 0) for longer
+-- than the media GC grace period are deleted.
+CREATE TABLE IF NOT EXISTS media_blobs (
+    sha256 TEXT PRIMARY KEY,
+    sha1 TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    refs INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    released_at DATETIME
+);
+
+-- Deleted media files, so incremental media sync can report deletions. A
+-- grave is dropped when a file of the same name is added again.
+CREATE TABLE IF NOT EXISTS user_media_graves (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    filename TEXT NOT NULL,
+    hash TEXT NOT NULL,
+    usn INTEGER NOT NULL,
+    FOREIGN KEY(user_id) REFERENCES users(id),
+    UNIQUE(user_id, filename)
+);
+
+-- Smaller encodings of media files made by media.Transcoder, stored as
+-- shared blobs. source is the blob they were made from, so other users'
+-- copies of the same content reuse them.
+CREATE TABLE IF NOT EXISTS user_media_variants (
+    user_id INTEGER NOT NULL,
+    hash TEXT NOT NULL,
+    content_type TEXT NOT NULL,
+    blob TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    source TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (user_id, hash, content_type),
+    FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+-- Media uploads reserved with a presigned URL. A file moves to user_media
+-- once the client confirms the upload and the stored object checks out.
+CREATE TABLE IF NOT EXISTS user_media_pending (
+    user_id INTEGER NOT NULL,
+    hash TEXT NOT NULL,
+    filename TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, hash),
+    FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+-- Full upload sessions. Chunks are staged here and only replace the user's
+-- collection when the session is committed.
+CREATE TABLE IF NOT EXISTS sync_uploads (
+    id TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS sync_upload_chunks (
+    upload_id TEXT NOT NULL,
+    seq INTEGER NOT NULL,
+    payload BLOB NOT NULL, -- JSON encoded SyncPayload
+    PRIMARY KEY (upload_id, seq),
+    FOREIGN KEY(upload_id) REFERENCES sync_uploads(id)
+);
+
+-- Host keys issued to Anki desktop clients by the Anki sync protocol endpoint
+CREATE TABLE IF NOT EXISTS anki_host_keys (
+    key TEXT PRIMARY KEY, -- SHA-256 of the key, hex
+    user_id INTEGER NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_used_at INTEGER NOT NULL DEFAULT 0, -- epoch seconds
+    FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
 -- Indexes for efficient queries
 CREATE INDEX IF NOT EXISTS idx_user_decks_user ON user_decks(user_id);
 CREATE INDEX IF NOT EXISTS idx_user_decks_usn ON user_decks(user_id, usn);
@@ -91,5 +223,17 @@ CREATE INDEX IF NOT EXISTS idx_user_notes_user ON user_notes(user_id);
 CREATE INDEX IF NOT EXISTS idx_user_notes_usn ON user_notes(user_id, usn);
 CREATE INDEX IF NOT EXISTS idx_user_cards_user ON user_cards(user_id);
 CREATE INDEX IF NOT EXISTS idx_user_cards_usn ON user_cards(user_id, usn);
+CREATE INDEX IF NOT EXISTS idx_user_notetypes_usn ON user_notetypes(user_id, usn);
+CREATE INDEX IF NOT EXISTS idx_user_deck_configs_usn ON user_deck_configs(user_id, usn);
 CREATE INDEX IF NOT EXISTS idx_user_graves_user ON user_graves(user_id, usn);
+CREATE INDEX IF NOT EXISTS idx_user_graves_oid ON user_graves(user_id, type, oid);
+CREATE INDEX IF NOT EXISTS idx_user_revlog_usn ON user_revlog(user_id, usn);
+CREATE INDEX IF NOT EXISTS idx_user_revlog_card ON user_revlog(user_id, cid);
 CREATE INDEX IF NOT EXISTS idx_user_media_hash ON user_media(user_id, hash);
+CREATE INDEX IF NOT EXISTS idx_sync_uploads_user ON sync_uploads(user_id);
+CREATE INDEX IF NOT EXISTS idx_user_media_filename ON user_media(user_id, filename);
+CREATE INDEX IF NOT EXISTS idx_user_media_usn ON user_media(user_id, usn);
+CREATE INDEX IF NOT EXISTS idx_user_media_graves_usn ON user_media_graves(user_id, usn);
+CREATE INDEX IF NOT EXISTS idx_user_media_variants_source ON user_media_variants(source);
+CREATE INDEX IF NOT EXISTS idx_media_blobs_released ON media_blobs(refs, released_at);
+CREATE INDEX IF NOT EXISTS idx_anki_host_keys_user ON anki_host_keys(user_id);
@@ -0,0 +1,248 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrUploadNotFound is returned for unknown, expired or foreign upload sessions
+	ErrUploadNotFound = errors.New("upload session not found")
+	// ErrUploadIncomplete is returned when committing a session with missing chunks
+	ErrUploadIncomplete = errors.New("upload session is missing chunks")
+	// ErrUploadChunkCount is returned when committing fewer chunks than were
+	// uploaded, or none
+	ErrUploadChunkCount = errors.New("upload session has chunks past the committed count")
+	// ErrUploadInProgress is returned when beginning a full upload while
+	// another one of the user is open
+	ErrUploadInProgress = errors.New("another full upload is in progress")
+	// ErrUploadTooLarge is returned for chunks past MaxUploadChunks or
+	// MaxUploadBytes
+	ErrUploadTooLarge = errors.New("upload session is too large")
+)
+
+const (
+	// MaxUploadChunks caps the chunks of a full upload
+	MaxUploadChunks = 1024
+	// MaxUploadBytes caps the bytes staged for a full upload
+	MaxUploadBytes = 1 << 30
+)
+
+// BeginFullUpload opens a full upload session and returns its id. It fails
+// with ErrUploadInProgress while the user has a session begun within the last
+// hour. Older unfinished sessions of the user, and anyone's sessions older
+// than a day, are discarded.
+func (r *Repository) BeginFullUpload(userID int) (string, error) {
+	ctx := context.Background()
+	uid := int64(userID)
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+	qtx := r.Q.WithTx(tx)
+
+	open, err := qtx.CountOpenUploads(ctx, uid)
+	if err != nil {
+		return "", err
+	}
+	if open > 0 {
+		return "", ErrUploadInProgress
+	}
+	if err := qtx.DeleteStaleUploadChunks(ctx, uid); err != nil {
+		return "", err
+	}
+	if err := qtx.DeleteStaleUploads(ctx, uid); err != nil {
+		return "", err
+	}
+
+	id := GenerateRandomString(16)
+	if id == "" {
+		return "", errors.New("failed to generate upload id")
+	}
+	if err := qtx.CreateUpload(ctx, CreateUploadParams{ID: id, UserID: uid}); err != nil {
+		return "", err
+	}
+	return id, tx.Commit()
+}
+
+// PutFullUploadChunk stages chunk seq of an upload session. Re-sending a
+// chunk replaces it, so clients can retry after a dropped connection. Chunks
+// past MaxUploadChunks, or taking the session past MaxUploadBytes, fail with
+// ErrUploadTooLarge.
+func (r *Repository) PutFullUploadChunk(userID int, uploadID string, seq int, payload *SyncPayload) error {
+	ctx := context.Background()
+	if err := checkUploadOwner(ctx, r.Q, int64(userID), uploadID); err != nil {
+		return err
+	}
+	if seq < 0 || seq >= MaxUploadChunks {
+		return fmt.Errorf("%w: chunk %d of at most %d", ErrUploadTooLarge, seq, MaxUploadChunks)
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	stored, err := r.Q.PutUploadChunk(ctx, PutUploadChunkParams{
+		UploadID: uploadID,
+		Seq:      int64(seq),
+		Payload:  data,
+		MaxBytes: MaxUploadBytes,
+	})
+	if err != nil {
+		return err
+	}
+	if stored == 0 {
+		return fmt.Errorf("%w: over %d bytes", ErrUploadTooLarge, MaxUploadBytes)
+	}
+	return nil
+}
+
+// CommitFullUpload replaces the user's collection with chunks 0..chunks-1 of
+// the session and returns the new USN. The swap happens in one transaction:
+// if any chunk is missing or fails to apply, the old collection is kept.
+// Chunks with a seq of chunks or more are an error rather than dropped.
+func (r *Repository) CommitFullUpload(userID int, uploadID string, chunks int) (int, error) {
+	ctx := context.Background()
+	uid := int64(userID)
+	if chunks < 1 || chunks > MaxUploadChunks {
+		return 0, fmt.Errorf("%w: committing %d chunks", ErrUploadChunkCount, chunks)
+	}
+
+	// Checked in the transaction, so the upload cannot be aborted or
+	// receive more chunks before the swap
+	check := func(q *Queries) error {
+		if err := checkUploadOwner(ctx, q, uid, uploadID); err != nil {
+			return err
+		}
+		extra, err := q.CountUploadChunksFrom(ctx, CountUploadChunksFromParams{UploadID: uploadID, Seq: int64(chunks)})
+		if err != nil {
+			return err
+		}
+		if extra > 0 {
+			return fmt.Errorf("%w: %d chunks from seq %d", ErrUploadChunkCount, extra, chunks)
+		}
+		return nil
+	}
+	load := func(q *Queries, seq int) (*SyncPayload, error) {
+		data, err := q.GetUploadChunk(ctx, GetUploadChunkParams{UploadID: uploadID, Seq: int64(seq)})
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: chunk %d", ErrUploadIncomplete, seq)
+		}
+		if err != nil {
+			return nil, err
+		}
+		var payload SyncPayload
+		if err := json.Unmarshal(data, &payload); err != nil {
+			return nil, fmt.Errorf("chunk %d is corrupt: %w", seq, err)
+		}
+		return &payload, nil
+	}
+	done := func(q *Queries) error {
+		if err := q.DeleteUploadChunks(ctx, uploadID); err != nil {
+			return err
+		}
+		return q.DeleteUpload(ctx, uploadID)
+	}
+	return r.replaceCollection(ctx, uid, chunks, check, load, done)
+}
+
+// AbortFullUpload discards an upload session and its staged chunks
+func (r *Repository) AbortFullUpload(userID int, uploadID string) error {
+	ctx := context.Background()
+	if err := checkUploadOwner(ctx, r.Q, int64(userID), uploadID); err != nil {
+		return err
+	}
+	if err := r.Q.DeleteUploadChunks(ctx, uploadID); err != nil {
+		return err
+	}
+	return r.Q.DeleteUpload(ctx, uploadID)
+}
+
+// ReplaceCollection atomically replaces the user's collection with payload
+// and returns the new USN
+func (r *Repository) ReplaceCollection(userID int, payload *SyncPayload) (int, error) {
+	load := func(*Queries, int) (*SyncPayload, error) { return payload, nil }
+	return r.replaceCollection(context.Background(), int64(userID), 1, nil, load, nil)
+}
+
+// ReplaceAnkiCollection replaces the user's collection with a full upload
+// from Anki desktop and returns the new USN. scm/crt are taken from the
+// uploaded collection.
+func (r *Repository) ReplaceAnkiCollection(userID int, payload *SyncPayload, scm, crt int64) (int, error) {
+	ctx := context.Background()
+	uid := int64(userID)
+	load := func(*Queries, int) (*SyncPayload, error) { return payload, nil }
+	done := func(q *Queries) error {
+		return q.SetCollectionSchema(ctx, SetCollectionSchemaParams{Scm: scm, Crt: crt, UserID: uid})
+	}
+	return r.replaceCollection(ctx, uid, 1, nil, load, done)
+}
+
+// replaceCollection deletes the user's collection and applies chunks
+// 0..chunks-1 from load in a single transaction. check, if set, runs first
+// and done, if set, before the commit. Media is synced separately and kept. The USN sequence continues,
+// so the new objects are newer than anything other devices have seen.
+func (r *Repository) replaceCollection(ctx context.Context, uid int64, chunks int, check func(q *Queries) error,
+	load func(q *Queries, seq int) (*SyncPayload, error), done func(q *Queries) error) (int, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	qtx := r.Q.WithTx(tx)
+
+	if check != nil {
+		if err := check(qtx); err != nil {
+			return 0, err
+		}
+	}
+	if err := deleteCollection(ctx, qtx, uid); err != nil {
+		return 0, err
+	}
+	if err := qtx.CreateSyncMeta(ctx, uid); err != nil {
+		return 0, err
+	}
+	usn, err := qtx.UpdateUSN(ctx, uid)
+	if err != nil {
+		return 0, err
+	}
+	// A full sync invalidates incremental state of Anki desktop clients
+	if err := qtx.BumpCollectionSchema(ctx, uid); err != nil {
+		return 0, err
+	}
+
+	for seq := 0; seq < chunks; seq++ {
+		payload, err := load(qtx, seq)
+		if err != nil {
+			return 0, err
+		}
+		// The collection was just emptied, nothing can conflict
+		payload.ClientUSN = nil
+		if _, err := applyPayload(ctx, qtx, uid, payload, ConflictLastWriteWins, 0, usn); err != nil {
+			return 0, fmt.Errorf("chunk %d: %w", seq, err)
+		}
+	}
+
+	if done != nil {
+		if err := done(qtx); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return int(usn), nil
+}
+
+func checkUploadOwner(ctx context.Context, q *Queries, userID int64, uploadID string) error {
+	owner, err := q.GetUploadOwner(ctx, uploadID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && owner != userID) {
+		return ErrUploadNotFound
+	}
+	return err
+}
@@ -0,0 +1,171 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestCommitFullUpload(t *testing.T) {
+	chunk := func(deckID int64) *SyncPayload {
+		return &SyncPayload{Decks: []SyncDeck{{ID: deckID, Name: "deck"}}}
+	}
+	tests := []struct {
+		name    string
+		uploads []int // seqs of the uploaded chunks
+		commit  int
+		wantErr error
+		// wantDecks is the number of decks after a successful commit
+		wantDecks int
+	}{
+		{name: "all chunks", uploads: []int{0, 1, 2}, commit: 3, wantDecks: 3},
+		{name: "chunks out of order", uploads: []int{2, 0, 1}, commit: 3, wantDecks: 3},
+		{name: "missing chunk", uploads: []int{0, 2}, commit: 3, wantErr: ErrUploadIncomplete},
+		{name: "no chunks", commit: 0, wantErr: ErrUploadChunkCount},
+		{name: "zero chunks committed", uploads: []int{0}, commit: 0, wantErr: ErrUploadChunkCount},
+		{name: "chunk past the count", uploads: []int{0, 1, 5}, commit: 2, wantErr: ErrUploadChunkCount},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID := createTestUser(t)
+			before, err := testRepo.PushSyncSafe(userID, chunk(100), ConflictLastWriteWins)
+			if err != nil {
+				t.Fatal(err)
+			}
+			uploadID, err := testRepo.BeginFullUpload(userID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, seq := range tt.uploads {
+				if err := testRepo.PutFullUploadChunk(userID, uploadID, seq, chunk(int64(seq))); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			usn, err := testRepo.CommitFullUpload(userID, uploadID, tt.commit)
+			decks, derr := testRepo.GetDecksSince(userID, 0)
+			if derr != nil {
+				t.Fatal(derr)
+			}
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("commit error = %v, want %v", err, tt.wantErr)
+				}
+				if len(decks) != 1 || decks[0].ID != 100 {
+					t.Errorf("decks after a failed commit = %+v, want the old collection", decks)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(decks) != tt.wantDecks {
+				t.Errorf("got %d decks, want %d", len(decks), tt.wantDecks)
+			}
+			if usn <= before.USN {
+				t.Errorf("usn after commit = %d, want more than %d before it", usn, before.USN)
+			}
+		})
+	}
+}
+
+func TestReplaceCollectionKeepsMediaAndUSN(t *testing.T) {
+	userID := createTestUser(t)
+	if _, err := testRepo.PutMedia(userID, MediaFile{Filename: "a.png", Hash: "abc", Size: 3}); err != nil {
+		t.Fatal(err)
+	}
+	pushed, err := testRepo.PushSyncSafe(userID, &SyncPayload{Decks: []SyncDeck{{ID: 1, Name: "old"}}}, ConflictLastWriteWins)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usn, err := testRepo.ReplaceCollection(userID, &SyncPayload{Decks: []SyncDeck{{ID: 2, Name: "new"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usn <= pushed.USN {
+		t.Errorf("usn after full sync = %d, want more than %d", usn, pushed.USN)
+	}
+	media, err := testRepo.ListMedia(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(media) != 1 || media[0].Filename != "a.png" {
+		t.Errorf("media after full sync = %+v, want a.png kept", media)
+	}
+
+	// A device that last synced before the full sync sees its objects as changed
+	_, err = testRepo.PushSyncSafe(userID, &SyncPayload{ClientUSN: &pushed.USN, Decks: []SyncDeck{{ID: 2, Name: "stale"}}}, ConflictReject)
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) {
+		t.Errorf("stale push after full sync: err = %v, want a conflict", err)
+	}
+}
+
+func TestBeginFullUploadWhileOpen(t *testing.T) {
+	userID := createTestUser(t)
+	first, err := testRepo.BeginFullUpload(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testRepo.BeginFullUpload(userID); !errors.Is(err, ErrUploadInProgress) {
+		t.Fatalf("second upload: err = %v, want ErrUploadInProgress", err)
+	}
+
+	// An abandoned session no longer blocks, and is discarded
+	if _, err := testRepo.DB.Exec(`UPDATE sync_uploads SET created_at = datetime('now', '-2 hours') WHERE id = ?`, first); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testRepo.BeginFullUpload(userID); err != nil {
+		t.Fatalf("upload after an abandoned one: %v", err)
+	}
+	if err := testRepo.PutFullUploadChunk(userID, first, 0, &SyncPayload{}); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("chunk to the abandoned upload: err = %v, want ErrUploadNotFound", err)
+	}
+}
+
+func TestPutFullUploadChunkLimits(t *testing.T) {
+	userID := createTestUser(t)
+	uploadID, err := testRepo.BeginFullUpload(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, seq := range []int{-1, MaxUploadChunks} {
+		if err := testRepo.PutFullUploadChunk(userID, uploadID, seq, &SyncPayload{}); !errors.Is(err, ErrUploadTooLarge) {
+			t.Errorf("chunk %d: err = %v, want ErrUploadTooLarge", seq, err)
+		}
+	}
+	if err := testRepo.PutFullUploadChunk(userID, uploadID, MaxUploadChunks-1, &SyncPayload{}); err != nil {
+		t.Errorf("last chunk: %v", err)
+	}
+
+	// The staged bytes are capped per upload; a replaced chunk does not count
+	if err := testRepo.AbortFullUpload(userID, uploadID); err != nil {
+		t.Fatal(err)
+	}
+	if uploadID, err = testRepo.BeginFullUpload(userID); err != nil {
+		t.Fatal(err)
+	}
+	put := func(seq int64, size int) int64 {
+		t.Helper()
+		stored, err := testRepo.Q.PutUploadChunk(context.Background(), PutUploadChunkParams{
+			UploadID: uploadID,
+			Seq:      seq,
+			Payload:  make([]byte, size),
+			MaxBytes: 100,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stored
+	}
+	if put(0, 60) != 1 {
+		t.Error("first chunk refused")
+	}
+	if put(1, 60) != 0 {
+		t.Error("chunk past the cap stored")
+	}
+	if put(0, 90) != 1 {
+		t.Error("replacing a chunk within the cap refused")
+	}
+}
@@ -0,0 +1,93 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ThrottleAttempt counts an attempt under key, unless the key is locked out,
+// in which case it returns until when and counts nothing. Attempts are
+// forgotten once the last one is older than forgetBefore. lockFor returns
+// how long the key is locked out after the given number of attempts; the
+// lock is returned when it is set.
+func ThrottleAttempt(key string, forgetBefore time.Time, lockFor func(attempts int) time.Duration) (lockedUntil time.Time, locked time.Duration, err error) {
+	now := time.Now().UTC()
+	ctx := context.Background()
+	conn, err := DB.Conn(ctx)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	defer conn.Close()
+	// IMMEDIATE takes the write lock up front, so concurrent attempts wait
+	// for each other instead of failing with SQLITE_BUSY when a deferred
+	// transaction tries to upgrade its read lock
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		return time.Time{}, 0, err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			conn.ExecContext(ctx, "ROLLBACK")
+		}
+	}()
+
+	// Rows nobody tried in a long time hold nothing worth keeping
+	if _, err := conn.ExecContext(ctx, "DELETE FROM auth_throttles WHERE last_attempt_at < ? AND (locked_until IS NULL OR locked_until < ?)",
+		forgetBefore.UTC(), now); err != nil {
+		return time.Time{}, 0, err
+	}
+
+	var attempts int
+	var lastAttempt time.Time
+	var until sql.NullTime
+	err = conn.QueryRowContext(ctx, "SELECT attempts, last_attempt_at, locked_until FROM auth_throttles WHERE key = ?",
+		key).Scan(&attempts, &lastAttempt, &until)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, 0, err
+	}
+	if until.Valid && until.Time.After(now) {
+		return until.Time, 0, nil
+	}
+	if lastAttempt.Before(forgetBefore) {
+		attempts = 0
+	}
+
+	attempts++
+	locked = lockFor(attempts)
+	until = sql.NullTime{Time: now.Add(locked), Valid: locked > 0}
+	if _, err := conn.ExecContext(ctx, `INSERT INTO auth_throttles (key, attempts, last_attempt_at, locked_until) VALUES (?, ?, ?, ?)
+		ON CONFLICT(key) DO UPDATE SET attempts = excluded.attempts,
+			last_attempt_at = excluded.last_attempt_at, locked_until = excluded.locked_until`,
+		key, attempts, now, until); err != nil {
+		return time.Time{}, 0, err
+	}
+	if _, err := conn.ExecContext(ctx, "COMMIT"); err != nil {
+		return time.Time{}, 0, err
+	}
+	committed = true
+	return time.Time{}, locked, nil
+}
+
+// ForgiveThrottleAttempt takes back an attempt under key, lifting its lock
+// when no more than free attempts remain
+func ForgiveThrottleAttempt(key string, free int) error {
+	_, err := DB.Exec(`UPDATE auth_throttles SET attempts = MAX(attempts - 1, 0),
+		locked_until = CASE WHEN attempts - 1 <= ? THEN NULL ELSE locked_until END
+		WHERE key = ?`, free, key)
+	return err
+}
+
+// ClearThrottle forgets every attempt under key
+func ClearThrottle(key string) error {
+	_, err := DB.Exec("DELETE FROM auth_throttles WHERE key = ?", key)
+	return err
+}
+
+// RecordAuthEvent adds an event, such as a lockout, to the audit log
+func RecordAuthEvent(event, email, ip, detail string) error {
+	_, err := DB.Exec("INSERT INTO auth_events (event, email, ip, detail, created_at) VALUES (?, ?, ?, ?, ?)",
+		event, email, ip, detail, time.Now().UTC())
+	return err
+}
@@ -0,0 +1,82 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+)
+
+// storageQuotas is the storage each plan includes, for media files and
+// uploaded group decks together. Unknown plans get the free quota.
+var storageQuotas = map[string]int64{
+	"free":       100 << 20,
+	"pro":        5 << 30,
+	"group_host": 20 << 30,
+}
+
+// StorageQuota returns the bytes a user on plan may store
+func StorageQuota(plan string) int64 {
+	if quota, ok := storageQuotas[plan]; ok {
+		return quota
+	}
+	return storageQuotas["free"]
+}
+
+// StorageUsage is what a user stores on the server
+type StorageUsage struct {
+	Plan       string `json:"plan"`
+	QuotaBytes int64  `json:"quota_bytes"`
+	MediaBytes int64  `json:"media_bytes"`
+	MediaFiles int    `json:"media_files"`
+	// DeckBytes covers decks the user uploaded to groups. Decks count once
+	// their upload has been measured.
+	DeckBytes int64 `json:"deck_bytes"`
+	Decks     int   `json:"decks"`
+	Cards     int   `json:"cards"`
+	Notes     int   `json:"notes"`
+}
+
+// UsedBytes is the storage counted against the quota
+func (u *StorageUsage) UsedBytes() int64 {
+	return u.MediaBytes + u.DeckBytes
+}
+
+// GetStorageUsage returns the user's current storage use and quota
+func (r *Repository) GetStorageUsage(userID int) (*StorageUsage, error) {
+	ctx := context.Background()
+	uid := int64(userID)
+
+	user, err := GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, sql.ErrNoRows
+	}
+	usage := &StorageUsage{
+		Plan:       user.SubscriptionStatus,
+		QuotaBytes: StorageQuota(user.SubscriptionStatus),
+	}
+
+	if usage.MediaBytes, err = r.Q.GetMediaBytes(ctx, uid); err != nil {
+		return nil, err
+	}
+	files, err := r.Q.CountUserMedia(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	usage.MediaFiles = int(files)
+	if usage.DeckBytes, usage.Decks, err = GetUploadedDeckBytes(userID); err != nil {
+		return nil, err
+	}
+	cards, err := r.Q.CountUserCards(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	usage.Cards = int(cards)
+	notes, err := r.Q.CountUserNotes(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	usage.Notes = int(notes)
+	return usage, nil
+}
@@ -2,8 +2,12 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 )
 
+// ErrUserNotFound is returned for users that do not exist (any more)
+var ErrUserNotFound = errors.New("user not found")
+
 type User struct {
 	ID           int    `json:"id"`
 	Email        string `json:"email"`
@@ -14,6 +18,8 @@ type User struct {
 	Degree       string `json:"degree,omitempty"`
 	SubscriptionStatus string `json:"subscription_status"`
 	SubscriptionExpiry *string `json:"subscription_expiry,omitempty"`
+	TokenVersion int `json:"-"`
+	EmailVerified bool `json:"email_verified"`
 }
 
 func CreateUser(email, passwordHash, username string) (*User, error) {
@@ -36,15 +42,18 @@ func CreateUser(email, passwordHash, username string) (*User, error) {
 	}, nil
 }
 
+// GetUserByEmail finds a user by email regardless of case, preferring an
+// exact match among accounts whose addresses differ only in case
 func GetUserByEmail(email string) (*User, error) {
-	query := `SELECT id, email, password_hash, username, avatar_url, university, degree, subscription_status, subscription_expiry FROM users WHERE email = ?`
+	query := `SELECT id, email, password_hash, username, avatar_url, university, degree, subscription_status, subscription_expiry, token_version, email_verified_at IS NOT NULL FROM users
+		WHERE email = ?1 COLLATE NOCASE ORDER BY email = ?1 DESC, id LIMIT 1`
 	row := DB.QueryRow(query, email)
 
 	var u User
 	var avatarURL, university, degree, subscriptionExpiry sql.NullString
 	var subscriptionStatus string
 
-	err := row.Scan(&u.ID, &u.Email, &u.PasswordHash, &u.Username, &avatarURL, &university, &degree, &subscriptionStatus, &subscriptionExpiry)
+	err := row.Scan(&u.ID, &u.Email, &u.PasswordHash, &u.Username, &avatarURL, &university, &degree, &subscriptionStatus, &subscriptionExpiry, &u.TokenVersion, &u.EmailVerified)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil // Not found
@@ -71,20 +80,29 @@ func UpdateUser(id int, avatarURL, university, degree string) error {
 }
 
 func DeleteUser(id int) error {
+    if _, err := DB.Exec(`DELETE FROM sessions WHERE user_id = ?`, id); err != nil {
+        return err
+    }
+    if _, err := DB.Exec(`DELETE FROM user_identities WHERE user_id = ?`, id); err != nil {
+        return err
+    }
+    if _, err := DB.Exec(`DELETE FROM email_verifications WHERE user_id = ?`, id); err != nil {
+        return err
+    }
     query := `DELETE FROM users WHERE id = ?`
     _, err := DB.Exec(query, id)
     return err
 }
 
 func GetUserByID(id int) (*User, error) {
-    query := `SELECT id, email, password_hash, username, avatar_url, university, degree, subscription_status, subscription_expiry FROM users WHERE id = ?`
+    query := `SELECT id, email, password_hash, username, avatar_url, university, degree, subscription_status, subscription_expiry, token_version, email_verified_at IS NOT NULL FROM users WHERE id = ?`
     row := DB.QueryRow(query, id)
 
     var u User
     var avatarURL, university, degree, subscriptionExpiry sql.NullString
 	var subscriptionStatus string
 
-    err := row.Scan(&u.ID, &u.Email, &u.PasswordHash, &u.Username, &avatarURL, &university, &degree, &subscriptionStatus, &subscriptionExpiry)
+    err := row.Scan(&u.ID, &u.Email, &u.PasswordHash, &u.Username, &avatarURL, &university, &degree, &subscriptionStatus, &subscriptionExpiry, &u.TokenVersion, &u.EmailVerified)
     if err != nil {
         if err == sql.ErrNoRows {
             return nil, nil // Not found
@@ -0,0 +1,36 @@
+package database
+
+import "testing"
+
+func TestGetUserByEmailIgnoresCase(t *testing.T) {
+	mixed, err := CreateUser("Mixed.Case@Example.com", "hash", "mixedcase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, email := range []string{"Mixed.Case@Example.com", "mixed.case@example.com", "MIXED.CASE@EXAMPLE.COM"} {
+		user, err := GetUserByEmail(email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user == nil || user.ID != mixed.ID {
+			t.Errorf("GetUserByEmail(%q) = %+v, want user %d", email, user, mixed.ID)
+		}
+	}
+
+	// Accounts from before lookups ignored case may differ only in case;
+	// the exact address wins
+	lower, err := CreateUser("mixed.case@example.com", "hash", "lowercase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user, err := GetUserByEmail("mixed.case@example.com"); err != nil || user == nil || user.ID != lower.ID {
+		t.Errorf("exact lower case match = %+v, %v, want user %d", user, err, lower.ID)
+	}
+	if user, err := GetUserByEmail("Mixed.Case@Example.com"); err != nil || user == nil || user.ID != mixed.ID {
+		t.Errorf("exact mixed case match = %+v, %v, want user %d", user, err, mixed.ID)
+	}
+
+	if user, err := GetUserByEmail("nobody@example.com"); err != nil || user != nil {
+		t.Errorf("unknown email = %+v, %v, want nil", user, err)
+	}
+}
@@ -2,6 +2,7 @@ package database
 
 import (
 	"crypto/rand"
+	"database/sql"
 	"encoding/hex"
 )
 
@@ -13,3 +14,11 @@ func GenerateRandomString(n int) string {
 	}
 	return hex.EncodeToString(bytes)
 }
+
+// nullFloat converts an optional value to a nullable column value
+func nullFloat(v *float64) sql.NullFloat64 {
+	if v == nil {
+		return sql.NullFloat64{}
+	}
+	return sql.NullFloat64{Float64: *v, Valid: true}
+}
@@ -8,6 +8,46 @@ import (
 
 // SendResetEmail sends a password reset code to the specified email address
 func SendResetEmail(toEmail, code string) error {
+	subject := "Reset your Checkst Password"
+	body := fmt.Sprintf(`Hello,
+
+You requested a password reset for your Checkst account.
+Your reset code is:
+
+%s
+
+This code will expire in 10 minutes.
+If you did not request this reset, please ignore this email.
+Also, check your spam folder if you don't use this often!
+
+Best regards,
+Checkst Team`, code)
+
+	return send(toEmail, subject, body)
+}
+
+// SendVerificationEmail sends the code confirming a new account's address
+func SendVerificationEmail(toEmail, code string) error {
+	subject := "Verify your Checkst email address"
+	body := fmt.Sprintf(`Hello,
+
+Welcome to Checkst! Please confirm this is your email address by
+entering this code in the app:
+
+%s
+
+This code will expire in 24 hours.
+If you did not create a Checkst account, please ignore this email.
+
+Best regards,
+Checkst Team`, code)
+
+	return send(toEmail, subject, body)
+}
+
+// send sends a plain text email through the SMTP server configured by the
+// SMTP_* environment variables
+func send(toEmail, subject, body string) error {
 	smtpHost := os.Getenv("SMTP_HOST")
 	smtpPort := os.Getenv("SMTP_PORT")
 	smtpUser := os.Getenv("SMTP_USER")
@@ -23,21 +63,6 @@ func SendResetEmail(toEmail, code string) error {
 	}
 
 	auth := smtp.PlainAuth("", smtpUser, smtpPass, smtpHost)
-	
-	subject := "Reset your Checkst Password"
-	body := fmt.Sprintf(`Hello,
-
-You requested a password reset for your Checkst account.
-Your reset code is:
-
-%s
-
-This code will expire in 10 minutes.
-If you did not request this reset, please ignore this email.
-Also, check your spam folder if you don't use this often!
-
-Best regards,
-Checkst Team`, code)
 
 	msg := []byte(fmt.Sprintf("To: %s\r\n"+
 		"From: %s\r\n"+
@@ -0,0 +1,111 @@
+package media
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/magnusohle/openanki-backend/internal/database"
+)
+
+// BlobKey is the key of media content shared by all users, by the hex
+// SHA-256 of the content
+func BlobKey(sha256 string) string {
+	if len(sha256) < 2 {
+		return "media/sha256/" + sha256
+	}
+	return "media/sha256/" + sha256[:2] + "/" + sha256
+}
+
+// FileKey is the key of a user's media file: its shared blob, or for files
+// stored before deduplication its MediaKey
+func FileKey(userID int, f *database.MediaFile) string {
+	if f.Blob != "" {
+		return BlobKey(f.Blob)
+	}
+	return MediaKey(userID, f.Hash)
+}
+
+// StoreContent makes sure data is stored as a shared blob and returns its
+// SHA-256 for database.MediaFile.Blob. Content already stored is not written
+// again.
+func StoreContent(ctx context.Context, repo *database.Repository, store BlobStore, data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	blob := hex.EncodeToString(sum[:])
+	_, err := repo.FindMediaBlob(blob)
+	if err == nil {
+		return blob, nil
+	}
+	if !errors.Is(err, database.ErrMediaBlobMissing) {
+		return "", err
+	}
+
+	if err := store.Put(ctx, BlobKey(blob), data); err != nil {
+		return "", err
+	}
+	sum1 := sha1.Sum(data)
+	if err := repo.RegisterMediaBlob(blob, hex.EncodeToString(sum1[:]), int64(len(data))); err != nil {
+		return "", err
+	}
+	return blob, nil
+}
+
+// Spooled is content copied to a temporary file, with its digests
+type Spooled struct {
+	File   *os.File
+	Size   int64
+	SHA1   []byte
+	SHA256 []byte
+}
+
+// Spool copies r to a temporary file, hashing it on the way. At most limit+1
+// bytes are read, so a Size over limit means r was longer. Close the result
+// to remove the file.
+func Spool(r io.Reader, limit int64) (*Spooled, error) {
+	f, err := os.CreateTemp("", "openanki-spool-*")
+	if err != nil {
+		return nil, err
+	}
+	s1, s256 := sha1.New(), sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, s1, s256), io.LimitReader(r, limit+1))
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return &Spooled{File: f, Size: n, SHA1: s1.Sum(nil), SHA256: s256.Sum(nil)}, nil
+}
+
+// Close removes the temporary file
+func (s *Spooled) Close() error {
+	s.File.Close()
+	return os.Remove(s.File.Name())
+}
+
+// StoreSpooled is StoreContent for spooled content, streamed to the store
+// rather than held in memory
+func StoreSpooled(ctx context.Context, repo *database.Repository, store BlobStore, s *Spooled) (string, error) {
+	blob := hex.EncodeToString(s.SHA256)
+	_, err := repo.FindMediaBlob(blob)
+	if err == nil {
+		return blob, nil
+	}
+	if !errors.Is(err, database.ErrMediaBlobMissing) {
+		return "", err
+	}
+
+	if _, err := s.File.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	if err := store.PutReader(ctx, BlobKey(blob), s.File, s.Size); err != nil {
+		return "", err
+	}
+	if err := repo.RegisterMediaBlob(blob, hex.EncodeToString(s.SHA1), s.Size); err != nil {
+		return "", err
+	}
+	return blob, nil
+}
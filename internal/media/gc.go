@@ -0,0 +1,333 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/magnusohle/openanki-backend/internal/anki"
+	"github.com/magnusohle/openanki-backend/internal/database"
+)
+
+// GC removes stored media nothing points at any more, e.g. after a full sync
+// reset or a deletion synced from a device:
+//
+//   - with RemoveUnused, media files no note references that were stored
+//     more than Grace ago. They get graves, so devices delete them on their
+//     next media sync.
+//   - shared blobs (BlobKey) whose last file went away more than Grace ago
+//   - per-user objects (MediaKey), i.e. staged uploads and media stored
+//     before deduplication, that no upload reservation or old file uses and
+//     that were last written more than Grace ago
+//
+// so uploads in flight, and files whose notes have not synced yet, are left
+// alone.
+type GC struct {
+	Repo  *database.Repository
+	Store BlobStore
+	Grace time.Duration
+	// DryRun only reports what would be deleted
+	DryRun bool
+	// Migrate moves media stored before deduplication to shared blobs. The
+	// per-user copies are collected by a later run once past Grace.
+	Migrate bool
+	// RemoveUnused deletes the users' media files no note references, not
+	// just the storage nothing uses. Without it they are only counted.
+	RemoveUnused bool
+}
+
+// GCObject is a stored media blob
+type GCObject struct {
+	UserID       int
+	Hash         string
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// GCReport is the outcome of a GC run
+type GCReport struct {
+	Users   int
+	Objects int
+	// Orphans are the blobs past the grace period that nothing points at
+	Orphans     []GCObject
+	OrphanBytes int64
+	// Recent counts unused blobs still within the grace period
+	Recent int
+	// Released counts shared blobs without files past the grace period
+	Released      int
+	ReleasedBytes int64
+	Deleted       int
+	DeletedBytes  int64
+	// Migrated counts files moved to shared blobs
+	Migrated int
+	// Unused counts media files no note references that were stored more
+	// than Grace ago. Like Anki's Check Media, names starting with "_" are
+	// taken to be used by templates, and LaTeX renders ("latex-") by the
+	// [latex] markup they were made from.
+	Unused      int
+	UnusedBytes int64
+	// UnusedRecent counts unreferenced files still within the grace period
+	UnusedRecent int
+	// Removed counts the unused files deleted with RemoveUnused. Their
+	// content is collected like other released media, by a later run.
+	Removed      int
+	RemovedBytes int64
+	// Missing counts note references to files the user does not have
+	Missing int
+}
+
+// Run scans storage once
+func (g *GC) Run(ctx context.Context) (*GCReport, error) {
+	cutoff := time.Now().Add(-g.Grace)
+	if !g.DryRun {
+		if err := g.Repo.ExpirePendingMedia(cutoff); err != nil {
+			return nil, err
+		}
+	}
+
+	blobs, err := g.listBlobs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	users, err := g.Repo.ListMediaUsers()
+	if err != nil {
+		return nil, err
+	}
+	for uid := range blobs {
+		users = append(users, uid)
+	}
+	sort.Ints(users)
+
+	report := &GCReport{}
+	for i, uid := range users {
+		if i > 0 && users[i-1] == uid {
+			continue
+		}
+		report.Users++
+		if err := g.scanUser(ctx, uid, blobs[uid], cutoff, report); err != nil {
+			return report, err
+		}
+	}
+	return report, g.collectReleased(ctx, cutoff, report)
+}
+
+// collectReleased deletes the shared blobs no file has used since cutoff
+func (g *GC) collectReleased(ctx context.Context, cutoff time.Time, report *GCReport) error {
+	released, err := g.Repo.ListReleasedMediaBlobs(cutoff)
+	if err != nil {
+		return err
+	}
+	for _, b := range released {
+		report.Released++
+		report.ReleasedBytes += b.Size
+		if g.DryRun {
+			continue
+		}
+		// Forget the blob first, so nothing can start using it meanwhile
+		deleted, err := g.Repo.DeleteReleasedMediaBlob(b.SHA256, cutoff)
+		if err != nil {
+			return err
+		}
+		if !deleted {
+			continue
+		}
+		if err := g.Store.Delete(ctx, BlobKey(b.SHA256)); err != nil {
+			log.Printf("⚠️ Failed to delete released media %s: %v", BlobKey(b.SHA256), err)
+			continue
+		}
+		report.Deleted++
+		report.DeletedBytes += b.Size
+	}
+	return nil
+}
+
+func (g *GC) scanUser(ctx context.Context, userID int, blobs []GCObject, cutoff time.Time, report *GCReport) error {
+	files, err := g.Repo.ListMedia(userID)
+	if err != nil {
+		return err
+	}
+	pending, err := g.Repo.ListPendingMedia(userID, cutoff)
+	if err != nil {
+		return err
+	}
+	fields, err := g.Repo.ListNoteFields(userID)
+	if err != nil {
+		return err
+	}
+
+	refs := noteReferences(fields)
+	removed, err := g.removeUnused(userID, files, refs, len(fields) > 0, cutoff, report)
+	if err != nil {
+		return err
+	}
+
+	// Per-user objects are live while a file stored before deduplication
+	// uses them
+	live := make(map[string]bool, len(files)+len(pending))
+	names := make(map[string]bool, len(files))
+	for i := range files {
+		f := &files[i]
+		names[f.Filename] = true
+		if removed[f.Filename] {
+			continue
+		}
+		if f.Blob == "" && g.Migrate && !g.DryRun {
+			if err := g.migrate(ctx, userID, f); err != nil {
+				log.Printf("⚠️ Failed to migrate media %s: %v", MediaKey(userID, f.Hash), err)
+			} else {
+				report.Migrated++
+			}
+		}
+		if f.Blob == "" {
+			live[f.Hash] = true
+		}
+	}
+	for _, hash := range pending {
+		live[hash] = true
+	}
+	for name := range refs {
+		if !names[name] {
+			report.Missing++
+		}
+	}
+
+	for _, b := range blobs {
+		report.Objects++
+		if live[b.Hash] {
+			continue
+		}
+		if b.LastModified.After(cutoff) {
+			report.Recent++
+			continue
+		}
+		report.Orphans = append(report.Orphans, b)
+		report.OrphanBytes += b.Size
+		if g.DryRun {
+			continue
+		}
+		deleted, err := g.deleteBlob(ctx, b)
+		if err != nil {
+			log.Printf("⚠️ Failed to delete orphaned media %s: %v", b.Key, err)
+			continue
+		}
+		if deleted {
+			report.Deleted++
+			report.DeletedBytes += b.Size
+		}
+	}
+	return nil
+}
+
+// removeUnused counts the files no note references that were stored before
+// cutoff and, with RemoveUnused, deletes them and returns their names. Users
+// without notes keep their files, as their collection may not have been
+// uploaded yet.
+func (g *GC) removeUnused(userID int, files []database.MediaFile, refs map[string]bool, hasNotes bool, cutoff time.Time, report *GCReport) (map[string]bool, error) {
+	var unused []string
+	for _, f := range files {
+		if refs[f.Filename] || strings.HasPrefix(f.Filename, "_") || strings.HasPrefix(f.Filename, "latex-") {
+			continue
+		}
+		if f.StoredAt.After(cutoff) {
+			report.UnusedRecent++
+			continue
+		}
+		report.Unused++
+		report.UnusedBytes += f.Size
+		unused = append(unused, f.Filename)
+	}
+	removed := map[string]bool{}
+	if g.DryRun || !g.RemoveUnused || !hasNotes || len(unused) == 0 {
+		return removed, nil
+	}
+	deleted, err := g.Repo.DeleteUnusedMedia(userID, unused, cutoff, noteReferences)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range deleted {
+		removed[f.Filename] = true
+		report.Removed++
+		report.RemovedBytes += f.Size
+	}
+	return removed, nil
+}
+
+// noteReferences returns the names of the media files the notes' fields use
+func noteReferences(fields []string) map[string]bool {
+	refs := map[string]bool{}
+	for _, flds := range fields {
+		for _, name := range anki.MediaReferences(flds) {
+			if !strings.Contains(name, "://") && !strings.HasPrefix(name, "data:") {
+				refs[name] = true
+			}
+		}
+	}
+	return refs
+}
+
+// migrate moves f, stored before deduplication, to its shared blob
+func (g *GC) migrate(ctx context.Context, userID int, f *database.MediaFile) error {
+	data, err := g.Store.Get(ctx, MediaKey(userID, f.Hash))
+	if err != nil {
+		return err
+	}
+	blob, err := StoreContent(ctx, g.Repo, g.Store, data)
+	if err != nil {
+		return err
+	}
+	if err := g.Repo.AttachMediaBlob(userID, f.Hash, blob); err != nil {
+		return err
+	}
+	f.Blob = blob
+	return nil
+}
+
+// deleteBlob removes b unless a file or reservation started using it since
+// the scan, and reports whether it did
+func (g *GC) deleteBlob(ctx context.Context, b GCObject) (bool, error) {
+	f, err := g.Repo.GetMediaFileByHash(b.UserID, b.Hash)
+	if err == nil && f.Blob == "" {
+		return false, nil
+	}
+	if err != nil && !errors.Is(err, database.ErrMediaNotFound) {
+		return false, err
+	}
+	_, err = g.Repo.GetPendingMedia(b.UserID, b.Hash)
+	if err == nil {
+		return false, nil
+	}
+	if !errors.Is(err, database.ErrMediaNotFound) {
+		return false, err
+	}
+	return true, g.Store.Delete(ctx, b.Key)
+}
+
+// listBlobs returns the stored media blobs by user
+func (g *GC) listBlobs(ctx context.Context) (map[int][]GCObject, error) {
+	objects, err := g.Store.List(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	blobs := map[int][]GCObject{}
+	for _, o := range objects {
+		// Skip exports/, groups/ and anything else not {userID}/{hash}
+		uid, hash, ok := strings.Cut(o.Key, "/")
+		id, err := strconv.Atoi(uid)
+		if !ok || err != nil || hash == "" || strings.Contains(hash, "/") {
+			continue
+		}
+		blobs[id] = append(blobs[id], GCObject{
+			UserID:       id,
+			Hash:         hash,
+			Key:          o.Key,
+			Size:         o.Size,
+			LastModified: o.LastModified,
+		})
+	}
+	return blobs, nil
+}
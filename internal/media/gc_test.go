@@ -0,0 +1,156 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/magnusohle/openanki-backend/internal/database"
+	"github.com/magnusohle/openanki-backend/internal/testutil"
+)
+
+func TestGCReleasedBlobs(t *testing.T) {
+	ctx := context.Background()
+	store := NewLocalStore(t.TempDir(), "", nil)
+	owner, other := testutil.CreateUser(t).ID, testutil.CreateUser(t).ID
+
+	released := putTestMedia(t, store, owner, "released.png", []byte("released content"))
+	reacquired := putTestMedia(t, store, owner, "reacquired.png", []byte("reacquired content"))
+	recent := putTestMedia(t, store, owner, "recent.png", []byte("recently released content"))
+	for _, name := range []string{"released.png", "reacquired.png", "recent.png"} {
+		if _, err := testutil.Repo.DeleteMedia(owner, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, blob := range []string{released.Blob, reacquired.Blob} {
+		if _, err := testutil.Repo.DB.Exec(`UPDATE media_blobs SET released_at = datetime('now', '-2 days') WHERE sha256 = ?`, blob); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Another user stores the same content after the cutoff
+	putTestMedia(t, store, other, "mine.png", []byte("reacquired content"))
+
+	gc := &GC{Repo: testutil.Repo, Store: store, Grace: 24 * time.Hour}
+	report, err := gc.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Released != 1 || report.Deleted != 1 {
+		t.Errorf("released %d, deleted %d; want 1 each", report.Released, report.Deleted)
+	}
+
+	if _, err := store.Head(ctx, BlobKey(released.Blob)); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("released blob still stored: %v", err)
+	}
+	if _, err := testutil.Repo.FindMediaBlob(released.Blob); err == nil {
+		t.Error("released blob still recorded")
+	}
+	for name, blob := range map[string]string{"re-acquired": reacquired.Blob, "recently released": recent.Blob} {
+		if _, err := store.Head(ctx, BlobKey(blob)); err != nil {
+			t.Errorf("%s blob deleted: %v", name, err)
+		}
+		if _, err := testutil.Repo.FindMediaBlob(blob); err != nil {
+			t.Errorf("%s blob forgotten: %v", name, err)
+		}
+	}
+}
+
+func TestGCOrphanedUploads(t *testing.T) {
+	ctx := context.Background()
+	store := NewLocalStore(t.TempDir(), "", nil)
+	userID := testutil.CreateUser(t).ID
+
+	// Staged uploads: abandoned, in flight and reserved
+	keys := map[string]string{
+		"abandoned": MediaKey(userID, "aaaa"),
+		"recent":    MediaKey(userID, "bbbb"),
+		"reserved":  MediaKey(userID, "cccc"),
+	}
+	for _, key := range keys {
+		if err := store.Put(ctx, key, []byte("staged")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := testutil.Repo.ReserveMedia(userID, "c.png", "cccc", 6); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	for _, name := range []string{"abandoned", "reserved"} {
+		if err := os.Chtimes(filepath.Join(store.Dir, keys[name]), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A dry run only reports
+	gc := &GC{Repo: testutil.Repo, Store: store, Grace: 24 * time.Hour, DryRun: true}
+	report, err := gc.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Orphans) != 1 || report.Orphans[0].Key != keys["abandoned"] || report.Deleted != 0 {
+		t.Errorf("dry run: orphans %+v, deleted %d", report.Orphans, report.Deleted)
+	}
+
+	gc.DryRun = false
+	if _, err := gc.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for name, key := range keys {
+		_, err := store.Head(ctx, key)
+		if gone := errors.Is(err, ErrObjectNotFound); gone != (name == "abandoned") {
+			t.Errorf("%s upload: deleted = %v (%v)", name, gone, err)
+		}
+	}
+}
+
+func TestGCUnusedFiles(t *testing.T) {
+	ctx := context.Background()
+	store := NewLocalStore(t.TempDir(), "", nil)
+	userID := testutil.CreateUser(t).ID
+
+	names := []string{"my pic.png", "latex-3f2a.png", "_font.ttf", "unused.png"}
+	for _, name := range names {
+		putTestMedia(t, store, userID, name, []byte(name+" of "+strconv.Itoa(userID)))
+	}
+	if _, err := testutil.Repo.DB.Exec(`UPDATE user_media SET mtime = ? WHERE user_id = ?`, time.Now().Add(-48*time.Hour).Unix(), userID); err != nil {
+		t.Fatal(err)
+	}
+	_, err := testutil.Repo.DB.Exec(`INSERT INTO user_notes (id, user_id, guid, mid, mod, usn, flds) VALUES (1, ?, 'g', 1, 1, 1, ?)`,
+		userID, `<img src="my%20pic.png">`+"\x1f"+`[latex]$x^2$[/latex]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Unused files are only counted unless removal is asked for
+	gc := &GC{Repo: testutil.Repo, Store: store, Grace: 24 * time.Hour}
+	report, err := gc.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Unused != 1 || report.Removed != 0 {
+		t.Errorf("unused %d, removed %d; want 1 and 0", report.Unused, report.Removed)
+	}
+	for _, name := range names {
+		if _, err := testutil.Repo.GetMediaFile(userID, name); err != nil {
+			t.Errorf("%s removed without RemoveUnused: %v", name, err)
+		}
+	}
+
+	gc.RemoveUnused = true
+	if report, err = gc.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if report.Removed != 1 {
+		t.Errorf("removed %d, want 1", report.Removed)
+	}
+	for _, name := range names {
+		_, err := testutil.Repo.GetMediaFile(userID, name)
+		if gone := errors.Is(err, database.ErrMediaNotFound); gone != (name == "unused.png") {
+			t.Errorf("%s: removed = %v (%v)", name, gone, err)
+		}
+	}
+}
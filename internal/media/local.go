@@ -0,0 +1,295 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxLocalUpload caps a single PUT to a signed upload URL
+const maxLocalUpload = 300 << 20
+
+// LocalStore is a BlobStore keeping objects as files under Dir, for
+// self-hosting without object storage. With a Secret it also hands out
+// signed, expiring URLs under BaseURL, which ServeHTTP answers.
+type LocalStore struct {
+	Dir     string
+	BaseURL string
+	Secret  []byte
+}
+
+var _ BlobStore = (*LocalStore)(nil)
+
+// NewLocalStore stores objects under dir. Signed URLs point at baseURL and
+// are only issued when secret is set.
+func NewLocalStore(dir, baseURL string, secret []byte) *LocalStore {
+	return &LocalStore{Dir: dir, BaseURL: strings.TrimSuffix(baseURL, "/"), Secret: secret}
+}
+
+// path maps key to a file under Dir, rejecting keys that would escape it
+func (s *LocalStore) path(key string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(key))
+	if key == "" || filepath.IsAbs(clean) || clean == "." || clean == ".." ||
+		strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", errors.New("invalid key")
+	}
+	return filepath.Join(s.Dir, clean), nil
+}
+
+func (s *LocalStore) PresignPut(ctx context.Context, key, contentType string, expiry time.Duration) (string, error) {
+	return s.presign(http.MethodPut, key, expiry)
+}
+
+func (s *LocalStore) PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	return s.presign(http.MethodGet, key, expiry)
+}
+
+// presign returns BaseURL/{key}?expires=...&sig=..., valid for method only
+func (s *LocalStore) presign(method, key string, expiry time.Duration) (string, error) {
+	if len(s.Secret) == 0 || s.BaseURL == "" {
+		return "", ErrPresignUnsupported
+	}
+	if _, err := s.path(key); err != nil {
+		return "", err
+	}
+	expires := strconv.FormatInt(time.Now().Add(expiry).Unix(), 10)
+	segments := strings.Split(key, "/")
+	for i, seg := range segments {
+		segments[i] = url.PathEscape(seg)
+	}
+	q := url.Values{"expires": {expires}, "sig": {s.sign(method, key, expires)}}
+	return s.BaseURL + "/" + strings.Join(segments, "/") + "?" + q.Encode(), nil
+}
+
+// sign is the hex HMAC-SHA256 of a request for key expiring at expires
+func (s *LocalStore) sign(method, key, expires string) string {
+	mac := hmac.New(sha256.New, s.Secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s", method, key, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ServeHTTP answers the URLs issued by PresignPut and PresignGet. It expects
+// the key as the request path, so mount it with http.StripPrefix. Downloads
+// support Range and conditional requests via an ETag.
+func (s *LocalStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	method := r.Method
+	if method == http.MethodHead {
+		// HEAD is allowed with a download signature
+		method = http.MethodGet
+	}
+	if method != http.MethodGet && method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	expires := r.URL.Query().Get("expires")
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || len(s.Secret) == 0 ||
+		!hmac.Equal([]byte(r.URL.Query().Get("sig")), []byte(s.sign(method, key, expires))) {
+		http.Error(w, "Invalid signature", http.StatusForbidden)
+		return
+	}
+	if time.Now().Unix() > exp {
+		http.Error(w, "Link expired", http.StatusForbidden)
+		return
+	}
+	path, err := s.path(key)
+	if err != nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	if method == http.MethodPut {
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxLocalUpload))
+		if err != nil {
+			http.Error(w, "Upload too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err := s.Put(r.Context(), key, data); err != nil {
+			http.Error(w, "Failed to store object", http.StatusInternalServerError)
+			return
+		}
+		if info, err := os.Stat(path); err == nil {
+			w.Header().Set("ETag", localETag(info))
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("ETag", localETag(info))
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	// ServeContent handles Range, If-Range and If-None-Match
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
+
+// localETag identifies a version of a stored file. Objects are only ever
+// replaced whole, so size and modification time are enough.
+func localETag(info fs.FileInfo) string {
+	return fmt.Sprintf("\"%x-%x\"", info.ModTime().UnixNano(), info.Size())
+}
+
+// Put writes data to key. The file is replaced atomically so readers never
+// see a partial object.
+func (s *LocalStore) Put(ctx context.Context, key string, data []byte) error {
+	return s.PutReader(ctx, key, bytes.NewReader(data), int64(len(data)))
+}
+
+// PutReader writes size bytes read from r to key, replacing the file
+// atomically like Put
+func (s *LocalStore) PutReader(ctx context.Context, key string, r io.Reader, size int64) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	n, err := io.Copy(f, io.LimitReader(r, size))
+	if err == nil && n != size {
+		err = io.ErrUnexpectedEOF
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Get reads the object stored at key
+func (s *LocalStore) Get(ctx context.Context, key string) ([]byte, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, ErrObjectNotFound
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrObjectNotFound
+	}
+	return data, err
+}
+
+// Open streams the object stored at key
+func (s *LocalStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, ErrObjectNotFound
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrObjectNotFound
+	}
+	return f, err
+}
+
+// Head returns the size of the object stored at key
+func (s *LocalStore) Head(ctx context.Context, key string) (*ObjectInfo, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, ErrObjectNotFound
+	}
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && !info.Mode().IsRegular()) {
+		return nil, ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectInfo{Size: info.Size()}, nil
+}
+
+// Copy copies the file at src to dst, replacing dst atomically like Put
+func (s *LocalStore) Copy(ctx context.Context, src, dst string) error {
+	path, err := s.path(src)
+	if err != nil {
+		return ErrObjectNotFound
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrObjectNotFound
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return s.PutReader(ctx, dst, f, info.Size())
+}
+
+// Delete removes the object stored at key
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// List returns every object whose key starts with prefix
+func (s *LocalStore) List(ctx context.Context, prefix string) ([]StoredObject, error) {
+	var objects []StoredObject
+	err := filepath.WalkDir(s.Dir, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && path == s.Dir {
+			return filepath.SkipDir
+		}
+		if err != nil {
+			return err
+		}
+		// Skip directories and uploads in progress
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".upload-") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.Dir, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+		objects = append(objects, StoredObject{Key: key, Size: info.Size(), LastModified: info.ModTime()})
+		return nil
+	})
+	return objects, err
+}
@@ -0,0 +1,206 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestLocalStore(t *testing.T) *LocalStore {
+	t.Helper()
+	return NewLocalStore(t.TempDir(), "http://media.test/blobs", []byte("secret"))
+}
+
+// serveSigned sends a request for a URL issued by s to s.ServeHTTP, as the
+// server mounts it under LocalBlobPath
+func serveSigned(s *LocalStore, method, rawURL string, body io.Reader, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, rawURL, body)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	http.StripPrefix("/blobs", s).ServeHTTP(w, req)
+	return w
+}
+
+func TestLocalStoreSignedURLs(t *testing.T) {
+	ctx := context.Background()
+	s := newTestLocalStore(t)
+	if err := s.Put(ctx, "1/abc", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	get, err := s.PresignGet(ctx, "1/abc", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	put, err := s.PresignPut(ctx, "1/new", "application/octet-stream", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := s.PresignGet(ctx, "1/abc", -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// tamper sets the query parameter name of u to value
+	tamper := func(u, name, value string) string {
+		parsed, err := url.Parse(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q := parsed.Query()
+		q.Set(name, value)
+		parsed.RawQuery = q.Encode()
+		return parsed.String()
+	}
+	sig := func(u string) string {
+		parsed, _ := url.Parse(u)
+		return parsed.Query().Get("sig")
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		want   int
+	}{
+		{"download", http.MethodGet, get, http.StatusOK},
+		{"head", http.MethodHead, get, http.StatusOK},
+		{"tampered signature", http.MethodGet, tamper(get, "sig", strings.Repeat("0", len(sig(get)))), http.StatusForbidden},
+		{"missing signature", http.MethodGet, tamper(get, "sig", ""), http.StatusForbidden},
+		{"extended expiry", http.MethodGet, tamper(get, "expires", "99999999999"), http.StatusForbidden},
+		{"other key", http.MethodGet, strings.Replace(get, "1/abc", "1/abd", 1), http.StatusForbidden},
+		{"expired", http.MethodGet, expired, http.StatusForbidden},
+		{"download signature used to upload", http.MethodPut, get, http.StatusForbidden},
+		{"upload signature used to download", http.MethodGet, put, http.StatusForbidden},
+		{"other secret", http.MethodGet, get, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := s
+			if tt.name == "other secret" {
+				store = NewLocalStore(s.Dir, s.BaseURL, []byte("other"))
+			}
+			w := serveSigned(store, tt.method, tt.url, strings.NewReader("x"), nil)
+			if w.Code != tt.want {
+				t.Errorf("status %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+
+	// The upload URL stores the body under its key
+	w := serveSigned(s, http.MethodPut, put, strings.NewReader("uploaded"), nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload: status %d: %s", w.Code, w.Body)
+	}
+	if data, err := s.Get(ctx, "1/new"); err != nil || string(data) != "uploaded" {
+		t.Errorf("uploaded object = %q, %v", data, err)
+	}
+}
+
+func TestLocalStoreRejectsEscapingKeys(t *testing.T) {
+	ctx := context.Background()
+	s := newTestLocalStore(t)
+	for _, key := range []string{"", ".", "..", "../outside", "1/../../outside", "/etc/passwd"} {
+		if err := s.Put(ctx, key, []byte("x")); err == nil {
+			t.Errorf("Put(%q) succeeded", key)
+		}
+		if _, err := s.Get(ctx, key); !errors.Is(err, ErrObjectNotFound) {
+			t.Errorf("Get(%q): err = %v, want ErrObjectNotFound", key, err)
+		}
+		if _, err := s.PresignGet(ctx, key, time.Minute); err == nil {
+			t.Errorf("PresignGet(%q) succeeded", key)
+		}
+	}
+
+	// A correctly signed URL for an escaping key is refused too
+	key, expires := "../outside", "99999999999"
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.URL.Path = "/" + key
+	req.URL.RawQuery = url.Values{"expires": {expires}, "sig": {s.sign(http.MethodGet, key, expires)}}.Encode()
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("escaping key: status %d, want 404", w.Code)
+	}
+
+	// Keys inside the store that merely contain dots are fine
+	if err := s.Put(ctx, "1/a..b", []byte("x")); err != nil {
+		t.Errorf("Put(1/a..b): %v", err)
+	}
+}
+
+func TestLocalStoreRangeAndETag(t *testing.T) {
+	ctx := context.Background()
+	s := newTestLocalStore(t)
+	if err := s.Put(ctx, "1/abc", []byte("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+	get, err := s.PresignGet(ctx, "1/abc", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := serveSigned(s, http.MethodGet, get, nil, http.Header{"Range": {"bytes=2-5"}})
+	if w.Code != http.StatusPartialContent || w.Body.String() != "2345" {
+		t.Errorf("range: status %d, body %q, want 206 2345", w.Code, w.Body)
+	}
+	if got := w.Header().Get("Content-Range"); got != "bytes 2-5/10" {
+		t.Errorf("Content-Range = %q", got)
+	}
+
+	w = serveSigned(s, http.MethodGet, get, nil, nil)
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" {
+		t.Fatalf("download: status %d, ETag %q", w.Code, etag)
+	}
+	w = serveSigned(s, http.MethodGet, get, nil, http.Header{"If-None-Match": {etag}})
+	if w.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match with the current ETag: status %d, want 304", w.Code)
+	}
+
+	// Replacing the object changes its ETag
+	time.Sleep(10 * time.Millisecond)
+	if err := s.Put(ctx, "1/abc", []byte("changed")); err != nil {
+		t.Fatal(err)
+	}
+	w = serveSigned(s, http.MethodGet, get, nil, http.Header{"If-None-Match": {etag}})
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), []byte("changed")) {
+		t.Errorf("If-None-Match with a stale ETag: status %d, body %q", w.Code, w.Body)
+	}
+}
+
+func TestLocalStorePresignWithoutSecret(t *testing.T) {
+	s := NewLocalStore(t.TempDir(), "http://media.test/blobs", nil)
+	if _, err := s.PresignGet(context.Background(), "1/abc", time.Minute); !errors.Is(err, ErrPresignUnsupported) {
+		t.Errorf("err = %v, want ErrPresignUnsupported", err)
+	}
+}
+
+func TestLocalStoreCopy(t *testing.T) {
+	ctx := context.Background()
+	s := newTestLocalStore(t)
+	if err := s.Put(ctx, "groups/1/uploads/deck", []byte("deck")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Copy(ctx, "groups/1/uploads/deck", "groups/1/decks/deck"); err != nil {
+		t.Fatal(err)
+	}
+	// Later writes to the source leave the copy alone
+	if err := s.Put(ctx, "groups/1/uploads/deck", []byte("changed")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := s.Get(ctx, "groups/1/decks/deck"); err != nil || string(data) != "deck" {
+		t.Errorf("copy = %q, %v", data, err)
+	}
+	if err := s.Copy(ctx, "groups/1/uploads/missing", "groups/1/decks/missing"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("copying a missing key: %v, want ErrObjectNotFound", err)
+	}
+}
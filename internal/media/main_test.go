@@ -0,0 +1,31 @@
+package media
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"testing"
+
+	"github.com/magnusohle/openanki-backend/internal/database"
+	"github.com/magnusohle/openanki-backend/internal/testutil"
+)
+
+func TestMain(m *testing.M) {
+	testutil.Main(m, nil)
+}
+
+// putTestMedia stores data as the user's file name, the way confirmed
+// uploads are
+func putTestMedia(t *testing.T, store BlobStore, userID int, name string, data []byte) database.MediaFile {
+	t.Helper()
+	blob, err := StoreContent(context.Background(), testutil.Repo, store, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha1.Sum(data)
+	f := database.MediaFile{Filename: name, Hash: hex.EncodeToString(sum[:]), Size: int64(len(data)), Blob: blob}
+	if _, err := testutil.Repo.PutMedia(userID, f); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
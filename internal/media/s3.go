@@ -1,94 +1,191 @@
 package media
 
 import (
+	"bytes"
 	"context"
-	"log"
-	"os"
+	"errors"
+	"io"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
-type S3Service struct {
-	Client        *s3.Client
-	Presigner     *s3.PresignClient
-	Bucket        string
-	IsConfigured  bool
+// S3Config describes an S3 compatible bucket
+type S3Config struct {
+	// Endpoint overrides the AWS endpoint, e.g. for R2 or MinIO
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// UsePathStyle addresses the bucket as endpoint/bucket, as MinIO needs
+	UsePathStyle bool
 }
 
-func NewS3Service() (*S3Service, error) {
-	accountId := os.Getenv("R2_ACCOUNT_ID")
-	accessKeyId := os.Getenv("R2_ACCESS_KEY_ID")
-	accessKeySecret := os.Getenv("R2_SECRET_ACCESS_KEY")
-	bucketName := os.Getenv("R2_BUCKET_NAME")
-
-	if accountId == "" || accessKeyId == "" || accessKeySecret == "" || bucketName == "" {
-		log.Printf("❌ R2 Config Missing: AccountID=%v, KeyID=%v, Secret=%v, Bucket=%v", 
-            accountId != "", accessKeyId != "", accessKeySecret != "", bucketName != "")
-		return &S3Service{IsConfigured: false}, nil
-	}
-    log.Println("✅ R2/S3 Configured Successfully")
+// S3Store is a BlobStore backed by an S3 compatible bucket (R2, AWS, MinIO)
+type S3Store struct {
+	Client    *s3.Client
+	Presigner *s3.PresignClient
+	Bucket    string
+}
 
-	r2Resolver := aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
-		return aws.Endpoint{
-			URL: "https://" + accountId + ".eu.r2.cloudflarestorage.com",
-		}, nil
-	})
+var _ BlobStore = (*S3Store)(nil)
 
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithEndpointResolverWithOptions(r2Resolver),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(accessKeyId, accessKeySecret, "")),
-		config.WithRegion("auto"),
+// NewS3Store connects to the bucket described by cfg
+func NewS3Store(cfg S3Config) (*S3Store, error) {
+	awsCfg, err := config.LoadDefaultConfig(context.TODO(),
+		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(cfg.AccessKeyID, cfg.SecretAccessKey, "")),
+		config.WithRegion(cfg.Region),
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	client := s3.NewFromConfig(cfg)
-	presigner := s3.NewPresignClient(client)
-
-	return &S3Service{
-		Client:       client,
-		Presigner:    presigner,
-		Bucket:       bucketName,
-		IsConfigured: true,
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if cfg.Endpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.Endpoint)
+		}
+		o.UsePathStyle = cfg.UsePathStyle
+	})
+	return &S3Store{
+		Client:    client,
+		Presigner: s3.NewPresignClient(client),
+		Bucket:    cfg.Bucket,
 	}, nil
 }
 
-func (s *S3Service) GeneratePresignedPutURL(key string, contentType string, expiry time.Duration) (string, error) {
-	if !s.IsConfigured {
-		return "", nil // Or error
-	}
-
-	req, err := s.Presigner.PresignPutObject(context.TODO(), &s3.PutObjectInput{
+func (s *S3Store) PresignPut(ctx context.Context, key, contentType string, expiry time.Duration) (string, error) {
+	req, err := s.Presigner.PresignPutObject(ctx, &s3.PutObjectInput{
 		Bucket:      aws.String(s.Bucket),
 		Key:         aws.String(key),
 		ContentType: aws.String(contentType),
 	}, s3.WithPresignExpires(expiry))
-
 	if err != nil {
 		return "", err
 	}
+	return req.URL, nil
+}
 
+func (s *S3Store) PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	req, err := s.Presigner.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(expiry))
+	if err != nil {
+		return "", err
+	}
 	return req.URL, nil
 }
 
-func (s *S3Service) GeneratePresignedGetURL(key string, expiry time.Duration) (string, error) {
-	if !s.IsConfigured {
-		return "", nil
+// Put uploads data to key
+func (s *S3Store) Put(ctx context.Context, key string, data []byte) error {
+	return s.PutReader(ctx, key, bytes.NewReader(data), int64(len(data)))
+}
+
+// PutReader uploads size bytes read from r to key. Requests are signed over
+// the payload, so r should be seekable, such as a file.
+func (s *S3Store) PutReader(ctx context.Context, key string, r io.Reader, size int64) error {
+	_, err := s.Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(s.Bucket),
+		Key:           aws.String(key),
+		Body:          r,
+		ContentLength: aws.Int64(size),
+		ContentType:   aws.String("application/octet-stream"),
+	})
+	return err
+}
+
+// Get downloads the object stored at key
+func (s *S3Store) Get(ctx context.Context, key string) ([]byte, error) {
+	body, err := s.Open(ctx, key)
+	if err != nil {
+		return nil, err
 	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
 
-	req, err := s.Presigner.PresignGetObject(context.TODO(), &s3.GetObjectInput{
+// Open streams the object stored at key
+func (s *S3Store) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	out, err := s.Client.GetObject(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(s.Bucket),
 		Key:    aws.String(key),
-	}, s3.WithPresignExpires(60*time.Minute))
+	})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return nil, ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
 
+// Head returns the metadata of the object stored at key
+func (s *S3Store) Head(ctx context.Context, key string) (*ObjectInfo, error) {
+	out, err := s.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return nil, ErrObjectNotFound
+	}
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	return &ObjectInfo{Size: aws.ToInt64(out.ContentLength)}, nil
+}
 
-	return req.URL, nil
+// Copy copies the object at src to dst inside the bucket, without
+// downloading it
+func (s *S3Store) Copy(ctx context.Context, src, dst string) error {
+	segments := strings.Split(src, "/")
+	for i, seg := range segments {
+		segments[i] = url.PathEscape(seg)
+	}
+	_, err := s.Client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(s.Bucket),
+		Key:        aws.String(dst),
+		CopySource: aws.String(s.Bucket + "/" + strings.Join(segments, "/")),
+	})
+	return err
+}
+
+// Delete removes the object stored at key
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	_, err := s.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+// List returns every object whose key starts with prefix
+func (s *S3Store) List(ctx context.Context, prefix string) ([]StoredObject, error) {
+	var objects []StoredObject
+	p := s3.NewListObjectsV2Paginator(s.Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.Bucket),
+		Prefix: aws.String(prefix),
+	})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range page.Contents {
+			objects = append(objects, StoredObject{
+				Key:          aws.ToString(o.Key),
+				Size:         aws.ToInt64(o.Size),
+				LastModified: aws.ToTime(o.LastModified),
+			})
+		}
+	}
+	return objects, nil
 }
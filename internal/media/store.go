@@ -0,0 +1,166 @@
+package media
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BlobStore keeps media files, group decks and exports by key. Keys are
+// "/" separated paths such as MediaKey's {userID}/{hash}.
+type BlobStore interface {
+	// PresignPut returns a URL the client can PUT the object to directly
+	PresignPut(ctx context.Context, key, contentType string, expiry time.Duration) (string, error)
+	// PresignGet returns a URL the client can download the object from
+	PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error)
+	Put(ctx context.Context, key string, data []byte) error
+	// PutReader writes the size bytes read from r to key
+	PutReader(ctx context.Context, key string, r io.Reader, size int64) error
+	// Get, Open and Head return ErrObjectNotFound for missing keys
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Open streams the object stored at key
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Head(ctx context.Context, key string) (*ObjectInfo, error)
+	// Copy stores a copy of the object at src under dst within the store
+	Copy(ctx context.Context, src, dst string) error
+	// Delete succeeds for missing keys
+	Delete(ctx context.Context, key string) error
+	// List returns every object whose key starts with prefix
+	List(ctx context.Context, prefix string) ([]StoredObject, error)
+}
+
+// ErrObjectNotFound is returned for keys that do not exist in the store
+var ErrObjectNotFound = errors.New("object not found")
+
+// ErrPresignUnsupported is returned by stores that cannot hand out URLs
+var ErrPresignUnsupported = errors.New("store does not support presigned URLs")
+
+// ObjectInfo is the metadata of a stored object
+type ObjectInfo struct {
+	Size int64
+}
+
+// StoredObject is an entry of a store listing
+type StoredObject struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// MediaKey is where a user's upload of the given content hash is staged,
+// and where media stored before deduplication lives (see BlobKey)
+func MediaKey(userID int, hash string) string {
+	return strconv.Itoa(userID) + "/" + hash
+}
+
+// NewBlobStore sets up the store chosen by STORAGE_BACKEND:
+//
+//	r2     Cloudflare R2 (R2_ACCOUNT_ID, R2_ACCESS_KEY_ID, R2_SECRET_ACCESS_KEY, R2_BUCKET_NAME)
+//	s3     any S3 compatible service such as MinIO (S3_ENDPOINT, S3_REGION,
+//	       S3_BUCKET, S3_ACCESS_KEY_ID, S3_SECRET_ACCESS_KEY, S3_FORCE_PATH_STYLE)
+//	local  files under MEDIA_DIR, ./data/media by default. Signed links are
+//	       served by the server under PUBLIC_URL/blobs/ and signed with
+//	       MEDIA_URL_SECRET.
+//
+// Without STORAGE_BACKEND the first configured of r2 and s3 is used, falling
+// back to local.
+func NewBlobStore() (BlobStore, error) {
+	backend := os.Getenv("STORAGE_BACKEND")
+	if backend == "" {
+		switch {
+		case os.Getenv("R2_BUCKET_NAME") != "":
+			backend = "r2"
+		case os.Getenv("S3_BUCKET") != "":
+			backend = "s3"
+		default:
+			backend = "local"
+		}
+	}
+
+	switch backend {
+	case "r2":
+		account := os.Getenv("R2_ACCOUNT_ID")
+		if account == "" {
+			return nil, errors.New("R2 storage is missing R2_ACCOUNT_ID")
+		}
+		return newConfiguredS3Store("R2", S3Config{
+			Endpoint:        "https://" + account + ".eu.r2.cloudflarestorage.com",
+			Region:          "auto",
+			Bucket:          os.Getenv("R2_BUCKET_NAME"),
+			AccessKeyID:     os.Getenv("R2_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("R2_SECRET_ACCESS_KEY"),
+		})
+	case "s3":
+		region := os.Getenv("S3_REGION")
+		if region == "" {
+			region = "us-east-1"
+		}
+		pathStyle, _ := strconv.ParseBool(os.Getenv("S3_FORCE_PATH_STYLE"))
+		return newConfiguredS3Store("S3", S3Config{
+			Endpoint:        os.Getenv("S3_ENDPOINT"),
+			Region:          region,
+			Bucket:          os.Getenv("S3_BUCKET"),
+			AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+			UsePathStyle:    pathStyle,
+		})
+	case "local":
+		dir := os.Getenv("MEDIA_DIR")
+		if dir == "" {
+			dir = "./data/media"
+		}
+		log.Printf("📁 Storing media on local disk in %s", dir)
+		return NewLocalStore(dir, localBaseURL(), localURLSecret()), nil
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_BACKEND %q", backend)
+	}
+}
+
+func newConfiguredS3Store(name string, cfg S3Config) (*S3Store, error) {
+	if cfg.Bucket == "" || cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+		return nil, fmt.Errorf("%s storage is missing its bucket or credentials", name)
+	}
+	store, err := NewS3Store(cfg)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("✅ %s storage configured (bucket %s)", name, cfg.Bucket)
+	return store, nil
+}
+
+// LocalBlobPath is where the server mounts LocalStore.ServeHTTP
+const LocalBlobPath = "/blobs/"
+
+// localBaseURL is the URL signed local links start with
+func localBaseURL() string {
+	base := os.Getenv("PUBLIC_URL")
+	if base == "" {
+		port := os.Getenv("PORT")
+		if port == "" {
+			port = "8080"
+		}
+		base = "http://localhost:" + port
+	}
+	return strings.TrimSuffix(base, "/") + strings.TrimSuffix(LocalBlobPath, "/")
+}
+
+// localURLSecret is the key local links are signed with. Without
+// MEDIA_URL_SECRET a random key is used, so links die with the process.
+func localURLSecret() []byte {
+	if secret := os.Getenv("MEDIA_URL_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	log.Println("⚠️ MEDIA_URL_SECRET not set, signed media links will not survive a restart")
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil
+	}
+	return secret
+}
@@ -0,0 +1,167 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// storageEnv lists the variables NewBlobStore reads
+var storageEnv = []string{
+	"STORAGE_BACKEND", "MEDIA_DIR", "MEDIA_URL_SECRET", "PUBLIC_URL",
+	"R2_ACCOUNT_ID", "R2_ACCESS_KEY_ID", "R2_SECRET_ACCESS_KEY", "R2_BUCKET_NAME",
+	"S3_ENDPOINT", "S3_REGION", "S3_BUCKET", "S3_ACCESS_KEY_ID", "S3_SECRET_ACCESS_KEY", "S3_FORCE_PATH_STYLE",
+}
+
+func TestNewBlobStore(t *testing.T) {
+	dir := t.TempDir()
+	s3Creds := map[string]string{"S3_BUCKET": "b", "S3_ACCESS_KEY_ID": "k", "S3_SECRET_ACCESS_KEY": "s"}
+	r2Creds := map[string]string{"R2_ACCOUNT_ID": "a", "R2_BUCKET_NAME": "b", "R2_ACCESS_KEY_ID": "k", "R2_SECRET_ACCESS_KEY": "s"}
+	with := func(base map[string]string, extra ...string) map[string]string {
+		env := map[string]string{}
+		for k, v := range base {
+			env[k] = v
+		}
+		for i := 0; i+1 < len(extra); i += 2 {
+			env[extra[i]] = extra[i+1]
+		}
+		return env
+	}
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    string // "local", "s3" or "" for an error
+		wantErr string
+	}{
+		{name: "default", env: map[string]string{"MEDIA_DIR": dir}, want: "local"},
+		{name: "local", env: with(s3Creds, "STORAGE_BACKEND", "local", "MEDIA_DIR", dir), want: "local"},
+		{name: "s3 by bucket", env: s3Creds, want: "s3"},
+		{name: "r2 by bucket", env: with(r2Creds, "S3_BUCKET", "other"), want: "s3"},
+		{name: "explicit s3", env: with(s3Creds, "STORAGE_BACKEND", "s3"), want: "s3"},
+		{name: "s3 without credentials", env: map[string]string{"STORAGE_BACKEND": "s3", "S3_BUCKET": "b"}, wantErr: "bucket or credentials"},
+		{name: "r2 without account", env: with(r2Creds, "R2_ACCOUNT_ID", ""), wantErr: "R2_ACCOUNT_ID"},
+		{name: "unknown backend", env: map[string]string{"STORAGE_BACKEND": "ftp"}, wantErr: "unknown STORAGE_BACKEND"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, k := range storageEnv {
+				t.Setenv(k, tt.env[k])
+			}
+			store, err := NewBlobStore()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want one mentioning %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch s := store.(type) {
+			case *LocalStore:
+				if tt.want != "local" {
+					t.Errorf("got a local store, want %s", tt.want)
+				}
+				if s.Dir != dir || len(s.Secret) == 0 || !strings.HasSuffix(s.BaseURL, "/blobs") {
+					t.Errorf("local store = dir %q, base %q, %d byte secret", s.Dir, s.BaseURL, len(s.Secret))
+				}
+			case *S3Store:
+				if tt.want != "s3" {
+					t.Errorf("got an S3 store, want %s", tt.want)
+				}
+				if s.Bucket != "b" {
+					t.Errorf("bucket = %q", s.Bucket)
+				}
+			default:
+				t.Errorf("got %T", store)
+			}
+		})
+	}
+}
+
+func TestLocalStoreObjects(t *testing.T) {
+	ctx := context.Background()
+	s := NewLocalStore(t.TempDir(), "", nil)
+
+	if _, err := s.Get(ctx, "1/missing"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Get missing: err = %v", err)
+	}
+	if _, err := s.Open(ctx, "1/missing"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Open missing: err = %v", err)
+	}
+	if _, err := s.Head(ctx, "1"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Head of a directory: err = %v", err)
+	}
+	if err := s.Delete(ctx, "1/missing"); err != nil {
+		t.Errorf("Delete missing: %v", err)
+	}
+
+	for key, data := range map[string]string{"1/a": "aa", "1/b": "bbb", "2/a": "c", "exports/1/x.apkg": "pkg"} {
+		if err := s.Put(ctx, key, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Put(ctx, "1/a", []byte("replaced")); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := s.Head(ctx, "1/a"); err != nil || info.Size != int64(len("replaced")) {
+		t.Errorf("Head = %+v, %v", info, err)
+	}
+	rc, err := s.Open(ctx, "1/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil || string(data) != "replaced" {
+		t.Errorf("Open = %q, %v", data, err)
+	}
+
+	// A short reader leaves the old object in place
+	if err := s.PutReader(ctx, "1/b", strings.NewReader("x"), 10); err == nil {
+		t.Error("PutReader with a short reader succeeded")
+	}
+	if data, _ := s.Get(ctx, "1/b"); string(data) != "bbb" {
+		t.Errorf("1/b after a failed write = %q", data)
+	}
+	if err := s.PutReader(ctx, "1/c", bytes.NewReader([]byte("cccc")), 4); err != nil {
+		t.Fatal(err)
+	}
+
+	// An upload in progress is not listed
+	if err := os.WriteFile(filepath.Join(s.Dir, "1", ".upload-123"), []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	objects, err := s.List(ctx, "1/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, o := range objects {
+		keys = append(keys, o.Key)
+	}
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != "1/a,1/b,1/c" {
+		t.Errorf("List(1/) = %v", keys)
+	}
+
+	if err := s.Delete(ctx, "1/a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Head(ctx, "1/a"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Head after Delete: err = %v", err)
+	}
+
+	// A store whose directory does not exist yet is empty
+	empty := NewLocalStore(filepath.Join(s.Dir, "none"), "", nil)
+	if objects, err := empty.List(ctx, ""); err != nil || len(objects) != 0 {
+		t.Errorf("List of a missing directory = %v, %v", objects, err)
+	}
+}
@@ -0,0 +1,294 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/magnusohle/openanki-backend/internal/database"
+)
+
+const (
+	// defaultTranscodeMinSize is the smallest file transcoded by default
+	defaultTranscodeMinSize = 256 << 10
+	// transcodeQueue is the number of files waiting before uploads skip
+	// transcoding
+	transcodeQueue = 256
+)
+
+// sourceKinds maps the file extensions transcoded to their kind of media
+var sourceKinds = map[string]string{
+	".png":  "image",
+	".jpg":  "image",
+	".jpeg": "image",
+	".wav":  "audio",
+	".aif":  "audio",
+	".aiff": "audio",
+	".flac": "audio",
+}
+
+// variantTypes are the variants made of each kind of media
+var variantTypes = map[string][]string{
+	"image": {"image/avif", "image/webp"},
+	"audio": {"audio/ogg"},
+}
+
+// variantExts are the file extensions of variant content types
+var variantExts = map[string]string{
+	"image/avif": ".avif",
+	"image/webp": ".webp",
+	"audio/ogg":  ".ogg",
+}
+
+// encoder runs a local tool writing a variant of in to out
+type encoder struct {
+	tool string
+	args func(in, out string) []string
+}
+
+// variantEncoders lists the tools for each variant in order of preference
+var variantEncoders = map[string][]encoder{
+	"image/avif": {
+		{"avifenc", func(in, out string) []string { return []string{"--speed", "6", in, out} }},
+		{"ffmpeg", ffmpegArgs("-c:v", "libaom-av1", "-still-picture", "1", "-crf", "32")},
+	},
+	"image/webp": {
+		{"cwebp", func(in, out string) []string { return []string{"-quiet", "-q", "80", in, "-o", out} }},
+		{"ffmpeg", ffmpegArgs("-c:v", "libwebp", "-quality", "80")},
+	},
+	"audio/ogg": {
+		{"opusenc", func(in, out string) []string { return []string{"--quiet", "--bitrate", "48", in, out} }},
+		{"ffmpeg", ffmpegArgs("-vn", "-c:a", "libopus", "-b:a", "48k")},
+	},
+}
+
+func ffmpegArgs(codec ...string) func(in, out string) []string {
+	return func(in, out string) []string {
+		args := []string{"-nostdin", "-loglevel", "error", "-y", "-i", in}
+		return append(append(args, codec...), out)
+	}
+}
+
+// Transcoder makes smaller variants of uploaded media in the background:
+// AVIF and WebP for PNG and JPEG images, Opus for uncompressed audio. It
+// runs the encoders installed on the server (avifenc, cwebp, opusenc or
+// ffmpeg). Variants that are not smaller than the original are dropped.
+type Transcoder struct {
+	Repo  *database.Repository
+	Store BlobStore
+	// MinSize is the smallest file worth transcoding
+	MinSize int64
+	// Timeout bounds a single encoder run
+	Timeout time.Duration
+
+	encoders map[string]encoder
+	jobs     chan transcodeJob
+}
+
+type transcodeJob struct {
+	userID int
+	file   database.MediaFile
+}
+
+// NewTranscoder uses the first installed encoder of each variant. It returns
+// nil when none is installed.
+func NewTranscoder(repo *database.Repository, store BlobStore, minSize int64) *Transcoder {
+	t := &Transcoder{
+		Repo:     repo,
+		Store:    store,
+		MinSize:  minSize,
+		Timeout:  2 * time.Minute,
+		encoders: map[string]encoder{},
+		jobs:     make(chan transcodeJob, transcodeQueue),
+	}
+	for contentType, candidates := range variantEncoders {
+		for _, e := range candidates {
+			if path, err := exec.LookPath(e.tool); err == nil {
+				t.encoders[contentType] = encoder{tool: path, args: e.args}
+				break
+			}
+		}
+	}
+	if len(t.encoders) == 0 {
+		return nil
+	}
+	return t
+}
+
+// NewTranscoderFromEnv returns the transcoder enabled by MEDIA_TRANSCODE, or
+// nil. MEDIA_TRANSCODE_MIN_BYTES overrides the size threshold.
+func NewTranscoderFromEnv(repo *database.Repository, store BlobStore) *Transcoder {
+	if on, _ := strconv.ParseBool(os.Getenv("MEDIA_TRANSCODE")); !on {
+		return nil
+	}
+	minSize := int64(defaultTranscodeMinSize)
+	if v := os.Getenv("MEDIA_TRANSCODE_MIN_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			log.Printf("⚠️ Invalid MEDIA_TRANSCODE_MIN_BYTES %q, using %d", v, minSize)
+		} else {
+			minSize = n
+		}
+	}
+	t := NewTranscoder(repo, store, minSize)
+	if t == nil {
+		log.Println("⚠️ MEDIA_TRANSCODE is set but no encoder (avifenc, cwebp, opusenc, ffmpeg) is installed")
+		return nil
+	}
+	types := make([]string, 0, len(t.encoders))
+	for contentType := range t.encoders {
+		types = append(types, contentType)
+	}
+	log.Printf("✅ Media transcoding enabled (%s)", strings.Join(types, ", "))
+	return t
+}
+
+// Start runs workers transcoding queued files until ctx is done
+func (t *Transcoder) Start(ctx context.Context, workers int) {
+	for i := 0; i < workers; i++ {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job := <-t.jobs:
+					if err := t.Process(ctx, job.userID, job.file); err != nil {
+						log.Printf("⚠️ Failed to transcode %q of user %d: %v", job.file.Filename, job.userID, err)
+					}
+				}
+			}
+		}()
+	}
+}
+
+// Enqueue schedules the user's file f for transcoding. It never blocks:
+// without a transcoder or with a full queue the file is skipped.
+func (t *Transcoder) Enqueue(userID int, f database.MediaFile) {
+	if t == nil || !t.wants(f) {
+		return
+	}
+	select {
+	case t.jobs <- transcodeJob{userID: userID, file: f}:
+	default:
+		log.Printf("⚠️ Transcode queue full, skipping %q of user %d", f.Filename, userID)
+	}
+}
+
+// wants reports whether f is a kind of media worth transcoding
+func (t *Transcoder) wants(f database.MediaFile) bool {
+	_, ok := sourceKinds[strings.ToLower(filepath.Ext(f.Filename))]
+	return ok && f.Size >= t.MinSize
+}
+
+// Process makes the missing variants of the user's file f. Variants other
+// users' copies of the same content already have are reused.
+func (t *Transcoder) Process(ctx context.Context, userID int, f database.MediaFile) error {
+	if !t.wants(f) {
+		return nil
+	}
+	ext := strings.ToLower(filepath.Ext(f.Filename))
+	existing, err := t.Repo.ListMediaVariants(userID, f.Hash)
+	if err != nil {
+		return err
+	}
+	have := map[string]bool{}
+	for _, v := range existing {
+		have[v.ContentType] = true
+	}
+
+	if f.Blob != "" {
+		shared, err := t.Repo.FindVariantsBySource(f.Blob)
+		if err != nil {
+			return err
+		}
+		for _, v := range shared {
+			if have[v.ContentType] {
+				continue
+			}
+			err := t.Repo.AddMediaVariant(userID, f.Hash, f.Blob, v)
+			if errors.Is(err, database.ErrMediaNotFound) {
+				return nil
+			}
+			if err != nil && !errors.Is(err, database.ErrMediaBlobMissing) {
+				return err
+			}
+			have[v.ContentType] = err == nil
+		}
+	}
+
+	var missing []string
+	for _, contentType := range variantTypes[sourceKinds[ext]] {
+		if _, ok := t.encoders[contentType]; ok && !have[contentType] {
+			missing = append(missing, contentType)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	data, err := t.Store.Get(ctx, FileKey(userID, &f))
+	if err != nil {
+		return err
+	}
+	dir, err := os.MkdirTemp("", "transcode-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	in := filepath.Join(dir, "in"+ext)
+	if err := os.WriteFile(in, data, 0600); err != nil {
+		return err
+	}
+
+	for _, contentType := range missing {
+		out, err := t.encode(ctx, contentType, in, filepath.Join(dir, "out"+variantExts[contentType]))
+		if err != nil {
+			log.Printf("⚠️ Failed to make %s of %q: %v", contentType, f.Filename, err)
+			continue
+		}
+		if len(out) == 0 || len(out) >= len(data) {
+			continue
+		}
+		blob, err := StoreContent(ctx, t.Repo, t.Store, out)
+		if err != nil {
+			return err
+		}
+		v := database.MediaVariant{ContentType: contentType, Blob: blob, Size: int64(len(out))}
+		err = t.Repo.AddMediaVariant(userID, f.Hash, f.Blob, v)
+		if errors.Is(err, database.ErrMediaNotFound) {
+			// Deleted meanwhile; the GC collects the blob
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encode runs the encoder of contentType and returns its output
+func (t *Transcoder) encode(ctx context.Context, contentType, in, out string) ([]byte, error) {
+	e := t.encoders[contentType]
+	ctx, cancel := context.WithTimeout(ctx, t.Timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, e.tool, e.args(in, out)...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, errors.New(strings.TrimSpace(err.Error() + ": " + string(output)))
+	}
+	return os.ReadFile(out)
+}
+
+// VariantFilename is name with the extension of a variant of contentType
+func VariantFilename(name, contentType string) string {
+	ext, ok := variantExts[contentType]
+	if !ok {
+		return name
+	}
+	return strings.TrimSuffix(name, filepath.Ext(name)) + ext
+}
@@ -0,0 +1,188 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/magnusohle/openanki-backend/internal/database"
+	"github.com/magnusohle/openanki-backend/internal/testutil"
+)
+
+// shellEncoder is an encoder running script with the input and output paths
+// as $1 and $2
+func shellEncoder(t *testing.T, script string) encoder {
+	t.Helper()
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no sh to run test encoders")
+	}
+	return encoder{tool: sh, args: func(in, out string) []string {
+		return []string{"-c", script, "sh", in, out}
+	}}
+}
+
+// testContent is 600 bytes of media unique to the user. Blobs are recorded
+// in the shared test database, so tests with a store of their own must not
+// reuse content.
+func testContent(userID int) []byte {
+	return bytes.Repeat([]byte(fmt.Sprintf("%06d", userID)), 100)
+}
+
+func TestTranscoderProcess(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		// encoders by content type
+		encoders map[string]string
+		file     string
+		want     []string // content types of the variants made
+	}{
+		{
+			name:     "smaller variants",
+			encoders: map[string]string{"image/webp": `head -c 100 "$1" > "$2"`, "image/avif": `head -c 50 "$1" > "$2"`},
+			file:     "a.png",
+			want:     []string{"image/avif", "image/webp"},
+		},
+		{
+			name:     "failing encoder",
+			encoders: map[string]string{"image/webp": `echo broken >&2; exit 1`, "image/avif": `head -c 50 "$1" > "$2"`},
+			file:     "a.png",
+			want:     []string{"image/avif"},
+		},
+		{
+			name:     "variant not smaller",
+			encoders: map[string]string{"image/webp": `cat "$1" "$1" > "$2"`},
+			file:     "a.png",
+		},
+		{
+			name:     "empty output",
+			encoders: map[string]string{"image/webp": `: > "$2"`},
+			file:     "a.png",
+		},
+		{
+			name:     "hanging encoder",
+			encoders: map[string]string{"image/webp": `exec sleep 5`},
+			file:     "a.png",
+		},
+		{
+			name:     "kind without encoder",
+			encoders: map[string]string{"image/webp": `head -c 10 "$1" > "$2"`},
+			file:     "a.wav",
+		},
+		{
+			name:     "not transcoded",
+			encoders: map[string]string{"image/webp": `head -c 10 "$1" > "$2"`},
+			file:     "a.gif",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewLocalStore(t.TempDir(), "", nil)
+			userID := testutil.CreateUser(t).ID
+			f := putTestMedia(t, store, userID, tt.file, testContent(userID))
+
+			tr := &Transcoder{Repo: testutil.Repo, Store: store, Timeout: 500 * time.Millisecond, encoders: map[string]encoder{}}
+			for contentType, script := range tt.encoders {
+				tr.encoders[contentType] = shellEncoder(t, script)
+			}
+			if err := tr.Process(ctx, userID, f); err != nil {
+				t.Fatal(err)
+			}
+
+			variants, err := testutil.Repo.ListMediaVariants(userID, f.Hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, v := range variants {
+				got = append(got, v.ContentType)
+				data, err := store.Get(ctx, BlobKey(v.Blob))
+				if err != nil || int64(len(data)) != v.Size || int64(len(data)) >= f.Size {
+					t.Errorf("%s variant: %d bytes stored of %d recorded, %v", v.ContentType, len(data), v.Size, err)
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("variants = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("variants = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestTranscoderReusesVariants(t *testing.T) {
+	ctx := context.Background()
+	store := NewLocalStore(t.TempDir(), "", nil)
+	first, second := testutil.CreateUser(t).ID, testutil.CreateUser(t).ID
+	original := testContent(first)
+	tr := &Transcoder{Repo: testutil.Repo, Store: store, Timeout: time.Second, encoders: map[string]encoder{}}
+	tr.encoders["image/webp"] = shellEncoder(t, `head -c 100 "$1" > "$2"`)
+	f := putTestMedia(t, store, first, "a.png", original)
+	if err := tr.Process(ctx, first, f); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second user's copy gets the variant without running the encoder
+	tr.encoders["image/webp"] = shellEncoder(t, `exit 1`)
+	f2 := putTestMedia(t, store, second, "b.png", original)
+	if err := tr.Process(ctx, second, f2); err != nil {
+		t.Fatal(err)
+	}
+	a, err := testutil.Repo.ListMediaVariants(first, f.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := testutil.Repo.ListMediaVariants(second, f2.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a) != 1 || len(b) != 1 || a[0].Blob != b[0].Blob {
+		t.Errorf("variants = %+v and %+v, want one shared blob", a, b)
+	}
+}
+
+func TestTranscoderWants(t *testing.T) {
+	tr := &Transcoder{MinSize: 100}
+	for _, tt := range []struct {
+		name string
+		size int64
+		want bool
+	}{
+		{"a.png", 100, true},
+		{"A.JPG", 1000, true},
+		{"a.flac", 1000, true},
+		{"a.png", 99, false},
+		{"a.webp", 1000, false},
+		{"a.mp3", 1000, false},
+		{"png", 1000, false},
+	} {
+		if got := tr.wants(database.MediaFile{Filename: tt.name, Size: tt.size}); got != tt.want {
+			t.Errorf("wants(%s, %d bytes) = %v, want %v", tt.name, tt.size, got, tt.want)
+		}
+	}
+
+	// Without a transcoder nothing is queued
+	var none *Transcoder
+	none.Enqueue(1, database.MediaFile{Filename: "a.png", Size: 1 << 20})
+}
+
+func TestVariantFilename(t *testing.T) {
+	for _, tt := range []struct{ name, contentType, want string }{
+		{"cat.png", "image/webp", "cat.webp"},
+		{"cat.tar.png", "image/avif", "cat.tar.avif"},
+		{"song.wav", "audio/ogg", "song.ogg"},
+		{"cat.png", "image/png", "cat.png"},
+	} {
+		if got := VariantFilename(tt.name, tt.contentType); got != tt.want {
+			t.Errorf("VariantFilename(%q, %q) = %q, want %q", tt.name, tt.contentType, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+// Package oidc verifies identity tokens of Sign in with Apple and Google.
+package oidc
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// keysTTL is how long fetched provider keys are used before refetching
+	keysTTL = time.Hour
+	// minRefetch spaces out refetches for tokens with unknown key ids, and
+	// retries while the provider is failing
+	minRefetch = time.Minute
+)
+
+// ErrInvalidToken is returned for identity tokens that fail verification
+var ErrInvalidToken = errors.New("invalid identity token")
+
+// ErrNotConfigured is returned by providers without client ids
+var ErrNotConfigured = errors.New("provider not configured")
+
+// ErrKeysUnavailable is returned when the provider's keys cannot be fetched,
+// so tokens cannot be checked either way
+var ErrKeysUnavailable = errors.New("provider keys unavailable")
+
+// Identity is the account an identity token vouches for
+type Identity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+// Provider verifies the identity tokens of one OpenID provider
+type Provider struct {
+	Name string
+	// Issuers are the accepted iss claims
+	Issuers []string
+	// Audiences are our client ids (bundle ids, OAuth client ids)
+	Audiences []string
+	JWKSURL   string
+	Client    *http.Client
+
+	mu   sync.Mutex
+	keys map[string]*rsa.PublicKey
+	// fetchedAt is when keys were fetched, triedAt when a fetch last ended
+	// and fetchErr how it failed, if it did
+	fetchedAt time.Time
+	triedAt   time.Time
+	fetchErr  error
+	// fetching is closed when the fetch in flight ends
+	fetching chan struct{}
+}
+
+// Apple is Sign in with Apple for the bundle or service ids in
+// APPLE_CLIENT_IDS. APPLE_ISSUER and APPLE_JWKS_URL override the endpoints,
+// e.g. to point at a local stand-in.
+func Apple() *Provider {
+	return fromEnv("apple", "APPLE", []string{"https://appleid.apple.com"}, "https://appleid.apple.com/auth/keys")
+}
+
+// Google is Google Sign-In for the OAuth client ids in GOOGLE_CLIENT_IDS.
+// GOOGLE_ISSUER and GOOGLE_JWKS_URL override the endpoints.
+func Google() *Provider {
+	return fromEnv("google", "GOOGLE", []string{"https://accounts.google.com", "accounts.google.com"},
+		"https://www.googleapis.com/oauth2/v3/certs")
+}
+
+func fromEnv(name, prefix string, issuers []string, jwksURL string) *Provider {
+	if v := os.Getenv(prefix + "_ISSUER"); v != "" {
+		issuers = []string{v}
+	}
+	if v := os.Getenv(prefix + "_JWKS_URL"); v != "" {
+		jwksURL = v
+	}
+	return &Provider{
+		Name:      name,
+		Issuers:   issuers,
+		Audiences: splitList(os.Getenv(prefix + "_CLIENT_IDS")),
+		JWKSURL:   jwksURL,
+		Client:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+type idClaims struct {
+	Email string `json:"email"`
+	// Apple sends email_verified as a string, Google as a bool
+	EmailVerified any    `json:"email_verified"`
+	Nonce         string `json:"nonce"`
+	jwt.RegisteredClaims
+}
+
+// Verify checks an identity token's signature, issuer, audience and expiry,
+// and its nonce when one is given
+func (p *Provider) Verify(ctx context.Context, token, nonce string) (*Identity, error) {
+	if len(p.Audiences) == 0 {
+		return nil, ErrNotConfigured
+	}
+	claims := &idClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.key(ctx, kid)
+	}, jwt.WithValidMethods([]string{"RS256"}), jwt.WithExpirationRequired(), jwt.WithLeeway(time.Minute))
+	if errors.Is(err, ErrKeysUnavailable) || (err != nil && ctx.Err() != nil) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	if !contains(p.Issuers, claims.Issuer) {
+		return nil, fmt.Errorf("%w: issuer %q", ErrInvalidToken, claims.Issuer)
+	}
+	audienceOK := false
+	for _, aud := range claims.Audience {
+		audienceOK = audienceOK || contains(p.Audiences, aud)
+	}
+	if !audienceOK {
+		return nil, fmt.Errorf("%w: audience %v", ErrInvalidToken, claims.Audience)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidToken)
+	}
+	if nonce != "" && claims.Nonce != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidToken)
+	}
+
+	verified := false
+	switch v := claims.EmailVerified.(type) {
+	case bool:
+		verified = v
+	case string:
+		verified = v == "true"
+	}
+	return &Identity{
+		Provider:      p.Name,
+		Subject:       claims.Subject,
+		Email:         strings.ToLower(claims.Email),
+		EmailVerified: verified && claims.Email != "",
+	}, nil
+}
+
+func contains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// key returns the provider's signing key kid, refetching the provider's keys
+// when they are stale or kid is new. One request fetches at a time, outside
+// the lock; the others wait for its result.
+func (p *Provider) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	for {
+		p.mu.Lock()
+		key, ok := p.keys[kid]
+		if ok && time.Since(p.fetchedAt) < keysTTL {
+			p.mu.Unlock()
+			return key, nil
+		}
+		if time.Since(p.triedAt) < minRefetch {
+			err := p.fetchErr
+			p.mu.Unlock()
+			switch {
+			case ok:
+				// Keep using the known key while the provider is unreachable
+				return key, nil
+			case err != nil:
+				return nil, fmt.Errorf("%w: %v", ErrKeysUnavailable, err)
+			default:
+				return nil, fmt.Errorf("unknown key %q", kid)
+			}
+		}
+		if wait := p.fetching; wait != nil {
+			p.mu.Unlock()
+			select {
+			case <-wait:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		done := make(chan struct{})
+		p.fetching = done
+		p.mu.Unlock()
+
+		keys, err := p.fetchKeys(ctx)
+
+		p.mu.Lock()
+		p.fetching = nil
+		// A request that gave up says nothing about the provider
+		if ctx.Err() == nil {
+			p.triedAt, p.fetchErr = time.Now(), err
+			if err == nil {
+				p.keys, p.fetchedAt = keys, p.triedAt
+			}
+		}
+		p.mu.Unlock()
+		close(done)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+type jwks struct {
+	Keys []struct {
+		KeyType string `json:"kty"`
+		ID      string `json:"kid"`
+		N       string `json:"n"`
+		E       string `json:"e"`
+	} `json:"keys"`
+}
+
+func (p *Provider) fetchKeys(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.JWKSURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s keys: %s", p.Name, resp.Status)
+	}
+
+	var set jwks
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.KeyType != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			continue
+		}
+		exp := 0
+		for _, b := range e {
+			exp = exp<<8 | int(b)
+		}
+		keys[k.ID] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exp}
+	}
+	return keys, nil
+}
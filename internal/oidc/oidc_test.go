@@ -0,0 +1,288 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testIssuer   = "https://issuer.example"
+	testAudience = "app.example"
+)
+
+// testJWKS serves the public halves of its keys like a provider
+type testJWKS struct {
+	keys    map[string]*rsa.PrivateKey
+	fetches atomic.Int32
+	server  *httptest.Server
+}
+
+func newTestJWKS(t *testing.T, kids ...string) *testJWKS {
+	t.Helper()
+	j := &testJWKS{keys: map[string]*rsa.PrivateKey{}}
+	for _, kid := range kids {
+		j.add(t, kid)
+	}
+	j.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		j.fetches.Add(1)
+		var set jwks
+		for kid, key := range j.keys {
+			set.Keys = append(set.Keys, struct {
+				KeyType string `json:"kty"`
+				ID      string `json:"kid"`
+				N       string `json:"n"`
+				E       string `json:"e"`
+			}{"RSA", kid, base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())})
+		}
+		json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(j.server.Close)
+	return j
+}
+
+func (j *testJWKS) add(t *testing.T, kid string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.keys[kid] = key
+}
+
+func (j *testJWKS) provider() *Provider {
+	return &Provider{
+		Name:      "test",
+		Issuers:   []string{testIssuer},
+		Audiences: []string{testAudience},
+		JWKSURL:   j.server.URL,
+		Client:    j.server.Client(),
+	}
+}
+
+// sign issues a token with the key kid for claims on top of valid defaults
+func (j *testJWKS) sign(t *testing.T, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	all := jwt.MapClaims{
+		"iss":            testIssuer,
+		"aud":            testAudience,
+		"sub":            "subject-1",
+		"email":          "Someone@Example.com",
+		"email_verified": true,
+		"exp":            time.Now().Add(time.Hour).Unix(),
+	}
+	for k, v := range claims {
+		if v == nil {
+			delete(all, k)
+		} else {
+			all[k] = v
+		}
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, all)
+	token.Header["kid"] = kid
+	s, err := token.SignedString(j.keys[kid])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestVerify(t *testing.T) {
+	j := newTestJWKS(t, "k1")
+	p := j.provider()
+	identity, err := p.Verify(context.Background(), j.sign(t, "k1", nil), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Identity{Provider: "test", Subject: "subject-1", Email: "someone@example.com", EmailVerified: true}
+	if *identity != want {
+		t.Errorf("identity = %+v, want %+v", *identity, want)
+	}
+}
+
+func TestVerifyEmailVerified(t *testing.T) {
+	j := newTestJWKS(t, "k1")
+	p := j.provider()
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		want   bool
+	}{
+		{"bool", jwt.MapClaims{"email_verified": true}, true},
+		{"false", jwt.MapClaims{"email_verified": false}, false},
+		{"apple string", jwt.MapClaims{"email_verified": "true"}, true},
+		{"apple string false", jwt.MapClaims{"email_verified": "false"}, false},
+		{"missing", jwt.MapClaims{"email_verified": nil}, false},
+		{"no email", jwt.MapClaims{"email": nil}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, err := p.Verify(context.Background(), j.sign(t, "k1", tt.claims), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if identity.EmailVerified != tt.want {
+				t.Errorf("EmailVerified = %v, want %v", identity.EmailVerified, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	j := newTestJWKS(t, "k1")
+	p := j.provider()
+	other := newTestJWKS(t, "k1")
+	hs := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"iss": testIssuer, "aud": testAudience, "sub": "s", "exp": time.Now().Add(time.Hour).Unix(),
+	})
+	hs.Header["kid"] = "k1"
+	hsToken, err := hs.SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		nonce string
+	}{
+		{"garbage", "not.a.token", ""},
+		{"wrong issuer", j.sign(t, "k1", jwt.MapClaims{"iss": "https://evil.example"}), ""},
+		{"wrong audience", j.sign(t, "k1", jwt.MapClaims{"aud": "other.app"}), ""},
+		{"expired", j.sign(t, "k1", jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}), ""},
+		{"no expiry", j.sign(t, "k1", jwt.MapClaims{"exp": nil}), ""},
+		{"no subject", j.sign(t, "k1", jwt.MapClaims{"sub": nil}), ""},
+		{"nonce mismatch", j.sign(t, "k1", jwt.MapClaims{"nonce": "a"}), "b"},
+		{"nonce missing", j.sign(t, "k1", nil), "b"},
+		{"signed by another key", other.sign(t, "k1", nil), ""},
+		{"HS256", hsToken, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := p.Verify(context.Background(), tt.token, tt.nonce); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("err = %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+
+	if _, err := p.Verify(context.Background(), j.sign(t, "k1", jwt.MapClaims{"nonce": "a"}), "a"); err != nil {
+		t.Errorf("matching nonce: %v", err)
+	}
+	// Tokens may list several audiences
+	if _, err := p.Verify(context.Background(), j.sign(t, "k1", jwt.MapClaims{"aud": []string{"other.app", testAudience}}), ""); err != nil {
+		t.Errorf("audience list: %v", err)
+	}
+}
+
+func TestVerifyNotConfigured(t *testing.T) {
+	j := newTestJWKS(t, "k1")
+	p := j.provider()
+	p.Audiences = nil
+	if _, err := p.Verify(context.Background(), j.sign(t, "k1", nil), ""); !errors.Is(err, ErrNotConfigured) {
+		t.Errorf("err = %v, want ErrNotConfigured", err)
+	}
+}
+
+func TestVerifyKeyRotation(t *testing.T) {
+	j := newTestJWKS(t, "k1")
+	p := j.provider()
+	ctx := context.Background()
+	if _, err := p.Verify(ctx, j.sign(t, "k1", nil), ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Verify(ctx, j.sign(t, "k1", nil), ""); err != nil {
+		t.Fatal(err)
+	}
+	if n := j.fetches.Load(); n != 1 {
+		t.Errorf("fetched keys %d times, want once while cached", n)
+	}
+
+	// A key id seen within minRefetch of the last fetch waits
+	j.add(t, "k2")
+	if _, err := p.Verify(ctx, j.sign(t, "k2", nil), ""); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("new key right after a fetch: err = %v, want ErrInvalidToken", err)
+	}
+	if n := j.fetches.Load(); n != 1 {
+		t.Errorf("fetched keys %d times, want no refetch within minRefetch", n)
+	}
+
+	// Later a new key id refetches the keys
+	p.fetchedAt = time.Now().Add(-minRefetch)
+	p.triedAt = p.fetchedAt
+	if _, err := p.Verify(ctx, j.sign(t, "k2", nil), ""); err != nil {
+		t.Errorf("new key after minRefetch: %v", err)
+	}
+	if n := j.fetches.Load(); n != 2 {
+		t.Errorf("fetched keys %d times, want 2", n)
+	}
+
+	// Known keys keep working past keysTTL while the provider is down
+	j.server.Close()
+	p.fetchedAt = time.Now().Add(-keysTTL)
+	p.triedAt = p.fetchedAt
+	if _, err := p.Verify(ctx, j.sign(t, "k1", nil), ""); err != nil {
+		t.Errorf("known key while the provider is down: %v", err)
+	}
+}
+
+func TestVerifyProviderDown(t *testing.T) {
+	j := newTestJWKS(t, "k1")
+	p := j.provider()
+	token := j.sign(t, "k1", nil)
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		j.fetches.Add(1)
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	p.JWKSURL = failing.URL
+
+	// Not the token's fault, and retried only after minRefetch
+	for range 3 {
+		_, err := p.Verify(context.Background(), token, "")
+		if !errors.Is(err, ErrKeysUnavailable) || errors.Is(err, ErrInvalidToken) {
+			t.Fatalf("err = %v, want ErrKeysUnavailable", err)
+		}
+	}
+	if n := j.fetches.Load(); n != 1 {
+		t.Errorf("fetched keys %d times, want once within minRefetch", n)
+	}
+
+	p.JWKSURL = j.server.URL
+	p.triedAt = time.Now().Add(-minRefetch)
+	if _, err := p.Verify(context.Background(), token, ""); err != nil {
+		t.Errorf("after the provider recovered: %v", err)
+	}
+}
+
+func TestVerifyConcurrentFetch(t *testing.T) {
+	j := newTestJWKS(t, "k1")
+	p := j.provider()
+	token := j.sign(t, "k1", nil)
+	errs := make(chan error, 10)
+	for range cap(errs) {
+		go func() {
+			_, err := p.Verify(context.Background(), token, "")
+			errs <- err
+		}()
+	}
+	for range cap(errs) {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+	if n := j.fetches.Load(); n != 1 {
+		t.Errorf("fetched keys %d times, want once", n)
+	}
+}
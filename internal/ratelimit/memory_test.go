@@ -0,0 +1,138 @@
+package ratelimit
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreBurst(t *testing.T) {
+	s := NewMemoryStore()
+	limit := Limit{Rate: 3, Per: time.Minute}
+	for i := 0; i < limit.Rate; i++ {
+		res, err := s.Take("a", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Allowed || res.Remaining != limit.Rate-1-i {
+			t.Fatalf("request %d = %+v, want allowed with %d remaining", i, res, limit.Rate-1-i)
+		}
+	}
+
+	res, err := s.Take("a", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Allowed || res.Remaining != 0 {
+		t.Errorf("request over the burst = %+v, want denied", res)
+	}
+	// A token comes back every 20s; refilling all three takes a minute
+	if res.RetryAfter <= 19*time.Second || res.RetryAfter > 20*time.Second {
+		t.Errorf("RetryAfter = %v, want about 20s", res.RetryAfter)
+	}
+	if res.Reset <= 59*time.Second || res.Reset > time.Minute {
+		t.Errorf("Reset = %v, want about a minute", res.Reset)
+	}
+
+	// Buckets are per key
+	if res, _ := s.Take("b", limit); !res.Allowed || res.Remaining != limit.Rate-1 {
+		t.Errorf("other key = %+v, want a full bucket", res)
+	}
+}
+
+func TestMemoryStoreRefill(t *testing.T) {
+	s := NewMemoryStore()
+	limit := Limit{Rate: 2, Per: 400 * time.Millisecond}
+	for i := 0; i < limit.Rate; i++ {
+		s.Take("a", limit)
+	}
+	if res, _ := s.Take("a", limit); res.Allowed {
+		t.Fatal("request over the burst allowed")
+	}
+
+	// Half the period refills one token
+	time.Sleep(250 * time.Millisecond)
+	if res, _ := s.Take("a", limit); !res.Allowed {
+		t.Errorf("request after a token refilled = %+v, want allowed", res)
+	}
+	if res, _ := s.Take("a", limit); res.Allowed {
+		t.Errorf("second request after one token refilled = %+v, want denied", res)
+	}
+
+	// Refilling stops at the capacity
+	time.Sleep(time.Second)
+	res, _ := s.Take("a", limit)
+	if !res.Allowed || res.Remaining != limit.Rate-1 {
+		t.Errorf("request after a long wait = %+v, want %d remaining", res, limit.Rate-1)
+	}
+}
+
+func TestMemoryStoreDeniedRequestsDoNotCount(t *testing.T) {
+	s := NewMemoryStore()
+	limit := Limit{Rate: 1, Per: 100 * time.Millisecond}
+	s.Take("a", limit)
+	for i := 0; i < 5; i++ {
+		s.Take("a", limit)
+	}
+	// The retries did not push the refill back
+	time.Sleep(120 * time.Millisecond)
+	if res, _ := s.Take("a", limit); !res.Allowed {
+		t.Errorf("request after the period = %+v, want allowed", res)
+	}
+}
+
+func TestMemoryStoreSweep(t *testing.T) {
+	s := NewMemoryStore()
+	limit := Limit{Rate: 2, Per: time.Hour}
+	s.Take("full", Limit{Rate: 1, Per: time.Millisecond})
+	s.Take("draining", limit)
+	time.Sleep(5 * time.Millisecond)
+
+	// The next take after sweepInterval drops buckets that have refilled
+	s.lastSweep = time.Now().Add(-sweepInterval)
+	s.Take("new", limit)
+	if _, ok := s.buckets["full"]; ok {
+		t.Error("refilled bucket kept")
+	}
+	if _, ok := s.buckets["draining"]; !ok {
+		t.Error("bucket still refilling dropped")
+	}
+	if res, _ := s.Take("draining", limit); res.Remaining != 0 {
+		t.Errorf("kept bucket = %+v, want its earlier request counted", res)
+	}
+
+	// Sweeps wait for sweepInterval
+	s.buckets["draining"].full = time.Now().Add(-time.Second)
+	s.Take("new", limit)
+	if _, ok := s.buckets["draining"]; !ok {
+		t.Error("swept again before sweepInterval")
+	}
+}
+
+func TestMemoryStoreConcurrent(t *testing.T) {
+	s := NewMemoryStore()
+	limit := Limit{Rate: 10, Per: time.Hour}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := s.Take("a", limit)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if res.Allowed {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != limit.Rate {
+		t.Errorf("%d of 50 concurrent requests allowed, want %d", allowed, limit.Rate)
+	}
+}
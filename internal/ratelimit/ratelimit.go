@@ -0,0 +1,137 @@
+// Package ratelimit limits request rates with token buckets.
+package ratelimit
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit allows Rate requests per Per, in bursts of up to Rate. The zero
+// Limit allows everything.
+type Limit struct {
+	Rate int
+	Per  time.Duration
+}
+
+// Off reports whether the limit allows everything
+func (l Limit) Off() bool {
+	return l.Rate <= 0 || l.Per <= 0
+}
+
+// String formats the limit as ParseLimit reads it
+func (l Limit) String() string {
+	if l.Off() {
+		return "off"
+	}
+	switch l.Per {
+	case time.Second:
+		return fmt.Sprintf("%d/s", l.Rate)
+	case time.Minute:
+		return fmt.Sprintf("%d/m", l.Rate)
+	case time.Hour:
+		return fmt.Sprintf("%d/h", l.Rate)
+	}
+	return fmt.Sprintf("%d/%s", l.Rate, l.Per)
+}
+
+// ParseLimit reads a limit like "120/m": a number of requests per s, m, h or
+// a Go duration of whole seconds such as 10s, as the RateLimit-Policy header
+// counts in seconds. "off" allows everything. Rates over one request per
+// nanosecond of the period are rejected.
+func ParseLimit(s string) (Limit, error) {
+	s = strings.TrimSpace(s)
+	if s == "off" {
+		return Limit{}, nil
+	}
+	rate, per, ok := strings.Cut(s, "/")
+	n, err := strconv.Atoi(rate)
+	if !ok || err != nil || n <= 0 {
+		return Limit{}, fmt.Errorf("rate limit %q is not requests/period", s)
+	}
+	var d time.Duration
+	switch per {
+	case "s":
+		d = time.Second
+	case "m":
+		d = time.Minute
+	case "h":
+		d = time.Hour
+	default:
+		if d, err = time.ParseDuration(per); err != nil || d < time.Second || d%time.Second != 0 {
+			return Limit{}, fmt.Errorf("rate limit %q has an invalid period, want whole seconds", s)
+		}
+	}
+	if time.Duration(n) > d {
+		return Limit{}, fmt.Errorf("rate limit %q is too high", s)
+	}
+	return Limit{Rate: n, Per: d}, nil
+}
+
+// Result is the state of a bucket after taking a token from it
+type Result struct {
+	Allowed bool
+	// Remaining is the number of requests left in the bucket
+	Remaining int
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+	// RetryAfter is how long until the next request is allowed, when this
+	// one was not
+	RetryAfter time.Duration
+}
+
+// Store keeps token buckets. MemoryStore keeps them in the process; a store
+// shared by several servers makes them enforce one limit together.
+type Store interface {
+	// Take takes a token from the bucket under key, which holds up to
+	// limit.Rate tokens and refills at limit.Rate per limit.Per
+	Take(key string, limit Limit) (Result, error)
+}
+
+// Limiter limits the requests of each client, as told apart by Key
+type Limiter struct {
+	// Name identifies the limit, keeping its buckets apart from other limits'
+	Name  string
+	Limit Limit
+	Store Store
+	// Key returns the client a request counts against, e.g. its user or IP
+	Key func(*http.Request) string
+}
+
+// Handler is middleware answering requests over the limit with 429. Every
+// response carries RateLimit-Limit, -Remaining, -Reset and -Policy headers.
+func (l *Limiter) Handler(next http.Handler) http.Handler {
+	if l.Limit.Off() {
+		return next
+	}
+	policy := fmt.Sprintf("%d;w=%d", l.Limit.Rate, int(l.Limit.Per/time.Second))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res, err := l.Store.Take(l.Name+":"+l.Key(r), l.Limit)
+		if err != nil {
+			// A broken store should not take the API down with it
+			log.Printf("⚠️ Rate limit %s not enforced: %v", l.Name, err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Set("RateLimit-Limit", strconv.Itoa(l.Limit.Rate))
+		h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		h.Set("RateLimit-Reset", strconv.Itoa(seconds(res.Reset)))
+		h.Set("RateLimit-Policy", policy)
+		if !res.Allowed {
+			h.Set("Retry-After", strconv.Itoa(max(seconds(res.RetryAfter), 1)))
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// seconds rounds d up to whole seconds
+func seconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
@@ -0,0 +1,38 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	valid := map[string]Limit{
+		"off":          {},
+		"120/m":        {Rate: 120, Per: time.Minute},
+		"5/s":          {Rate: 5, Per: time.Second},
+		"1000/h":       {Rate: 1000, Per: time.Hour},
+		"10/10s":       {Rate: 10, Per: 10 * time.Second},
+		" 30/1m30s ":   {Rate: 30, Per: 90 * time.Second},
+		"1000000000/s": {Rate: 1e9, Per: time.Second},
+	}
+	for s, want := range valid {
+		got, err := ParseLimit(s)
+		if err != nil || got != want {
+			t.Errorf("ParseLimit(%q) = %+v, %v, want %+v", s, got, err, want)
+		}
+		// String round-trips
+		if again, err := ParseLimit(got.String()); err != nil || again != got {
+			t.Errorf("ParseLimit(%q) = %+v, %v, want %+v", got.String(), again, err, got)
+		}
+	}
+
+	for _, s := range []string{
+		"", "120", "0/m", "-1/m", "x/m", "10/d", "10/0s", "10/-1s",
+		"10/500ms", "10/1500ms", // the policy header counts whole seconds
+		"1000000001/s", // less than a nanosecond per request
+	} {
+		if got, err := ParseLimit(s); err == nil {
+			t.Errorf("ParseLimit(%q) = %+v, want an error", s, got)
+		}
+	}
+}
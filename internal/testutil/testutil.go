@@ -0,0 +1,79 @@
+// Package testutil sets up the database the tests of other packages run
+// against.
+package testutil
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/magnusohle/openanki-backend/internal/database"
+)
+
+// Repo is the test database, open while Main runs the tests
+var Repo *database.Repository
+
+// Main runs a package's tests against a fresh database with the main and
+// sync schemas applied, from the repository root, where the schema files are
+// read. setup, if not nil, is called with the database before the tests run.
+// Call it from TestMain; it does not return.
+func Main(m *testing.M, setup func(repo *database.Repository)) {
+	os.Exit(run(m, setup))
+}
+
+func run(m *testing.M, setup func(repo *database.Repository)) int {
+	if err := chdirRoot(); err != nil {
+		panic(err)
+	}
+	dir, err := os.MkdirTemp("", "openanki-test")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	Repo, err = database.InitDB(filepath.Join(dir, "test.db"))
+	if err == nil {
+		err = Repo.InitSyncSchema()
+	}
+	if err != nil {
+		panic(err)
+	}
+	defer database.DB.Close()
+	if setup != nil {
+		setup(Repo)
+	}
+	return m.Run()
+}
+
+// chdirRoot changes to the nearest directory above the working directory
+// that holds go.mod
+func chdirRoot() error {
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return os.Chdir(dir)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return fmt.Errorf("no go.mod above %s", dir)
+		}
+		dir = parent
+	}
+}
+
+var users int
+
+// CreateUser adds a user of its own for a test
+func CreateUser(t *testing.T) *database.User {
+	t.Helper()
+	users++
+	user, err := database.CreateUser(fmt.Sprintf("user%d@example.com", users), "hash", fmt.Sprintf("user%d", users))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}